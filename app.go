@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -31,6 +32,9 @@ type App struct {
 	installingGit     bool               // Flag to prevent concurrent Git installation
 	nodeInstallDone   chan bool          // Channel to signal Node.js installation completion
 	installMutex      sync.Mutex
+	logOutput         io.Writer // Mirrors log messages, used by the CLI --verbose flag
+	pendingLaunches   sync.WaitGroup // Launch scripts still waiting for their environment
+	configWatch       configWatchState
 }
 var OnConfigChanged func(AppConfig)
 var UpdateTrayMenu func(string)
@@ -42,6 +46,10 @@ type ModelConfig struct {
 	ApiKey    string `json:"api_key"`
 	WireApi   string `json:"wire_api"`
 	IsCustom  bool   `json:"is_custom"`
+	KeyGroup  string `json:"key_group"` // Shares the API key with other tools, see key_groups.go
+	// Several labelled keys, one picked per launch (see provider_keys.go)
+	Keys        []ProviderKey `json:"keys,omitempty"`
+	KeyStrategy string        `json:"key_strategy,omitempty"`
 }
 type ProjectConfig struct {
 	Id            string `json:"id"`
@@ -57,6 +65,11 @@ type ProjectConfig struct {
 	ProxyPort     string `json:"proxy_port"`
 	ProxyUsername string `json:"proxy_username"`
 	ProxyPassword string `json:"proxy_password"`
+	// Provider overrides; empty values fall back to the tool's current provider
+	Tool     string            `json:"tool"`     // Tool pinned to this project
+	Provider string            `json:"provider"` // Provider (model_name) of the pinned tool
+	ModelId  string            `json:"model_id"` // Overrides the provider's model ID
+	Env      map[string]string `json:"env"`      // Extra environment variables for launches
 }
 type PythonEnvironment struct {
 	Name string `json:"name"` // Environment name (e.g.", "base", "myenv")
@@ -66,6 +79,7 @@ type PythonEnvironment struct {
 type ToolConfig struct {
 	CurrentModel string        `json:"current_model"`
 	Models       []ModelConfig `json:"models"`
+	Failover     []string      `json:"failover,omitempty"` // Providers the gateway tries when the current one fails
 }
 type CodeBuddyModel struct {
 	Id               string `json:"id"`
@@ -83,6 +97,7 @@ type CodeBuddyFileConfig struct {
 	AvailableModels []string         `json:"availableModels"`
 }
 type AppConfig struct {
+	SchemaVersion        int             `json:"schema_version"` // See config_schema.go
 	Claude               ToolConfig      `json:"claude"`
 	Gemini               ToolConfig      `json:"gemini"`
 	Codex                ToolConfig      `json:"codex"`
@@ -114,6 +129,43 @@ type AppConfig struct {
 	DefaultProxyPort     string `json:"default_proxy_port"`
 	DefaultProxyUsername string `json:"default_proxy_username"`
 	DefaultProxyPassword string `json:"default_proxy_password"`
+	// Secret storage: "auto", "keyring", "file" or "plaintext" (see secrets.go)
+	SecretBackend string `json:"secret_backend"`
+	// How launches open a terminal on Linux (see terminal.go)
+	Terminal TerminalConfig `json:"terminal"`
+	// Named sets of providers, keys and proxy settings (see profiles.go)
+	ActiveProfile string          `json:"active_profile"`
+	Profiles      []ConfigProfile `json:"profiles"`
+	// Port of the local protocol gateway, 0 for the default (see gateway.go)
+	GatewayPort int `json:"gateway_port,omitempty"`
+	// Token accounting: route every Claude and Codex launch through the gateway, and
+	// prices per model or provider/model (see usage.go)
+	UsageTracking bool                  `json:"usage_tracking,omitempty"`
+	ModelPrices   map[string]ModelPrice `json:"model_prices,omitempty"`
+}
+// supportedTools lists the tool names in the order they appear in the UI
+var supportedTools = []string{"claude", "gemini", "codex", "opencode", "codebuddy", "qoder", "iflow", "kilo"}
+// toolConfig returns the per-tool config for a tool name, or nil for unknown tools
+func (c *AppConfig) toolConfig(name string) *ToolConfig {
+	switch strings.ToLower(name) {
+	case "claude":
+		return &c.Claude
+	case "gemini":
+		return &c.Gemini
+	case "codex":
+		return &c.Codex
+	case "opencode":
+		return &c.Opencode
+	case "codebuddy":
+		return &c.CodeBuddy
+	case "qoder":
+		return &c.Qoder
+	case "iflow":
+		return &c.IFlow
+	case "kilo":
+		return &c.Kilo
+	}
+	return nil
 }
 type Skill struct {
 	Name        string `json:"name"`
@@ -150,54 +202,6 @@ func (a *App) domReady(ctx context.Context) {
 	// IsInitMode and PauseEnvCheck logic is handled inside CheckEnvironment
 	a.CheckEnvironment(false)
 }
-func (a *App) startConfigWatcher() {
-	var err error
-	a.watcher, err = fsnotify.NewWatcher()
-	if err != nil {
-		a.log("Failed to create file watcher: " + err.Error())
-		return
-	}
-	go func() {
-		for {
-			select {
-			case event, ok := <-a.watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					a.log(a.tr("Config file modified: ") + event.Name)
-					// Reload config and emit event
-					// We use a debounce-like approach or just reload.
-					// Since Wails events are async, it should be fine.
-					// However, writing the config (SaveConfig) also triggers a write event.
-					// We should probably check if the change was internal or external,
-					// but that's hard. For now, simply reloading might be okay,
-					// but it could cause a loop if we are not careful.
-					// Actually, if we just emit 'config-updated', the frontend updates.
-					// But if the frontend updates, it might save...
-					// Let's assume for now this is for external edits.
-					config, err := a.LoadConfig()
-					if err == nil {
-						a.emitEvent("config-updated", config)
-					}
-				}
-			case err, ok := <-a.watcher.Errors:
-				if !ok {
-					return
-				}
-				a.log("Watcher error: " + err.Error())
-			}
-		}
-	}()
-	configPath, err := a.getConfigPath()
-	if err == nil {
-		if err := a.watcher.Add(configPath); err != nil {
-			a.log("Failed to watch config file: " + err.Error())
-		} else {
-			a.log("Watching config file: " + configPath)
-		}
-	}
-}
 func (a *App) SetLanguage(lang string) {
 	a.CurrentLanguage = lang
 	if UpdateTrayMenu != nil {
@@ -289,34 +293,71 @@ func (a *App) getIFlowConfigPaths() (string, string) {
 	config := filepath.Join(dir, "settings.json")
 	return dir, config
 }
+// Keys written by versions that did not record ownership in managed_keys.json
+var legacyClaudeSettingsKeys = [][]string{
+	{"env", "ANTHROPIC_AUTH_TOKEN"}, {"env", "ANTHROPIC_BASE_URL"}, {"env", "ANTHROPIC_MODEL"},
+	{"env", "ANTHROPIC_DEFAULT_HAIKU_MODEL"}, {"env", "ANTHROPIC_DEFAULT_OPUS_MODEL"}, {"env", "ANTHROPIC_DEFAULT_SONNET_MODEL"},
+	{"env", "ANTHROPIC_SMALL_FAST_MODEL"}, {"env", "API_TIMEOUT_MS"}, {"env", "CLAUDE_CODE_DISABLE_NONESSENTIAL_TRAFFIC"},
+	{"env", "CLAUDE_CODE_USE_COLORS"}, {"env", "CLAUDE_CODE_MAX_OUTPUT_TOKENS"}, {"env", "MAX_THINKING_TOKENS"},
+}
 func (a *App) clearClaudeConfig() {
-	dir, _, legacy := a.getClaudeConfigPaths()
-	home, _ := os.UserHomeDir()
-	os.RemoveAll(dir)
-	os.Remove(legacy)
-	os.Remove(filepath.Join(home, ".claude.json.backup"))
-	a.log("Cleared Claude configuration files")
+	a.backupToolConfig("claude", "clear", "")
+	_, settingsPath, legacyPath := a.getClaudeConfigPaths()
+	if err := removeManagedJSON(settingsPath, legacyClaudeSettingsKeys); err != nil {
+		a.log("Failed to clean Claude settings: " + err.Error())
+	}
+	if err := removeManagedJSON(legacyPath, [][]string{{"customApiKeyResponses"}}); err != nil {
+		a.log("Failed to clean Claude settings: " + err.Error())
+	}
+	a.log("Removed AICoder settings from Claude configuration")
 }
+var legacyGeminiSettingsKeys = [][]string{{"security", "auth", "selectedType"}, {"general", "previewFeatures"}}
 func (a *App) clearGeminiConfig() {
-	dir, _, legacy := a.getGeminiConfigPaths()
-	os.RemoveAll(dir)
-	os.Remove(legacy)
-	a.log("Cleared Gemini configuration files")
+	a.backupToolConfig("gemini", "clear", "")
+	_, settingsPath, _ := a.getGeminiConfigPaths()
+	if err := removeManagedJSON(settingsPath, legacyGeminiSettingsKeys); err != nil {
+		a.log("Failed to clean Gemini settings: " + err.Error())
+	}
+	a.log("Removed AICoder settings from Gemini configuration")
 }
 func (a *App) clearCodexConfig() {
-	dir, _ := a.getCodexConfigPaths()
-	os.RemoveAll(dir)
-	a.log("Cleared Codex configuration directory")
+	a.backupToolConfig("codex", "clear", "")
+	dir, authPath := a.getCodexConfigPaths()
+	if err := removeManagedJSON(authPath, [][]string{{"OPENAI_API_KEY"}}); err != nil {
+		a.log("Failed to clean Codex auth.json: " + err.Error())
+	}
+	configPath := filepath.Join(dir, "config.toml")
+	legacy := [][]string{{"model_provider"}, {"model"}}
+	// Versions before ownership tracking wrote the table model_provider points to
+	if existing, err := os.ReadFile(configPath); err == nil {
+		if name := codexModelProvider(existing); name != "" {
+			legacy = append(legacy, []string{"model_providers", name})
+		}
+	}
+	err := removeManagedFile(configPath, legacy, func(existing []byte, owned [][]string) ([]byte, bool, error) {
+		data, empty := removeCodexConfig(existing, owned)
+		return data, empty, nil
+	})
+	if err != nil {
+		a.log("Failed to clean Codex config.toml: " + err.Error())
+	}
+	a.log("Removed AICoder settings from Codex configuration")
 }
 func (a *App) clearOpencodeConfig() {
-	dir, _ := a.getOpencodeConfigPaths()
-	os.RemoveAll(dir)
-	a.log("Cleared Opencode configuration directory")
+	a.backupToolConfig("opencode", "clear", "")
+	_, configPath := a.getOpencodeConfigPaths()
+	if err := removeManagedJSON(configPath, [][]string{{"provider", "myprovider"}}); err != nil {
+		a.log("Failed to clean Opencode configuration: " + err.Error())
+	}
+	a.log("Removed AICoder settings from Opencode configuration")
 }
 func (a *App) clearIFlowConfig() {
-	dir, _ := a.getIFlowConfigPaths()
-	os.RemoveAll(dir)
-	a.log("Cleared iFlow configuration directory")
+	a.backupToolConfig("iflow", "clear", "")
+	_, configPath := a.getIFlowConfigPaths()
+	if err := removeManagedJSON(configPath, [][]string{{"selectedAuthType"}, {"apiKey"}, {"baseUrl"}, {"modelName"}}); err != nil {
+		a.log("Failed to clean iFlow configuration: " + err.Error())
+	}
+	a.log("Removed AICoder settings from iFlow configuration")
 }
 func (a *App) getKiloConfigPaths() (string, string) {
 	home, _ := os.UserHomeDir()
@@ -325,9 +366,12 @@ func (a *App) getKiloConfigPaths() (string, string) {
 	return dir, config
 }
 func (a *App) clearKiloConfig() {
+	a.backupToolConfig("kilo", "clear", "")
 	_, configPath := a.getKiloConfigPaths()
-	os.Remove(configPath)
-	a.log("Cleared Kilo Code configuration file")
+	if err := removeManagedJSONEntries(configPath, [][]string{{"providers", "default"}}); err != nil {
+		a.log("Failed to clean Kilo Code configuration: " + err.Error())
+	}
+	a.log("Removed AICoder settings from Kilo Code configuration")
 }
 func (a *App) clearEnvVars() {
 	vars := []string{
@@ -345,6 +389,7 @@ func (a *App) clearEnvVars() {
 	}
 }
 func (a *App) syncToClaudeSettings(config AppConfig) error {
+	a.backupToolConfig("claude", "sync", "")
 	var selectedModel *ModelConfig
 	for _, m := range config.Claude.Models {
 		if m.ModelName == config.Claude.CurrentModel {
@@ -363,108 +408,33 @@ func (a *App) syncToClaudeSettings(config AppConfig) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
+	endpoint := getProviderRegistry().Resolve("claude", selectedModel)
 	settings := make(map[string]interface{})
+	for k, v := range endpoint.Settings {
+		settings[k] = v
+	}
 	env := make(map[string]string)
+	for k, v := range endpoint.Env {
+		env[k] = v
+	}
 	// Exclusively use AUTH_TOKEN for custom providers
 	env["ANTHROPIC_AUTH_TOKEN"] = selectedModel.ApiKey
-	env["CLAUDE_CODE_USE_COLORS"] = "true"
-	env["CLAUDE_CODE_MAX_OUTPUT_TOKENS"] = "64000"
-	env["MAX_THINKING_TOKENS"] = "31999"
-	switch strings.ToLower(selectedModel.ModelName) {
-	case "kimi":
-		env["ANTHROPIC_BASE_URL"] = "https://api.kimi.com/coding"
-		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_MODEL"] = selectedModel.ModelId
-	case "glm", "glm-4.7":
-		env["ANTHROPIC_BASE_URL"] = "https://open.bigmodel.cn/api/anthropic"
-		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_MODEL"] = selectedModel.ModelId
-		settings["permissions"] = map[string]string{"defaultMode": "dontAsk"}
-	case "doubao":
-		env["ANTHROPIC_BASE_URL"] = "https://ark.cn-beijing.volces.com/api/coding"
-		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_MODEL"] = selectedModel.ModelId
-	case "minimax":
-		env["ANTHROPIC_BASE_URL"] = "https://api.minimaxi.com/anthropic"
-		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_MODEL"] = selectedModel.ModelId
-		env["ANTHROPIC_SMALL_FAST_MODEL"] = selectedModel.ModelId
-		env["API_TIMEOUT_MS"] = "3000000"
-		env["CLAUDE_CODE_DISABLE_NONESSENTIAL_TRAFFIC"] = "1"
-	case "deepseek":
-		env["ANTHROPIC_BASE_URL"] = "https://api.deepseek.com/anthropic"
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "deepseek-chat"
-		}
-		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = modelId
-		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = modelId
-		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = modelId
-		env["ANTHROPIC_MODEL"] = modelId
-	case "gaccode":
-		env["ANTHROPIC_BASE_URL"] = "https://gaccode.com/claudecode"
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "sonnet"
-		}
-		env["ANTHROPIC_DEFAULT_HAIKU_MODEL"] = modelId
-		env["ANTHROPIC_DEFAULT_OPUS_MODEL"] = modelId
-		env["ANTHROPIC_DEFAULT_SONNET_MODEL"] = modelId
-		env["ANTHROPIC_MODEL"] = modelId
-	default:
-		env["ANTHROPIC_BASE_URL"] = selectedModel.ModelUrl
-		env["ANTHROPIC_MODEL"] = selectedModel.ModelId
-	}
+	env["ANTHROPIC_BASE_URL"] = endpoint.BaseUrl
 	settings["env"] = env
-	data, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return err
-	}
-	// Check if settings file needs update
-	if existingData, err := os.ReadFile(settingsPath); err == nil {
-		if bytes.Equal(existingData, data) {
-			// Settings file is already up to date, skip main settings write
-			// But still need to update .claude.json for API key responses
-			goto updateLegacyJson
-		}
-	}
-	if err := os.WriteFile(settingsPath, data, 0644); err != nil {
+	// Only the keys above are touched, hooks, statusLine, MCP servers etc. are kept
+	if err := writeManagedJSON(settingsPath, settings); err != nil {
 		return err
 	}
 	// 2. Sync to ~/.claude.json for customApiKeyResponses
-updateLegacyJson:
-	var claudeJson map[string]interface{}
-	if jsonData, err := os.ReadFile(legacyPath); err == nil {
-		json.Unmarshal(jsonData, &claudeJson)
-	}
-	if claudeJson == nil {
-		claudeJson = make(map[string]interface{})
-	}
-	claudeJson["customApiKeyResponses"] = map[string]interface{}{
-		"approved": []string{selectedModel.ApiKey},
-		"rejected": []string{},
-	}
-	data2, err := json.MarshalIndent(claudeJson, "", "  ")
-	if err != nil {
-		return err
-	}
-	// Check if legacy file needs update
-	if existingData, err := os.ReadFile(legacyPath); err == nil {
-		if bytes.Equal(existingData, data2) {
-			return nil
-		}
-	}
-	return os.WriteFile(legacyPath, data2, 0644)
+	return writeManagedJSON(legacyPath, map[string]interface{}{
+		"customApiKeyResponses": map[string]interface{}{
+			"approved": []string{selectedModel.ApiKey},
+			"rejected": []string{},
+		},
+	})
 }
 func (a *App) syncToCodexSettings(config AppConfig) error {
+	a.backupToolConfig("codex", "sync", "")
 	var selectedModel *ModelConfig
 	for _, m := range config.Codex.Models {
 		if m.ModelName == config.Codex.CurrentModel {
@@ -484,231 +454,23 @@ func (a *App) syncToCodexSettings(config AppConfig) error {
 		return err
 	}
 	// Create auth.json
-	authData := map[string]string{
-		"OPENAI_API_KEY": selectedModel.ApiKey,
-	}
-	authJson, err := json.MarshalIndent(authData, "", "  ")
-	if err != nil {
-		return err
-	}
-	// Check if auth.json needs update
-	if existingData, err := os.ReadFile(authPath); err == nil {
-		if bytes.Equal(existingData, authJson) {
-			// Auth file is already up to date, skip writing
-			goto writeConfigToml
-		}
-	}
-	if err := os.WriteFile(authPath, authJson, 0644); err != nil {
+	if err := writeManagedJSON(authPath, map[string]interface{}{"OPENAI_API_KEY": selectedModel.ApiKey}); err != nil {
 		return err
 	}
 	// Create config.toml
-writeConfigToml:
 	configPath := filepath.Join(dir, "config.toml")
-	baseUrl := selectedModel.ModelUrl
-	var configToml string
-	if strings.ToLower(selectedModel.ModelName) == "aigocode" {
-		if baseUrl == "" {
-			baseUrl = "https://api.aigocode.com/openai"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "gpt-5.2-codex"
-		}
-		configToml = fmt.Sprintf(`model_provider = "aigocode"
-model = "%s"
-model_reasoning_effort = "high"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.aigocode]
-name = "aigocode"
-base_url = "%s"
-wire_api = "responses"
-requires_openai_auth = true
-`, modelId, baseUrl)
-	} else if strings.ToLower(selectedModel.ModelName) == "deepseek" {
-		if baseUrl == "" {
-			baseUrl = "https://api.deepseek.com/v1"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "deepseek-chat"
-		}
-		configToml = fmt.Sprintf(`model_provider = "deepseek"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.deepseek]
-name = "deepseek"
-base_url = "%s"
-wire_api = "chat"
-request_max_retries = 4
-stream_max_retries = 8
-stream_idle_timeout_ms = 120000
-`, modelId, baseUrl)
-	} else if strings.ToLower(selectedModel.ModelName) == "glm" {
-		if baseUrl == "" {
-			baseUrl = "https://open.bigmodel.cn/api/paas/v4"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "glm-4.7"
-		}
-		configToml = fmt.Sprintf(`model_provider = "glm"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.glm]
-name = "glm"
-base_url = "%s"
-wire_api = "chat"
-request_max_retries = 4
-stream_max_retries = 8
-stream_idle_timeout_ms = 120000
-`, modelId, baseUrl)
-	} else if strings.ToLower(selectedModel.ModelName) == "doubao" {
-		if baseUrl == "" {
-			baseUrl = "https://ark.cn-beijing.volces.com/api/coding/v3"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "doubao-seed-code-preview-latest"
-		}
-		configToml = fmt.Sprintf(`model_provider = "doubao"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.doubao]
-name = "doubao"
-base_url = "%s"
-wire_api = "chat"
-request_max_retries = 4
-stream_max_retries = 8
-stream_idle_timeout_ms = 120000
-`, modelId, baseUrl)
-	} else if strings.ToLower(selectedModel.ModelName) == "kimi" {
-		if baseUrl == "" {
-			baseUrl = "https://api.kimi.com/coding/v1"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "kimi-for-coding"
-		}
-		configToml = fmt.Sprintf(`model_provider = "kimi"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.kimi]
-name = "kimi"
-base_url = "%s"
-wire_api = "chat"
-request_max_retries = 4
-stream_max_retries = 8
-stream_idle_timeout_ms = 120000
-`, modelId, baseUrl)
-	} else if strings.ToLower(selectedModel.ModelName) == "minimax" {
-		if baseUrl == "" {
-			baseUrl = "https://api.minimaxi.com/v1"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "MiniMax-M2.1"
-		}
-		configToml = fmt.Sprintf(`model_provider = "minimax"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.minimax]
-name = "minimax"
-base_url = "%s"
-wire_api = "chat"
-request_max_retries = 4
-stream_max_retries = 8
-stream_idle_timeout_ms = 120000
-`, modelId, baseUrl)
-	} else if strings.ToLower(selectedModel.ModelName) == "coderelay" {
-		if baseUrl == "" {
-			baseUrl = "https://api.code-relay.com/v1"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "gpt-5.2-codex"
-		}
-		configToml = fmt.Sprintf(`model_provider = "coderelay"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.coderelay]
-name = "coderelay"
-base_url = "%s"
-wire_api = "responses"
-`, modelId, baseUrl)
-	} else {
-		if baseUrl == "" {
-			baseUrl = "https://api.aicodemirror.com/api/codex/backend-api/codex"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "gpt-5.2-codex"
-		}
-		configToml = fmt.Sprintf(`model_provider = "aicodemirror"
-model = "%s"
-model_reasoning_effort = "xhigh"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.aicodemirror]
-name = "aicodemirror"
-base_url = "%s"
-wire_api = "responses"
-`, modelId, baseUrl)
-	}
-	if selectedModel.IsCustom || (strings.ToLower(selectedModel.ModelName) != "aigocode" &&
-		strings.ToLower(selectedModel.ModelName) != "deepseek" &&
-		strings.ToLower(selectedModel.ModelName) != "glm" &&
-		strings.ToLower(selectedModel.ModelName) != "doubao" &&
-		strings.ToLower(selectedModel.ModelName) != "kimi" &&
-		strings.ToLower(selectedModel.ModelName) != "minimax" &&
-		strings.ToLower(selectedModel.ModelName) != "coderelay" &&
-		strings.ToLower(selectedModel.ModelName) != "aicodemirror") {
-		// --- CUSTOM OR OTHER PROVIDERS ---
-		wireApi := selectedModel.WireApi
-		if wireApi == "" {
-			wireApi = "chat"
-		}
-		providerName := strings.ToLower(selectedModel.ModelName)
-		if providerName == "" || providerName == "custom" {
-			providerName = "custom"
-		}
-		modelId := selectedModel.ModelId
-		if modelId == "" {
-			modelId = "gpt-5.2-codex"
-		}
-		configToml = fmt.Sprintf(`model_provider = "%s"
-model = "%s"
-model_reasoning_effort = "high"
-disable_response_storage = true
-preferred_auth_method = "apikey"
-[model_providers.%s]
-name = "%s"
-base_url = "%s"
-wire_api = "%s"
-`, providerName, modelId, providerName, providerName, baseUrl, wireApi)
-	}
-	configBytes := []byte(configToml)
-	// Check if config.toml needs update
-	if existingData, err := os.ReadFile(configPath); err == nil {
-		if bytes.Equal(existingData, configBytes) {
-			return nil
-		}
-	}
-	return os.WriteFile(configPath, configBytes, 0644)
+	endpoint := getProviderRegistry().Resolve("codex", selectedModel)
+	if endpoint.WireApi == "" {
+		endpoint.WireApi = "chat"
+	}
+	// Merge into the existing file so user-maintained sections (mcp_servers, profiles, ...) survive
+	return updateManagedFile(configPath, func(existing []byte, owned [][]string) ([]byte, [][]string, error) {
+		data, keys := mergeCodexConfig(existing, owned, providerKey(selectedModel.ModelName), endpoint)
+		return data, keys, nil
+	})
 }
 func (a *App) syncToOpencodeSettings(config AppConfig) error {
+	a.backupToolConfig("opencode", "sync", "")
 	var selectedModel *ModelConfig
 	for _, m := range config.Opencode.Models {
 		if m.ModelName == config.Opencode.CurrentModel {
@@ -727,44 +489,10 @@ func (a *App) syncToOpencodeSettings(config AppConfig) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	baseUrl := selectedModel.ModelUrl
-	modelId := selectedModel.ModelId
+	endpoint := getProviderRegistry().Resolve("opencode", selectedModel)
+	baseUrl := endpoint.BaseUrl
+	modelId := endpoint.ModelId
 	providerName := selectedModel.ModelName
-	// Fallback logic for Opencode (align with Codex providers)
-	if modelId == "" {
-		switch strings.ToLower(providerName) {
-		case "deepseek":
-			modelId = "deepseek-chat"
-			if baseUrl == "" {
-				baseUrl = "https://api.deepseek.com/v1"
-			}
-		case "glm":
-			modelId = "glm-4.7"
-			if baseUrl == "" {
-				baseUrl = "https://open.bigmodel.cn/api/paas/v4"
-			}
-		case "doubao":
-			modelId = "doubao-seed-code-preview-latest"
-			if baseUrl == "" {
-				baseUrl = "https://ark.cn-beijing.volces.com/api/coding/v3"
-			}
-		case "kimi":
-			modelId = "kimi-for-coding"
-			if baseUrl == "" {
-				baseUrl = "https://api.kimi.com/coding/v1"
-			}
-		case "minimax":
-			modelId = "MiniMax-M2.1"
-			if baseUrl == "" {
-				baseUrl = "https://api.minimaxi.com/v1"
-			}
-		default:
-			modelId = "opencode-1.0"
-			if baseUrl == "" {
-				baseUrl = "https://api.aicodemirror.com/api/opencode/v1"
-			}
-		}
-	}
 	// Build the JSON structure
 	opencodeJson := map[string]interface{}{
 		"$schema": "https://opencode.ai/config.json",
@@ -789,13 +517,10 @@ func (a *App) syncToOpencodeSettings(config AppConfig) error {
 			},
 		},
 	}
-	data, err := json.MarshalIndent(opencodeJson, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(configPath, data, 0644)
+	return writeManagedJSON(configPath, opencodeJson)
 }
 func (a *App) syncToGeminiSettings(config AppConfig) error {
+	a.backupToolConfig("gemini", "sync", "")
 	var selectedModel *ModelConfig
 	for _, m := range config.Gemini.Models {
 		if m.ModelName == config.Gemini.CurrentModel {
@@ -843,23 +568,10 @@ func (a *App) syncToGeminiSettings(config AppConfig) error {
 		a.log(fmt.Sprintf("Gemini: Configured to use environment variables (API Key from env)"))
 	}
 
-	// Use compact JSON format for faster serialization
-	configJson, err := json.MarshalIndent(configData, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	// Check if file exists and has same content to avoid unnecessary writes
-	if existingData, err := os.ReadFile(configPath); err == nil {
-		if bytes.Equal(existingData, configJson) {
-			// File already has the correct content, skip writing
-			return nil
-		}
-	}
-
-	return os.WriteFile(configPath, configJson, 0644)
+	return writeManagedJSON(configPath, configData)
 }
 func (a *App) syncToIFlowSettings(config AppConfig) error {
+	a.backupToolConfig("iflow", "sync", "")
 	var selectedModel *ModelConfig
 	for _, m := range config.IFlow.Models {
 		if m.ModelName == config.IFlow.CurrentModel {
@@ -878,56 +590,20 @@ func (a *App) syncToIFlowSettings(config AppConfig) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	// Prepare defaults
-	baseUrl := selectedModel.ModelUrl
-	modelId := selectedModel.ModelId
-	providerName := strings.ToLower(selectedModel.ModelName)
-	// Fallback logic for iFlow (align with Codex providers)
-	if modelId == "" {
-		switch providerName {
-		case "deepseek":
-			modelId = "deepseek-chat"
-			if baseUrl == "" {
-				baseUrl = "https://api.deepseek.com/v1"
-			}
-		case "glm":
-			modelId = "glm-4.7"
-			if baseUrl == "" {
-				baseUrl = "https://open.bigmodel.cn/api/paas/v4"
-			}
-		case "doubao":
-			modelId = "doubao-seed-code-preview-latest"
-			if baseUrl == "" {
-				baseUrl = "https://ark.cn-beijing.volces.com/api/coding/v3"
-			}
-		case "kimi":
-			modelId = "kimi-for-coding"
-			if baseUrl == "" {
-				baseUrl = "https://api.kimi.com/coding/v1"
-			}
-		case "minimax":
-			modelId = "MiniMax-M2.1"
-			if baseUrl == "" {
-				baseUrl = "https://api.minimaxi.com/v1"
-			}
-		default:
-			modelId = "gpt-4o"
-		}
-	}
+	endpoint := getProviderRegistry().Resolve("iflow", selectedModel)
+	baseUrl := endpoint.BaseUrl
+	modelId := endpoint.ModelId
 	// Build the JSON structure for settings.json
-	settings := map[string]string{
+	settings := map[string]interface{}{
 		"selectedAuthType": "openai-compatible",
 		"apiKey":           selectedModel.ApiKey,
 		"baseUrl":          baseUrl,
 		"modelName":        modelId,
 	}
-	data, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(configPath, data, 0644)
+	return writeManagedJSON(configPath, settings)
 }
 func (a *App) syncToKiloSettings(config AppConfig) error {
+	a.backupToolConfig("kilo", "sync", "")
 	var selectedModel *ModelConfig
 	for _, m := range config.Kilo.Models {
 		if m.ModelName == config.Kilo.CurrentModel {
@@ -946,61 +622,11 @@ func (a *App) syncToKiloSettings(config AppConfig) error {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	// Read existing config if it exists
-	var kiloConfig map[string]interface{}
-	existingData, err := os.ReadFile(configPath)
-	if err == nil {
-		// File exists, parse it
-		if err := json.Unmarshal(existingData, &kiloConfig); err != nil {
-			// If parsing fails, create new config
-			kiloConfig = make(map[string]interface{})
-		}
-	} else {
-		// File doesn't exist, create new config
-		kiloConfig = make(map[string]interface{})
-	}
 	// Prepare provider configuration
-	baseUrl := selectedModel.ModelUrl
-	modelId := selectedModel.ModelId
-	providerName := strings.ToLower(selectedModel.ModelName)
-	// Fallback logic for common providers
-	if modelId == "" {
-		switch providerName {
-		case "deepseek":
-			modelId = "deepseek-chat"
-			if baseUrl == "" {
-				baseUrl = "https://api.deepseek.com/v1"
-			}
-		case "glm":
-			modelId = "glm-4.7"
-			if baseUrl == "" {
-				baseUrl = "https://open.bigmodel.cn/api/paas/v4"
-			}
-		case "doubao":
-			modelId = "doubao-seed-code-preview-latest"
-			if baseUrl == "" {
-				baseUrl = "https://ark.cn-beijing.volces.com/api/coding/v3"
-			}
-		case "kimi":
-			modelId = "kimi-for-coding"
-			if baseUrl == "" {
-				baseUrl = "https://api.kimi.com/coding/v1"
-			}
-		case "minimax":
-			modelId = "MiniMax-M2.1"
-			if baseUrl == "" {
-				baseUrl = "https://api.minimaxi.com/v1"
-			}
-		case "xiaomi":
-			modelId = "mimo-v2-flash"
-			if baseUrl == "" {
-				baseUrl = "https://api.xiaomimimo.com/v1"
-			}
-		default:
-			modelId = "gpt-4o"
-		}
-	}
-	// Build provider object
+	endpoint := getProviderRegistry().Resolve("kilo", selectedModel)
+	baseUrl := endpoint.BaseUrl
+	modelId := endpoint.ModelId
+	// Build provider object, the user's other providers are kept
 	provider := map[string]interface{}{
 		"id":            "default",
 		"provider":      "openai",
@@ -1008,16 +634,10 @@ func (a *App) syncToKiloSettings(config AppConfig) error {
 		"openAiModelId": modelId,
 		"openAiBaseUrl": baseUrl,
 	}
-	// Update providers array
-	kiloConfig["providers"] = []interface{}{provider}
-	// Write config file
-	data, err := json.MarshalIndent(kiloConfig, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(configPath, data, 0644)
+	return writeManagedJSONEntry(configPath, "providers", provider)
 }
 func (a *App) syncToCodeBuddySettings(config AppConfig, projectPath string) error {
+	a.backupToolConfig("codebuddy", "sync", projectPath)
 	if projectPath == "" {
 		projectPath = a.GetCurrentProjectPath()
 	}
@@ -1040,25 +660,9 @@ func (a *App) syncToCodeBuddySettings(config AppConfig, projectPath string) erro
 			continue
 		}
 		vendor := strings.ToLower(m.ModelName)
-		idStr := m.ModelId
-		if idStr == "" {
-			switch vendor {
-			case "deepseek":
-				idStr = "deepseek-chat"
-			case "glm":
-				idStr = "glm-4.7"
-			case "doubao":
-				idStr = "doubao-seed-code-preview-latest"
-			case "kimi":
-				idStr = "kimi-for-coding"
-			case "minimax":
-				idStr = "MiniMax-M2.1"
-			default:
-				idStr = vendor + "-model"
-			}
-		}
-		modelIds := strings.Split(idStr, ",")
-		modelUrl := m.ModelUrl
+		endpoint := getProviderRegistry().Resolve("codebuddy", &m)
+		modelIds := strings.Split(endpoint.ModelId, ",")
+		modelUrl := endpoint.BaseUrl
 		if modelUrl != "" && !strings.HasSuffix(modelUrl, "/chat/completions") {
 			if strings.HasSuffix(modelUrl, "/") {
 				modelUrl += "chat/completions"
@@ -1096,6 +700,7 @@ func (a *App) syncToCodeBuddySettings(config AppConfig, projectPath string) erro
 	return os.WriteFile(cbFilePath, data, 0644)
 }
 func (a *App) syncToQoderSettings(config AppConfig, projectPath string) error {
+	a.backupToolConfig("qoder", "sync", projectPath)
 	if projectPath == "" {
 		projectPath = a.GetCurrentProjectPath()
 	}
@@ -1118,25 +723,9 @@ func (a *App) syncToQoderSettings(config AppConfig, projectPath string) error {
 			continue
 		}
 		vendor := strings.ToLower(m.ModelName)
-		idStr := m.ModelId
-		if idStr == "" {
-			switch vendor {
-			case "deepseek":
-				idStr = "deepseek-chat"
-			case "glm":
-				idStr = "glm-4.7"
-			case "doubao":
-				idStr = "doubao-seed-code-preview-latest"
-			case "kimi":
-				idStr = "kimi-for-coding"
-			case "minimax":
-				idStr = "MiniMax-M2.1"
-			default:
-				idStr = vendor + "-model"
-			}
-		}
-		modelIds := strings.Split(idStr, ",")
-		modelUrl := m.ModelUrl
+		endpoint := getProviderRegistry().Resolve("qoder", &m)
+		modelIds := strings.Split(endpoint.ModelId, ",")
+		modelUrl := endpoint.BaseUrl
 		if modelUrl != "" && !strings.HasSuffix(modelUrl, "/chat/completions") {
 			if strings.HasSuffix(modelUrl, "/") {
 				modelUrl += "chat/completions"
@@ -1173,30 +762,36 @@ func (a *App) syncToQoderSettings(config AppConfig, projectPath string) error {
 	}
 	return os.WriteFile(qFilePath, data, 0644)
 }
+// getBaseUrl returns the Claude base URL for a provider: the user's URL if set, otherwise the registry default.
 func getBaseUrl(selectedModel *ModelConfig) string {
-	// If user provided a URL for the selected model, always prefer it.
-	if selectedModel.ModelUrl != "" {
-		return selectedModel.ModelUrl
-	}
-	// Otherwise, fall back to hardcoded defaults for known providers that have them.
-	baseUrl := "" // Default to empty string
-	switch strings.ToLower(selectedModel.ModelName) {
-	case "kimi":
-		baseUrl = "https://api.kimi.com/coding"
-	case "glm", "glm-4.7":
-		baseUrl = "https://open.bigmodel.cn/api/anthropic"
-	case "doubao":
-		baseUrl = "https://ark.cn-beijing.volces.com/api/coding"
-	case "minimax":
-		baseUrl = "https://api.minimaxi.com/anthropic"
-	case "deepseek":
-		baseUrl = "https://api.deepseek.com/anthropic"
-	case "gaccode":
-		baseUrl = "https://gaccode.com/claudecode"
-	}
-	return baseUrl
+	return getProviderRegistry().Resolve("claude", selectedModel).BaseUrl
 }
+var errNoProviderSelected = errors.New("no provider selected")
 func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonProject bool, pythonEnv string, projectDir string, useProxy bool) {
+	err := a.launchTool(toolName, yoloMode, adminMode, pythonProject, pythonEnv, projectDir, useProxy)
+	if err == errNoProviderSelected {
+		title := "提示"
+		message := "请先选择一个服务商。"
+		if a.CurrentLanguage == "en" {
+			title = "Notice"
+			message = "Please select a provider first."
+		}
+		a.ShowMessage(title, message)
+	} else if err != nil {
+		a.log("Error launching " + toolName + ": " + err.Error())
+	}
+}
+// launchTool prepares the tool config and environment and opens the tool in a terminal
+func (a *App) launchTool(toolName string, yoloMode bool, adminMode bool, pythonProject bool, pythonEnv string, projectDir string, useProxy bool) error {
+	spec, err := a.prepareLaunch(toolName, yoloMode, adminMode, pythonProject, pythonEnv, projectDir, useProxy)
+	if err != nil {
+		return err
+	}
+	a.platformLaunch(spec.BinaryName, spec.YoloMode, spec.AdminMode, spec.PythonEnv, spec.ProjectDir, spec.Env, spec.ModelId)
+	return nil
+}
+// prepareLaunch syncs the tool's config files and builds the environment it is started with
+func (a *App) prepareLaunch(toolName string, yoloMode bool, adminMode bool, pythonProject bool, pythonEnv string, projectDir string, useProxy bool) (*launchSpec, error) {
 	a.log(fmt.Sprintf("LaunchTool called: %s, yolo=%v, admin=%v, py=%v, pyenv=%s, dir=%s, proxy=%v",
 		toolName, yoloMode, adminMode, pythonProject, pythonEnv, projectDir, useProxy))
 	a.log(fmt.Sprintf("Launching %s...", toolName))
@@ -1212,8 +807,25 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 	}
 	config, err := a.LoadConfig()
 	if err != nil {
-		a.log("Error loading config: " + err.Error())
-		return
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	// Project overrides only change this launch, they are never saved
+	project := config.projectForDir(projectDir)
+	if err := config.applyProjectOverrides(project, toolName); err != nil {
+		return nil, err
+	}
+	// Through the gateway the tool gets the gateway token, the gateway picks the key
+	routed, err := a.routeThroughGateway(&config, toolName, project)
+	if err != nil {
+		return nil, err
+	}
+	if !routed {
+		if err := a.selectLaunchKey(&config, toolName); err != nil {
+			return nil, err
+		}
+		if err := a.resolveLaunchKey(&config, toolName); err != nil {
+			return nil, err
+		}
 	}
 	var toolCfg ToolConfig
 	var envKey, envBaseUrl string
@@ -1260,7 +872,7 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 		envBaseUrl = "QODER_BASE_URL"
 		binaryName = "qoder"
 	default:
-		return
+		return nil, fmt.Errorf("unknown tool: %s", toolName)
 	}
 	var selectedModel *ModelConfig
 	for _, m := range toolCfg.Models {
@@ -1270,14 +882,7 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 		}
 	}
 	if selectedModel == nil || toolCfg.CurrentModel == "" {
-		title := "提示"
-        message := "请先选择一个服务商。"
-		if a.CurrentLanguage == "en" {
-			title = "Notice"
-			message = "Please select a provider first."
-		}
-		a.ShowMessage(title, message)
-		return
+		return nil, errNoProviderSelected
 	}
 	// Ensure ActiveTool is set correctly for syncToSystemEnv
 	config.ActiveTool = strings.ToLower(toolName)
@@ -1287,45 +892,7 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 	env := make(map[string]string)
 	// Proxy settings
 	if useProxy && goruntime.GOOS != "windows" {
-		var proxyHost, proxyPort, proxyUsername, proxyPassword string
-		// Get proxy configuration (matching project path > global default)
-		var targetProj *ProjectConfig
-		for i := range config.Projects {
-			if config.Projects[i].Path == projectDir {
-				targetProj = &config.Projects[i]
-				break
-			}
-		}
-		// Fallback to CurrentProject if path match not found
-		if targetProj == nil {
-			for i := range config.Projects {
-				if config.Projects[i].Id == config.CurrentProject {
-					targetProj = &config.Projects[i]
-					break
-				}
-			}
-		}
-		if targetProj != nil {
-			proxyHost = targetProj.ProxyHost
-			proxyPort = targetProj.ProxyPort
-			proxyUsername = targetProj.ProxyUsername
-			proxyPassword = targetProj.ProxyPassword
-		}
-		// Use global default if project not configured
-		if proxyHost == "" {
-			proxyHost = config.DefaultProxyHost
-			proxyPort = config.DefaultProxyPort
-			proxyUsername = config.DefaultProxyUsername
-			proxyPassword = config.DefaultProxyPassword
-		}
-		if proxyHost != "" && proxyPort != "" {
-			var proxyURL string
-			if proxyUsername != "" && proxyPassword != "" {
-				proxyURL = fmt.Sprintf("http://%s:%s@%s:%s",
-					proxyUsername, proxyPassword, proxyHost, proxyPort)
-			} else {
-				proxyURL = fmt.Sprintf("http://%s:%s", proxyHost, proxyPort)
-			}
+		if proxyURL, proxyAddr := config.proxyFor(project); proxyURL != "" {
 			// Set proxy environment variables (both cases for compatibility)
 			os.Setenv("HTTP_PROXY", proxyURL)
 			os.Setenv("HTTPS_PROXY", proxyURL)
@@ -1335,9 +902,20 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 			env["HTTPS_PROXY"] = proxyURL
 			env["http_proxy"] = proxyURL
 			env["https_proxy"] = proxyURL
-			a.log(fmt.Sprintf("Proxy enabled: %s:%s", proxyHost, proxyPort))
+			a.log("Proxy enabled: " + proxyAddr)
 		}
 	}
+	if routed {
+		// The gateway is local, a proxy must not get between it and the tool
+		noProxy := "127.0.0.1,localhost"
+		if existing := os.Getenv("NO_PROXY"); existing != "" {
+			noProxy = existing + "," + noProxy
+		}
+		os.Setenv("NO_PROXY", noProxy)
+		os.Setenv("no_proxy", noProxy)
+		env["NO_PROXY"] = noProxy
+		env["no_proxy"] = noProxy
+	}
 	if strings.ToLower(selectedModel.ModelName) != "original" {
 		// --- OTHER PROVIDER MODE: WRITE CONFIG & SET ENV ---
 		// Set process environment variables
@@ -1347,10 +925,12 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 			os.Setenv(envBaseUrl, selectedModel.ModelUrl)
 			env[envBaseUrl] = selectedModel.ModelUrl
 		}
-		// Add CODEBUDDY_CODE_MAX_OUTPUT_TOKENS for DeepSeek
-		if strings.ToLower(selectedModel.ModelName) == "deepseek" {
-			os.Setenv("CODEBUDDY_CODE_MAX_OUTPUT_TOKENS", "8192")
-			env["CODEBUDDY_CODE_MAX_OUTPUT_TOKENS"] = "8192"
+		// Extra env vars from the provider registry (Claude gets them via settings.json)
+		if strings.ToLower(toolName) != "claude" {
+			for k, v := range getProviderRegistry().Resolve(toolName, selectedModel).Env {
+				os.Setenv(k, v)
+				env[k] = v
+			}
 		}
 		// Set generic model name env var if applicable
 		if selectedModel.ModelId != "" {
@@ -1460,13 +1040,100 @@ func (a *App) LaunchTool(toolName string, yoloMode bool, adminMode bool, pythonP
 		}
 		a.log(fmt.Sprintf("Running %s in Original mode: Custom configurations cleared.", toolName))
 	}
-	// Platform specific launch
-	a.platformLaunch(binaryName, yoloMode, adminMode, pythonEnv, projectDir, env, selectedModel.ModelId)
+	if project != nil {
+		for k, v := range project.Env {
+			os.Setenv(k, v)
+			env[k] = v
+		}
+	}
+	return &launchSpec{
+		ToolName:   strings.ToLower(toolName),
+		BinaryName: binaryName,
+		ProjectDir: projectDir,
+		PythonEnv:  pythonEnv,
+		ModelId:    selectedModel.ModelId,
+		YoloMode:   yoloMode,
+		AdminMode:  adminMode,
+		Env:        env,
+	}, nil
+}
+// projectForDir returns the project configured for dir, or nil
+func (c *AppConfig) projectForDir(dir string) *ProjectConfig {
+	for i := range c.Projects {
+		if c.Projects[i].Path == dir || filepath.Clean(c.Projects[i].Path) == filepath.Clean(dir) {
+			return &c.Projects[i]
+		}
+	}
+	return nil
+}
+// proxyFor returns the proxy URL and host:port to use for a project (matching project
+// path, then the current project, then the global default), or "" when none is set.
+func (c *AppConfig) proxyFor(project *ProjectConfig) (string, string) {
+	// Fallback to CurrentProject if path match not found
+	if project == nil {
+		for i := range c.Projects {
+			if c.Projects[i].Id == c.CurrentProject {
+				project = &c.Projects[i]
+				break
+			}
+		}
+	}
+	var host, port, username, password string
+	if project != nil {
+		host, port, username, password = project.ProxyHost, project.ProxyPort, project.ProxyUsername, project.ProxyPassword
+	}
+	// Use global default if project not configured
+	if host == "" {
+		host, port, username, password = c.DefaultProxyHost, c.DefaultProxyPort, c.DefaultProxyUsername, c.DefaultProxyPassword
+	}
+	if host == "" || port == "" {
+		return "", ""
+	}
+	addr := host + ":" + port
+	if username != "" && password != "" {
+		return fmt.Sprintf("http://%s:%s@%s", username, password, addr), addr
+	}
+	return "http://" + addr, addr
+}
+// defaultToolFor returns the tool to launch in dir: the project's pinned tool, else the active tool
+func (c *AppConfig) defaultToolFor(dir string) string {
+	if project := c.projectForDir(dir); project != nil && project.Tool != "" {
+		return strings.ToLower(project.Tool)
+	}
+	return c.ActiveTool
+}
+// applyProjectOverrides selects the project's pinned provider and model ID for toolName.
+// Resolution order: project override, then the tool's current provider.
+func (c *AppConfig) applyProjectOverrides(project *ProjectConfig, toolName string) error {
+	if project == nil || (project.Tool != "" && !strings.EqualFold(project.Tool, toolName)) {
+		return nil
+	}
+	toolCfg := c.toolConfig(toolName)
+	if toolCfg == nil {
+		return nil
+	}
+	if project.Provider != "" {
+		model := getProviderModel(toolCfg, project.Provider)
+		if model == nil {
+			return fmt.Errorf("project %s pins provider %q, which is not configured for %s", project.Name, project.Provider, toolName)
+		}
+		toolCfg.CurrentModel = model.ModelName
+	}
+	if project.ModelId != "" {
+		if model := getProviderModel(toolCfg, toolCfg.CurrentModel); model != nil {
+			model.ModelId = project.ModelId
+		}
+	}
+	return nil
 }
 func (a *App) log(message string) {
+	message = maskLogSecrets(message)
 	if a.IsInitMode {
 		fmt.Println(message)
 	}
+	if a.logOutput != nil {
+		fmt.Fprintln(a.logOutput, message)
+	}
 	if a.ctx != nil {
 		a.emitEvent("env-log", message)
 	}
@@ -1481,182 +1148,33 @@ func (a *App) getConfigPath() (string, error) {
 	}
 	return filepath.Join(home, ".aicoder_config.json"), nil
 }
-func (a *App) LoadConfig() (AppConfig, error) {
+// loadConfigLocked is LoadConfig without taking the config lock (see config_store.go)
+func (a *App) loadConfigLocked() (AppConfig, error) {
 	path, err := a.getConfigPath()
 	if err != nil {
 		return AppConfig{}, err
 	}
-	// Helper for default models
-	defaultClaudeModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "GLM", ModelId: "glm-4.7", ModelUrl: "https://open.bigmodel.cn/api/anthropic", ApiKey: ""},
-		{ModelName: "Kimi", ModelId: "kimi-k2-thinking", ModelUrl: "https://api.kimi.com/coding", ApiKey: ""},
-		{ModelName: "Doubao", ModelId: "doubao-seed-code-preview-latest", ModelUrl: "https://ark.cn-beijing.volces.com/api/coding", ApiKey: ""},
-		{ModelName: "MiniMax", ModelId: "MiniMax-M2.1", ModelUrl: "https://api.minimaxi.com/anthropic", ApiKey: ""},
-		{ModelName: "DeepSeek", ModelId: "deepseek-chat", ModelUrl: "https://api.deepseek.com/anthropic", ApiKey: ""},
-		{ModelName: "AIgoCode", ModelId: "sonnet", ModelUrl: "https://api.aigocode.com/api", ApiKey: ""},
-		{ModelName: "Noin.AI", ModelId: "sonnet", ModelUrl: "https://ai.ourines.com/api", ApiKey: ""},
-		{ModelName: "AiCodeMirror", ModelId: "sonnet", ModelUrl: "https://api.aicodemirror.com/api/claudecode", ApiKey: ""},
-		{ModelName: "GACCode", ModelId: "sonnet", ModelUrl: "https://gaccode.com/claudecode", ApiKey: ""},
-		{ModelName: "CodeRelay", ModelId: "claude-3-5-sonnet-20241022", ModelUrl: "https://api.code-relay.com/", ApiKey: ""},
-		{ModelName: "ChatFire", ModelId: "sonnet", ModelUrl: "https://api.chatfire.cn", ApiKey: ""},
-		{ModelName: "Custom", ModelId: "", ModelUrl: "", ApiKey: "", IsCustom: true},
-	}
-	defaultGeminiModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "AIgoCode", ModelId: "gemini-2.0-flash-exp", ModelUrl: "https://api.aigocode.com/gemini", ApiKey: ""},
-		{ModelName: "AiCodeMirror", ModelId: "gemini-2.0-flash-exp", ModelUrl: "https://api.aicodemirror.com/api/gemini", ApiKey: ""},
-		{ModelName: "ChatFire", ModelId: "gemini-2.5-pro", ModelUrl: "https://api.chatfire.cn/v1beta/models/gemini-2.5-pro:generateContent", ApiKey: ""},
-		{ModelName: "Custom", ModelId: "", ModelUrl: "", ApiKey: "", IsCustom: true},
-	}
-	defaultCodexModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "AIgoCode", ModelId: "gpt-5.2-codex", ModelUrl: "https://api.aigocode.com/openai", ApiKey: ""},
-		{ModelName: "AiCodeMirror", ModelId: "gpt-5.2-codex", ModelUrl: "https://api.aicodemirror.com/api/codex/backend-api/codex", ApiKey: ""},
-		{ModelName: "CodeRelay", ModelId: "gpt-5.2-codex", ModelUrl: "https://api.code-relay.com/v1", ApiKey: ""},
-		{ModelName: "ChatFire", ModelId: "gpt-5.1-codex-mini", ModelUrl: "https://api.chatfire.cn/v1", ApiKey: "", WireApi: "responses"},
-		{ModelName: "DeepSeek", ModelId: "deepseek-chat", ModelUrl: "https://api.deepseek.com/v1", ApiKey: ""},
-		{ModelName: "GLM", ModelId: "glm-4.7", ModelUrl: "https://open.bigmodel.cn/api/paas/v4", ApiKey: ""},
-		{ModelName: "Doubao", ModelId: "doubao-seed-code-preview-latest", ModelUrl: "https://ark.cn-beijing.volces.com/api/coding/v3", ApiKey: ""},
-		{ModelName: "Kimi", ModelId: "kimi-for-coding", ModelUrl: "https://api.kimi.com/coding/v1", ApiKey: ""},
-		{ModelName: "MiniMax", ModelId: "MiniMax-M2.1", ModelUrl: "https://api.minimaxi.com/v1", ApiKey: ""},
-		{ModelName: "Custom", ModelId: "", ModelUrl: "", ApiKey: "", IsCustom: true},
-	}
-	defaultOpencodeModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "ChatFire", ModelId: "gpt-4o", ModelUrl: "https://api.chatfire.cn/v1", ApiKey: ""},
-		{ModelName: "DeepSeek", ModelId: "deepseek-chat", ModelUrl: "https://api.deepseek.com/v1", ApiKey: ""},
-		{ModelName: "GLM", ModelId: "glm-4.7", ModelUrl: "https://open.bigmodel.cn/api/paas/v4", ApiKey: ""},
-		{ModelName: "Doubao", ModelId: "doubao-seed-code-preview-latest", ModelUrl: "https://ark.cn-beijing.volces.com/api/coding/v3", ApiKey: ""},
-		{ModelName: "Kimi", ModelId: "kimi-for-coding", ModelUrl: "https://api.kimi.com/coding/v1", ApiKey: ""},
-		{ModelName: "MiniMax", ModelId: "MiniMax-M2.1", ModelUrl: "https://api.minimaxi.com/v1", ApiKey: ""},
-		{ModelName: "Custom", ModelId: "", ModelUrl: "", ApiKey: "", IsCustom: true},
-	}
-	defaultQoderModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "Qoder", ModelId: "qoder-1.0", ModelUrl: "https://api.qoder.com/v1", ApiKey: ""},
-	}
-	defaultIFlowModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "DeepSeek", ModelId: "deepseek-chat", ModelUrl: "https://api.deepseek.com/v1", ApiKey: ""},
-		{ModelName: "GLM", ModelId: "glm-4.7", ModelUrl: "https://open.bigmodel.cn/api/paas/v4", ApiKey: ""},
-		{ModelName: "Doubao", ModelId: "doubao-seed-code-preview-latest", ModelUrl: "https://ark.cn-beijing.volces.com/api/coding/v3", ApiKey: ""},
-		{ModelName: "Kimi", ModelId: "kimi-for-coding", ModelUrl: "https://api.kimi.com/coding/v1", ApiKey: ""},
-		{ModelName: "MiniMax", ModelId: "MiniMax-M2.1", ModelUrl: "https://api.minimaxi.com/v1", ApiKey: ""},
-		{ModelName: "XiaoMi", ModelId: "mimo-v2-flash", ModelUrl: "https://api.xiaomimimo.com/v1", ApiKey: ""},
-		{ModelName: "Custom", ModelId: "", ModelUrl: "", ApiKey: "", IsCustom: true},
-	}
-	defaultKiloModels := []ModelConfig{
-		{ModelName: "Original", ModelId: "", ModelUrl: "", ApiKey: ""},
-		{ModelName: "AiCodeMirror", ModelId: "sonnet", ModelUrl: "https://api.aicodemirror.com/api/kilo", ApiKey: ""},
-		{ModelName: "ChatFire", ModelId: "gpt-4o", ModelUrl: "https://api.chatfire.cn/v1", ApiKey: ""},
-		{ModelName: "DeepSeek", ModelId: "deepseek-chat", ModelUrl: "https://api.deepseek.com/v1", ApiKey: ""},
-		{ModelName: "GLM", ModelId: "glm-4.7", ModelUrl: "https://open.bigmodel.cn/api/paas/v4", ApiKey: ""},
-		{ModelName: "Doubao", ModelId: "doubao-seed-code-preview-latest", ModelUrl: "https://ark.cn-beijing.volces.com/api/coding/v3", ApiKey: ""},
-		{ModelName: "Kimi", ModelId: "kimi-for-coding", ModelUrl: "https://api.kimi.com/coding/v1", ApiKey: ""},
-		{ModelName: "MiniMax", ModelId: "MiniMax-M2.1", ModelUrl: "https://api.minimaxi.com/v1", ApiKey: ""},
-		{ModelName: "XiaoMi", ModelId: "mimo-v2-flash", ModelUrl: "https://api.xiaomimimo.com/v1", ApiKey: ""},
-		{ModelName: "Custom", ModelId: "", ModelUrl: "", ApiKey: "", IsCustom: true},
-	}
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// Check for old config file for migration
+	registry := getProviderRegistry()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Carry over the config of the Claude-only predecessor
 		home, _ := os.UserHomeDir()
-		oldPath := filepath.Join(home, ".claude_model_config.json")
-		if _, err := os.Stat(oldPath); err == nil {
-			// Migrate old config
-			data, err := os.ReadFile(oldPath)
-			if err == nil {
-				var oldConfig struct {
-					CurrentModel string          `json:"current_model"`
-					Models       []ModelConfig   `json:"models"`
-					Projects     []ProjectConfig `json:"projects"`
-					CurrentProj  string          `json:"current_project"`
-				}
-				if err := json.Unmarshal(data, &oldConfig); err == nil {
-					config := AppConfig{
-						Claude: ToolConfig{
-							CurrentModel: oldConfig.CurrentModel,
-							Models:       oldConfig.Models,
-						},
-						Gemini: ToolConfig{
-							CurrentModel: "Gemini 1.5 Pro",
-							Models:       defaultGeminiModels,
-						},
-						Codex: ToolConfig{
-							CurrentModel: "Codex",
-							Models:       defaultCodexModels,
-						},
-						Opencode: ToolConfig{
-							CurrentModel: "AiCodeMirror",
-							Models:       defaultOpencodeModels,
-						},
-						CodeBuddy: ToolConfig{
-							CurrentModel: "AiCodeMirror",
-							Models:       defaultOpencodeModels,
-						},
-						Qoder: ToolConfig{
-							CurrentModel: "Original",
-							Models:       defaultQoderModels,
-						},
-						IFlow: ToolConfig{
-							CurrentModel: "Original",
-							Models:       defaultIFlowModels,
-						},
-						Kilo: ToolConfig{
-							CurrentModel: "AiCodeMirror",
-							Models:       defaultKiloModels,
-						},
-						Projects:       oldConfig.Projects,
-						CurrentProject: oldConfig.CurrentProj,
-						ActiveTool:     "claude",
-						ShowGemini:     true,
-						ShowCodex:      true,
-						ShowOpenCode:   true,
-						ShowCodeBuddy:  true,
-						ShowQoder:      true,
-						ShowIFlow:      true,
-						ShowKilo:       true,
+		if oldData, err := os.ReadFile(filepath.Join(home, ".claude_model_config.json")); err == nil {
+			if doc, err := legacyModelConfigDocument(oldData); err == nil {
+				if _, err := upgradeConfigDocument(doc); err == nil {
+					if data, err := json.Marshal(doc); err == nil {
+						var config AppConfig
+						if err := json.Unmarshal(data, &config); err == nil {
+							normalizeConfig(&config, registry)
+							saved, _ := a.saveConfigLocked(config)
+							return saved, nil
+						}
 					}
-					a.SaveConfig(config)
-					// Optional: os.Remove(oldPath)
-					return config, nil
 				}
 			}
 		}
 		// Create default config
 		defaultConfig := AppConfig{
-			Claude: ToolConfig{
-				CurrentModel: "GLM",
-				Models:       defaultClaudeModels,
-			},
-			Gemini: ToolConfig{
-				CurrentModel: "Gemini 1.5 Pro",
-				Models:       defaultGeminiModels,
-			},
-			Codex: ToolConfig{
-				CurrentModel: "Codex",
-				Models:       defaultCodexModels,
-			},
-			Opencode: ToolConfig{
-				CurrentModel: "AiCodeMirror",
-				Models:       defaultOpencodeModels,
-			},
-			CodeBuddy: ToolConfig{
-				CurrentModel: "AiCodeMirror",
-				Models:       defaultOpencodeModels,
-			},
-			Qoder: ToolConfig{
-				CurrentModel: "Original",
-				Models:       defaultQoderModels,
-			},
-			IFlow: ToolConfig{
-				CurrentModel: "Original",
-				Models:       defaultIFlowModels,
-			},
-			Kilo: ToolConfig{
-				CurrentModel: "AiCodeMirror",
-				Models:       defaultKiloModels,
-			},
 			Projects: []ProjectConfig{
 				{
 					Id:       "default",
@@ -1676,347 +1194,34 @@ func (a *App) LoadConfig() (AppConfig, error) {
 			ShowKilo:         true,
 			EnvCheckInterval: 7, // Default to 7 days
 		}
-		err = a.SaveConfig(defaultConfig)
-		return defaultConfig, err
+		normalizeConfig(&defaultConfig, registry)
+		return a.saveConfigLocked(defaultConfig)
 	}
-	config := AppConfig{
-		ShowGemini:    true,
-		ShowCodex:     true,
-		ShowOpenCode:  true,
-		ShowCodeBuddy: true,
-		ShowQoder:     true,
-		ShowIFlow:     true,
-		ShowKilo:      true,
-	}
-	data, err := os.ReadFile(path)
 	if err != nil {
-		return config, err
-	}
-
-	// Check if this is an old config without the new show_* fields
-	// If show_kilo is not present in JSON, default it to true
-	var rawConfig map[string]interface{}
-	json.Unmarshal(data, &rawConfig)
-	hasShowKilo := false
-	if _, ok := rawConfig["show_kilo"]; ok {
-		hasShowKilo = true
+		return AppConfig{}, err
 	}
-
-	err = json.Unmarshal(data, &config)
+	config, migrated, err := decodeConfigFile(path, data)
 	if err != nil {
 		return config, err
 	}
-
-	// Set default values for new fields if not present in old configs
-	if !hasShowKilo {
-		config.ShowKilo = true
-	}
-
-	// Set default values for new fields if not present or invalid
-	if config.EnvCheckInterval < 2 || config.EnvCheckInterval > 30 {
-		config.EnvCheckInterval = 7 // Default to 7 days
-	}
-	// Ensure defaults for new fields
-	if config.Claude.CurrentModel == "" && len(config.Claude.Models) > 0 {
-		config.Claude.CurrentModel = config.Claude.Models[0].ModelName
-	}
-	// Helper to ensure a model exists in the list
-	ensureModel := func(models *[]ModelConfig, name, url, id, wireApi string) {
-		for i := range *models {
-			if strings.EqualFold((*models)[i].ModelName, name) {
-				(*models)[i].ModelName = name // Update to canonical casing
-				if url != "" {
-					(*models)[i].ModelUrl = url
-				}
-				if id != "" {
-					(*models)[i].ModelId = id
-				}
-				if wireApi != "" {
-					(*models)[i].WireApi = wireApi
-				}
-				return
-			}
-		}
-		*models = append(*models, ModelConfig{ModelName: name, ModelUrl: url, ModelId: id, WireApi: wireApi, ApiKey: ""})
-	}
-	if config.Gemini.Models == nil || len(config.Gemini.Models) == 0 {
-		config.Gemini.Models = defaultGeminiModels
-		config.Gemini.CurrentModel = "AiCodeMirror"
-	}
-	if config.Codex.Models == nil || len(config.Codex.Models) == 0 {
-		config.Codex.Models = defaultCodexModels
-		config.Codex.CurrentModel = "AiCodeMirror"
-	}
-	if config.Opencode.Models == nil || len(config.Opencode.Models) == 0 {
-		config.Opencode.Models = defaultOpencodeModels
-		config.Opencode.CurrentModel = "AiCodeMirror"
-	}
-	if config.CodeBuddy.Models == nil || len(config.CodeBuddy.Models) == 0 {
-		config.CodeBuddy.Models = defaultOpencodeModels
-		config.CodeBuddy.CurrentModel = "AiCodeMirror"
-	}
-	if config.Qoder.Models == nil || len(config.Qoder.Models) == 0 {
-		config.Qoder.Models = defaultQoderModels
-		config.Qoder.CurrentModel = "Original"
-	}
-	if config.IFlow.Models == nil || len(config.IFlow.Models) == 0 {
-		config.IFlow.Models = defaultIFlowModels
-		config.IFlow.CurrentModel = "Original"
-	}
-	if config.Kilo.Models == nil || len(config.Kilo.Models) == 0 {
-		config.Kilo.Models = defaultKiloModels
-		config.Kilo.CurrentModel = "AiCodeMirror"
-	}
-	ensureModel(&config.Claude.Models, "AiCodeMirror", "https://api.aicodemirror.com/api/claudecode", "sonnet", "")
-	ensureModel(&config.Claude.Models, "Noin.AI", "https://ai.ourines.com/api", "sonnet", "")
-	ensureModel(&config.Claude.Models, "AIgoCode", "https://api.aigocode.com/api", "sonnet", "")
-	ensureModel(&config.Claude.Models, "CodeRelay", "https://api.code-relay.com/", "claude-3-5-sonnet-20241022", "")
-	ensureModel(&config.Claude.Models, "ChatFire", "https://api.chatfire.cn", "sonnet", "")
-	ensureModel(&config.Claude.Models, "GACCode", "https://gaccode.com/claudecode", "sonnet", "")
-	ensureModel(&config.Claude.Models, "DeepSeek", "https://api.deepseek.com/anthropic", "deepseek-chat", "")
-	ensureModel(&config.Claude.Models, "Kimi", "https://api.kimi.com/coding", "kimi-k2-thinking", "")
-	ensureModel(&config.Claude.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.Claude.Models, "GLM", "https://open.bigmodel.cn/api/anthropic", "glm-4.7", "")
-	ensureModel(&config.Claude.Models, "MiniMax", "https://api.minimaxi.com/anthropic", "MiniMax-M2.1", "")
-	ensureModel(&config.Claude.Models, "XiaoMi", "https://api.xiaomimimo.com/anthropic", "mimo-v2-flash", "")
-	// Deduplicate AiCodeMirror for Claude if both AICodeMirror and AiCodeMirror exist
-	dedupeAiCodeMirror := func(models *[]ModelConfig) {
-		var newModels []ModelConfig
-		foundAi := false
-		for _, m := range *models {
-			if strings.EqualFold(m.ModelName, "AiCodeMirror") {
-				if !foundAi {
-					m.ModelName = "AiCodeMirror" // Standardize
-					newModels = append(newModels, m)
-					foundAi = true
-				}
-			} else {
-				newModels = append(newModels, m)
-			}
-		}
-		*models = newModels
-	}
-	dedupeAiCodeMirror(&config.Claude.Models)
-	ensureModel(&config.Gemini.Models, "AiCodeMirror", "https://api.aicodemirror.com/api/gemini", "gemini-2.0-flash-exp", "")
-	ensureModel(&config.Gemini.Models, "ChatFire", "https://api.chatfire.cn/v1beta/models/gemini-2.5-pro:generateContent", "gemini-2.5-pro", "")
-	ensureModel(&config.Codex.Models, "AiCodeMirror", "https://api.aicodemirror.com/api/codex/backend-api/codex", "gpt-5.2-codex", "responses")
-	ensureModel(&config.Codex.Models, "CodeRelay", "https://api.code-relay.com/v1", "gpt-5.2-codex", "responses")
-	ensureModel(&config.Codex.Models, "ChatFire", "https://api.chatfire.cn/v1", "gpt-5.1-codex-mini", "responses")
-	ensureModel(&config.Codex.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.Codex.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.Codex.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.Codex.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.Codex.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.Codex.Models, "XiaoMi", "https://api.xiaomimimo.com/v1", "mimo-v2-flash", "")
-	ensureModel(&config.Opencode.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.Opencode.Models, "ChatFire", "https://api.chatfire.cn/v1", "gpt-4o", "")
-	ensureModel(&config.Opencode.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.Opencode.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.Opencode.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.Opencode.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.Opencode.Models, "XiaoMi", "https://api.xiaomimimo.com/v1", "mimo-v2-flash", "")
-	ensureModel(&config.CodeBuddy.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.CodeBuddy.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.CodeBuddy.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.CodeBuddy.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.CodeBuddy.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.CodeBuddy.Models, "XiaoMi", "https://api.xiaomimimo.com/v1", "mimo-v2-flash", "")
-	ensureModel(&config.Codex.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.Codex.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.Codex.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.Codex.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.Opencode.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.Opencode.Models, "ChatFire", "https://api.chatfire.cn/v1", "gpt-4o", "")
-	ensureModel(&config.Opencode.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.Opencode.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.Opencode.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.Opencode.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.CodeBuddy.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.CodeBuddy.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.CodeBuddy.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.CodeBuddy.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.CodeBuddy.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.IFlow.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.IFlow.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.IFlow.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.IFlow.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.IFlow.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.IFlow.Models, "XiaoMi", "https://api.xiaomimimo.com/v1", "mimo-v2-flash", "")
-	ensureModel(&config.Kilo.Models, "AiCodeMirror", "https://api.aicodemirror.com/api/kilo", "sonnet", "")
-	ensureModel(&config.Kilo.Models, "ChatFire", "https://api.chatfire.cn/v1", "gpt-4o", "")
-	ensureModel(&config.Kilo.Models, "DeepSeek", "https://api.deepseek.com/v1", "deepseek-chat", "")
-	ensureModel(&config.Kilo.Models, "GLM", "https://open.bigmodel.cn/api/paas/v4", "glm-4.7", "")
-	ensureModel(&config.Kilo.Models, "Doubao", "https://ark.cn-beijing.volces.com/api/coding/v3", "doubao-seed-code-preview-latest", "")
-	ensureModel(&config.Kilo.Models, "Kimi", "https://api.kimi.com/coding/v1", "kimi-for-coding", "")
-	ensureModel(&config.Kilo.Models, "MiniMax", "https://api.minimaxi.com/v1", "MiniMax-M2.1", "")
-	ensureModel(&config.Kilo.Models, "XiaoMi", "https://api.xiaomimimo.com/v1", "mimo-v2-flash", "")
-	// Ensure 'Original' is always present and first
-	ensureOriginal := func(models *[]ModelConfig) {
-		found := false
-		for _, m := range *models {
-			if m.ModelName == "Original" {
-				found = true
-				break
-			}
-		}
-		if !found {
-			*models = append([]ModelConfig{{ModelName: "Original", ModelUrl: "", ApiKey: ""}}, *models...)
-		}
-	}
-	// Opencode does NOT use common relay providers
-	cleanOpencodeModels := func(models *[]ModelConfig) {
-		var newModels []ModelConfig
-		for _, m := range *models {
-			name := strings.ToLower(m.ModelName)
-			if name != "aigocode" && name != "aicodemirror" && name != "coderelay" && name != "chatfire" {
-				newModels = append(newModels, m)
-			}
-		}
-		*models = newModels
-	}
-	ensureOriginal(&config.Claude.Models)
-	ensureOriginal(&config.Gemini.Models)
-	ensureOriginal(&config.Codex.Models)
-	ensureOriginal(&config.Opencode.Models)
-	ensureOriginal(&config.CodeBuddy.Models)
-	ensureOriginal(&config.Qoder.Models)
-	ensureOriginal(&config.IFlow.Models)
-	ensureOriginal(&config.Kilo.Models)
-	cleanOpencodeModels(&config.Opencode.Models)
-	cleanOpencodeModels(&config.CodeBuddy.Models)
-	cleanOpencodeModels(&config.IFlow.Models)
-	// Ensure 'Custom' is always present
-	ensureCustom := func(models *[]ModelConfig) {
-		found := false
-		for _, m := range *models {
-			if m.ModelName == "Custom" || m.IsCustom {
-				found = true
-				break
-			}
-		}
-		if !found {
-			*models = append(*models, ModelConfig{ModelName: "Custom", ModelUrl: "", ApiKey: "", IsCustom: true})
-		}
-	}
-	ensureCustom(&config.Claude.Models)
-	ensureCustom(&config.Gemini.Models)
-	ensureCustom(&config.Codex.Models)
-	ensureCustom(&config.Opencode.Models)
-	ensureCustom(&config.CodeBuddy.Models)
-	ensureCustom(&config.IFlow.Models)
-	ensureCustom(&config.Kilo.Models)
-	// Qoder only has Original and Qoder
-	// Preserve existing Qoder key if present
-	var existingQoderKey string
-	for _, m := range config.Qoder.Models {
-		if m.ModelName == "Qoder" {
-			existingQoderKey = m.ApiKey
-			break
-		}
-	}
-	config.Qoder.Models = defaultQoderModels
-	if existingQoderKey != "" {
-		for i := range config.Qoder.Models {
-			if config.Qoder.Models[i].ModelName == "Qoder" {
-				config.Qoder.Models[i].ApiKey = existingQoderKey
-				break
-			}
-		}
-	}
-	// Ensure 'Custom' is always last for all tools
-	moveCustomToLast := func(models *[]ModelConfig) {
-		var customModel *ModelConfig
-		var newModels []ModelConfig
-		for _, m := range *models {
-			if m.IsCustom || m.ModelName == "Custom" {
-				m.IsCustom = true // Ensure flag is set
-				customModel = &m
-			} else {
-				newModels = append(newModels, m)
-			}
+	normalizeConfig(&config, registry)
+	if migrated {
+		if err := a.saveToPath(path, config); err != nil {
+			return config, err
 		}
-		if customModel != nil {
-			newModels = append(newModels, *customModel)
-		}
-		*models = newModels
+		a.log(fmt.Sprintf("Migrated %s to schema version %d", path, currentConfigSchema))
 	}
-	// Ensure 'Original' is always first for all tools
-	ensureOriginalFirst := func(models *[]ModelConfig) {
-		var originalModel *ModelConfig
-		var newModels []ModelConfig
-		for i := range *models {
-			m := &(*models)[i]
-			if m.ModelName == "Original" {
-				originalModel = m
-			} else {
-				newModels = append(newModels, *m)
-			}
-		}
-		if originalModel != nil {
-			*models = append([]ModelConfig{*originalModel}, newModels...)
-		}
-	}
-	moveCustomToLast(&config.Claude.Models)
-	moveCustomToLast(&config.Gemini.Models)
-	moveCustomToLast(&config.Codex.Models)
-	moveCustomToLast(&config.Opencode.Models)
-	moveCustomToLast(&config.CodeBuddy.Models)
-	moveCustomToLast(&config.IFlow.Models)
-	moveCustomToLast(&config.Kilo.Models)
-	ensureOriginalFirst(&config.Claude.Models)
-	ensureOriginalFirst(&config.Gemini.Models)
-	ensureOriginalFirst(&config.Codex.Models)
-	ensureOriginalFirst(&config.Opencode.Models)
-	ensureOriginalFirst(&config.CodeBuddy.Models)
-	ensureOriginalFirst(&config.Qoder.Models)
-	ensureOriginalFirst(&config.IFlow.Models)
-	ensureOriginalFirst(&config.Kilo.Models)
-	// Ensure CurrentModel is valid
-	if config.Gemini.CurrentModel == "" {
-		config.Gemini.CurrentModel = "Original"
-	}
-	if config.Codex.CurrentModel == "" {
-		config.Codex.CurrentModel = "Original"
-	}
-	if config.Opencode.CurrentModel == "" {
-		config.Opencode.CurrentModel = "Original"
-	}
-	if config.CodeBuddy.CurrentModel == "" {
-		config.CodeBuddy.CurrentModel = "Original"
-	}
-	if config.Qoder.CurrentModel == "" {
-		config.Qoder.CurrentModel = "Original"
-	}
-	if config.IFlow.CurrentModel == "" {
-		config.IFlow.CurrentModel = "Original"
-	}
-	if config.Kilo.Models == nil || len(config.Kilo.Models) == 0 {
-		config.Kilo.Models = defaultKiloModels
-		config.Kilo.CurrentModel = "AiCodeMirror"
-	}
-	if config.Kilo.CurrentModel == "" {
-		config.Kilo.CurrentModel = "AiCodeMirror"
-	}
-	if config.ActiveTool == "" {
-		config.ActiveTool = "message"
-	}
-	// Normalize CurrentModel casing for all tools
-	normalizeCurrentModel := func(toolCfg *ToolConfig) {
-		for _, m := range toolCfg.Models {
-			if strings.EqualFold(m.ModelName, toolCfg.CurrentModel) {
-				toolCfg.CurrentModel = m.ModelName
-				break
+	// The config may hold proxy passwords and API keys, keep it private
+	os.Chmod(path, 0600)
+	// Move plaintext secrets of older configs into the secret store
+	if hasPlaintextSecrets(&config) {
+		if stored, err := a.protectSecrets(config, nil); err == nil && !hasPlaintextSecrets(&stored) {
+			if err := a.saveToPath(path, stored); err == nil {
+				a.log("Moved API keys and passwords from the config file into the secret store")
 			}
 		}
 	}
-	normalizeCurrentModel(&config.Claude)
-	normalizeCurrentModel(&config.Gemini)
-	normalizeCurrentModel(&config.Codex)
-	normalizeCurrentModel(&config.Opencode)
-	normalizeCurrentModel(&config.CodeBuddy)
-	normalizeCurrentModel(&config.Qoder)
-	normalizeCurrentModel(&config.IFlow)
-	normalizeCurrentModel(&config.Kilo)
+	a.resolveSecrets(&config)
 	return config, nil
 }
 // getProviderModel gets the model for a specific provider name from a tool config
@@ -2028,85 +1233,9 @@ func getProviderModel(toolConfig *ToolConfig, providerName string) *ModelConfig
 	}
 	return nil
 }
-// syncAllProviderApiKeys synchronizes apikeys of all providers (except 'Original' and 'Custom') across all tools
-func syncAllProviderApiKeys(a *App, oldConfig, newConfig *AppConfig) {
-	// Map of tools for easy access
-	tools := map[string]*ToolConfig{
-		"claude":    &newConfig.Claude,
-		"gemini":    &newConfig.Gemini,
-		"codex":     &newConfig.Codex,
-		"opencode":  &newConfig.Opencode,
-		"codebuddy": &newConfig.CodeBuddy,
-		"qoder":     &newConfig.Qoder,
-		"iflow":     &newConfig.IFlow,
-	}
-	oldTools := map[string]*ToolConfig{
-		"claude":    &oldConfig.Claude,
-		"gemini":    &oldConfig.Gemini,
-		"codex":     &oldConfig.Codex,
-		"opencode":  &oldConfig.Opencode,
-		"codebuddy": &oldConfig.CodeBuddy,
-		"qoder":     &oldConfig.Qoder,
-		"iflow":     &oldConfig.IFlow,
-	}
-	// providerName (lower) -> intended API key
-	intentions := make(map[string]string)
-	activeToolName := strings.ToLower(newConfig.ActiveTool)
-	// 1. Detect Intent from Active Tool (Highest Priority)
-	if activeTool, ok := tools[activeToolName]; ok {
-		oldActive := oldTools[activeToolName]
-		if oldActive != nil {
-			for _, m := range activeTool.Models {
-				if strings.EqualFold(m.ModelName, "Original") || strings.EqualFold(m.ModelName, "Custom") || m.IsCustom {
-					continue
-				}
-				oldM := getProviderModel(oldActive, m.ModelName)
-				// If key changed or a new key was added where none existed
-				if (oldM != nil && m.ApiKey != oldM.ApiKey) || (oldM == nil && m.ApiKey != "") {
-					intentions[strings.ToLower(m.ModelName)] = m.ApiKey
-					a.log(fmt.Sprintf("Sync: detected %s intent from active tool %s", m.ModelName, activeToolName))
-				}
-			}
-		}
-	}
-	// 2. Detect Intent from other tools (if not already captured from active tool)
-	for name, tool := range tools {
-		if name == activeToolName {
-			continue
-		}
-		oldTool := oldTools[name]
-		if oldTool == nil {
-			continue
-		}
-		for _, m := range tool.Models {
-			if strings.EqualFold(m.ModelName, "Original") || strings.EqualFold(m.ModelName, "Custom") || m.IsCustom {
-				continue
-			}
-			lowerName := strings.ToLower(m.ModelName)
-			if _, handled := intentions[lowerName]; handled {
-				continue
-			}
-			oldM := getProviderModel(oldTool, m.ModelName)
-			if (oldM != nil && m.ApiKey != oldM.ApiKey) || (oldM == nil && m.ApiKey != "") {
-				intentions[lowerName] = m.ApiKey
-				a.log(fmt.Sprintf("Sync: detected %s intent from tool %s", m.ModelName, name))
-			}
-		}
-	}
-	// 3. Propagate all intentions to ALL tools
-	for providerLower, targetKey := range intentions {
-		for _, tool := range tools {
-			for i := range tool.Models {
-				if strings.ToLower(tool.Models[i].ModelName) == providerLower {
-					if tool.Models[i].ApiKey != targetKey {
-						tool.Models[i].ApiKey = targetKey
-					}
-				}
-			}
-		}
-	}
-}
-func (a *App) SaveConfig(config AppConfig) error {
+// saveConfigLocked is SaveConfig without taking the config lock or notifying listeners.
+// It returns the config as saved, after sanitizing and syncing key groups.
+func (a *App) saveConfigLocked(config AppConfig) (AppConfig, error) {
 	// Sanitize: Ensure Custom models have a name (prevent empty tab button)
 	sanitizeCustomNames := func(models []ModelConfig) {
 		for i := range models {
@@ -2122,28 +1251,43 @@ func (a *App) SaveConfig(config AppConfig) error {
 	sanitizeCustomNames(config.CodeBuddy.Models)
 	sanitizeCustomNames(config.Qoder.Models)
 	sanitizeCustomNames(config.IFlow.Models)
+	syncPrimaryKeys(&config)
+	path, _ := a.getConfigPath()
+	if err := checkConfigWritable(path); err != nil {
+		return config, err
+	}
 	// Load old config to compare for sync logic
 	var oldConfig AppConfig
-	path, _ := a.getConfigPath()
 	if data, err := os.ReadFile(path); err == nil {
 		json.Unmarshal(data, &oldConfig)
 	}
-	// Sync all apikeys across all tools before saving
-	syncAllProviderApiKeys(a, &oldConfig, &config)
-	if err := a.saveToPath(path, config); err != nil {
-		return err
+	oldRefs := secretRefs(&oldConfig)
+	a.resolveSecrets(&oldConfig)
+	// Copy changed keys to the other members of their key group
+	for _, change := range syncKeyGroups(&oldConfig, &config) {
+		a.log(fmt.Sprintf("Sync: %s/%s takes the key of %s (key group %s)", change.Tool, change.Provider, change.Source, change.Group))
 	}
-	if OnConfigChanged != nil {
-		OnConfigChanged(config)
+	if errs := newValidationErrors(&oldConfig, &config, getProviderRegistry()); len(errs) > 0 {
+		return config, ConfigValidationError{Issues: errs}
 	}
-	return nil
+	// Only references to the secrets are written to disk
+	stored, err := a.protectSecrets(config, oldRefs)
+	if err != nil {
+		return config, err
+	}
+	return config, a.saveToPath(path, stored)
 }
 func (a *App) saveToPath(path string, config AppConfig) error {
+	config.SchemaVersion = currentConfigSchema
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+	a.rememberConfigWrite(data)
+	return nil
 }
 type UpdateResult struct {
 	HasUpdate     bool   `json:"has_update"`
@@ -2468,23 +1612,40 @@ func (a *App) RecoverCC() error {
 		a.emitRecoverLog(fmt.Sprintf("Error getting home dir: %v", err))
 		return err
 	}
-	// Remove ~/.claude directory
+	// Snapshot everything we are about to delete so the recovery can be undone
 	claudeDir := filepath.Join(home, ".claude")
+	backupId, skipped, err := snapshotConfig("claude", "recover", []string{claudeDir, filepath.Join(home, ".claude.json"), filepath.Join(home, ".claude.json.backup")})
+	if err != nil {
+		a.emitRecoverLog(fmt.Sprintf("Failed to back up Claude configuration: %v", err))
+		return fmt.Errorf("failed to back up Claude configuration: %w", err)
+	}
+	a.emitRecoverLog(fmt.Sprintf("Backed up Claude configuration (backup %s).", backupId))
+	// Files too large for the backup could not be brought back, so they are kept
+	var keep []string
+	for _, path := range skipped {
+		keep = append(keep, expandHome(path))
+		a.emitRecoverLog(fmt.Sprintf("Keeping %s, it is too large to back up.", path))
+	}
+	// Remove ~/.claude directory
 	a.emitRecoverLog(fmt.Sprintf("Checking directory: %s", claudeDir))
 	if _, err := os.Stat(claudeDir); !os.IsNotExist(err) {
 		a.emitRecoverLog("Found .claude directory. Removing...")
-		if err := os.RemoveAll(claudeDir); err != nil {
+		if err := removeAllExcept(claudeDir, keep); err != nil {
 			a.emitRecoverLog(fmt.Sprintf("Failed to remove .claude directory: %v", err))
 			return fmt.Errorf("failed to remove .claude directory: %w", err)
 		}
-		a.emitRecoverLog("Successfully removed .claude directory.")
+		if len(keep) > 0 {
+			a.emitRecoverLog("Removed .claude directory except the files kept above.")
+		} else {
+			a.emitRecoverLog("Successfully removed .claude directory.")
+		}
 	} else {
 		a.emitRecoverLog(".claude directory not found, skipping.")
 	}
 	// Remove ~/.claude.json file
 	claudeJsonPath := filepath.Join(home, ".claude.json")
 	a.emitRecoverLog(fmt.Sprintf("Checking file: %s", claudeJsonPath))
-	if _, err := os.Stat(claudeJsonPath); !os.IsNotExist(err) {
+	if _, err := os.Stat(claudeJsonPath); !os.IsNotExist(err) && !containsPath(keep, claudeJsonPath) {
 		a.emitRecoverLog("Found .claude.json file. Removing...")
 		if err := os.Remove(claudeJsonPath); err != nil && !os.IsNotExist(err) {
 			a.emitRecoverLog(fmt.Sprintf("Failed to remove .claude.json file: %v", err))
@@ -2497,7 +1658,7 @@ func (a *App) RecoverCC() error {
 	// Remove ~/.claude.json.backup file
 	claudeJsonBackupPath := filepath.Join(home, ".claude.json.backup")
 	a.emitRecoverLog(fmt.Sprintf("Checking file: %s", claudeJsonBackupPath))
-	if _, err := os.Stat(claudeJsonBackupPath); !os.IsNotExist(err) {
+	if _, err := os.Stat(claudeJsonBackupPath); !os.IsNotExist(err) && !containsPath(keep, claudeJsonBackupPath) {
 		a.emitRecoverLog("Found .claude.json.backup file. Removing...")
 		if err := os.Remove(claudeJsonBackupPath); err != nil && !os.IsNotExist(err) {
 			a.emitRecoverLog(fmt.Sprintf("Failed to remove .claude.json.backup file: %v", err))
@@ -2514,6 +1675,11 @@ func (a *App) emitRecoverLog(msg string) {
 	a.emitEvent("recover-log", msg)
 }
 func (a *App) ShowMessage(title, message string) {
+	if a.ctx == nil {
+		// Headless (CLI) mode has no window to show a dialog in
+		fmt.Fprintf(os.Stderr, "%s: %s\n", title, message)
+		return
+	}
 	runtime.MessageDialog(a.ctx, runtime.MessageDialogOptions{
 		Type:    runtime.InfoDialog,
 		Title:   title,
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Before AICoder touches a tool's config files it copies them into a zip archive under
+// ~/.cceasy/backups/<tool>/<id>.zip, so a bad provider switch can be rolled back.
+
+const (
+	maxConfigBackups   = 30                  // Per tool
+	minConfigBackups   = 5                   // Kept regardless of age
+	configBackupMaxAge = 30 * 24 * time.Hour // Older snapshots are pruned
+	maxBackupFileSize  = 20 << 20            // Larger files are skipped and listed in the manifest
+	backupManifestName = "manifest.json"
+	backupIdLayout     = "20060102-150405.000"
+)
+
+type ConfigBackup struct {
+	Id      string   `json:"id"`
+	Tool    string   `json:"tool"`
+	Created string   `json:"created"` // RFC3339
+	Reason  string   `json:"reason"`
+	Files   []string `json:"files"`
+	Skipped []string `json:"skipped,omitempty"` // Too large to back up, a restore leaves them as they are
+	Size    int64    `json:"size"`
+}
+
+type ConfigBackupDiff struct {
+	Path   string `json:"path"`
+	Status string `json:"status"` // "unchanged", "modified", "added" (created since the backup) or "removed"
+	Diff   string `json:"diff"`   // Unified diff from the backup to the current content
+}
+
+type backupManifest struct {
+	Tool    string        `json:"tool"`
+	Created time.Time     `json:"created"`
+	Reason  string        `json:"reason"`
+	Hash    string        `json:"hash"`
+	Files   []backupEntry `json:"files"`
+	Skipped []string      `json:"skipped,omitempty"` // Files over maxBackupFileSize
+}
+
+type backupEntry struct {
+	Path    string      `json:"path"` // Home directory is written as "~"
+	Exists  bool        `json:"exists"`
+	Archive string      `json:"archive,omitempty"` // Zip entry holding the content
+	Mode    fs.FileMode `json:"mode,omitempty"`
+}
+
+var (
+	backupMutex     sync.Mutex
+	backupIdPattern = regexp.MustCompile(`^[0-9A-Za-z._-]+$`)
+)
+
+func getBackupDir(tool string) (string, error) {
+	if !isSupportedTool(tool) {
+		return "", fmt.Errorf("unknown tool: %s", tool)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".cceasy", "backups", strings.ToLower(tool)), nil
+}
+
+func isSupportedTool(tool string) bool {
+	for _, t := range supportedTools {
+		if strings.EqualFold(t, tool) {
+			return true
+		}
+	}
+	return false
+}
+
+// configBackupPaths lists the files AICoder writes for a tool.
+func (a *App) configBackupPaths(tool, projectPath string) []string {
+	switch strings.ToLower(tool) {
+	case "claude":
+		_, settings, legacy := a.getClaudeConfigPaths()
+		return []string{settings, legacy}
+	case "gemini":
+		_, settings, legacy := a.getGeminiConfigPaths()
+		return []string{settings, legacy}
+	case "codex":
+		dir, auth := a.getCodexConfigPaths()
+		return []string{filepath.Join(dir, "config.toml"), auth}
+	case "opencode":
+		_, config := a.getOpencodeConfigPaths()
+		return []string{config}
+	case "iflow":
+		_, config := a.getIFlowConfigPaths()
+		return []string{config}
+	case "kilo":
+		_, config := a.getKiloConfigPaths()
+		return []string{config}
+	case "codebuddy", "qoder":
+		if projectPath == "" {
+			projectPath = a.GetCurrentProjectPath()
+		}
+		if projectPath == "" {
+			return nil
+		}
+		return []string{filepath.Join(projectPath, "."+strings.ToLower(tool), "models.json")}
+	}
+	return nil
+}
+
+// backupToolConfig snapshots a tool's config files before they are changed. Failures are
+// logged but never block the sync itself.
+func (a *App) backupToolConfig(tool, reason, projectPath string) {
+	if _, _, err := snapshotConfig(tool, reason, a.configBackupPaths(tool, projectPath)); err != nil {
+		a.log(fmt.Sprintf("Failed to back up %s config: %v", tool, err))
+	}
+}
+
+// snapshotConfig archives paths (files or directories) and returns the backup ID and the
+// files too large to be archived. When nothing changed since the latest snapshot, the
+// latest ID is returned instead. Files are streamed into a temporary archive next to
+// the snapshots, so a large directory is never held in memory.
+func snapshotConfig(tool, reason string, paths []string) (id string, skipped []string, err error) {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+	dir, err := getBackupDir(tool)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", nil, err
+	}
+	// Backups may contain API keys, CreateTemp keeps them private
+	tmp, err := os.CreateTemp(dir, "snapshot-*.zip.tmp")
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name()) // Renamed away on success
+	}()
+	zw := zip.NewWriter(tmp)
+	hasher := sha256.New()
+	manifest := backupManifest{Tool: strings.ToLower(tool), Reason: reason}
+	addFile := func(path string, info fs.FileInfo) error {
+		if info.Size() > maxBackupFileSize {
+			skipped = append(skipped, collapseHome(path))
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entry := backupEntry{Path: collapseHome(path), Exists: true, Archive: fmt.Sprintf("files/%d", len(manifest.Files)), Mode: info.Mode().Perm()}
+		w, err := zw.Create(entry.Archive)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "%s\x00true\x00", entry.Path)
+		n, err := io.Copy(io.MultiWriter(w, hasher), f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "\x00%d\x00", n)
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			manifest.Files = append(manifest.Files, backupEntry{Path: collapseHome(path)})
+			fmt.Fprintf(hasher, "%s\x00false\x00", collapseHome(path))
+			continue
+		} else if err != nil {
+			return "", nil, err
+		}
+		if !info.IsDir() {
+			if err := addFile(path, info); err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+		err = filepath.Walk(path, func(p string, fi fs.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+			return addFile(p, fi)
+		})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	for _, path := range skipped {
+		fmt.Fprintf(hasher, "%s\x00skipped\x00", path)
+	}
+	manifest.Hash = hex.EncodeToString(hasher.Sum(nil))
+	manifest.Skipped = skipped
+	if latest, err := listBackupManifests(dir); err == nil && len(latest) > 0 && latest[0].manifest.Hash == manifest.Hash {
+		return latest[0].id, skipped, nil
+	}
+	now := time.Now()
+	manifest.Created = now
+	id = now.Format(backupIdLayout)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, id+".zip")); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", now.Format(backupIdLayout), i)
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	w, err := zw.Create(backupManifestName)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := w.Write(manifestData); err != nil {
+		return "", nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, id+".zip")); err != nil {
+		return "", nil, err
+	}
+	pruneConfigBackups(dir)
+	return id, skipped, nil
+}
+
+type backupInfo struct {
+	id       string
+	size     int64
+	manifest backupManifest
+}
+
+// listBackupManifests returns the snapshots in dir, newest first.
+func listBackupManifests(dir string) ([]backupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []backupInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".zip") {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), ".zip")
+		manifest, err := readBackupManifest(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		info := backupInfo{id: id, manifest: manifest}
+		if fi, err := e.Info(); err == nil {
+			info.size = fi.Size()
+		}
+		backups = append(backups, info)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].manifest.Created.After(backups[j].manifest.Created)
+	})
+	return backups, nil
+}
+
+func readBackupManifest(path string) (backupManifest, error) {
+	var manifest backupManifest
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return manifest, err
+	}
+	defer zr.Close()
+	data, err := readZipEntry(&zr.Reader, backupManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found in backup", name)
+}
+
+// pruneConfigBackups applies the retention policy: at most maxConfigBackups snapshots,
+// and nothing older than configBackupMaxAge beyond the newest minConfigBackups.
+func pruneConfigBackups(dir string) {
+	backups, err := listBackupManifests(dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-configBackupMaxAge)
+	for i, b := range backups {
+		if i >= maxConfigBackups || (i >= minConfigBackups && b.manifest.Created.Before(cutoff)) {
+			os.Remove(filepath.Join(dir, b.id+".zip"))
+		}
+	}
+}
+
+func openConfigBackup(tool, id string) (*zip.ReadCloser, backupManifest, error) {
+	var manifest backupManifest
+	dir, err := getBackupDir(tool)
+	if err != nil {
+		return nil, manifest, err
+	}
+	if !backupIdPattern.MatchString(id) {
+		return nil, manifest, fmt.Errorf("invalid backup id: %s", id)
+	}
+	zr, err := zip.OpenReader(filepath.Join(dir, id+".zip"))
+	if err != nil {
+		return nil, manifest, err
+	}
+	data, err := readZipEntry(&zr.Reader, backupManifestName)
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		zr.Close()
+		return nil, manifest, err
+	}
+	return zr, manifest, nil
+}
+
+// ListConfigBackups returns the config snapshots of a tool, newest first.
+func (a *App) ListConfigBackups(tool string) ([]ConfigBackup, error) {
+	dir, err := getBackupDir(tool)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := listBackupManifests(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ConfigBackup, 0, len(backups))
+	for _, b := range backups {
+		files := make([]string, 0, len(b.manifest.Files))
+		for _, f := range b.manifest.Files {
+			files = append(files, f.Path)
+		}
+		result = append(result, ConfigBackup{
+			Id:      b.id,
+			Tool:    b.manifest.Tool,
+			Created: b.manifest.Created.Format(time.RFC3339),
+			Reason:  b.manifest.Reason,
+			Files:   files,
+			Skipped: b.manifest.Skipped,
+			Size:    b.size,
+		})
+	}
+	return result, nil
+}
+
+// RestoreConfigBackup puts a tool's config files back the way they were in a snapshot.
+// The current state is snapshotted first, so a restore can itself be undone. It returns
+// the files that were too large to back up, and so are left as they are.
+func (a *App) RestoreConfigBackup(tool, id string) ([]string, error) {
+	zr, manifest, err := openConfigBackup(tool, id)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var current []string
+	for _, f := range manifest.Files {
+		current = append(current, expandHome(f.Path))
+	}
+	if _, _, err := snapshotConfig(tool, "restore", current); err != nil {
+		return nil, fmt.Errorf("failed to back up current config: %w", err)
+	}
+	for _, f := range manifest.Files {
+		path := expandHome(f.Path)
+		if !f.Exists {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		data, err := readZipEntry(&zr.Reader, f.Archive)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := os.WriteFile(path, data, mode); err != nil {
+			return nil, err
+		}
+	}
+	if len(manifest.Skipped) > 0 {
+		a.log(fmt.Sprintf("Restored %s config from backup %s, except files too large to back up: %s", tool, id, strings.Join(manifest.Skipped, ", ")))
+	} else {
+		a.log(fmt.Sprintf("Restored %s config from backup %s", tool, id))
+	}
+	return manifest.Skipped, nil
+}
+
+// DiffConfigBackup compares a snapshot with the files currently on disk.
+func (a *App) DiffConfigBackup(tool, id string) ([]ConfigBackupDiff, error) {
+	zr, manifest, err := openConfigBackup(tool, id)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var diffs []ConfigBackupDiff
+	for _, f := range manifest.Files {
+		var old []byte
+		if f.Exists {
+			if old, err = readZipEntry(&zr.Reader, f.Archive); err != nil {
+				return nil, err
+			}
+		}
+		cur, err := os.ReadFile(expandHome(f.Path))
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		d := ConfigBackupDiff{Path: f.Path}
+		switch {
+		case f.Exists == exists && bytes.Equal(old, cur):
+			d.Status = "unchanged"
+		case !f.Exists && !exists:
+			d.Status = "unchanged"
+		case !f.Exists:
+			d.Status = "added"
+		case !exists:
+			d.Status = "removed"
+		default:
+			d.Status = "modified"
+		}
+		if d.Status != "unchanged" {
+			d.Diff = unifiedDiff("backup/"+f.Path, "current/"+f.Path, string(old), string(cur))
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+func collapseHome(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if rel, err := filepath.Rel(home, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(filepath.Join("~", rel))
+	}
+	return path
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, filepath.FromSlash(strings.TrimPrefix(path, "~")))
+	}
+	return path
+}
+
+// removeAllExcept removes path like os.RemoveAll, but leaves the files in keep and the
+// directories that hold them.
+func removeAllExcept(path string, keep []string) error {
+	if containsPath(keep, path) {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := removeAllExcept(filepath.Join(path, e.Name()), keep); err != nil {
+			return err
+		}
+	}
+	if rest, err := os.ReadDir(path); err == nil && len(rest) > 0 {
+		return nil // Holds a kept file
+	}
+	return os.Remove(path)
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if filepath.Clean(p) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// unifiedDiff renders a line diff with three lines of context.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	a := splitDiffLines(oldText)
+	b := splitDiffLines(newText)
+	if len(a)*len(b) > 4_000_000 {
+		return fmt.Sprintf("--- %s\n+++ %s\n(file too large to diff)\n", oldName, newName)
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	type op struct {
+		kind       byte
+		text       string
+		oldN, newN int // Line numbers before this op
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i], i, j})
+			i++
+			j++
+		case j >= len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j], i, j})
+			j++
+		}
+	}
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		// Extend the hunk while changes are within 2*context lines of each other
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' && next-end < 2*context {
+				next++
+			}
+			if next < len(ops) && ops[next].kind != ' ' {
+				end = next
+				continue
+			}
+			break
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		oldCount, newCount := 0, 0
+		for _, o := range ops[start:stop] {
+			if o.kind != '+' {
+				oldCount++
+			}
+			if o.kind != '-' {
+				newCount++
+			}
+		}
+		oldStart, newStart := ops[start].oldN+1, ops[start].newN+1
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, o := range ops[start:stop] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.text)
+			out.WriteByte('\n')
+		}
+		k = stop
+	}
+	return out.String()
+}
+
+func splitDiffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
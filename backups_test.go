@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotConfigRecordsSkippedFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	small := filepath.Join(home, ".gemini", "settings.json")
+	large := filepath.Join(home, ".gemini", "history.log")
+	if err := os.MkdirAll(filepath.Dir(small), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(small, []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(large, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(large, maxBackupFileSize+1); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{}
+	want := []string{"~/.gemini/history.log"}
+	id, skipped, err := snapshotConfig("gemini", "test", []string{small, large})
+	if err != nil || !reflect.DeepEqual(skipped, want) {
+		t.Fatalf("snapshotConfig skipped %v, %v", skipped, err)
+	}
+	backups, err := app.ListConfigBackups("gemini")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("ListConfigBackups = %v, %v", backups, err)
+	}
+	if !reflect.DeepEqual(backups[0].Files, []string{"~/.gemini/settings.json"}) || !reflect.DeepEqual(backups[0].Skipped, want) {
+		t.Fatalf("backup files = %v, skipped = %v", backups[0].Files, backups[0].Skipped)
+	}
+
+	if err := os.WriteFile(small, []byte(`{"a":2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	skipped, err = app.RestoreConfigBackup("gemini", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Fatalf("RestoreConfigBackup skipped = %v, want %v", skipped, want)
+	}
+	if data, _ := os.ReadFile(small); string(data) != `{"a":1}` {
+		t.Fatalf("settings.json = %s", data)
+	}
+	if info, err := os.Stat(large); err != nil || info.Size() != maxBackupFileSize+1 {
+		t.Fatalf("large file changed: %v, %v", info, err)
+	}
+}
+
+func TestRecoverCCKeepsFilesTooLargeToBackUp(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	claudeDir := filepath.Join(home, ".claude")
+	settings := filepath.Join(claudeDir, "settings.json")
+	large := filepath.Join(claudeDir, "projects", "p1", "session.jsonl")
+	other := filepath.Join(claudeDir, "todos", "t.json")
+	for _, path := range []string{settings, large, other} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Truncate(large, maxBackupFileSize+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".claude.json"), []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{}
+	if err := app.RecoverCC(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(large); err != nil || info.Size() != maxBackupFileSize+1 {
+		t.Fatalf("the file too large to back up was not kept: %v, %v", info, err)
+	}
+	for _, path := range []string{settings, filepath.Dir(other), filepath.Join(home, ".claude.json")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", path, err)
+		}
+	}
+
+	backups, err := app.ListConfigBackups("claude")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("ListConfigBackups = %v, %v", backups, err)
+	}
+	if _, err := app.RestoreConfigBackup("claude", backups[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{settings, other, filepath.Join(home, ".claude.json")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was not restored: %v", path, err)
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(home, ".cceasy", "backups", "claude"))
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".zip" {
+			t.Errorf("temporary file %s left in the backup directory", e.Name())
+		}
+	}
+}
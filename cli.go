@@ -0,0 +1,1130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// Headless command line interface. `aicoder <command> ...` works on the same config and
+// launch logic as the GUI without starting the webview, so it can be used over SSH and
+// from scripts. Every command accepts --json for machine-readable output.
+
+const cliUsage = `Usage: aicoder <command> [arguments] [--json] [--verbose]
+
+Commands:
+  use <tool> <provider>              Switch the provider of a tool
+  launch [<tool>] [--project <path>] [--yolo] [--admin] [--proxy] [--python-env <name>]
+                                     Open a tool in a new terminal
+  run [<tool>] [launch options] [-- <tool arguments>]
+                                     Run a tool in the current terminal
+  providers list [--tool <tool>]     List the configured providers
+  providers test <tool> <provider> [--project <path>] [--proxy]
+                                     Send a minimal request to check the URL, key and model
+  providers models <tool> <provider> [--refresh]
+                                     List the model IDs the provider offers
+  providers keys <tool> <provider> [--reject <label>]
+                                     Show when each key was last used, or mark one as rejected
+  profiles list                      List the profiles
+  profiles use <name>                Activate a profile
+  profiles create <name> [--from <profile>]
+                                     Add an empty profile, or a copy of another one
+  profiles rename <old> <new>        Rename a profile
+  profiles delete <name>             Delete an inactive profile
+  config get [<path>] [--show-secrets]
+                                     Print the config or a single value (e.g. claude.current_model)
+  config set <path> <value> [--dry-run]
+                                     Change a config value (e.g. claude.models.GLM.api_key sk-...)
+  config path                        Print the location of the config file
+  config validate                    Check the config for errors and warnings
+  config export <file> [--root <dir>] [--skills]
+                                     Write a config bundle without API keys and passwords
+  config import <file> [--strategy overwrite|keep|rename] [--root <dir>] [--dry-run]
+                                     Merge a config bundle into the config
+  tools status [<tool>...]           Show installed tools and versions
+  tools install <tool>...            Install tools into ~/.cceasy/tools
+  tools update <tool>...             Update tools installed by AICoder
+  gateway serve [--port <n>]         Run the local protocol gateway in the foreground
+  gateway status|stop                Show or stop the gateway started by launches, and its failovers
+  usage [report] [--range <range>] [--by <fields>] [--csv <file>]
+                                     Sum the tokens and cost of requests through the gateway
+                                     (range: month, last-month, today, 7d, all, 2026-09, ...;
+                                     fields: project, tool, provider, model, day, month)
+  usage price <model> <input> <output> [--cache-read <p>] [--cache-write <p>] [--currency <c>]
+                                     Set a price per million tokens (model or provider/model)
+
+Tools: claude, gemini, codex, opencode, codebuddy, qoder, iflow, kilo
+Without <tool>, launch and run use the tool pinned to the project, else the active tool.
+Run without a command to start the desktop app, or with --tui for the terminal UI.
+`
+
+type cliContext struct {
+	app    *App
+	json   bool
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// errUsage marks errors caused by invalid arguments (exit code 2).
+type errUsage struct{ msg string }
+
+func (e errUsage) Error() string { return e.msg }
+
+// errReported is returned when the command already printed its failure.
+type errReported struct{ error }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return errUsage{fmt.Sprintf(format, args...)}
+}
+
+var cliCommands = map[string]func(*cliContext, []string) error{
+	"use":       cliUse,
+	"launch":    cliLaunch,
+	"run":       cliRun,
+	"providers": cliProviders,
+	"profiles":  cliProfiles,
+	"config":    cliConfig,
+	"tools":     cliTools,
+	"gateway":   cliGateway,
+	"usage":     cliTokenUsage,
+}
+
+// isCLICommand reports whether the first program argument selects the headless CLI.
+func isCLICommand(arg string) bool {
+	_, ok := cliCommands[arg]
+	return ok || arg == "help" || arg == "--help" || arg == "-h"
+}
+
+// runCLI runs a CLI command and returns the process exit code. Global flags after "--"
+// belong to the tool and are left alone.
+func runCLI(app *App, args []string) int {
+	ctx := &cliContext{app: app, stdout: os.Stdout, stderr: os.Stderr}
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		switch arg {
+		case "--json", "-json":
+			ctx.json = true
+		case "--verbose", "-verbose", "-v":
+			app.logOutput = os.Stderr
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 || rest[0] == "help" || rest[0] == "--help" || rest[0] == "-h" {
+		fmt.Fprint(ctx.stdout, cliUsage)
+		return 0
+	}
+	cmd, ok := cliCommands[rest[0]]
+	if !ok {
+		fmt.Fprintf(ctx.stderr, "unknown command %q\n\n%s", rest[0], cliUsage)
+		return 2
+	}
+	if err := cmd(ctx, rest[1:]); err != nil {
+		var reported errReported
+		if errors.As(err, &reported) {
+			return 1
+		}
+		if ctx.json {
+			ctx.printJSON(map[string]string{"error": err.Error()})
+		} else {
+			fmt.Fprintln(ctx.stderr, "Error:", err)
+		}
+		var usage errUsage
+		if errors.As(err, &usage) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func (c *cliContext) printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(c.stderr, "Error:", err)
+		return
+	}
+	fmt.Fprintln(c.stdout, string(data))
+}
+
+// parseFlags parses flags that may appear before, between or after positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usageErrorf("%v", err)
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func findToolConfig(config *AppConfig, tool string) (*ToolConfig, error) {
+	toolCfg := config.toolConfig(tool)
+	if toolCfg == nil {
+		return nil, usageErrorf("unknown tool %q (expected one of: %s)", tool, strings.Join(supportedTools, ", "))
+	}
+	return toolCfg, nil
+}
+
+func cliUse(c *cliContext, args []string) error {
+	positional, err := parseFlags(flag.NewFlagSet("use", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageErrorf("usage: aicoder use <tool> <provider>")
+	}
+	tool := strings.ToLower(positional[0])
+	var model ModelConfig
+	err = c.app.UpdateConfig(func(config *AppConfig) error {
+		toolCfg, err := findToolConfig(config, tool)
+		if err != nil {
+			return err
+		}
+		selected := getProviderModel(toolCfg, positional[1])
+		if selected == nil {
+			var names []string
+			for _, m := range toolCfg.Models {
+				names = append(names, m.ModelName)
+			}
+			return fmt.Errorf("provider %q is not configured for %s (available: %s)", positional[1], tool, strings.Join(names, ", "))
+		}
+		model = *selected
+		toolCfg.CurrentModel = model.ModelName
+		config.ActiveTool = tool
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	needsKey := !strings.EqualFold(model.ModelName, "Original") && model.ApiKey == ""
+	if c.json {
+		c.printJSON(map[string]interface{}{"tool": tool, "provider": model.ModelName, "has_api_key": !needsKey})
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "%s now uses %s\n", tool, model.ModelName)
+	if needsKey {
+		fmt.Fprintf(c.stderr, "Warning: %s has no API key, set one with: aicoder config set %s.models.%s.api_key <key>\n", model.ModelName, tool, model.ModelName)
+	}
+	return nil
+}
+
+// cliLaunchOptions are the launch settings shared by the launch and run commands.
+type cliLaunchOptions struct {
+	tool          string
+	dir           string
+	yolo          bool
+	admin         bool
+	proxy         bool
+	pythonProject bool
+	pythonEnv     string
+}
+
+func parseLaunchOptions(c *cliContext, name string, args []string) (*cliLaunchOptions, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	project := fs.String("project", "", "project directory")
+	yolo := fs.Bool("yolo", false, "skip permission prompts")
+	admin := fs.Bool("admin", false, "run as administrator")
+	proxy := fs.Bool("proxy", false, "use the configured proxy")
+	pythonEnv := fs.String("python-env", "", "Python environment to activate")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) > 1 {
+		return nil, usageErrorf("usage: aicoder %s [<tool>] [--project <path>] [--yolo]", name)
+	}
+	config, err := c.app.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	dir := *project
+	if dir == "" {
+		dir = c.app.GetCurrentProjectPath()
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("project directory %s does not exist", dir)
+	}
+	// Without a tool argument the project's pinned tool or the active tool is used
+	tool := config.defaultToolFor(dir)
+	if len(positional) == 1 {
+		tool = strings.ToLower(positional[0])
+	}
+	if tool == "" {
+		return nil, usageErrorf("usage: aicoder %s <tool> [--project <path>] [--yolo]", name)
+	}
+	if _, err := findToolConfig(&config, tool); err != nil {
+		return nil, err
+	}
+	opts := &cliLaunchOptions{tool: tool, dir: dir, yolo: *yolo, admin: *admin, proxy: *proxy, pythonProject: *pythonEnv != "", pythonEnv: *pythonEnv}
+	// Settings of a configured project apply unless overridden on the command line
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, p := range config.Projects {
+		if filepath.Clean(p.Path) != dir {
+			continue
+		}
+		if !set["yolo"] {
+			opts.yolo = p.YoloMode
+		}
+		if !set["admin"] {
+			opts.admin = p.AdminMode
+		}
+		if !set["proxy"] {
+			opts.proxy = p.UseProxy
+		}
+		if !set["python-env"] && p.PythonProject {
+			opts.pythonProject = true
+			opts.pythonEnv = p.PythonEnv
+		}
+		break
+	}
+	return opts, nil
+}
+
+func cliLaunch(c *cliContext, args []string) error {
+	opts, err := parseLaunchOptions(c, "launch", args)
+	if err != nil {
+		return err
+	}
+	if err := c.app.launchTool(opts.tool, opts.yolo, opts.admin, opts.pythonProject, opts.pythonEnv, opts.dir, opts.proxy); err != nil {
+		if err == errNoProviderSelected {
+			return fmt.Errorf("no provider selected for %s, run: aicoder use %s <provider>", opts.tool, opts.tool)
+		}
+		return err
+	}
+	// The new terminal reads its environment from this process
+	c.app.pendingLaunches.Wait()
+	if c.json {
+		c.printJSON(map[string]interface{}{"tool": opts.tool, "project": opts.dir, "yolo": opts.yolo, "launched": true})
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "Launched %s in %s\n", opts.tool, opts.dir)
+	return nil
+}
+
+// cliRun starts the tool in the current terminal. Arguments after "--" are passed to
+// the tool unchanged.
+func cliRun(c *cliContext, args []string) error {
+	var extraArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, extraArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	opts, err := parseLaunchOptions(c, "run", args)
+	if err != nil {
+		return err
+	}
+	spec, err := c.app.prepareLaunch(opts.tool, opts.yolo, opts.admin, opts.pythonProject, opts.pythonEnv, opts.dir, opts.proxy)
+	if err != nil {
+		if err == errNoProviderSelected {
+			return fmt.Errorf("no provider selected for %s, run: aicoder use %s <provider>", opts.tool, opts.tool)
+		}
+		return err
+	}
+	return c.app.runInPlace(spec, extraArgs)
+}
+
+type cliProvider struct {
+	Tool      string `json:"tool"`
+	Provider  string `json:"provider"`
+	Current   bool   `json:"current"`
+	HasApiKey bool   `json:"has_api_key"`
+	Keys      int    `json:"keys"`
+	BaseUrl   string `json:"base_url"`
+	ModelId   string `json:"model_id"`
+	WireApi   string `json:"wire_api,omitempty"`
+}
+
+func cliProviders(c *cliContext, args []string) error {
+	fs := flag.NewFlagSet("providers", flag.ContinueOnError)
+	toolFilter := fs.String("tool", "", "only list providers of this tool")
+	reject := fs.String("reject", "", "mark the key with this label as rejected")
+	project := fs.String("project", "", "project directory whose proxy to use")
+	proxy := fs.Bool("proxy", false, "use the configured proxy")
+	refresh := fs.Bool("refresh", false, "ignore the cached model list")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 3 && (positional[0] == "test" || positional[0] == "models") {
+		dir := *project
+		if dir == "" {
+			dir = c.app.GetCurrentProjectPath()
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		if positional[0] == "models" {
+			return cliProviderModels(c, positional[1], positional[2], dir, *proxy, *refresh)
+		}
+		return cliProviderTest(c, positional[1], positional[2], dir, *proxy)
+	}
+	if len(positional) == 3 && positional[0] == "keys" {
+		return cliProviderKeys(c, positional[1], positional[2], *reject)
+	}
+	if len(positional) != 1 || positional[0] != "list" {
+		return usageErrorf("usage: aicoder providers list [--tool <tool>] | providers test|models|keys <tool> <provider>")
+	}
+	config, err := c.app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	tools := supportedTools
+	if *toolFilter != "" {
+		if _, err := findToolConfig(&config, *toolFilter); err != nil {
+			return err
+		}
+		tools = []string{strings.ToLower(*toolFilter)}
+	}
+	registry := getProviderRegistry()
+	providers := []cliProvider{}
+	for _, tool := range tools {
+		toolCfg := config.toolConfig(tool)
+		for i := range toolCfg.Models {
+			m := &toolCfg.Models[i]
+			p := cliProvider{Tool: tool, Provider: m.ModelName, Current: m.ModelName == toolCfg.CurrentModel, HasApiKey: m.ApiKey != "", Keys: len(m.Keys)}
+			if !strings.EqualFold(m.ModelName, "Original") {
+				ep := registry.Resolve(tool, m)
+				p.BaseUrl, p.ModelId, p.WireApi = ep.BaseUrl, ep.ModelId, ep.WireApi
+			}
+			providers = append(providers, p)
+		}
+	}
+	if c.json {
+		c.printJSON(providers)
+		return nil
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOOL\tPROVIDER\tCURRENT\tKEY\tMODEL\tBASE URL")
+	for _, p := range providers {
+		current, key := "", "-"
+		if p.Current {
+			current = "*"
+		}
+		if p.Keys > 1 {
+			key = fmt.Sprintf("%d keys", p.Keys)
+		} else if p.HasApiKey {
+			key = "set"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Tool, p.Provider, current, key, p.ModelId, p.BaseUrl)
+	}
+	return w.Flush()
+}
+
+func cliProviderTest(c *cliContext, tool, provider, dir string, useProxy bool) error {
+	result, err := c.app.testProvider(tool, provider, dir, useProxy)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		c.printJSON(result)
+	} else {
+		fmt.Fprintf(c.stdout, "%s/%s: %s\n", result.Tool, result.Provider, result.Status)
+		fmt.Fprintf(c.stdout, "  POST %s (%s, model %s)\n", result.Url, result.Protocol, result.Model)
+		if result.Proxy != "" {
+			fmt.Fprintf(c.stdout, "  via proxy %s\n", result.Proxy)
+		}
+		if result.HttpCode != 0 {
+			fmt.Fprintf(c.stdout, "  HTTP %d in %d ms\n", result.HttpCode, result.LatencyMs)
+		}
+		if result.Message != "" {
+			fmt.Fprintf(c.stdout, "  %s\n", result.Message)
+		}
+		if result.Hint != "" {
+			fmt.Fprintf(c.stdout, "  Hint: %s\n", result.Hint)
+		}
+	}
+	if result.Status != ProviderTestOK {
+		return errReported{errors.New(result.Status)}
+	}
+	return nil
+}
+
+func cliProviderModels(c *cliContext, tool, provider, dir string, useProxy, refresh bool) error {
+	list, err := c.app.listProviderModels(tool, provider, dir, useProxy, refresh)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		c.printJSON(list)
+		return nil
+	}
+	number := func(n int) string {
+		if n == 0 {
+			return "-"
+		}
+		return strconv.Itoa(n)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tCONTEXT\tMAX OUTPUT\tNAME")
+	for _, m := range list.Models {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Id, number(m.ContextWindow), number(m.MaxOutputTokens), m.DisplayName)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if list.Cached {
+		fmt.Fprintf(c.stdout, "(cached %s, --refresh to update)\n", list.FetchedAt)
+	}
+	return nil
+}
+
+func cliProviderKeys(c *cliContext, tool, provider, reject string) error {
+	if reject != "" {
+		if err := c.app.ReportKeyRejected(tool, provider, reject); err != nil {
+			return err
+		}
+	}
+	statuses, err := c.app.GetProviderKeyStatus(tool, provider)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		c.printJSON(statuses)
+		return nil
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LABEL\tLAST USED\tLAST REJECTED")
+	for _, s := range statuses {
+		used, rejected := s.LastUsed, s.LastRejected
+		if used == "" {
+			used = "-"
+		}
+		if rejected == "" {
+			rejected = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Label, used, rejected)
+	}
+	return w.Flush()
+}
+
+func cliProfiles(c *cliContext, args []string) error {
+	fs := flag.NewFlagSet("profiles", flag.ContinueOnError)
+	from := fs.String("from", "", "profile to copy")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageErrorf("usage: aicoder profiles list | use <name> | create <name> [--from <profile>] | rename <old> <new> | delete <name>")
+	}
+	want := map[string]int{"list": 1, "use": 2, "create": 2, "rename": 3, "delete": 2}
+	if n, ok := want[positional[0]]; !ok {
+		return usageErrorf("unknown profiles command %q", positional[0])
+	} else if len(positional) != n {
+		return usageErrorf("wrong number of arguments for profiles %s", positional[0])
+	}
+	var message string
+	switch positional[0] {
+	case "list":
+		profiles, err := c.app.GetProfiles()
+		if err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(profiles)
+			return nil
+		}
+		for _, p := range profiles {
+			marker := " "
+			if p.Active {
+				marker = "*"
+			}
+			fmt.Fprintf(c.stdout, "%s %s\n", marker, p.Name)
+		}
+		return nil
+	case "use":
+		_, err = c.app.ActivateProfile(positional[1])
+		message = "Switched to profile " + positional[1]
+	case "create":
+		if *from != "" {
+			err = c.app.CloneProfile(*from, positional[1])
+		} else {
+			err = c.app.CreateProfile(positional[1])
+		}
+		message = "Created profile " + positional[1]
+	case "rename":
+		err = c.app.RenameProfile(positional[1], positional[2])
+		message = fmt.Sprintf("Renamed profile %s to %s", positional[1], positional[2])
+	case "delete":
+		err = c.app.DeleteProfile(positional[1])
+		message = "Deleted profile " + positional[1]
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		c.printJSON(map[string]interface{}{"command": positional[0], "profile": positional[len(positional)-1], "ok": true})
+	} else {
+		fmt.Fprintln(c.stdout, message)
+	}
+	return nil
+}
+
+func cliConfig(c *cliContext, args []string) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	showSecrets := fs.Bool("show-secrets", false, "print API keys and passwords in clear")
+	root := fs.String("root", "", "directory project paths are relative to in a bundle")
+	skills := fs.Bool("skills", false, "include skill packages in the bundle")
+	strategy := fs.String("strategy", BundleMergeKeep, "how to resolve bundle conflicts")
+	dryRun := fs.Bool("dry-run", false, "report what set or import would change")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageErrorf("usage: aicoder config get [<path>] | set <path> <value> | path | validate | export <file> | import <file>")
+	}
+	switch positional[0] {
+	case "path":
+		path, err := c.app.getConfigPath()
+		if err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(map[string]string{"path": path})
+		} else {
+			fmt.Fprintln(c.stdout, path)
+		}
+		return nil
+	case "get":
+		if len(positional) > 2 {
+			return usageErrorf("usage: aicoder config get [<path>]")
+		}
+		config, err := c.app.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if !*showSecrets {
+			config = cloneConfig(config)
+			for _, f := range secretFields(&config) {
+				*f.value = maskSecret(*f.value)
+			}
+		}
+		doc, err := configDocument(config)
+		if err != nil {
+			return err
+		}
+		var value interface{} = doc
+		if len(positional) == 2 {
+			if value, err = getConfigValue(doc, splitConfigPath(positional[1])); err != nil {
+				return err
+			}
+		}
+		if s, ok := value.(string); ok && !c.json {
+			fmt.Fprintln(c.stdout, s)
+			return nil
+		}
+		c.printJSON(value)
+		return nil
+	case "set":
+		if len(positional) != 3 {
+			return usageErrorf("usage: aicoder config set <path> <value> [--dry-run]")
+		}
+		var synced []KeySyncChange
+		errDryRun := errors.New("dry run")
+		err := c.app.UpdateConfig(func(config *AppConfig) error {
+			doc, err := configDocument(*config)
+			if err != nil {
+				return err
+			}
+			if err := setConfigValue(doc, splitConfigPath(positional[1]), positional[2]); err != nil {
+				return err
+			}
+			updated, err := decodeConfigDocument(doc)
+			if err != nil {
+				return err
+			}
+			preview := cloneConfig(updated)
+			synced = syncKeyGroups(config, &preview)
+			if *dryRun {
+				return errDryRun
+			}
+			*config = updated
+			return nil
+		})
+		if err != nil && err != errDryRun {
+			return err
+		}
+		if synced == nil {
+			synced = []KeySyncChange{}
+		}
+		if c.json {
+			c.printJSON(map[string]interface{}{"path": positional[1], "updated": !*dryRun, "key_sync": synced})
+			return nil
+		}
+		if *dryRun {
+			fmt.Fprintf(c.stdout, "Would update %s\n", positional[1])
+		} else {
+			fmt.Fprintf(c.stdout, "Updated %s\n", positional[1])
+		}
+		for _, change := range synced {
+			fmt.Fprintf(c.stdout, "  also %s/%s (key group %s)\n", change.Tool, change.Provider, change.Group)
+		}
+		return nil
+	case "validate":
+		config, err := c.app.LoadConfig()
+		if err != nil {
+			return err
+		}
+		issues := c.app.ValidateConfig(config)
+		if c.json {
+			c.printJSON(issues)
+		} else if len(issues) == 0 {
+			fmt.Fprintln(c.stdout, "No problems found")
+		}
+		failed := false
+		for _, issue := range issues {
+			if !c.json {
+				fmt.Fprintln(c.stdout, issue)
+			}
+			failed = failed || issue.Severity == SeverityError
+		}
+		if failed {
+			return errReported{errors.New("config has errors")}
+		}
+		return nil
+	case "export":
+		if len(positional) != 2 {
+			return usageErrorf("usage: aicoder config export <file> [--root <dir>] [--skills]")
+		}
+		path, err := c.app.ExportConfigBundle(BundleExportOptions{Path: positional[1], ProjectRoot: *root, IncludeSkills: *skills})
+		if err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(map[string]string{"path": path})
+		} else {
+			fmt.Fprintf(c.stdout, "Exported config bundle to %s (API keys and passwords are not included)\n", path)
+		}
+		return nil
+	case "import":
+		if len(positional) != 2 {
+			return usageErrorf("usage: aicoder config import <file> [--strategy overwrite|keep|rename] [--root <dir>] [--dry-run]")
+		}
+		switch *strategy {
+		case BundleMergeOverwrite, BundleMergeKeep, BundleMergeRename:
+		default:
+			return usageErrorf("unknown merge strategy %q (expected overwrite, keep or rename)", *strategy)
+		}
+		report, err := c.app.importConfigBundle(positional[1], *strategy, *root, *dryRun)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(report)
+			return nil
+		}
+		printBundleReport(c.stdout, report, *dryRun)
+		return nil
+	}
+	return usageErrorf("unknown config command %q", positional[0])
+}
+
+func printBundleReport(out io.Writer, report BundleImportReport, dryRun bool) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tACTION")
+	unchanged := 0
+	for _, item := range report.Items {
+		if item.Action == "unchanged" {
+			unchanged++
+			continue
+		}
+		name := item.Name
+		if item.Tool != "" {
+			name = item.Tool + "/" + name
+		}
+		action := item.Action
+		if item.Action == "renamed" {
+			action += " to " + item.NewName
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", item.Kind, name, action)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "(%d unchanged)\n", unchanged)
+	if dryRun {
+		fmt.Fprintf(out, "\nDry run: %d conflicts would be resolved with %q, nothing was changed.\n", report.Conflicts, report.Strategy)
+	} else {
+		fmt.Fprintf(out, "\n%d conflicts resolved with %q.\n", report.Conflicts, report.Strategy)
+	}
+	if len(report.MissingSecrets) > 0 {
+		fmt.Fprintf(out, "Set the API keys or passwords of: %s\n", strings.Join(report.MissingSecrets, ", "))
+	}
+}
+
+// maskSecret hides all but the ends of a secret value.
+func maskSecret(value string) string {
+	if value == "" || isSecretRef(value) || isKeyRef(value) {
+		return value
+	}
+	if len(value) <= 12 {
+		return "****"
+	}
+	return value[:4] + "..." + value[len(value)-4:]
+}
+
+// configDocument converts a config to its generic JSON form, as stored on disk.
+func configDocument(config AppConfig) (map[string]interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+// decodeConfigDocument converts a generic document back, rejecting unknown keys and wrong types.
+func decodeConfigDocument(doc map[string]interface{}) (AppConfig, error) {
+	var config AppConfig
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return config, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, fmt.Errorf("invalid value: %w", err)
+	}
+	return config, nil
+}
+
+// splitConfigPath turns "claude.models[0].api_key" into [claude models 0 api_key].
+func splitConfigPath(path string) []string {
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	var parts []string
+	for _, p := range strings.Split(path, ".") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// configChild returns the element of a list or object addressed by one path segment.
+// List elements can be addressed by index or by their model_name, id or name.
+func configChild(parent interface{}, key string) (interface{}, func(interface{}), error) {
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[key]
+		if !ok {
+			keys := make([]string, 0, len(node))
+			for k := range node {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return nil, nil, fmt.Errorf("unknown key %q (available: %s)", key, strings.Join(keys, ", "))
+		}
+		return value, func(v interface{}) { node[key] = v }, nil
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node) {
+			return node[i], func(v interface{}) { node[i] = v }, nil
+		}
+		for i, item := range node {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, field := range []string{"model_name", "id", "name"} {
+				if name, ok := obj[field].(string); ok && strings.EqualFold(name, key) {
+					return item, func(v interface{}) { node[i] = v }, nil
+				}
+			}
+		}
+		return nil, nil, fmt.Errorf("no list element %q", key)
+	}
+	return nil, nil, fmt.Errorf("cannot look up %q in a %T value", key, parent)
+}
+
+func getConfigValue(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, key := range path {
+		next, _, err := configChild(current, key)
+		if err != nil {
+			return nil, err
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// setConfigValue sets a value at path. String fields take the raw text, other fields are
+// parsed as JSON (true, 7, ["a"], ...).
+func setConfigValue(doc map[string]interface{}, path []string, raw string) error {
+	if len(path) == 0 {
+		return usageErrorf("empty config path")
+	}
+	parent, err := getConfigValue(doc, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	current, set, err := configChild(parent, path[len(path)-1])
+	if err != nil {
+		return err
+	}
+	var value interface{} = raw
+	if _, isString := current.(string); !isString {
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			if current != nil {
+				return fmt.Errorf("%s expects a JSON value: %w", strings.Join(path, "."), err)
+			}
+			value = raw
+		}
+	}
+	set(value)
+	return nil
+}
+
+func cliTools(c *cliContext, args []string) error {
+	positional, err := parseFlags(flag.NewFlagSet("tools", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageErrorf("usage: aicoder tools status|install|update [<tool>...]")
+	}
+	tm := NewToolManager(c.app)
+	names := positional[1:]
+	for _, name := range names {
+		if tm.GetPackageName(name) == "" {
+			return usageErrorf("unknown tool %q", name)
+		}
+	}
+	switch positional[0] {
+	case "status":
+		var statuses []ToolStatus
+		if len(names) == 0 {
+			statuses = c.app.CheckToolsStatus()
+		} else {
+			for _, name := range names {
+				statuses = append(statuses, tm.GetToolStatus(name))
+			}
+		}
+		if c.json {
+			c.printJSON(statuses)
+			return nil
+		}
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TOOL\tINSTALLED\tVERSION\tPATH")
+		for _, s := range statuses {
+			installed := "no"
+			if s.Installed {
+				installed = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, installed, s.Version, s.Path)
+		}
+		return w.Flush()
+	case "install", "update":
+		if len(names) == 0 {
+			return usageErrorf("usage: aicoder tools %s <tool>...", positional[0])
+		}
+		type result struct {
+			Tool  string `json:"tool"`
+			Ok    bool   `json:"ok"`
+			Error string `json:"error,omitempty"`
+		}
+		var results []result
+		failed := 0
+		for _, name := range names {
+			if !c.json {
+				verb := map[string]string{"install": "Installing", "update": "Updating"}[positional[0]]
+				fmt.Fprintf(c.stdout, "%s %s...\n", verb, name)
+			}
+			var err error
+			if positional[0] == "install" {
+				err = tm.InstallTool(name)
+			} else {
+				err = tm.UpdateTool(name)
+			}
+			r := result{Tool: name, Ok: err == nil}
+			if err != nil {
+				r.Error = err.Error()
+				failed++
+				if !c.json {
+					fmt.Fprintf(c.stderr, "%s: %v\n", name, err)
+				}
+			}
+			results = append(results, r)
+		}
+		if c.json {
+			c.printJSON(results)
+		}
+		if failed > 0 {
+			err := fmt.Errorf("%d of %d tools failed", failed, len(names))
+			if c.json {
+				return errReported{err}
+			}
+			return err
+		}
+		return nil
+	}
+	return usageErrorf("unknown tools command %q", positional[0])
+}
+
+func cliGateway(c *cliContext, args []string) error {
+	fs := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	port := fs.Int("port", 0, "")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("usage: aicoder gateway serve|status|stop")
+	}
+	switch positional[0] {
+	case "serve":
+		if *port == 0 {
+			config, err := c.app.LoadConfig()
+			if err != nil {
+				return err
+			}
+			*port = getGatewayPort(&config)
+		}
+		c.app.logOutput = timestampWriter{c.stdout}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return c.app.serveGateway(ctx, *port)
+	case "status":
+		status, err := c.app.GetGatewayStatus()
+		if err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(status)
+		} else if status.Running {
+			fmt.Fprintf(c.stdout, "running at %s (pid %d)\n", status.Url, status.Pid)
+			for _, f := range status.Failovers {
+				fmt.Fprintf(c.stdout, "%s: %s instead of %s since %s (%s)\n", f.Tool, f.Active, f.Provider, f.Since.Local().Format("15:04:05"), f.Reason)
+			}
+		} else if status.Error != "" {
+			fmt.Fprintln(c.stdout, status.Error)
+		} else {
+			fmt.Fprintln(c.stdout, "not running")
+		}
+		return nil
+	case "stop":
+		if err := c.app.StopGateway(); err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(map[string]bool{"stopped": true})
+		} else {
+			fmt.Fprintln(c.stdout, "stopped")
+		}
+		return nil
+	}
+	return usageErrorf("unknown gateway command %q", positional[0])
+}
+
+func cliTokenUsage(c *cliContext, args []string) error {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	rangeSpec := fs.String("range", "month", "")
+	groupBy := fs.String("by", "project", "")
+	csvPath := fs.String("csv", "", "")
+	cacheRead := fs.Float64("cache-read", 0, "")
+	cacheWrite := fs.Float64("cache-write", 0, "")
+	currency := fs.String("currency", "", "")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		positional = []string{"report"}
+	}
+	switch positional[0] {
+	case "report":
+		if *csvPath != "" {
+			path, err := c.app.ExportUsageCSV(*rangeSpec, *groupBy, *csvPath)
+			if err != nil {
+				return err
+			}
+			if c.json {
+				c.printJSON(map[string]string{"path": path})
+			} else {
+				fmt.Fprintf(c.stdout, "Wrote %s\n", path)
+			}
+			return nil
+		}
+		report, err := c.app.GetUsageReport(*rangeSpec, *groupBy)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		if c.json {
+			c.printJSON(report)
+			return nil
+		}
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		header := strings.ToUpper(strings.Join(report.GroupBy, "\t")) + "\tREQUESTS\tINPUT\tOUTPUT\tCACHE READ\tCACHE WRITE"
+		for _, cur := range report.Currencies {
+			header += "\t" + cur
+		}
+		fmt.Fprintln(w, header)
+		line := func(keys []string, row UsageReportRow) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d", strings.Join(keys, "\t"), row.Requests, row.InputTokens, row.OutputTokens, row.CacheReadTokens, row.CacheWriteTokens)
+			for _, cur := range report.Currencies {
+				fmt.Fprintf(w, "\t%.4f", row.Cost[cur])
+			}
+			fmt.Fprintln(w)
+		}
+		for _, row := range report.Rows {
+			line(row.Keys, row)
+		}
+		total := make([]string, len(report.GroupBy))
+		total[0] = "TOTAL"
+		line(total, report.Total)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if len(report.Unpriced) > 0 {
+			fmt.Fprintf(c.stdout, "No price for %s (aicoder usage price <model> <input> <output>)\n", strings.Join(report.Unpriced, ", "))
+		}
+		return nil
+	case "price":
+		if len(positional) != 4 {
+			return usageErrorf("usage: aicoder usage price <model>|<provider>/<model> <input> <output> [--cache-read <price>] [--cache-write <price>] [--currency <code>]")
+		}
+		input, err1 := strconv.ParseFloat(positional[2], 64)
+		output, err2 := strconv.ParseFloat(positional[3], 64)
+		if err1 != nil || err2 != nil {
+			return usageErrorf("prices must be numbers (per million tokens)")
+		}
+		price := ModelPrice{Input: input, Output: output, CacheRead: *cacheRead, CacheWrite: *cacheWrite, Currency: strings.ToUpper(*currency)}
+		if err := c.app.SetModelPrice(positional[1], price); err != nil {
+			return err
+		}
+		if c.json {
+			c.printJSON(price)
+		} else {
+			fmt.Fprintf(c.stdout, "Updated the price of %s\n", positional[1])
+		}
+		return nil
+	}
+	return usageErrorf("unknown usage command %q", positional[0])
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// runTestCLI runs a CLI command against app and returns its exit code and output.
+func runTestCLI(app *App, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	ctx := &cliContext{app: app, stdout: &stdout, stderr: &stderr}
+	code := 0
+	var rest []string
+	for _, arg := range args {
+		if arg == "--json" {
+			ctx.json = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	if err := cliCommands[rest[0]](ctx, rest[1:]); err != nil {
+		code = 1
+		if _, usage := err.(errUsage); usage {
+			code = 2
+		}
+		stderr.WriteString(err.Error())
+	}
+	return code, stdout.String(), stderr.String()
+}
+
+func cliTestApp(t *testing.T) *App {
+	models := []ModelConfig{
+		{ModelName: "GLM", ModelUrl: "https://open.bigmodel.cn/api/anthropic", ModelId: "glm-4.7", ApiKey: "sk-glm-0123456789"},
+		{ModelName: "Kimi", ModelUrl: "https://api.moonshot.cn/anthropic", ModelId: "kimi-k2"},
+	}
+	return newTestGateway(t, AppConfig{ActiveTool: "claude", Claude: ToolConfig{CurrentModel: "GLM", Models: models}}).app
+}
+
+func TestCLIUse(t *testing.T) {
+	app := cliTestApp(t)
+	code, stdout, _ := runTestCLI(app, "use", "claude", "kimi", "--json")
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &result); code != 0 || err != nil {
+		t.Fatalf("use = %d %q", code, stdout)
+	}
+	if result["provider"] != "Kimi" || result["has_api_key"] != false {
+		t.Errorf("use printed %v, want Kimi without a key", result)
+	}
+	config, _ := app.LoadConfig()
+	if config.Claude.CurrentModel != "Kimi" || config.ActiveTool != "claude" {
+		t.Errorf("current model %q, active tool %q after use", config.Claude.CurrentModel, config.ActiveTool)
+	}
+
+	if code, _, stderr := runTestCLI(app, "use", "claude", "Nope"); code != 1 || !strings.Contains(stderr, "available: ") {
+		t.Errorf("use of an unknown provider = %d %q, want 1 and the available providers", code, stderr)
+	}
+	if code, _, _ := runTestCLI(app, "use", "vim", "GLM"); code != 2 {
+		t.Errorf("use of an unknown tool = %d, want the usage exit code 2", code)
+	}
+}
+
+func TestCLIConfigGetSet(t *testing.T) {
+	app := cliTestApp(t)
+	if code, stdout, stderr := runTestCLI(app, "config", "set", "claude.models.kimi.api_key", "sk-kimi-0123456789"); code != 0 {
+		t.Fatalf("config set = %d %q %q", code, stdout, stderr)
+	}
+	if _, stdout, _ := runTestCLI(app, "config", "get", "claude.models.Kimi.api_key"); stdout != "sk-k...6789\n" {
+		t.Errorf("config get = %q, want the key masked", stdout)
+	}
+	if _, stdout, _ := runTestCLI(app, "config", "get", "claude.models.Kimi.api_key", "--show-secrets"); stdout != "sk-kimi-0123456789\n" {
+		t.Errorf("config get --show-secrets = %q", stdout)
+	}
+
+	// Values of other types are parsed as JSON, and must have the field's type
+	if code, _, stderr := runTestCLI(app, "config", "set", "claude.models.GLM.is_custom", "maybe"); code != 1 {
+		t.Errorf("config set of a bool to text = %d %q, want an error", code, stderr)
+	}
+	if code, _, _ := runTestCLI(app, "config", "set", "claude.models.GLM.is_custom", "true", "--dry-run"); code != 0 {
+		t.Fatal("config set --dry-run failed")
+	}
+	config, _ := app.LoadConfig()
+	if m := getProviderModel(&config.Claude, "GLM"); m.IsCustom {
+		t.Error("config set --dry-run saved the change")
+	}
+}
+
+func TestCLIProvidersList(t *testing.T) {
+	app := cliTestApp(t)
+	code, stdout, _ := runTestCLI(app, "providers", "list", "--tool", "claude", "--json")
+	var providers []cliProvider
+	if err := json.Unmarshal([]byte(stdout), &providers); code != 0 || err != nil {
+		t.Fatalf("providers list = %d %q", code, stdout)
+	}
+	found := map[string]cliProvider{}
+	for _, p := range providers {
+		if p.Tool != "claude" {
+			t.Errorf("provider %s of %s listed with --tool claude", p.Provider, p.Tool)
+		}
+		found[p.Provider] = p
+	}
+	if glm := found["GLM"]; !glm.Current || !glm.HasApiKey || glm.ModelId != "glm-4.7" {
+		t.Errorf("GLM = %+v, want the current provider with a key", glm)
+	}
+	if kimi := found["Kimi"]; kimi.Current || kimi.HasApiKey {
+		t.Errorf("Kimi = %+v, want neither current nor a key", kimi)
+	}
+}
+
+func TestParseLaunchOptionsProjectDefaults(t *testing.T) {
+	app := cliTestApp(t)
+	dir := t.TempDir()
+	err := app.UpdateConfig(func(config *AppConfig) error {
+		config.Projects = append(config.Projects, ProjectConfig{Id: "p1", Name: "p1", Path: dir, YoloMode: true, UseProxy: true, PythonProject: true, PythonEnv: "ml"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &cliContext{app: app}
+	opts, err := parseLaunchOptions(c, "run", []string{"--project", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.tool != "claude" || !opts.yolo || !opts.proxy || opts.pythonEnv != "ml" {
+		t.Errorf("options = %+v, want the active tool and the project's settings", opts)
+	}
+	// Flags given on the command line win over the project
+	opts, err = parseLaunchOptions(c, "run", []string{"codex", "--project", dir, "--yolo=false", "--python-env", "base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.tool != "codex" || opts.yolo || !opts.proxy || opts.pythonEnv != "base" {
+		t.Errorf("options = %+v, want codex without yolo and the base environment", opts)
+	}
+	if _, err := parseLaunchOptions(c, "run", []string{"--project", dir + "/missing"}); err == nil {
+		t.Error("a missing project directory was accepted")
+	}
+}
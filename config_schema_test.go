@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// checkGolden compares got with the golden file path, or rewrites it with -update.
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs (run go test -update to accept):\n%s", path, unifiedDiff("want", "got", string(want), string(got)))
+	}
+}
+
+func marshalGolden(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+func readDocument(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// TestConfigMigrations runs each migration step on its own against the fixtures
+// testdata/config_schema/v<N>-*.before.json and compares with the .after.json files.
+func TestConfigMigrations(t *testing.T) {
+	for i, m := range configMigrations {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d produces schema %d, steps must be consecutive", i, m.Version)
+		}
+		befores, err := filepath.Glob(filepath.Join("testdata", "config_schema", fmt.Sprintf("v%d-*.before.json", m.Version)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(befores) == 0 {
+			t.Errorf("migration to schema %d has no fixtures", m.Version)
+		}
+		for _, before := range befores {
+			t.Run(filepath.Base(before), func(t *testing.T) {
+				doc := readDocument(t, before)
+				if err := m.Migrate(doc); err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, strings.TrimSuffix(before, ".before.json")+".after.json", marshalGolden(t, doc))
+				// A step applied to its own output must not change it further
+				again := readDocument(t, before)
+				m.Migrate(again)
+				m.Migrate(again)
+				if !bytes.Equal(marshalGolden(t, again), marshalGolden(t, doc)) {
+					t.Error("migration is not idempotent")
+				}
+			})
+		}
+	}
+	if last := configMigrations[len(configMigrations)-1].Version; last != currentConfigSchema {
+		t.Errorf("last migration produces schema %d, currentConfigSchema is %d", last, currentConfigSchema)
+	}
+}
+
+func TestLegacyModelConfigDocument(t *testing.T) {
+	before := filepath.Join("testdata", "config_schema", "legacy-claude-model-config.before.json")
+	data, err := os.ReadFile(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := legacyModelConfigDocument(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upgradeConfigDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, strings.TrimSuffix(before, ".before.json")+".after.json", marshalGolden(t, doc))
+}
+
+func TestDecodeConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".aicoder_config.json")
+	data := []byte(`{"claude":{"current_model":"glm","models":[{"model_name":"glm","api_key":"sk"}]}}`)
+	config, migrated, err := decodeConfigFile(path, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated || config.SchemaVersion != currentConfigSchema || config.Claude.Models[0].ModelName != "GLM" {
+		t.Errorf("decodeConfigFile = %+v, migrated %v", config, migrated)
+	}
+	if backup, err := os.ReadFile(path + ".v0.bak"); err != nil || !bytes.Equal(backup, data) {
+		t.Errorf("backup = %q, %v", backup, err)
+	}
+
+	newer := []byte(fmt.Sprintf(`{"schema_version":%d}`, currentConfigSchema+1))
+	var errNewer errNewerConfig
+	if _, _, err := decodeConfigFile(path, newer); !errors.As(err, &errNewer) {
+		t.Errorf("decodeConfigFile of a newer config = %v, want errNewerConfig", err)
+	}
+	if err := os.WriteFile(path, newer, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfigWritable(path); !errors.As(err, &errNewer) {
+		t.Errorf("checkConfigWritable = %v, want errNewerConfig", err)
+	}
+}
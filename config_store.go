@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The GUI, tray, CLI and TUI all read and write ~/.aicoder_config.json. Writes go to a
+// temp file that is renamed over the config, so a crash never leaves it truncated, and
+// every load-modify-save runs under an advisory lock on ~/.aicoder_config.json.lock so
+// concurrent edits, also from other processes, are not lost.
+
+// configMutex serialises config access within the process; the file lock only excludes
+// other processes.
+var configMutex sync.Mutex
+
+// lockConfig takes the config lock and returns the function that releases it.
+func (a *App) lockConfig() (func(), error) {
+	path, err := a.getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	configMutex.Lock()
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		configMutex.Unlock()
+		return nil, fmt.Errorf("cannot open config lock: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		configMutex.Unlock()
+		return nil, fmt.Errorf("cannot lock config: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		configMutex.Unlock()
+	}, nil
+}
+
+// LoadConfig reads the config, applying migrations and defaults.
+func (a *App) LoadConfig() (AppConfig, error) {
+	unlock, err := a.lockConfig()
+	if err != nil {
+		return AppConfig{}, err
+	}
+	defer unlock()
+	return a.loadConfigLocked()
+}
+
+// SaveConfig replaces the config with config.
+func (a *App) SaveConfig(config AppConfig) error {
+	unlock, err := a.lockConfig()
+	if err != nil {
+		return err
+	}
+	saved, err := a.saveConfigLocked(config)
+	unlock()
+	if err != nil {
+		return err
+	}
+	if OnConfigChanged != nil {
+		OnConfigChanged(saved)
+	}
+	return nil
+}
+
+// UpdateConfig applies fn to the current config and saves the result, holding the lock
+// across the whole read-modify-write. Nothing is saved when fn returns an error.
+func (a *App) UpdateConfig(fn func(*AppConfig) error) error {
+	unlock, err := a.lockConfig()
+	if err != nil {
+		return err
+	}
+	config, err := a.loadConfigLocked()
+	if err == nil {
+		if err = fn(&config); err == nil {
+			config, err = a.saveConfigLocked(config)
+		}
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+	if OnConfigChanged != nil {
+		OnConfigChanged(config)
+	}
+	return nil
+}
+
+// switchProvider makes provider the current provider of tool and tool the active tool.
+func (a *App) switchProvider(tool, provider string) (AppConfig, error) {
+	var updated AppConfig
+	err := a.UpdateConfig(func(config *AppConfig) error {
+		toolCfg := config.toolConfig(tool)
+		if toolCfg == nil {
+			return fmt.Errorf("unknown tool: %s", tool)
+		}
+		toolCfg.CurrentModel = provider
+		config.ActiveTool = tool
+		updated = *config
+		return nil
+	})
+	return updated, err
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place.
+// A symlinked path is followed so the link itself is kept.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+// captureConfigChanges records the configs passed to OnConfigChanged during the test.
+func captureConfigChanges(t *testing.T) *[]AppConfig {
+	var changes []AppConfig
+	previous := OnConfigChanged
+	OnConfigChanged = func(config AppConfig) { changes = append(changes, config) }
+	t.Cleanup(func() { OnConfigChanged = previous })
+	return &changes
+}
+
+func providerKeyOf(config AppConfig, tool, provider string) string {
+	for _, m := range config.toolConfig(tool).Models {
+		if m.ModelName == provider {
+			return m.ApiKey
+		}
+	}
+	return ""
+}
+
+func setProviderKey(config *AppConfig, tool, provider, key string) {
+	models := config.toolConfig(tool).Models
+	for i := range models {
+		if models[i].ModelName == provider {
+			models[i].Keys = []ProviderKey{{Label: "main", ApiKey: key}}
+		}
+	}
+}
+
+func TestSaveConfigNotifiesSavedConfig(t *testing.T) {
+	kimi := func(key string) ToolConfig {
+		return ToolConfig{CurrentModel: "Kimi", Models: []ModelConfig{{ModelName: "Kimi", ModelUrl: "https://api.moonshot.cn/v1", ModelId: "kimi-k2", ApiKey: key}}}
+	}
+	a := newTestGateway(t, AppConfig{Claude: kimi("sk-old"), Codex: kimi("sk-old")}).app
+	config, err := a.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := captureConfigChanges(t)
+	setProviderKey(&config, "claude", "Kimi", "sk-new")
+	if err := a.SaveConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	err = a.UpdateConfig(func(config *AppConfig) error {
+		setProviderKey(config, "codex", "Kimi", "sk-newer")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The listeners see the keys the save copied to the other members of the group
+	want := [][2]string{{"sk-new", "sk-new"}, {"sk-newer", "sk-newer"}}
+	if len(*changes) != len(want) {
+		t.Fatalf("%d notifications, want %d", len(*changes), len(want))
+	}
+	for i, c := range *changes {
+		if got := [2]string{providerKeyOf(c, "claude", "Kimi"), providerKeyOf(c, "codex", "Kimi")}; got != want[i] {
+			t.Errorf("notification %d has keys %v, want %v", i, got, want[i])
+		}
+	}
+	saved, _ := a.LoadConfig()
+	if got := providerKeyOf(saved, "claude", "Kimi"); got != "sk-newer" {
+		t.Errorf("saved claude key = %q, want sk-newer", got)
+	}
+}
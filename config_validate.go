@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateConfig finds settings that would make a launch fail or behave unexpectedly.
+// Errors are refused by SaveConfig; warnings are only shown (settings page, TUI,
+// `aicoder config validate`). Paths use the index form of `aicoder config get`, e.g.
+// claude.models[3].model_url, and Key is a frontend translation key.
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationIssue is one problem found by ValidateConfig.
+type ValidationIssue struct {
+	Severity string `json:"severity"` // "error" or "warning"
+	Path     string `json:"path"`     // JSON path of the offending value
+	Key      string `json:"key"`      // Message key for translation
+	Message  string `json:"message"`  // English message
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Path, i.Message)
+}
+
+// ConfigValidationError is returned by SaveConfig for a config with errors.
+type ConfigValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (e ConfigValidationError) Error() string {
+	var parts []string
+	for _, issue := range e.Issues {
+		parts = append(parts, issue.Path+": "+issue.Message)
+	}
+	return "invalid config: " + strings.Join(parts, "; ")
+}
+
+// ValidateConfig checks a config and returns its issues, errors first.
+func (a *App) ValidateConfig(config AppConfig) []ValidationIssue {
+	return validateConfig(&config, getProviderRegistry())
+}
+
+func validateConfig(c *AppConfig, registry *ProviderRegistry) []ValidationIssue {
+	var issues []ValidationIssue
+	add := func(severity, path, key, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Severity: severity, Path: path, Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	pinned := make(map[string]bool) // tool/provider pinned by a project or in a failover chain
+	for _, p := range c.Projects {
+		if p.Provider == "" {
+			continue
+		}
+		for _, tool := range supportedTools {
+			if p.Tool == "" || strings.EqualFold(p.Tool, tool) {
+				pinned[tool+"/"+strings.ToLower(p.Provider)] = true
+			}
+		}
+	}
+	for _, tool := range supportedTools {
+		for _, name := range c.toolConfig(tool).Failover {
+			pinned[tool+"/"+strings.ToLower(name)] = true
+		}
+	}
+	for _, tool := range supportedTools {
+		toolCfg := c.toolConfig(tool)
+		seen := make(map[string]int)
+		for i := range toolCfg.Models {
+			m := &toolCfg.Models[i]
+			path := fmt.Sprintf("%s.models[%d]", tool, i)
+			name := strings.TrimSpace(m.ModelName)
+			if name == "" {
+				add(SeverityError, path+".model_name", "configProviderNameEmpty", "provider has no name")
+				continue
+			}
+			if first, ok := seen[strings.ToLower(name)]; ok {
+				add(SeverityError, path+".model_name", "configProviderNameDuplicate", "provider %q has the same name as %s.models[%d] (names are not case sensitive)", name, tool, first)
+				continue
+			}
+			seen[strings.ToLower(name)] = i
+			current := strings.EqualFold(name, toolCfg.CurrentModel)
+			labels := make(map[string]bool)
+			for j, k := range m.Keys {
+				keyPath := fmt.Sprintf("%s.keys[%d]", path, j)
+				if strings.TrimSpace(k.Label) == "" {
+					add(SeverityError, keyPath+".label", "configProviderKeyLabelEmpty", "key %d of provider %q has no label", j+1, name)
+				} else if labels[k.Label] {
+					add(SeverityError, keyPath+".label", "configProviderKeyLabelDuplicate", "provider %q has more than one key labelled %q", name, k.Label)
+				}
+				labels[k.Label] = true
+				if k.ApiKey == "" {
+					add(SeverityError, keyPath+".api_key", "configProviderKeyEmpty", "key %q of provider %q is empty", k.Label, name)
+				}
+				validateKeyRef(k.ApiKey, keyPath+".api_key", current, add)
+			}
+			if len(m.Keys) == 0 {
+				validateKeyRef(m.ApiKey, path+".api_key", current, add)
+			}
+			switch m.KeyStrategy {
+			case "", KeyStrategyPinned, KeyStrategyRoundRobin, KeyStrategyLRU:
+			default:
+				add(SeverityError, path+".key_strategy", "configProviderKeyStrategyUnknown", "unknown key strategy %q of provider %q", m.KeyStrategy, name)
+			}
+			if strings.EqualFold(name, "Original") {
+				continue
+			}
+			// Unused entries (like the empty Custom slot) are only checked once they are used
+			if !current && m.ApiKey == "" && !pinned[tool+"/"+strings.ToLower(name)] {
+				continue
+			}
+			ep := registry.Resolve(tool, m)
+			switch u, err := url.Parse(ep.BaseUrl); {
+			case strings.TrimSpace(ep.BaseUrl) == "":
+				add(SeverityError, path+".model_url", "configProviderUrlEmpty", "provider %q has no base URL", name)
+			case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+				add(SeverityError, path+".model_url", "configProviderUrlInvalid", "base URL %q of provider %q is not an http(s) URL", m.ModelUrl, name)
+			}
+			if tool == "claude" && ep.WireApi != "" && !strings.EqualFold(ep.WireApi, "anthropic") && !strings.EqualFold(ep.WireApi, "chat") {
+				add(SeverityError, path+".wire_api", "configProviderWireApiInvalid", "wire API %q of provider %q is not \"anthropic\" or \"chat\"", ep.WireApi, name)
+			}
+			if strings.TrimSpace(ep.ModelId) == "" {
+				add(SeverityError, path+".model_id", "configProviderModelIdEmpty", "provider %q has no model ID", name)
+			}
+			if current && m.ApiKey == "" {
+				add(SeverityWarning, path+".api_key", "configProviderApiKeyEmpty", "provider %q is selected but has no API key", name)
+			}
+		}
+		if toolCfg.CurrentModel != "" && getProviderModel(toolCfg, toolCfg.CurrentModel) == nil {
+			add(SeverityWarning, tool+".current_model", "configCurrentProviderMissing", "current provider %q is not configured", toolCfg.CurrentModel)
+		}
+		if len(toolCfg.Failover) > 0 && tool != "claude" && tool != "codex" {
+			add(SeverityWarning, tool+".failover", "configFailoverUnsupported", "failover only works for claude and codex, the chain of %s is ignored", tool)
+		}
+		for i, name := range toolCfg.Failover {
+			path := fmt.Sprintf("%s.failover[%d]", tool, i)
+			if m := getProviderModel(toolCfg, name); m == nil {
+				add(SeverityError, path, "configFailoverProviderMissing", "failover provider %q is not configured for %s", name, tool)
+			} else if strings.EqualFold(name, "Original") {
+				add(SeverityError, path, "configFailoverProviderOriginal", "the Original provider cannot be in a failover chain")
+			} else if m.ApiKey == "" && len(m.Keys) == 0 {
+				add(SeverityWarning, path, "configFailoverApiKeyEmpty", "failover provider %q has no API key", name)
+			}
+		}
+	}
+
+	ids := make(map[string]int)
+	for i, p := range c.Projects {
+		path := fmt.Sprintf("projects[%d]", i)
+		if p.Id == "" {
+			add(SeverityError, path+".id", "configProjectIdEmpty", "project %q has no ID", p.Name)
+		} else if first, ok := ids[p.Id]; ok {
+			add(SeverityError, path+".id", "configProjectIdDuplicate", "project %q has the same ID as projects[%d]", p.Name, first)
+		} else {
+			ids[p.Id] = i
+		}
+		if strings.TrimSpace(p.Path) == "" {
+			add(SeverityError, path+".path", "configProjectPathEmpty", "project %q has no directory", p.Name)
+		} else if info, err := os.Stat(p.Path); err != nil {
+			add(SeverityWarning, path+".path", "configProjectPathMissing", "directory %s of project %q does not exist", p.Path, p.Name)
+		} else if !info.IsDir() {
+			add(SeverityError, path+".path", "configProjectPathNotDir", "%s of project %q is not a directory", p.Path, p.Name)
+		}
+		if p.Tool != "" {
+			if toolCfg := c.toolConfig(p.Tool); toolCfg == nil {
+				add(SeverityError, path+".tool", "configProjectToolUnknown", "project %q pins unknown tool %q", p.Name, p.Tool)
+			} else if p.Provider != "" && getProviderModel(toolCfg, p.Provider) == nil {
+				add(SeverityError, path+".provider", "configProjectProviderMissing", "project %q pins provider %q, which is not configured for %s", p.Name, p.Provider, p.Tool)
+			}
+		}
+		var names []string
+		for name := range p.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !envNamePattern.MatchString(name) {
+				add(SeverityError, path+".env."+name, "configProjectEnvNameInvalid", "%q is not a valid environment variable name", name)
+			}
+		}
+		if p.UseProxy && strings.TrimSpace(p.ProxyHost) == "" && strings.TrimSpace(c.DefaultProxyHost) == "" {
+			add(SeverityWarning, path+".proxy_host", "configProxyHostEmpty", "project %q uses a proxy but no proxy host is set", p.Name)
+		}
+		validateProxyPort(p.ProxyPort, path+".proxy_port", add)
+	}
+	validateProxyPort(c.DefaultProxyPort, "default_proxy_port", add)
+	if c.GatewayPort < 0 || c.GatewayPort > 65535 {
+		add(SeverityError, "gateway_port", "configGatewayPortInvalid", "gateway port %d is not between 1 and 65535", c.GatewayPort)
+	}
+	var priced []string
+	for name := range c.ModelPrices {
+		priced = append(priced, name)
+	}
+	sort.Strings(priced)
+	for _, name := range priced {
+		p := c.ModelPrices[name]
+		if p.Input < 0 || p.Output < 0 || p.CacheRead < 0 || p.CacheWrite < 0 {
+			add(SeverityError, "model_prices."+name, "configModelPriceNegative", "price of %q is negative", name)
+		}
+		if p.Currency != "" && len(p.Currency) != 3 {
+			add(SeverityError, "model_prices."+name+".currency", "configModelPriceCurrencyInvalid", "currency %q of %q is not a three-letter code such as USD or CNY", p.Currency, name)
+		}
+	}
+
+	switch c.Terminal.Profile {
+	case "", TerminalProfileAuto:
+	case TerminalProfileCustom:
+		if !strings.Contains(c.Terminal.CustomCommand, "{script}") {
+			add(SeverityError, "terminal.custom_command", "configTerminalCommandInvalid", "custom terminal command must contain {script}")
+		} else if _, err := splitCommandLine(c.Terminal.CustomCommand); err != nil {
+			add(SeverityError, "terminal.custom_command", "configTerminalCommandInvalid", "invalid custom terminal command: %v", err)
+		}
+	default:
+		if _, ok := findTerminalProfile(c.Terminal.Profile); !ok {
+			add(SeverityError, "terminal.profile", "configTerminalProfileUnknown", "unknown terminal profile %q", c.Terminal.Profile)
+		}
+	}
+	switch c.Terminal.Tmux {
+	case TerminalTmuxOff, TerminalTmuxWindow, TerminalTmuxSplitH, TerminalTmuxSplitV:
+	default:
+		add(SeverityError, "terminal.tmux", "configTerminalTmuxUnknown", "unknown tmux mode %q", c.Terminal.Tmux)
+	}
+	switch c.SecretBackend {
+	case "", SecretBackendAuto, SecretBackendKeyring, SecretBackendFile, SecretBackendPlaintext:
+	default:
+		add(SeverityError, "secret_backend", "configSecretBackendUnknown", "unknown secret backend %q", c.SecretBackend)
+	}
+
+	profiles := make(map[string]bool)
+	for i, p := range c.Profiles {
+		if strings.TrimSpace(p.Name) == "" {
+			add(SeverityError, fmt.Sprintf("profiles[%d].name", i), "configProfileNameEmpty", "profile has no name")
+		} else if profiles[p.Name] {
+			add(SeverityError, fmt.Sprintf("profiles[%d].name", i), "configProfileNameDuplicate", "there is more than one profile named %q", p.Name)
+		}
+		profiles[p.Name] = true
+	}
+
+	// Errors first, keeping the order within each severity
+	sorted := make([]ValidationIssue, 0, len(issues))
+	for _, severity := range []string{SeverityError, SeverityWarning} {
+		for _, issue := range issues {
+			if issue.Severity == severity {
+				sorted = append(sorted, issue)
+			}
+		}
+	}
+	return sorted
+}
+
+// configIssueLines validates the saved config for display in the TUI.
+func (a *App) configIssueLines() []string {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return []string{"error: " + err.Error()}
+	}
+	var lines []string
+	for _, issue := range a.ValidateConfig(config) {
+		lines = append(lines, issue.String())
+	}
+	return lines
+}
+
+func validateProxyPort(port, path string, add func(severity, path, key, format string, args ...interface{})) {
+	if port == "" {
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		add(SeverityError, path, "configProxyPortInvalid", "proxy port %q is not a number between 1 and 65535", port)
+	}
+}
+
+// validateKeyRef checks an env:, file: or cmd: key reference without running it. For the
+// current provider it also warns when the variable or file is missing.
+func validateKeyRef(value, path string, current bool, add func(severity, path, key, format string, args ...interface{})) {
+	if !isKeyRef(value) {
+		return
+	}
+	if err := checkKeyRef(value); err != nil {
+		add(SeverityError, path, "configKeyRefInvalid", "invalid key reference %v", err)
+		return
+	}
+	if !current {
+		return
+	}
+	kind, target, _ := strings.Cut(value, ":")
+	target = strings.TrimSpace(target)
+	switch kind {
+	case "env":
+		if _, ok := os.LookupEnv(target); !ok {
+			add(SeverityWarning, path, "configKeyRefEnvUnset", "environment variable %s is not set", target)
+		}
+	case "file":
+		if _, err := os.Stat(expandHome(target)); err != nil {
+			add(SeverityWarning, path, "configKeyRefFileMissing", "key file %s does not exist", target)
+		}
+	}
+}
+
+// newValidationErrors returns the errors of config that oldConfig did not have, so a
+// config that was already broken on disk can still be saved while it is being fixed.
+func newValidationErrors(oldConfig, config *AppConfig, registry *ProviderRegistry) []ValidationIssue {
+	existing := make(map[string]bool)
+	for _, issue := range validateConfig(oldConfig, registry) {
+		existing[issue.Key+" "+issue.Message] = true
+	}
+	var errs []ValidationIssue
+	for _, issue := range validateConfig(config, registry) {
+		if issue.Severity == SeverityError && !existing[issue.Key+" "+issue.Message] {
+			errs = append(errs, issue)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func validationTestConfig(dir string) AppConfig {
+	return AppConfig{
+		Claude: ToolConfig{CurrentModel: "Mine", Models: []ModelConfig{
+			{ModelName: "Original"},
+			{ModelName: "Mine", ModelUrl: "https://llm.example/anthropic", ModelId: "mine-large", ApiKey: "sk-mine", IsCustom: true},
+			{ModelName: "Custom", IsCustom: true},
+		}},
+		Projects: []ProjectConfig{{Id: "p1", Name: "p1", Path: dir}},
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	type issue struct{ severity, path, key string }
+	cases := []struct {
+		name  string
+		edit  func(*AppConfig)
+		issue []issue
+	}{
+		{"valid", func(c *AppConfig) {}, nil},
+		{"unused empty Custom slot", func(c *AppConfig) { c.Claude.Models[2].ModelId = "" }, nil},
+		{"empty URL", func(c *AppConfig) { c.Claude.Models[1].ModelUrl = "" },
+			[]issue{{SeverityError, "claude.models[1].model_url", "configProviderUrlEmpty"}}},
+		{"non-http URL", func(c *AppConfig) { c.Claude.Models[1].ModelUrl = "ftp://llm.example" },
+			[]issue{{SeverityError, "claude.models[1].model_url", "configProviderUrlInvalid"}}},
+		{"Custom in use without model ID", func(c *AppConfig) {
+			c.Claude.Models[2] = ModelConfig{ModelName: "Custom", ModelUrl: "https://x.example", ApiKey: "sk-x", IsCustom: true}
+		}, []issue{{SeverityError, "claude.models[2].model_id", "configProviderModelIdEmpty"}}},
+		{"names differing in case", func(c *AppConfig) { c.Claude.Models[2].ModelName = "MINE" },
+			[]issue{{SeverityError, "claude.models[2].model_name", "configProviderNameDuplicate"}}},
+		{"selected without key", func(c *AppConfig) { c.Claude.Models[1].ApiKey = "" },
+			[]issue{{SeverityWarning, "claude.models[1].api_key", "configProviderApiKeyEmpty"}}},
+		{"missing project directory", func(c *AppConfig) { c.Projects[0].Path = filepath.Join(dir, "gone") },
+			[]issue{{SeverityWarning, "projects[0].path", "configProjectPathMissing"}}},
+		{"non-numeric proxy port, errors first", func(c *AppConfig) {
+			c.Projects[0].ProxyPort = "80a"
+			c.Projects[0].UseProxy = true
+		}, []issue{
+			{SeverityError, "projects[0].proxy_port", "configProxyPortInvalid"},
+			{SeverityWarning, "projects[0].proxy_host", "configProxyHostEmpty"},
+		}},
+		{"project pins an unknown provider", func(c *AppConfig) { c.Projects[0].Tool, c.Projects[0].Provider = "claude", "Gone" },
+			[]issue{{SeverityError, "projects[0].provider", "configProjectProviderMissing"}}},
+	}
+	for _, c := range cases {
+		config := validationTestConfig(dir)
+		c.edit(&config)
+		var got []issue
+		for _, i := range validateConfig(&config, getProviderRegistry()) {
+			got = append(got, issue{i.Severity, i.Path, i.Key})
+		}
+		if !reflect.DeepEqual(got, c.issue) {
+			t.Errorf("%s: issues %v, want %v", c.name, got, c.issue)
+		}
+	}
+}
+
+func TestSaveConfigRejectsNewErrors(t *testing.T) {
+	dir := t.TempDir()
+	base := validationTestConfig(dir)
+	base.Claude.Models[1].ModelUrl = "ftp://llm.example"
+	a := newTestGateway(t, base).app
+
+	// An error the config already had does not block saving other changes
+	err := a.UpdateConfig(func(c *AppConfig) error {
+		c.Language = "en"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("saving a config with an existing error: %v", err)
+	}
+	err = a.UpdateConfig(func(c *AppConfig) error {
+		c.DefaultProxyPort = "eighty"
+		return nil
+	})
+	var invalid ConfigValidationError
+	if !errors.As(err, &invalid) || len(invalid.Issues) != 1 || invalid.Issues[0].Path != "default_proxy_port" {
+		t.Fatalf("saving a new error = %v, want the proxy port refused", err)
+	}
+	saved, _ := a.LoadConfig()
+	if saved.DefaultProxyPort != "" || saved.Language != "en" {
+		t.Errorf("saved proxy port %q, language %q", saved.DefaultProxyPort, saved.Language)
+	}
+}
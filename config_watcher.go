@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// The config watcher picks up edits made outside AICoder (an editor, a dotfiles sync,
+// another AICoder process) and tells the frontend and tray what changed. It watches the
+// directory rather than the file, because editors and saveToPath replace the file by
+// rename, which ends a watch on the file itself. Bursts of events are debounced and
+// content AICoder wrote itself is recognised and ignored, so saving never loops.
+
+const configWatchDebounce = 300 * time.Millisecond
+
+// configWatchState remembers the config content AICoder last wrote or processed.
+type configWatchState struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// ConfigChange is one entry of the diff emitted with the "config-diff" event.
+type ConfigChange struct {
+	Kind   string `json:"kind"`           // "tool", "provider", "project" or "setting"
+	Action string `json:"action"`         // "added", "removed" or "modified"
+	Tool   string `json:"tool,omitempty"` // For tool and provider changes
+	Name   string `json:"name"`           // Provider name, project id or setting key
+}
+
+// rememberConfigWrite records content written by AICoder so the watcher skips it.
+func (a *App) rememberConfigWrite(data []byte) {
+	a.configWatch.mu.Lock()
+	a.configWatch.data = append([]byte(nil), data...)
+	a.configWatch.mu.Unlock()
+}
+
+func (a *App) startConfigWatcher() {
+	var err error
+	a.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		a.log("Failed to create file watcher: " + err.Error())
+		return
+	}
+	configPath, err := a.getConfigPath()
+	if err != nil {
+		return
+	}
+	if data, err := os.ReadFile(configPath); err == nil {
+		a.rememberConfigWrite(data)
+	}
+	watched := make(map[string]bool)
+	// The config may be a symlink (e.g. into a dotfiles repo); watch the target's directory too
+	targets := func() map[string]bool {
+		names := map[string]bool{configPath: true}
+		if target, err := filepath.EvalSymlinks(configPath); err == nil {
+			names[target] = true
+		}
+		for name := range names {
+			dir := filepath.Dir(name)
+			if watched[dir] {
+				continue
+			}
+			if err := a.watcher.Add(dir); err != nil {
+				a.log("Failed to watch config file: " + err.Error())
+				continue
+			}
+			watched[dir] = true
+			a.log("Watching config file: " + name)
+		}
+		return names
+	}
+	names := targets()
+	var debounce *time.Timer
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case event, ok := <-a.watcher.Events:
+				if !ok {
+					return
+				}
+				if !names[filepath.Clean(event.Name)] || event.Op == fsnotify.Chmod {
+					continue
+				}
+				if debounce == nil {
+					debounce = time.AfterFunc(configWatchDebounce, func() {
+						select {
+						case changed <- struct{}{}:
+						default:
+						}
+					})
+				} else {
+					debounce.Reset(configWatchDebounce)
+				}
+			case <-changed:
+				a.handleConfigFileChange(configPath)
+				// A rename may have changed where the symlink points
+				names = targets()
+			case err, ok := <-a.watcher.Errors:
+				if !ok {
+					return
+				}
+				a.log("Watcher error: " + err.Error())
+			}
+		}
+	}()
+}
+
+// handleConfigFileChange reloads the config after an external edit and emits
+// "config-updated" with the new config and "config-diff" with what changed.
+func (a *App) handleConfigFileChange(configPath string) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		// Removed, or between the remove and create of a save; the next event brings it back
+		return
+	}
+	a.configWatch.mu.Lock()
+	previous := a.configWatch.data
+	a.configWatch.mu.Unlock()
+	if bytes.Equal(previous, data) {
+		return
+	}
+	var oldConfig, newConfig AppConfig
+	if err := json.Unmarshal(data, &newConfig); err != nil {
+		// Probably saved half-way through an edit; wait for the next write
+		a.log("Config file changed but is not valid JSON yet: " + err.Error())
+		return
+	}
+	json.Unmarshal(previous, &oldConfig)
+	a.rememberConfigWrite(data)
+	changes := diffConfigs(&oldConfig, &newConfig)
+	if len(changes) == 0 {
+		return
+	}
+	a.log(a.tr("Config file modified: ") + configPath)
+	config, err := a.LoadConfig()
+	if err != nil {
+		a.log("Failed to reload config: " + err.Error())
+		return
+	}
+	a.emitEvent("config-updated", config)
+	a.emitEvent("config-diff", changes)
+	if OnConfigChanged != nil {
+		OnConfigChanged(config)
+	}
+}
+
+// diffConfigs lists the tools, providers, projects and settings that differ. Secret
+// values are compared but never included.
+func diffConfigs(oldConfig, newConfig *AppConfig) []ConfigChange {
+	var changes []ConfigChange
+	for _, tool := range supportedTools {
+		oldTool, newTool := oldConfig.toolConfig(tool), newConfig.toolConfig(tool)
+		if oldTool.CurrentModel != newTool.CurrentModel {
+			changes = append(changes, ConfigChange{Kind: "tool", Action: "modified", Tool: tool, Name: newTool.CurrentModel})
+		}
+		if !reflect.DeepEqual(oldTool.Failover, newTool.Failover) {
+			changes = append(changes, ConfigChange{Kind: "setting", Action: "modified", Name: tool + ".failover"})
+		}
+		oldModels := make(map[string]ModelConfig)
+		for _, m := range oldTool.Models {
+			oldModels[m.ModelName] = m
+		}
+		newModels := make(map[string]bool)
+		for _, m := range newTool.Models {
+			newModels[m.ModelName] = true
+			if old, ok := oldModels[m.ModelName]; !ok {
+				changes = append(changes, ConfigChange{Kind: "provider", Action: "added", Tool: tool, Name: m.ModelName})
+			} else if !reflect.DeepEqual(old, m) {
+				changes = append(changes, ConfigChange{Kind: "provider", Action: "modified", Tool: tool, Name: m.ModelName})
+			}
+		}
+		for _, m := range oldTool.Models {
+			if !newModels[m.ModelName] {
+				changes = append(changes, ConfigChange{Kind: "provider", Action: "removed", Tool: tool, Name: m.ModelName})
+			}
+		}
+	}
+	oldProjects := make(map[string]ProjectConfig)
+	for _, p := range oldConfig.Projects {
+		oldProjects[p.Id] = p
+	}
+	newProjects := make(map[string]bool)
+	for _, p := range newConfig.Projects {
+		newProjects[p.Id] = true
+		if old, ok := oldProjects[p.Id]; !ok {
+			changes = append(changes, ConfigChange{Kind: "project", Action: "added", Name: p.Id})
+		} else if !reflect.DeepEqual(old, p) {
+			changes = append(changes, ConfigChange{Kind: "project", Action: "modified", Name: p.Id})
+		}
+	}
+	for _, p := range oldConfig.Projects {
+		if !newProjects[p.Id] {
+			changes = append(changes, ConfigChange{Kind: "project", Action: "removed", Name: p.Id})
+		}
+	}
+	// Everything else, by JSON key
+	oldDoc, _ := configSettingsDocument(oldConfig)
+	newDoc, _ := configSettingsDocument(newConfig)
+	var keys []string
+	for k := range newDoc {
+		if !reflect.DeepEqual(oldDoc[k], newDoc[k]) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		changes = append(changes, ConfigChange{Kind: "setting", Action: "modified", Name: k})
+	}
+	return changes
+}
+
+// configSettingsDocument returns the top-level settings of config, without tools and projects.
+func configSettingsDocument(config *AppConfig) (map[string]interface{}, error) {
+	doc, err := configDocument(*config)
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range supportedTools {
+		delete(doc, tool)
+	}
+	delete(doc, "projects")
+	return doc, nil
+}
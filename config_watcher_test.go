@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// replaceConfigFile edits the config the way editors save: write a copy, rename it over.
+func replaceConfigFile(t *testing.T, path string, edit func(*AppConfig)) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config AppConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	edit(&config)
+	if data, err = json.MarshalIndent(config, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(filepath.Dir(path), ".aicoder_config.json.swp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextConfigChange waits for a config change notification, or returns false after wait.
+func nextConfigChange(changes <-chan AppConfig, wait time.Duration) (AppConfig, bool) {
+	select {
+	case config := <-changes:
+		return config, true
+	case <-time.After(wait):
+		return AppConfig{}, false
+	}
+}
+
+func TestConfigWatcher(t *testing.T) {
+	a := cliTestApp(t)
+	path, _ := a.getConfigPath()
+	changes := make(chan AppConfig, 10)
+	previous := OnConfigChanged
+	OnConfigChanged = func(config AppConfig) { changes <- config }
+	t.Cleanup(func() { OnConfigChanged = previous })
+	a.startConfigWatcher()
+	if a.watcher == nil {
+		t.Skip("file watching is not available")
+	}
+	t.Cleanup(func() { a.watcher.Close() })
+	quiet := 4 * configWatchDebounce
+
+	// AICoder's own saves are not reported a second time by the watcher
+	if _, err := a.switchProvider("claude", "Kimi"); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := nextConfigChange(changes, quiet); !ok || c.Claude.CurrentModel != "Kimi" {
+		t.Fatalf("no notification of the save")
+	}
+	if c, ok := nextConfigChange(changes, quiet); ok {
+		t.Fatalf("the watcher reported AICoder's own save, current model %s", c.Claude.CurrentModel)
+	}
+
+	// A burst of edits by rename is reported once, and the watch survives the renames
+	for _, provider := range []string{"GLM", "Kimi", "GLM"} {
+		replaceConfigFile(t, path, func(c *AppConfig) { c.Claude.CurrentModel = provider })
+	}
+	if c, ok := nextConfigChange(changes, quiet); !ok || c.Claude.CurrentModel != "GLM" {
+		t.Fatalf("edit by rename: notified %v with %q, want GLM", ok, c.Claude.CurrentModel)
+	}
+	if _, ok := nextConfigChange(changes, quiet); ok {
+		t.Fatal("a burst of edits was reported more than once")
+	}
+	replaceConfigFile(t, path, func(c *AppConfig) { c.Claude.CurrentModel = "Kimi" })
+	if c, ok := nextConfigChange(changes, quiet); !ok || c.Claude.CurrentModel != "Kimi" {
+		t.Fatalf("second edit by rename: notified %v with %q, want Kimi", ok, c.Claude.CurrentModel)
+	}
+
+	// Rewriting the same content is no change
+	replaceConfigFile(t, path, func(c *AppConfig) {})
+	if _, ok := nextConfigChange(changes, quiet); ok {
+		t.Error("an unchanged config was reported")
+	}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfig := AppConfig{
+		Claude:   ToolConfig{CurrentModel: "GLM", Models: []ModelConfig{{ModelName: "GLM", ApiKey: "sk-1"}, {ModelName: "Kimi"}}},
+		Projects: []ProjectConfig{{Id: "a", Path: "/a"}, {Id: "b", Path: "/b"}},
+		Language: "en",
+	}
+	newConfig := AppConfig{
+		Claude:   ToolConfig{CurrentModel: "Kimi", Models: []ModelConfig{{ModelName: "GLM", ApiKey: "sk-2"}, {ModelName: "Kimi"}, {ModelName: "MiniMax"}}},
+		Codex:    ToolConfig{Failover: []string{"Relay"}},
+		Projects: []ProjectConfig{{Id: "a", Path: "/a", YoloMode: true}, {Id: "c", Path: "/c"}},
+		Language: "zh",
+	}
+	want := []ConfigChange{
+		{Kind: "tool", Action: "modified", Tool: "claude", Name: "Kimi"},
+		{Kind: "provider", Action: "modified", Tool: "claude", Name: "GLM"},
+		{Kind: "provider", Action: "added", Tool: "claude", Name: "MiniMax"},
+		{Kind: "setting", Action: "modified", Name: "codex.failover"},
+		{Kind: "project", Action: "modified", Name: "a"},
+		{Kind: "project", Action: "added", Name: "c"},
+		{Kind: "project", Action: "removed", Name: "b"},
+		{Kind: "setting", Action: "modified", Name: "language"},
+	}
+	if got := diffConfigs(&oldConfig, &newConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffConfigs =\n%+v\nwant\n%+v", got, want)
+	}
+	if got := diffConfigs(&newConfig, &newConfig); len(got) != 0 {
+		t.Errorf("diff of a config with itself = %+v", got)
+	}
+}
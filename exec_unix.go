@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// execInPlace replaces the current process with the given program.
+func execInPlace(path string, args []string, env []string) error {
+	return syscall.Exec(path, append([]string{path}, args...), env)
+}
+
+// detachProcess makes cmd outlive this process and its terminal.
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
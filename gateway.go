@@ -0,0 +1,699 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The gateway is a local HTTP server that sits between a tool and a provider the tool
+// cannot talk to directly, e.g. Claude Code and a provider that only offers OpenAI Chat
+// Completions. It runs as a separate `aicoder gateway serve` process on 127.0.0.1, so it
+// keeps serving terminals after `aicoder launch` or the app exits, and it is shared by
+// every launch.
+//
+// Routes carry everything the gateway needs, so it keeps no per-launch state:
+//
+//	http://127.0.0.1:<port>/<tool>/<provider>/<project id or ->[/@<model>]/<API path>
+//
+// The optional @<model> segment is the model ID a project pins, which the provider is
+// asked for even when it is not in the provider's list. The upstream URL, models and key
+// are read from the config on each request. Tools
+// authenticate with the token in ~/.cceasy/gateway_token instead of the real key.
+
+const (
+	defaultGatewayPort    = 18421
+	gatewayStartTimeout   = 5 * time.Second
+	gatewayConnectTimeout = 10 * time.Second
+	gatewayMaxRequest     = 32 << 20 // Requests carry whole conversations with images
+	gatewayKeyCacheTTL    = 5 * time.Minute
+	gatewayServiceName    = "aicoder-gateway"
+)
+
+// GatewayStatus describes the local gateway.
+type GatewayStatus struct {
+	Running   bool              `json:"running"`
+	Url       string            `json:"url"`
+	Pid       int               `json:"pid,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Failovers []GatewayFailover `json:"failovers,omitempty"` // See gateway_failover.go
+}
+
+// gatewayUpstream is the provider a gateway route forwards to.
+type gatewayUpstream struct {
+	Tool     string
+	Provider string
+	Project  string   // Project ID, "" outside projects
+	Protocol string   // "anthropic", "chat" or "responses"
+	BaseUrl  string   // Without a trailing slash
+	Models   []string // Model IDs of the provider, the first is the default
+	ApiKey   string
+	keyRef   string // The key as configured, for recording rejections
+	client   *http.Client
+}
+
+// model returns the upstream model for a requested one: the request's if the provider
+// offers it, otherwise the provider's first model.
+func (u *gatewayUpstream) model(requested string) string {
+	for _, m := range u.Models {
+		if strings.EqualFold(m, requested) {
+			return m
+		}
+	}
+	if len(u.Models) > 0 {
+		return u.Models[0]
+	}
+	return requested
+}
+
+// gateway serves the routes. Its config is reloaded when the config file changes.
+type gateway struct {
+	app   *App
+	token string
+
+	mu         sync.Mutex
+	config     AppConfig
+	configMod  time.Time
+	keys       map[string]gatewayKey // Resolved key references
+	clients    map[string]*http.Client
+	breakers   map[string]*gatewayBreaker // By tool/provider
+	failovers  map[string]GatewayFailover // By tool/provider of the route
+	shutdownFn func()
+}
+
+type gatewayKey struct {
+	value   string
+	expires time.Time
+}
+
+func getGatewayPort(config *AppConfig) int {
+	if config.GatewayPort > 0 {
+		return config.GatewayPort
+	}
+	return defaultGatewayPort
+}
+
+func getGatewayTokenPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "gateway_token")
+}
+
+// gatewayToken returns the token tools use to authenticate, creating it on first use.
+func gatewayToken() (string, error) {
+	path := getGatewayTokenPath()
+	if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) >= 32 {
+		return strings.TrimSpace(string(data)), nil
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := "aicoder-" + hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// gatewayRouteURL returns the base URL a tool uses to reach provider through the gateway.
+// model is the model ID the project pins, if any.
+func gatewayRouteURL(port int, tool, provider, projectId, model string) string {
+	if projectId == "" {
+		projectId = "-"
+	}
+	route := fmt.Sprintf("http://127.0.0.1:%d/%s/%s/%s", port, url.PathEscape(strings.ToLower(tool)), url.PathEscape(provider), url.PathEscape(projectId))
+	if model != "" {
+		route += "/@" + url.PathEscape(model)
+	}
+	return route
+}
+
+// checkGateway asks the gateway on port for its status.
+func checkGateway(port int, token string) GatewayStatus {
+	status := GatewayStatus{Url: fmt.Sprintf("http://127.0.0.1:%d", port)}
+	client := &http.Client{Timeout: 2 * time.Second}
+	req, _ := http.NewRequest(http.MethodGet, status.Url+"/health", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return status
+	}
+	defer resp.Body.Close()
+	var health struct {
+		Service    string            `json:"service"`
+		Pid        int               `json:"pid"`
+		Authorized bool              `json:"authorized"`
+		Failovers  []GatewayFailover `json:"failovers"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&health) != nil || health.Service != gatewayServiceName {
+		status.Error = fmt.Sprintf("port %d is used by another program, set gateway_port to a free port", port)
+	} else if !health.Authorized {
+		status.Error = fmt.Sprintf("the gateway on port %d was started by another user", port)
+	} else {
+		status.Running, status.Pid, status.Failovers = true, health.Pid, health.Failovers
+	}
+	return status
+}
+
+// GetGatewayStatus reports whether the local gateway is running.
+func (a *App) GetGatewayStatus() (GatewayStatus, error) {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return GatewayStatus{}, err
+	}
+	token, err := gatewayToken()
+	if err != nil {
+		return GatewayStatus{}, err
+	}
+	return checkGateway(getGatewayPort(&config), token), nil
+}
+
+// ensureGateway starts the gateway process unless it is already running, and returns
+// its port and token.
+func (a *App) ensureGateway(config *AppConfig) (int, string, error) {
+	port := getGatewayPort(config)
+	token, err := gatewayToken()
+	if err != nil {
+		return 0, "", fmt.Errorf("creating the gateway token: %w", err)
+	}
+	if status := checkGateway(port, token); status.Running {
+		return port, token, nil
+	} else if status.Error != "" {
+		return 0, "", errors.New(status.Error)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, "", err
+	}
+	home, _ := os.UserHomeDir()
+	logFile, err := os.OpenFile(filepath.Join(home, ".cceasy", "gateway.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(exe, "gateway", "serve")
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return 0, "", fmt.Errorf("starting the gateway: %w", err)
+	}
+	cmd.Process.Release()
+	a.log(fmt.Sprintf("Started the gateway on port %d", port))
+	for deadline := time.Now().Add(gatewayStartTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if checkGateway(port, token).Running {
+			return port, token, nil
+		}
+	}
+	return 0, "", fmt.Errorf("the gateway did not start within %s, see ~/.cceasy/gateway.log", gatewayStartTimeout)
+}
+
+// routeThroughGateway points the current provider of a launch at the gateway when the
+// tool cannot talk to it directly, Claude Code or Codex with a provider whose wire_api
+// is "chat", when the tool has a failover chain or when usage_tracking is on. Like selectLaunchKey it only changes the
+// in-memory config.
+func (a *App) routeThroughGateway(config *AppConfig, tool string, project *ProjectConfig) (bool, error) {
+	tool = strings.ToLower(tool)
+	toolCfg := config.toolConfig(tool)
+	if toolCfg == nil {
+		return false, nil
+	}
+	m := getProviderModel(toolCfg, toolCfg.CurrentModel)
+	if m == nil || strings.EqualFold(m.ModelName, "Original") {
+		return false, nil
+	}
+	translate := gatewayProtocol(tool, getProviderRegistry().Resolve(tool, m).WireApi) == "chat"
+	failover := len(toolCfg.Failover) > 0
+	if (tool != "claude" && tool != "codex") || (!translate && !failover && !config.UsageTracking) {
+		return false, nil
+	}
+	port, token, err := a.ensureGateway(config)
+	if err != nil {
+		return false, fmt.Errorf("starting the gateway for %s: %w", m.ModelName, err)
+	}
+	var projectId, pinned string
+	if project != nil {
+		projectId = project.Id
+		if project.Tool == "" || strings.EqualFold(project.Tool, tool) {
+			pinned = project.ModelId
+		}
+	}
+	m.ModelUrl = gatewayRouteURL(port, tool, m.ModelName, projectId, pinned)
+	m.ApiKey, m.Keys, m.WireApi = token, nil, ""
+	if tool == "codex" {
+		m.WireApi = "responses" // What the gateway serves Codex
+	}
+	if translate {
+		a.log(fmt.Sprintf("%s speaks Chat Completions, routing %s through the gateway at %s", m.ModelName, tool, m.ModelUrl))
+	} else if failover {
+		a.log(fmt.Sprintf("Failover to %s: routing %s through the gateway at %s", strings.Join(toolCfg.Failover, ", "), tool, m.ModelUrl))
+	} else {
+		a.log(fmt.Sprintf("Usage tracking: routing %s through the gateway at %s", tool, m.ModelUrl))
+	}
+	return true, nil
+}
+
+// serveGateway runs the gateway until ctx is done or it is asked to stop.
+func (a *App) serveGateway(ctx context.Context, port int) error {
+	token, err := gatewayToken()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	gw := &gateway{app: a, token: token, keys: make(map[string]gatewayKey), clients: make(map[string]*http.Client),
+		breakers: make(map[string]*gatewayBreaker), failovers: make(map[string]GatewayFailover), shutdownFn: cancel}
+	server := &http.Server{Handler: gw, ReadHeaderTimeout: 30 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		server.Shutdown(shutdownCtx)
+	}()
+	a.log(fmt.Sprintf("Gateway listening on 127.0.0.1:%d", port))
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// StopGateway asks a running gateway to exit.
+func (a *App) StopGateway() error {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return err
+	}
+	token, err := gatewayToken()
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/shutdown", getGatewayPort(&config)), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return errors.New("the gateway is not running")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the gateway refused to stop (HTTP %d)", resp.StatusCode)
+	}
+	return nil
+}
+
+// authorized checks the token a tool sends as its API key.
+func (gw *gateway) authorized(r *http.Request) bool {
+	token := r.Header.Get("x-api-key")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(gw.token)) == 1
+}
+
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/health":
+		health := map[string]interface{}{"service": gatewayServiceName, "pid": os.Getpid(), "authorized": gw.authorized(r)}
+		if gw.authorized(r) {
+			health["failovers"] = gw.activeFailovers()
+		}
+		writeJSON(w, http.StatusOK, health)
+		return
+	case "/shutdown":
+		if r.Method != http.MethodPost || !gw.authorized(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"stopping": true})
+		gw.app.log("Gateway stopping")
+		go gw.shutdownFn()
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 4)
+	if len(parts) < 4 {
+		http.NotFound(w, r)
+		return
+	}
+	apiPath := "/" + parts[3]
+	var pinned string
+	if strings.HasPrefix(parts[3], "@") {
+		segment, rest, _ := strings.Cut(parts[3], "/")
+		pinned, _ = url.PathUnescape(segment[1:])
+		apiPath = "/" + rest
+	}
+	if !gw.authorized(r) {
+		writeGatewayError(w, apiPath, http.StatusUnauthorized, "the gateway token is missing or wrong, relaunch the tool from AICoder")
+		return
+	}
+	tool, _ := url.PathUnescape(parts[0])
+	provider, _ := url.PathUnescape(parts[1])
+	project, _ := url.PathUnescape(parts[2])
+	if project == "-" {
+		project = ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, gatewayMaxRequest))
+	if err != nil {
+		writeGatewayError(w, apiPath, http.StatusBadRequest, err.Error())
+		return
+	}
+	chain := gw.failoverChain(tool, provider)
+	var skipped []string // Why the providers before were skipped
+	for i, name := range chain {
+		last := i == len(chain)-1
+		var model string
+		if strings.EqualFold(name, provider) {
+			model = pinned // Other providers of the chain do not offer it
+		}
+		upstream, err := gw.upstream(tool, name, project, model)
+		if err != nil {
+			if last {
+				writeGatewayError(w, apiPath, http.StatusBadGateway, err.Error())
+				return
+			}
+			gw.app.log(fmt.Sprintf("Gateway %s/%s: %v", tool, name, err))
+			continue
+		}
+		call, err := gw.prepare(r, upstream, apiPath, body, !strings.EqualFold(name, provider))
+		if err != nil {
+			writeGatewayError(w, apiPath, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		if call.req == nil {
+			call.respond(w, nil)
+			return
+		}
+		resp, err := upstream.client.Do(call.req)
+		if err != nil && r.Context().Err() != nil {
+			return // The tool went away
+		}
+		gw.keyRejected(upstream, resp)
+		if failed := failoverReason(resp, err); failed != "" && len(chain) > 1 {
+			gw.providerFailed(upstream.Tool, name, failed)
+			if !last {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				skipped = append(skipped, name+": "+failed)
+				select {
+				case <-time.After(failoverDelay(i)):
+				case <-r.Context().Done():
+					return
+				}
+				continue
+			}
+		} else if len(chain) > 1 {
+			gw.providerServed(upstream.Tool, provider, name, strings.Join(skipped, "; "))
+		}
+		if err != nil {
+			gw.app.log(fmt.Sprintf("Gateway %s/%s: %v", upstream.Tool, name, err))
+			writeGatewayError(w, apiPath, http.StatusBadGateway, "the provider cannot be reached: "+err.Error())
+			return
+		}
+		defer resp.Body.Close()
+		call.respond(w, resp)
+		return
+	}
+}
+
+// prepare builds the request to an upstream for what the tool asked. fallback is set
+// for the providers of the failover chain, which get their own model.
+func (gw *gateway) prepare(r *http.Request, u *gatewayUpstream, apiPath string, body []byte, fallback bool) (*gatewayCall, error) {
+	switch client := clientProtocol(apiPath); {
+	case apiPath == "/v1/messages" && u.Protocol == "chat":
+		return gw.anthropicToChat(r, u, body)
+	case client == "responses" && u.Protocol == "chat":
+		return gw.responsesToChat(r, u, body)
+	case client == u.Protocol || client == "":
+		if model := requestModel(body); fallback && model != "" {
+			body = withModel(body, u.model(model))
+		}
+		return gw.passthrough(r, u, apiPath, body)
+	case apiPath == "/v1/messages/count_tokens":
+		return &gatewayCall{respond: func(w http.ResponseWriter, _ *http.Response) {
+			writeJSON(w, http.StatusOK, map[string]int{"input_tokens": estimateTokens(body)})
+		}}, nil
+	}
+	return &gatewayCall{respond: func(w http.ResponseWriter, _ *http.Response) {
+		writeGatewayError(w, apiPath, http.StatusNotFound, fmt.Sprintf("%s is not supported for %s providers", apiPath, u.Protocol))
+	}}, nil
+}
+
+// loadConfig returns the config, reloading it when the file changed.
+func (gw *gateway) loadConfig() (AppConfig, error) {
+	path, _ := gw.app.getConfigPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		return AppConfig{}, err
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if !info.ModTime().Equal(gw.configMod) {
+		config, err := gw.app.LoadConfig()
+		if err != nil {
+			return AppConfig{}, err
+		}
+		gw.config, gw.configMod = config, info.ModTime()
+		gw.keys = make(map[string]gatewayKey)
+	}
+	return gw.config, nil
+}
+
+// upstream looks up the provider of a route. A pinned model comes first in its models.
+func (gw *gateway) upstream(tool, provider, projectId, pinned string) (*gatewayUpstream, error) {
+	config, err := gw.loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading the config: %w", err)
+	}
+	m, err := findProviderEntry(&config, tool, provider)
+	if err != nil {
+		return nil, err
+	}
+	ep := getProviderRegistry().Resolve(tool, m)
+	u := &gatewayUpstream{Tool: strings.ToLower(tool), Provider: m.ModelName, Project: projectId, BaseUrl: strings.TrimRight(strings.TrimSpace(ep.BaseUrl), "/")}
+	u.Protocol = gatewayProtocol(u.Tool, ep.WireApi)
+	if pinned != "" {
+		u.Models = append(u.Models, pinned)
+	}
+	for _, id := range strings.Split(ep.ModelId, ",") {
+		if id = strings.TrimSpace(id); id != "" && !strings.EqualFold(id, pinned) {
+			u.Models = append(u.Models, id)
+		}
+	}
+	if u.BaseUrl == "" {
+		return nil, fmt.Errorf("provider %s has no base URL", m.ModelName)
+	}
+	if u.keyRef, u.ApiKey, err = gw.providerKey(u.Tool, m); err != nil {
+		return nil, err
+	}
+	var projectDir string
+	for _, p := range config.Projects {
+		if p.Id == projectId {
+			projectDir = p.Path
+		}
+	}
+	u.client, err = gw.client(&config, projectDir)
+	return u, err
+}
+
+// gatewayProtocol returns what a provider entry speaks: Claude entries speak Anthropic
+// Messages unless wire_api is "chat", other tools Chat Completions or Responses.
+func gatewayProtocol(tool, wireApi string) string {
+	switch {
+	case tool == "claude" && !strings.EqualFold(wireApi, "chat"):
+		return "anthropic"
+	case strings.EqualFold(wireApi, "responses"):
+		return "responses"
+	}
+	return "chat"
+}
+
+// providerKey picks the key of a provider entry for a request, so round-robin and LRU
+// spread requests over the keys, and returns it as configured and resolved. Resolved
+// references are kept for a few minutes so a cmd: reference does not run on every request.
+func (gw *gateway) providerKey(tool string, m *ModelConfig) (configured, resolved string, err error) {
+	configured = m.ApiKey
+	if len(m.Keys) > 0 {
+		chosen, err := chooseProviderKey(tool, m, time.Now())
+		if err != nil && chosen.ApiKey == "" {
+			return "", "", err
+		} else if err != nil {
+			gw.app.log(err.Error())
+		}
+		configured = chosen.ApiKey
+	}
+	if err := checkSecretResolved(m.ModelName, configured); err != nil {
+		return "", "", err
+	}
+	if !isKeyRef(configured) {
+		resolved = configured
+	} else {
+		gw.mu.Lock()
+		cached, ok := gw.keys[configured]
+		gw.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return configured, cached.value, nil
+		}
+		if resolved, err = resolveKeyRef(configured); err != nil {
+			return "", "", fmt.Errorf("resolving the API key of %s: %w", m.ModelName, err)
+		}
+		if resolved != "" {
+			gw.mu.Lock()
+			gw.keys[configured] = gatewayKey{value: resolved, expires: time.Now().Add(gatewayKeyCacheTTL)}
+			gw.mu.Unlock()
+		}
+	}
+	if resolved == "" {
+		return "", "", fmt.Errorf("provider %s has no API key", m.ModelName)
+	}
+	return configured, resolved, nil
+}
+
+// keyRejected records that an upstream refused the key of a request (401, 403 or 429),
+// so the next requests and launches prefer the provider's other keys for a while.
+func (gw *gateway) keyRejected(u *gatewayUpstream, resp *http.Response) {
+	if resp == nil || (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests) {
+		return
+	}
+	if err := markKeyRejected(u.Tool, u.Provider, u.keyRef, time.Now()); err != nil {
+		gw.app.log(fmt.Sprintf("Gateway %s/%s: recording the rejected key: %v", u.Tool, u.Provider, err))
+	}
+}
+
+// client returns the HTTP client for upstream requests, going through the project's
+// proxy when it enables one. Clients are shared so connections are reused.
+func (gw *gateway) client(config *AppConfig, projectDir string) (*http.Client, error) {
+	var proxyURL string
+	if project := config.projectForDir(projectDir); projectDir != "" && project != nil && project.UseProxy {
+		proxyURL, _ = config.proxyFor(project)
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if c, ok := gw.clients[proxyURL]; ok {
+		return c, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: gatewayConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = 10 * time.Minute
+	transport.Proxy = nil
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	c := &http.Client{Transport: transport}
+	gw.clients[proxyURL] = c
+	return c, nil
+}
+
+// newPost builds a JSON request to the upstream with its key as a bearer token.
+func (u *gatewayUpstream) newPost(ctx context.Context, path string, body interface{}) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.BaseUrl+path, strings.NewReader(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+u.ApiKey)
+	return req, nil
+}
+
+// chatCompletionsPath returns the path to append to the base URL for Chat Completions.
+func (u *gatewayUpstream) chatCompletionsPath() string {
+	if strings.HasSuffix(u.BaseUrl, "/chat/completions") {
+		return ""
+	}
+	return "/chat/completions"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeGatewayError reports an error in the format of the API the tool called.
+func writeGatewayError(w http.ResponseWriter, apiPath string, status int, message string) {
+	if strings.HasPrefix(apiPath, "/v1/messages") {
+		writeAnthropicError(w, status, message)
+		return
+	}
+	writeOpenAIError(w, status, message)
+}
+
+// writeOpenAIError writes an error the way the OpenAI APIs report it.
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errType, code := "invalid_request_error", ""
+	switch {
+	case status == http.StatusUnauthorized:
+		errType, code = "authentication_error", "invalid_api_key"
+	case status == http.StatusTooManyRequests:
+		errType, code = "rate_limit_error", "rate_limit_exceeded"
+	case status >= 500:
+		errType, code = "server_error", "server_error"
+	}
+	writeJSON(w, status, map[string]interface{}{"error": map[string]interface{}{"message": message, "type": errType, "code": code}})
+}
+
+// estimateTokens roughly counts the tokens of a request, for count_tokens calls the
+// upstream has no equivalent of.
+func estimateTokens(body []byte) int {
+	return len(body)/4 + 1
+}
+
+// sseWriter writes server-sent events and flushes after each one.
+type sseWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+func (s *sseWriter) event(name string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	if name != "" {
+		fmt.Fprintf(s.w, "event: %s\n", name)
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", payload)
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// timestampWriter prefixes each write, i.e. each log line, with the time.
+type timestampWriter struct{ w io.Writer }
+
+func (t timestampWriter) Write(p []byte) (int, error) {
+	fmt.Fprint(t.w, time.Now().Format("2006-01-02 15:04:05 "))
+	return t.w.Write(p)
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Translation of Anthropic Messages requests, as sent by Claude Code, to OpenAI Chat
+// Completions and of the responses back, streamed or not.
+//
+// Thinking blocks of earlier turns are only sent back (as reasoning_content) with the
+// tool calls they led to, which is what providers with reasoning and tools expect.
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        json.RawMessage      `json:"system"` // String or text blocks
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature"`
+	TopP          *float64             `json:"top_p"`
+	StopSequences []string             `json:"stop_sequences"`
+	Stream        bool                 `json:"stream"`
+	Tools         []anthropicTool      `json:"tools"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice"`
+	Thinking      *anthropicThinking   `json:"thinking"`
+	Metadata      struct {
+		UserId string `json:"user_id"`
+	} `json:"metadata"`
+}
+
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // String or blocks
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Signature *string          `json:"signature,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+	Id        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseId string           `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage  `json:"content,omitempty"` // tool_result: string or blocks
+	IsError   bool             `json:"is_error,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"` // "base64" or "url"
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Type        string          `json:"type"` // Empty or "custom" for client tools
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"` // "auto", "any", "tool" or "none"
+	Name string `json:"name"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"` // "enabled" or "disabled"
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// reasoningEffort maps a thinking budget to a Chat Completions reasoning effort. Claude
+// Code asks for about 4k tokens to "think", 10k to "think hard" and 32k to "ultrathink".
+func (t *anthropicThinking) reasoningEffort() string {
+	switch {
+	case t == nil || t.Type != "enabled":
+		return ""
+	case t.BudgetTokens <= 4096:
+		return "low"
+	case t.BudgetTokens <= 16384:
+		return "medium"
+	}
+	return "high"
+}
+
+type anthropicResponse struct {
+	Id           string           `json:"id"`
+	Type         string           `json:"type"`
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      []anthropicBlock `json:"content"`
+	StopReason   *string          `json:"stop_reason"`
+	StopSequence *string          `json:"stop_sequence"`
+	Usage        anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens          int `json:"input_tokens"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens"`
+}
+
+func newAnthropicUsage(u *chatUsage) anthropicUsage {
+	if u == nil {
+		return anthropicUsage{}
+	}
+	cached := u.cachedTokens()
+	return anthropicUsage{InputTokens: u.PromptTokens - cached, OutputTokens: u.CompletionTokens, CacheReadInputTokens: cached}
+}
+
+// parseAnthropicContent reads message content, turning a plain string into a text block.
+func parseAnthropicContent(raw json.RawMessage) ([]anthropicBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []anthropicBlock
+	err := json.Unmarshal(raw, &blocks)
+	return blocks, err
+}
+
+// joinText joins the text blocks of content.
+func joinText(blocks []anthropicBlock, sep string) string {
+	var parts []string
+	for _, b := range blocks {
+		if b.Type == "text" && b.Text != "" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func imagePart(src *anthropicSource) (chatPart, bool) {
+	switch {
+	case src == nil:
+		return chatPart{}, false
+	case src.Type == "base64":
+		return chatPart{Type: "image_url", ImageUrl: &chatImageUrl{Url: "data:" + src.MediaType + ";base64," + src.Data}}, true
+	case src.Type == "url" && src.Url != "":
+		return chatPart{Type: "image_url", ImageUrl: &chatImageUrl{Url: src.Url}}, true
+	}
+	return chatPart{}, false
+}
+
+// userContent returns the content of a user message: a plain string when there are no
+// images, since not every provider accepts content parts.
+func userContent(parts []chatPart) interface{} {
+	var texts []string
+	for _, p := range parts {
+		if p.Type != "text" {
+			return parts
+		}
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// anthropicToChatRequest translates a Messages request for the upstream model.
+func anthropicToChatRequest(req *anthropicRequest, model string) (*chatRequest, error) {
+	out := &chatRequest{
+		Model:           model,
+		MaxTokens:       req.MaxTokens,
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		Stop:            req.StopSequences,
+		Stream:          req.Stream,
+		User:            req.Metadata.UserId,
+		ReasoningEffort: req.Thinking.reasoningEffort(),
+	}
+	if req.Stream {
+		out.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	}
+	if system, err := parseAnthropicContent(req.System); err != nil {
+		return nil, fmt.Errorf("system: %w", err)
+	} else if text := joinText(system, "\n\n"); text != "" {
+		out.Messages = append(out.Messages, chatMessage{Role: "system", Content: text})
+	}
+	for i, msg := range req.Messages {
+		blocks, err := parseAnthropicContent(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if msg.Role == "assistant" {
+			out.Messages = append(out.Messages, assistantToChat(blocks))
+			continue
+		}
+		// Tool results become tool messages, which must directly follow the assistant
+		// message with the calls; the rest of the turn follows as a user message
+		var parts []chatPart
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				parts = append(parts, chatPart{Type: "text", Text: b.Text})
+			case "image":
+				if p, ok := imagePart(b.Source); ok {
+					parts = append(parts, p)
+				}
+			case "tool_result":
+				result, err := parseAnthropicContent(b.Content)
+				if err != nil {
+					return nil, fmt.Errorf("messages[%d]: tool_result: %w", i, err)
+				}
+				text := joinText(result, "\n")
+				if b.IsError {
+					text = "Error: " + text
+				}
+				out.Messages = append(out.Messages, chatMessage{Role: "tool", ToolCallId: b.ToolUseId, Content: text})
+				for _, r := range result {
+					if p, ok := imagePart(r.Source); ok && r.Type == "image" {
+						parts = append(parts, p)
+					}
+				}
+			}
+		}
+		if len(parts) > 0 {
+			out.Messages = append(out.Messages, chatMessage{Role: "user", Content: userContent(parts)})
+		}
+	}
+	for _, t := range req.Tools {
+		if (t.Type != "" && t.Type != "custom") || len(t.InputSchema) == 0 {
+			continue // Server tools such as web search only exist at Anthropic
+		}
+		out.Tools = append(out.Tools, chatTool{Type: "function", Function: chatFunction{Name: t.Name, Description: t.Description, Parameters: t.InputSchema}})
+	}
+	if req.ToolChoice != nil && len(out.Tools) > 0 {
+		switch req.ToolChoice.Type {
+		case "any":
+			out.ToolChoice = "required"
+		case "tool":
+			out.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]string{"name": req.ToolChoice.Name}}
+		case "none":
+			out.ToolChoice = "none"
+		}
+	}
+	return out, nil
+}
+
+func assistantToChat(blocks []anthropicBlock) chatMessage {
+	msg := chatMessage{Role: "assistant"}
+	var thinking []string
+	for _, b := range blocks {
+		switch b.Type {
+		case "thinking":
+			thinking = append(thinking, b.Thinking)
+		case "tool_use":
+			input := string(b.Input)
+			if input == "" || input == "null" {
+				input = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, chatToolCall{Id: b.Id, Type: "function", Function: chatFunctionCall{Name: b.Name, Arguments: input}})
+		}
+	}
+	if text := joinText(blocks, "\n"); text != "" {
+		msg.Content = text
+	}
+	if len(msg.ToolCalls) > 0 {
+		msg.ReasoningContent = strings.Join(thinking, "\n")
+	}
+	return msg
+}
+
+// anthropicStopReason maps a Chat Completions finish reason.
+func anthropicStopReason(finish string, hasToolCalls bool) string {
+	switch {
+	case finish == "tool_calls" || finish == "function_call" || (hasToolCalls && finish != "length"):
+		return "tool_use"
+	case finish == "length":
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+// chatToAnthropicResponse translates a whole Chat Completions response.
+func chatToAnthropicResponse(resp *chatResponse, model string) *anthropicResponse {
+	out := &anthropicResponse{Id: newMessageId(), Type: "message", Role: "assistant", Model: model, Content: []anthropicBlock{}, Usage: newAnthropicUsage(resp.Usage)}
+	var finish string
+	var hasToolCalls bool
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		finish = choice.FinishReason
+		if r := choice.Message.reasoning(); r != "" {
+			out.Content = append(out.Content, anthropicBlock{Type: "thinking", Thinking: r, Signature: new(string)})
+		}
+		if choice.Message.Content != "" {
+			out.Content = append(out.Content, anthropicBlock{Type: "text", Text: choice.Message.Content})
+		}
+		for _, call := range choice.Message.ToolCalls {
+			out.Content = append(out.Content, anthropicBlock{Type: "tool_use", Id: toolCallId(call.Id), Name: call.Function.Name, Input: toolArguments(call.Function.Arguments)})
+			hasToolCalls = true
+		}
+	}
+	stop := anthropicStopReason(finish, hasToolCalls)
+	out.StopReason = &stop
+	return out
+}
+
+func newMessageId() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "msg_" + hex.EncodeToString(buf)
+}
+
+// toolCallId returns the ID of a tool call, making one up for providers that send none.
+func toolCallId(id string) string {
+	if id != "" {
+		return id
+	}
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return "toolu_" + hex.EncodeToString(buf)
+}
+
+// anthropicToChat serves /v1/messages from a Chat Completions upstream.
+func (gw *gateway) anthropicToChat(r *http.Request, u *gatewayUpstream, body []byte) (*gatewayCall, error) {
+	var req anthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	model := u.model(req.Model)
+	chatReq, err := anthropicToChatRequest(&req, model)
+	if err != nil {
+		return nil, err
+	}
+	// Claude Code asks for more output than many models allow, which they refuse
+	if limit := cachedMaxOutputTokens(u.Protocol, u.BaseUrl, u.ApiKey, model); limit > 0 && chatReq.MaxTokens > limit {
+		chatReq.MaxTokens = limit
+	}
+	httpReq, err := u.newPost(r.Context(), u.chatCompletionsPath(), chatReq)
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayCall{req: httpReq, respond: func(w http.ResponseWriter, resp *http.Response) {
+		if resp.StatusCode != http.StatusOK {
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			msg := providerErrorMessage(data)
+			gw.app.log(fmt.Sprintf("Gateway %s/%s: HTTP %d: %s", u.Tool, u.Provider, resp.StatusCode, msg))
+			writeAnthropicError(w, resp.StatusCode, msg)
+			return
+		}
+		if !req.Stream {
+			var chatResp chatResponse
+			if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+				writeAnthropicError(w, http.StatusBadGateway, "the provider sent an invalid response: "+err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, chatToAnthropicResponse(&chatResp, model))
+			gw.recordUsage(u, model, chatTokenCounts(chatResp.Usage))
+			return
+		}
+		usage := streamChatAsAnthropic(newSSEWriter(w), resp.Body, model)
+		gw.recordUsage(u, model, chatTokenCounts(usage))
+	}}, nil
+}
+
+// streamChatAsAnthropic translates a Chat Completions stream into Messages events.
+// Thinking and text are passed on as they arrive; tool calls are sent whole at the
+// end, since their fragments can interleave.
+func streamChatAsAnthropic(sse *sseWriter, body io.Reader, model string) *chatUsage {
+	sse.event("message_start", map[string]interface{}{"type": "message_start", "message": anthropicResponse{
+		Id: newMessageId(), Type: "message", Role: "assistant", Model: model, Content: []anthropicBlock{},
+	}})
+	index, open := 0, "" // Current content block and its type
+	closeBlock := func() {
+		if open != "" {
+			sse.event("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+			index, open = index+1, ""
+		}
+	}
+	delta := func(blockType, text string) {
+		if open != blockType {
+			closeBlock()
+			block := map[string]interface{}{"type": blockType, blockType: ""}
+			if blockType == "thinking" {
+				block["signature"] = ""
+			}
+			sse.event("content_block_start", map[string]interface{}{"type": "content_block_start", "index": index, "content_block": block})
+			open = blockType
+		}
+		sse.event("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": index, "delta": map[string]string{"type": blockType + "_delta", blockType: text}})
+	}
+
+	var tools chatToolCallBuffer
+	var usage *chatUsage
+	var finish, streamErr string
+	err := readSSE(body, func(_, data string) bool {
+		if data == "[DONE]" {
+			return false
+		}
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return true // Skip what is not a chunk
+		}
+		if chunk.Error != nil {
+			raw, _ := json.Marshal(map[string]interface{}{"error": chunk.Error})
+			streamErr = providerErrorMessage(raw)
+			return false
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if r := choice.Delta.reasoning(); r != "" {
+				delta("thinking", r)
+			}
+			if choice.Delta.Content != "" {
+				delta("text", choice.Delta.Content)
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				tools.add(call)
+			}
+			if choice.FinishReason != "" {
+				finish = choice.FinishReason
+			}
+		}
+		return true
+	})
+	if err != nil {
+		streamErr = "the stream from the provider broke off: " + err.Error()
+	}
+	closeBlock()
+	if streamErr != "" {
+		sse.event("error", map[string]interface{}{"type": "error", "error": map[string]string{"type": "api_error", "message": streamErr}})
+		return usage
+	}
+	for _, call := range tools.calls {
+		sse.event("content_block_start", map[string]interface{}{"type": "content_block_start", "index": index, "content_block": map[string]interface{}{
+			"type": "tool_use", "id": toolCallId(call.Id), "name": call.Function.Name, "input": map[string]interface{}{},
+		}})
+		sse.event("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": index, "delta": map[string]string{
+			"type": "input_json_delta", "partial_json": string(toolArguments(call.Function.Arguments)),
+		}})
+		sse.event("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+		index++
+	}
+	sse.event("message_delta", map[string]interface{}{"type": "message_delta",
+		"delta": map[string]interface{}{"stop_reason": anthropicStopReason(finish, len(tools.calls) > 0), "stop_sequence": nil},
+		"usage": newAnthropicUsage(usage),
+	})
+	sse.event("message_stop", map[string]string{"type": "message_stop"})
+	return usage
+}
+
+// writeAnthropicError writes an error the way the Messages API reports it, so the tool
+// shows the message and retries where it would for Anthropic.
+func writeAnthropicError(w http.ResponseWriter, status int, message string) {
+	errType := "api_error"
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusForbidden:
+		errType = "permission_error"
+	case http.StatusNotFound:
+		errType = "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		errType = "request_too_large"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		errType = "overloaded_error"
+	}
+	writeJSON(w, status, map[string]interface{}{"type": "error", "error": map[string]string{"type": errType, "message": message}})
+}
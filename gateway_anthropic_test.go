@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func chatRelayConfig(baseUrl string) AppConfig {
+	return AppConfig{Claude: ToolConfig{CurrentModel: "Relay", Models: []ModelConfig{
+		{ModelName: "Relay", ModelUrl: baseUrl, ModelId: "relay-large, relay-small", ApiKey: "sk-relay", WireApi: "chat"},
+	}}}
+}
+
+// TestAnthropicToChat sends each testdata/gateway_anthropic/<case>.request.json through
+// the gateway, see checkTranslationGoldens.
+func TestAnthropicToChat(t *testing.T) {
+	checkTranslationGoldens(t, filepath.Join("testdata", "gateway_anthropic"), "/claude/Relay/-/v1/messages", chatRelayConfig)
+}
+
+// TestAnthropicStreamEvents checks the order of the translated events: every block is
+// started, receives deltas and is stopped before the next starts, with rising indexes.
+func TestAnthropicStreamEvents(t *testing.T) {
+	var received []upstreamRequest
+	srv := chatUpstream(t, filepath.Join("testdata", "gateway_anthropic", "stream-tool-calls.upstream.sse"), &received)
+	gw := newTestGateway(t, chatRelayConfig(srv.URL))
+	rec := serveGatewayRequest(gw, "/claude/Relay/-/v1/messages", `{"model":"x","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+	var events []string
+	open, next := -1, 0
+	readSSE(rec.Body, func(event, data string) bool {
+		var e struct {
+			Type  string `json:"type"`
+			Index int    `json:"index"`
+		}
+		if err := json.Unmarshal([]byte(data), &e); err != nil || e.Type != event {
+			t.Errorf("event %s with data %s", event, data)
+		}
+		events = append(events, event)
+		switch event {
+		case "content_block_start":
+			if open != -1 || e.Index != next {
+				t.Errorf("block %d started while %d is open or out of order", e.Index, open)
+			}
+			open = e.Index
+		case "content_block_delta":
+			if e.Index != open {
+				t.Errorf("delta for block %d while %d is open", e.Index, open)
+			}
+		case "content_block_stop":
+			if e.Index != open {
+				t.Errorf("stop for block %d while %d is open", e.Index, open)
+			}
+			open, next = -1, next+1
+		}
+		return true
+	})
+	if next != 3 || events[0] != "message_start" || events[len(events)-1] != "message_stop" {
+		t.Errorf("events = %v, want a text block and two tool blocks between message_start and message_stop", events)
+	}
+}
+
+func TestAnthropicPinnedModel(t *testing.T) {
+	request := `{"model":"%s","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+	cases := []struct {
+		route, requested, want string
+	}{
+		{"/claude/Relay/-/v1/messages", "claude-sonnet-4-5", "relay-large"},
+		{"/claude/Relay/-/v1/messages", "RELAY-SMALL", "relay-small"},
+		{"/claude/Relay/p1/@org%2Fpinned-model/v1/messages", "org/pinned-model", "org/pinned-model"},
+		{"/claude/Relay/p1/@org%2Fpinned-model/v1/messages", "claude-haiku-4-5", "org/pinned-model"},
+	}
+	for _, c := range cases {
+		var received []upstreamRequest
+		srv := chatUpstream(t, filepath.Join("testdata", "gateway_anthropic", "images.upstream.json"), &received)
+		gw := newTestGateway(t, chatRelayConfig(srv.URL))
+		rec := serveGatewayRequest(gw, c.route, strings.Replace(request, "%s", c.requested, 1))
+		if rec.Code != http.StatusOK || len(received) != 1 {
+			t.Fatalf("%s: HTTP %d, %d upstream requests", c.route, rec.Code, len(received))
+		}
+		var got chatRequest
+		json.Unmarshal(received[0].Body, &got)
+		if got.Model != c.want {
+			t.Errorf("%s with %s: upstream model %q, want %q", c.route, c.requested, got.Model, c.want)
+		}
+		var resp anthropicResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Model != c.want {
+			t.Errorf("%s: response model %q, want %q", c.route, resp.Model, c.want)
+		}
+	}
+}
+
+func TestReasoningEffort(t *testing.T) {
+	cases := []struct {
+		thinking *anthropicThinking
+		want     string
+	}{
+		{nil, ""},
+		{&anthropicThinking{Type: "disabled", BudgetTokens: 10000}, ""},
+		{&anthropicThinking{Type: "enabled", BudgetTokens: 1024}, "low"},
+		{&anthropicThinking{Type: "enabled", BudgetTokens: 4000}, "low"},
+		{&anthropicThinking{Type: "enabled", BudgetTokens: 10000}, "medium"},
+		{&anthropicThinking{Type: "enabled", BudgetTokens: 31999}, "high"},
+	}
+	for _, c := range cases {
+		if got := c.thinking.reasoningEffort(); got != c.want {
+			t.Errorf("reasoningEffort(%+v) = %q, want %q", c.thinking, got, c.want)
+		}
+	}
+	req := anthropicRequest{Thinking: &anthropicThinking{Type: "enabled", BudgetTokens: 10000}}
+	chatReq, err := anthropicToChatRequest(&req, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(chatReq)
+	if !bytes.Contains(data, []byte(`"reasoning_effort":"medium"`)) {
+		t.Errorf("chat request %s has no reasoning_effort", data)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// OpenAI Chat Completions, the upstream side of the gateway. Only the fields the
+// translations use are declared; providers add their own (reasoning_content, the
+// DeepSeek cache counters) which are read where they are common.
+
+type chatRequest struct {
+	Model           string             `json:"model"`
+	Messages        []chatMessage      `json:"messages"`
+	MaxTokens       int                `json:"max_tokens,omitempty"`
+	Temperature     *float64           `json:"temperature,omitempty"`
+	TopP            *float64           `json:"top_p,omitempty"`
+	Stop            []string           `json:"stop,omitempty"`
+	Stream          bool               `json:"stream,omitempty"`
+	StreamOptions   *chatStreamOptions `json:"stream_options,omitempty"`
+	Tools           []chatTool         `json:"tools,omitempty"`
+	ToolChoice      interface{}        `json:"tool_choice,omitempty"`
+	ReasoningEffort string             `json:"reasoning_effort,omitempty"`
+	User            string             `json:"user,omitempty"`
+}
+
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role             string         `json:"role"`
+	Content          interface{}    `json:"content"` // string, []chatPart or nil
+	ReasoningContent string         `json:"reasoning_content,omitempty"`
+	ToolCalls        []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallId       string         `json:"tool_call_id,omitempty"`
+}
+
+type chatPart struct {
+	Type     string        `json:"type"` // "text" or "image_url"
+	Text     string        `json:"text,omitempty"`
+	ImageUrl *chatImageUrl `json:"image_url,omitempty"`
+}
+
+type chatImageUrl struct {
+	Url string `json:"url"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"` // "function"
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type chatToolCall struct {
+	Index    *int             `json:"index,omitempty"` // Only in stream deltas
+	Id       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function chatFunctionCall `json:"function"`
+}
+
+type chatFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// chatResponse is a whole response or, with Delta set, one chunk of a stream.
+type chatResponse struct {
+	Id      string       `json:"id"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage"`
+	Error   interface{}  `json:"error"` // Some providers report errors inside a 200 stream
+}
+
+type chatChoice struct {
+	Index        int                 `json:"index"`
+	Message      chatResponseMessage `json:"message"`
+	Delta        chatResponseMessage `json:"delta"`
+	FinishReason string              `json:"finish_reason"`
+}
+
+type chatResponseMessage struct {
+	Role             string         `json:"role"`
+	Content          string         `json:"content"`
+	ReasoningContent string         `json:"reasoning_content"`
+	Reasoning        string         `json:"reasoning"` // OpenRouter and vLLM name for reasoning_content
+	ToolCalls        []chatToolCall `json:"tool_calls"`
+}
+
+// reasoning returns the reasoning text under either name.
+func (m *chatResponseMessage) reasoning() string {
+	if m.ReasoningContent != "" {
+		return m.ReasoningContent
+	}
+	return m.Reasoning
+}
+
+type chatUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CompletionTokensDetails *struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"` // DeepSeek
+}
+
+// cachedTokens returns how many prompt tokens were read from the provider's cache.
+func (u *chatUsage) cachedTokens() int {
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		return u.PromptTokensDetails.CachedTokens
+	}
+	return u.PromptCacheHitTokens
+}
+
+func (u *chatUsage) reasoningTokens() int {
+	if u.CompletionTokensDetails != nil {
+		return u.CompletionTokensDetails.ReasoningTokens
+	}
+	return 0
+}
+
+// chatToolCallBuffer collects the fragments of streamed tool calls by index.
+type chatToolCallBuffer struct {
+	calls []chatToolCall
+	index map[int]int
+}
+
+func (b *chatToolCallBuffer) add(delta chatToolCall) {
+	if b.index == nil {
+		b.index = make(map[int]int)
+	}
+	i := len(b.calls)
+	if delta.Index != nil {
+		i = *delta.Index
+	}
+	pos, ok := b.index[i]
+	if !ok {
+		pos = len(b.calls)
+		b.index[i] = pos
+		b.calls = append(b.calls, chatToolCall{Type: "function"})
+	}
+	call := &b.calls[pos]
+	if delta.Id != "" {
+		call.Id = delta.Id
+	}
+	call.Function.Name += delta.Function.Name
+	call.Function.Arguments += delta.Function.Arguments
+}
+
+// toolArguments returns the JSON arguments of a tool call, "{}" when the model sent
+// none or sent something that is not JSON.
+func toolArguments(args string) json.RawMessage {
+	args = strings.TrimSpace(args)
+	if args == "" || !json.Valid([]byte(args)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(args)
+}
+
+// readSSE calls fn for each server-sent event in r until fn returns false or r ends.
+func readSSE(r io.Reader, fn func(event, data string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	var event string
+	var data []string
+	dispatch := func() bool {
+		defer func() { event, data = "", nil }()
+		if len(data) == 0 {
+			return true
+		}
+		return fn(event, strings.Join(data, "\n"))
+	}
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if !dispatch() {
+				return nil
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, used as keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	dispatch()
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A tool can have a failover chain: providers tried in order, each with its own key and
+// models, when the current one is rate limited (429), fails (5xx) or cannot be reached.
+// The chain is applied per request, before anything is sent to the tool, so a session
+// carries on with the next provider instead of stalling.
+//
+// Each provider has a circuit breaker: a failure opens it for a cooldown that doubles
+// with each further failure, and while it is open the provider is only tried after the
+// others. The first success closes it again.
+
+const (
+	failoverBackoff         = 500 * time.Millisecond // Before the second provider, doubled for each next one
+	failoverMaxBackoff      = 8 * time.Second
+	failoverBreakerCooldown = 30 * time.Second
+	failoverMaxCooldown     = 10 * time.Minute
+)
+
+// GatewayFailover is a provider the gateway currently replaces with one of its chain.
+type GatewayFailover struct {
+	Tool     string    `json:"tool"`
+	Provider string    `json:"provider"` // The provider the tool was launched with
+	Active   string    `json:"active"`   // The provider serving its requests
+	Reason   string    `json:"reason"`   // Why the providers before Active were skipped
+	Since    time.Time `json:"since"`
+}
+
+type gatewayBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// gatewayCall is a tool request prepared for one upstream. respond writes the
+// upstream's response to the tool; without req it answers on its own.
+type gatewayCall struct {
+	req     *http.Request
+	respond func(w http.ResponseWriter, resp *http.Response)
+}
+
+// failoverChain returns the providers to try for a route: the route's provider and then
+// the tool's failover list, with those whose breaker is open moved to the end.
+func (gw *gateway) failoverChain(tool, provider string) []string {
+	config, err := gw.loadConfig()
+	if err != nil {
+		return []string{provider}
+	}
+	names := []string{provider}
+	if toolCfg := config.toolConfig(tool); toolCfg != nil {
+		for _, name := range toolCfg.Failover {
+			dup := false
+			for _, n := range names {
+				dup = dup || strings.EqualFold(n, name)
+			}
+			if !dup {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 1 {
+		return names
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	var closed, open []string
+	for _, name := range names {
+		if b := gw.breakers[tool+"/"+strings.ToLower(name)]; b != nil && time.Now().Before(b.openUntil) {
+			open = append(open, name)
+		} else {
+			closed = append(closed, name)
+		}
+	}
+	return append(closed, open...)
+}
+
+// failoverReason returns why a provider should be skipped, "" if its response can go
+// to the tool.
+func failoverReason(resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	return ""
+}
+
+// providerFailed opens the breaker of a provider.
+func (gw *gateway) providerFailed(tool, name, reason string) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	key := tool + "/" + strings.ToLower(name)
+	b := gw.breakers[key]
+	if b == nil {
+		b = &gatewayBreaker{}
+		gw.breakers[key] = b
+	}
+	b.failures++
+	cooldown := failoverBreakerCooldown << (b.failures - 1)
+	if cooldown > failoverMaxCooldown || cooldown <= 0 {
+		cooldown = failoverMaxCooldown
+	}
+	b.openUntil = time.Now().Add(cooldown)
+	gw.app.log(fmt.Sprintf("Gateway %s/%s: %s, trying it after the rest of the chain for %s", tool, name, reason, cooldown))
+}
+
+// providerServed closes the breaker of the provider that answered, and records whether
+// it replaces the route's provider.
+func (gw *gateway) providerServed(tool, provider, name, reason string) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	delete(gw.breakers, tool+"/"+strings.ToLower(name))
+	key := tool + "/" + strings.ToLower(provider)
+	previous, switched := gw.failovers[key]
+	if strings.EqualFold(name, provider) {
+		if switched {
+			delete(gw.failovers, key)
+			gw.app.log(fmt.Sprintf("Gateway %s: back to %s", tool, provider))
+		}
+		return
+	}
+	if switched && previous.Active == name {
+		return
+	}
+	if reason == "" {
+		reason = provider + " failed recently"
+	}
+	gw.failovers[key] = GatewayFailover{Tool: tool, Provider: provider, Active: name, Reason: reason, Since: time.Now()}
+	gw.app.log(fmt.Sprintf("Gateway %s: switched from %s to %s (%s)", tool, provider, name, reason))
+}
+
+// activeFailovers lists the current switches for the health endpoint, in a stable order
+// so that pollers only see a change when there is one.
+func (gw *gateway) activeFailovers() []GatewayFailover {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	list := []GatewayFailover{}
+	for _, f := range gw.failovers {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Tool != list[j].Tool {
+			return list[i].Tool < list[j].Tool
+		}
+		return list[i].Provider < list[j].Provider
+	})
+	return list
+}
+
+// failoverDelay is the backoff before the attempt after attempt.
+func failoverDelay(attempt int) time.Duration {
+	d := failoverBackoff << attempt
+	if d > failoverMaxBackoff || d <= 0 {
+		return failoverMaxBackoff
+	}
+	return d
+}
+
+// withModel replaces the model of a JSON request, for providers that do not offer the
+// model the tool asked for.
+func withModel(body []byte, model string) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields["model"] == nil {
+		return body
+	}
+	fields["model"], _ = json.Marshal(model)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// requestModel returns the model of a JSON request.
+func requestModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Model
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failoverUpstreams are Anthropic upstreams, one per provider, that record the
+// providers in the order they are tried.
+type failoverUpstreams struct {
+	mu       sync.Mutex
+	attempts []string
+	status   map[string]int
+	urls     map[string]string
+}
+
+// newFailoverUpstreams starts an upstream answering with the status of each provider.
+// A status of 0 gives an address that refuses connections.
+func newFailoverUpstreams(t *testing.T, status map[string]int) *failoverUpstreams {
+	f := &failoverUpstreams{status: status, urls: map[string]string{}}
+	for name, code := range status {
+		name := name
+		if code == 0 {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.urls[name] = "http://" + ln.Addr().String()
+			ln.Close()
+			continue
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f.mu.Lock()
+			f.attempts = append(f.attempts, name)
+			code := f.status[name]
+			f.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			w.Write([]byte(`{"id":"msg_1","type":"message","content":[],"served_by":"` + name + `"}`))
+		}))
+		t.Cleanup(srv.Close)
+		f.urls[name] = srv.URL
+	}
+	return f
+}
+
+func (f *failoverUpstreams) config(current string, failover ...string) AppConfig {
+	var models []ModelConfig
+	for _, name := range append([]string{current}, failover...) {
+		models = append(models, ModelConfig{ModelName: name, ModelUrl: f.urls[name], ModelId: strings.ToLower(name) + "-model", ApiKey: "sk-" + name})
+	}
+	return AppConfig{Claude: ToolConfig{CurrentModel: current, Models: models, Failover: failover}}
+}
+
+// take returns the attempts so far and forgets them.
+func (f *failoverUpstreams) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attempts := f.attempts
+	f.attempts = nil
+	return attempts
+}
+
+func (f *failoverUpstreams) setStatus(name string, code int) {
+	f.mu.Lock()
+	f.status[name] = code
+	f.mu.Unlock()
+}
+
+const failoverRequest = `{"model":"claude-sonnet-4-5","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+
+func TestGatewayFailover(t *testing.T) {
+	f := newFailoverUpstreams(t, map[string]int{"Primary": 429, "Down": 0, "Good": 200})
+	gw := newTestGateway(t, f.config("Primary", "Down", "Good"))
+
+	start := time.Now()
+	rec := serveGatewayRequest(gw, "/claude/Primary/-/v1/messages", failoverRequest)
+	elapsed := time.Since(start)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"served_by":"Good"`) {
+		t.Fatalf("HTTP %d %s, want Good's answer", rec.Code, rec.Body.String())
+	}
+	// Down refuses connections, so only the servers that listen see the attempts
+	if got, want := f.take(), []string{"Primary", "Good"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("attempts = %v, want %v", got, want)
+	}
+	if want := failoverDelay(0) + failoverDelay(1); elapsed < want {
+		t.Errorf("the chain took %s, want a backoff of at least %s", elapsed, want)
+	}
+	failovers := gw.activeFailovers()
+	if len(failovers) != 1 || failovers[0].Tool != "claude" || failovers[0].Provider != "Primary" || failovers[0].Active != "Good" {
+		t.Fatalf("active failovers = %+v, want Primary replaced by Good", failovers)
+	}
+	if reason := failovers[0].Reason; !strings.Contains(reason, "Primary: HTTP 429") || !strings.Contains(reason, "Down: ") {
+		t.Errorf("reason = %q, want both skipped providers", reason)
+	}
+
+	// The breakers of Primary and Down are open: Good is tried first, without backoff
+	if got, want := gw.failoverChain("claude", "Primary"), []string{"Good", "Primary", "Down"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain with open breakers = %v, want %v", got, want)
+	}
+	rec = serveGatewayRequest(gw, "/claude/Primary/-/v1/messages", failoverRequest)
+	if got := f.take(); rec.Code != http.StatusOK || !reflect.DeepEqual(got, []string{"Good"}) {
+		t.Errorf("HTTP %d after attempts %v, want Good alone", rec.Code, got)
+	}
+
+	// Once the cooldown is over and Primary answers, the breaker closes and the switch ends
+	f.setStatus("Primary", 200)
+	gw.mu.Lock()
+	for _, b := range gw.breakers {
+		b.openUntil = time.Now().Add(-time.Second)
+	}
+	gw.mu.Unlock()
+	if got, want := gw.failoverChain("claude", "Primary"), []string{"Primary", "Down", "Good"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain after the cooldown = %v, want %v", got, want)
+	}
+	rec = serveGatewayRequest(gw, "/claude/Primary/-/v1/messages", failoverRequest)
+	if got := f.take(); rec.Code != http.StatusOK || !reflect.DeepEqual(got, []string{"Primary"}) {
+		t.Errorf("HTTP %d after attempts %v, want Primary alone", rec.Code, got)
+	}
+	if failovers := gw.activeFailovers(); len(failovers) != 0 {
+		t.Errorf("active failovers = %+v, want none", failovers)
+	}
+	if _, open := gw.breakers["claude/primary"]; open {
+		t.Error("Primary's breaker was not reset")
+	}
+}
+
+func TestGatewayFailoverLastProvider(t *testing.T) {
+	f := newFailoverUpstreams(t, map[string]int{"Busy": 503, "Limited": 429})
+	gw := newTestGateway(t, f.config("Busy", "Limited"))
+
+	rec := serveGatewayRequest(gw, "/claude/Busy/-/v1/messages", failoverRequest)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("HTTP %d, want the last provider's 429 passed on", rec.Code)
+	}
+	if got, want := f.take(), []string{"Busy", "Limited"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("attempts = %v, want %v", got, want)
+	}
+	if failovers := gw.activeFailovers(); len(failovers) != 0 {
+		t.Errorf("active failovers = %+v, want none when no provider served", failovers)
+	}
+	// Both breakers are open, so the chain keeps its order
+	if got, want := gw.failoverChain("claude", "Busy"), []string{"Busy", "Limited"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain = %v, want %v", got, want)
+	}
+}
+
+func TestFailoverChain(t *testing.T) {
+	gw := newTestGateway(t, AppConfig{Claude: ToolConfig{CurrentModel: "A", Failover: []string{"B", "a", "C", "b"}}})
+	if got, want := gw.failoverChain("claude", "A"), []string{"A", "B", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain = %v, want %v without duplicates", got, want)
+	}
+	if got, want := gw.failoverChain("codex", "X"), []string{"X"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain without failover = %v, want %v", got, want)
+	}
+	gw.providerFailed("claude", "A", "HTTP 503")
+	gw.providerFailed("claude", "b", "HTTP 429")
+	if got, want := gw.failoverChain("claude", "A"), []string{"C", "A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain with open breakers = %v, want %v", got, want)
+	}
+	gw.providerServed("claude", "A", "B", "")
+	if got, want := gw.failoverChain("claude", "A"), []string{"B", "C", "A"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chain after B served = %v, want %v", got, want)
+	}
+}
+
+func TestProviderFailedCooldown(t *testing.T) {
+	gw := newTestGateway(t, AppConfig{})
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, failoverMaxCooldown, failoverMaxCooldown}
+	for i, cooldown := range want {
+		start := time.Now()
+		gw.providerFailed("claude", "GLM", "HTTP 503")
+		b := gw.breakers["claude/glm"]
+		if b.failures != i+1 {
+			t.Fatalf("failures = %d, want %d", b.failures, i+1)
+		}
+		if got := b.openUntil.Sub(start); got < cooldown || got > cooldown+time.Second {
+			t.Errorf("cooldown after %d failures = %s, want %s", i+1, got, cooldown)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		gw.providerFailed("claude", "GLM", "HTTP 503")
+	}
+	if got := time.Until(gw.breakers["claude/glm"].openUntil); got <= 0 || got > failoverMaxCooldown {
+		t.Errorf("cooldown after many failures = %s, want at most %s", got, failoverMaxCooldown)
+	}
+}
+
+func TestFailoverDelay(t *testing.T) {
+	want := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i, d := range want {
+		if got := failoverDelay(i); got != d {
+			t.Errorf("failoverDelay(%d) = %s, want %s", i, got, d)
+		}
+	}
+	if got := failoverDelay(63); got != failoverMaxBackoff {
+		t.Errorf("failoverDelay(63) = %s, want %s", got, failoverMaxBackoff)
+	}
+}
+
+func TestActiveFailoversOrder(t *testing.T) {
+	gw := newTestGateway(t, AppConfig{})
+	gw.providerServed("codex", "Relay", "OpenAI", "HTTP 429")
+	gw.providerServed("claude", "Kimi", "GLM", "HTTP 503")
+	gw.providerServed("claude", "GLM", "Kimi", "HTTP 429")
+	gw.providerServed("gemini", "Google", "Relay", "")
+	for i := 0; i < 20; i++ {
+		var got []string
+		for _, f := range gw.activeFailovers() {
+			got = append(got, f.Tool+"/"+f.Provider+">"+f.Active)
+		}
+		if want := []string{"claude/GLM>Kimi", "claude/Kimi>GLM", "codex/Relay>OpenAI", "gemini/Google>Relay"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("active failovers = %v, want %v", got, want)
+		}
+	}
+	if f := gw.activeFailovers()[3]; f.Reason != "Google failed recently" {
+		t.Errorf("reason = %q, want a default", f.Reason)
+	}
+}
+
+func TestFailoverReason(t *testing.T) {
+	cases := []struct {
+		code int
+		want string
+	}{{200, ""}, {400, ""}, {401, ""}, {429, "HTTP 429"}, {500, "HTTP 500"}, {503, "HTTP 503"}}
+	for _, c := range cases {
+		if got := failoverReason(&http.Response{StatusCode: c.code}, nil); got != c.want {
+			t.Errorf("failoverReason(HTTP %d) = %q, want %q", c.code, got, c.want)
+		}
+	}
+	if got := failoverReason(nil, net.UnknownNetworkError("refused")); got == "" {
+		t.Error("failoverReason of a connection error is empty")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Requests the provider understands as they are, e.g. Claude Code to an Anthropic
+// endpoint with usage_tracking on, are relayed unchanged apart from the key. The
+// response is copied through as it arrives and only read for its token counts.
+
+// gatewayRequestHeaders are not copied to the upstream request: the gateway sets its
+// own key, and leaves compression to the HTTP client so it can read the response.
+var gatewayRequestHeaders = map[string]bool{
+	"Authorization": true, "X-Api-Key": true, "Host": true, "Content-Length": true,
+	"Accept-Encoding": true, "Connection": true, "Keep-Alive": true, "Te": true, "Upgrade": true,
+	"Proxy-Authorization": true, "Proxy-Connection": true, "Transfer-Encoding": true,
+}
+
+// clientProtocol returns the protocol of an API path, "" for other endpoints.
+func clientProtocol(apiPath string) string {
+	switch {
+	case strings.HasPrefix(apiPath, "/v1/messages"):
+		return "anthropic"
+	case apiPath == "/responses" || apiPath == "/v1/responses":
+		return "responses"
+	case strings.HasSuffix(apiPath, "/chat/completions"):
+		return "chat"
+	}
+	return ""
+}
+
+// generatesTokens reports whether responses to an API path use tokens, unlike for
+// example count_tokens or a model list.
+func generatesTokens(apiPath string) bool {
+	return apiPath == "/v1/messages" || apiPath == "/responses" || apiPath == "/v1/responses" || strings.HasSuffix(apiPath, "/chat/completions")
+}
+
+// passthrough relays a request to the upstream with the provider's key.
+func (gw *gateway) passthrough(r *http.Request, u *gatewayUpstream, apiPath string, body []byte) (*gatewayCall, error) {
+	target := u.BaseUrl + apiPath
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.Header {
+		if !gatewayRequestHeaders[k] {
+			req.Header[k] = v
+		}
+	}
+	// Authenticate the way the tool did
+	if r.Header.Get("x-api-key") != "" {
+		req.Header.Set("x-api-key", u.ApiKey)
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("x-api-key") == "" {
+		req.Header.Set("Authorization", "Bearer "+u.ApiKey)
+	}
+	return &gatewayCall{req: req, respond: func(w http.ResponseWriter, resp *http.Response) {
+		gw.relay(w, resp, u, apiPath, body)
+	}}, nil
+}
+
+// relay copies an upstream response to the tool and records its usage.
+func (gw *gateway) relay(w http.ResponseWriter, resp *http.Response, u *gatewayUpstream, apiPath string, body []byte) {
+	for k, v := range resp.Header {
+		if k != "Connection" && k != "Keep-Alive" && k != "Transfer-Encoding" {
+			w.Header()[k] = v
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	tap := &usageTap{stream: strings.Contains(resp.Header.Get("Content-Type"), "event-stream")}
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return // The tool went away
+			}
+			tap.Write(buf[:n])
+			if tap.stream && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		gw.app.log(fmt.Sprintf("Gateway %s/%s: HTTP %d on %s", u.Tool, u.Provider, resp.StatusCode, apiPath))
+		return
+	}
+	if generatesTokens(apiPath) {
+		gw.recordUsage(u, requestModel(body), tap.counts(u.Protocol))
+	}
+}
+
+// tokenCounts are the tokens of one request, in the form of UsageRecord.
+type tokenCounts struct {
+	Input, Output, CacheRead, CacheWrite int
+}
+
+// merge takes the non-zero counts of o, for streams that report usage in several events.
+func (t *tokenCounts) merge(o tokenCounts) {
+	for _, f := range []struct{ dst, src *int }{{&t.Input, &o.Input}, {&t.Output, &o.Output}, {&t.CacheRead, &o.CacheRead}, {&t.CacheWrite, &o.CacheWrite}} {
+		if *f.src > 0 {
+			*f.dst = *f.src
+		}
+	}
+}
+
+// usageFields is a usage object under the field names of any of the protocols.
+type usageFields struct {
+	chatUsage
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	InputTokensDetails       struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+}
+
+func (f *usageFields) counts(protocol string) tokenCounts {
+	switch protocol {
+	case "anthropic":
+		return tokenCounts{f.InputTokens, f.OutputTokens, f.CacheReadInputTokens, f.CacheCreationInputTokens}
+	case "responses":
+		cached := f.InputTokensDetails.CachedTokens
+		return tokenCounts{f.InputTokens - cached, f.OutputTokens, cached, 0}
+	}
+	cached := f.cachedTokens()
+	return tokenCounts{f.PromptTokens - cached, f.CompletionTokens, cached, 0}
+}
+
+func chatTokenCounts(u *chatUsage) tokenCounts {
+	if u == nil {
+		return tokenCounts{}
+	}
+	return (&usageFields{chatUsage: *u}).counts("chat")
+}
+
+// usageTap reads the usage from a response while it is copied to the tool: from each
+// event of a stream, or from the whole body.
+type usageTap struct {
+	stream bool
+	buf    []byte
+	docs   [][]byte
+}
+
+const usageTapMaxBody = 32 << 20
+
+func (t *usageTap) Write(p []byte) {
+	if !t.stream {
+		if len(t.buf)+len(p) <= usageTapMaxBody {
+			t.buf = append(t.buf, p...)
+		}
+		return
+	}
+	t.buf = append(t.buf, p...)
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			return
+		}
+		line := bytes.TrimSpace(t.buf[:i])
+		t.buf = t.buf[i+1:]
+		// Only events that can carry usage are kept
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok && bytes.Contains(data, []byte(`"usage"`)) && !bytes.Contains(data, []byte(`"usage":null`)) {
+			t.docs = append(t.docs, bytes.TrimSpace(data))
+		}
+	}
+}
+
+// counts returns the tokens reported in the response so far.
+func (t *usageTap) counts(protocol string) tokenCounts {
+	docs := t.docs
+	if !t.stream {
+		docs = [][]byte{t.buf}
+	}
+	var total tokenCounts
+	for _, data := range docs {
+		var doc struct {
+			Usage   *usageFields `json:"usage"`
+			Message struct {
+				Usage *usageFields `json:"usage"`
+			} `json:"message"` // Anthropic message_start
+			Response struct {
+				Usage *usageFields `json:"usage"`
+			} `json:"response"` // Responses response.completed
+		}
+		if json.Unmarshal(data, &doc) != nil {
+			continue
+		}
+		for _, u := range []*usageFields{doc.Usage, doc.Message.Usage, doc.Response.Usage} {
+			if u != nil {
+				total.merge(u.counts(protocol))
+			}
+		}
+	}
+	return total
+}
+
+// recordUsage stores the tokens of a relayed request, see usage.go.
+func (gw *gateway) recordUsage(u *gatewayUpstream, model string, t tokenCounts) {
+	if t == (tokenCounts{}) {
+		return
+	}
+	if model == "" && len(u.Models) > 0 {
+		model = u.Models[0]
+	}
+	record := UsageRecord{Time: time.Now(), Tool: u.Tool, Provider: u.Provider, Model: model, Project: u.Project,
+		InputTokens: t.Input, OutputTokens: t.Output, CacheReadTokens: t.CacheRead, CacheWriteTokens: t.CacheWrite}
+	if err := appendUsageRecord(record); err != nil {
+		gw.app.log("Recording usage failed: " + err.Error())
+	}
+}
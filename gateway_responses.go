@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Translation of OpenAI Responses requests, as sent by Codex, to Chat Completions and of
+// the responses back. Codex keeps the conversation itself (store is false), so every
+// request carries the whole history as input items.
+//
+// Custom (free-form) tools such as apply_patch become functions with a single "input"
+// string, and their calls are turned back into custom_tool_call items.
+
+type responsesRequest struct {
+	Model           string          `json:"model"`
+	Instructions    string          `json:"instructions"`
+	Input           json.RawMessage `json:"input"` // String or items
+	Tools           []responsesTool `json:"tools"`
+	ToolChoice      json.RawMessage `json:"tool_choice"`
+	MaxOutputTokens int             `json:"max_output_tokens"`
+	Temperature     *float64        `json:"temperature"`
+	TopP            *float64        `json:"top_p"`
+	Stream          bool            `json:"stream"`
+	User            string          `json:"user"`
+}
+
+type responsesTool struct {
+	Type        string          `json:"type"` // "function" and "custom" are translated
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type responsesItem struct {
+	Type      string             `json:"type"`
+	Id        string             `json:"id,omitempty"`
+	Status    string             `json:"status,omitempty"`
+	Role      string             `json:"role,omitempty"`
+	Content   json.RawMessage    `json:"content,omitempty"` // message: string or parts
+	CallId    string             `json:"call_id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Arguments string             `json:"arguments,omitempty"`
+	Input     string             `json:"input,omitempty"`  // custom_tool_call
+	Output    json.RawMessage    `json:"output,omitempty"` // String or parts
+	Summary   []responsesSummary `json:"summary,omitempty"`
+}
+
+type responsesPart struct {
+	Type     string `json:"type"` // input_text, output_text, input_image, summary_text, reasoning_text
+	Text     string `json:"text,omitempty"`
+	ImageUrl string `json:"image_url,omitempty"`
+}
+
+type responsesSummary struct {
+	Type string `json:"type"` // "summary_text"
+	Text string `json:"text"`
+}
+
+type responsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokens        int `json:"output_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+	TotalTokens int `json:"total_tokens"`
+}
+
+func newResponsesUsage(u *chatUsage) *responsesUsage {
+	if u == nil {
+		return nil
+	}
+	out := &responsesUsage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens, TotalTokens: u.PromptTokens + u.CompletionTokens}
+	out.InputTokensDetails.CachedTokens = u.cachedTokens()
+	out.OutputTokensDetails.ReasoningTokens = u.reasoningTokens()
+	return out
+}
+
+// parseResponsesParts reads message content or tool output, turning a plain string into
+// a text part.
+func parseResponsesParts(raw json.RawMessage) ([]responsesPart, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []responsesPart{{Type: "input_text", Text: text}}, nil
+	}
+	var parts []responsesPart
+	err := json.Unmarshal(raw, &parts)
+	return parts, err
+}
+
+func responsesText(parts []responsesPart) string {
+	var texts []string
+	for _, p := range parts {
+		if p.Text != "" && p.Type != "input_image" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// responsesToChatRequest translates a Responses request for the upstream model. It also
+// returns the names of the custom tools, whose calls need translating back.
+func responsesToChatRequest(req *responsesRequest, model string) (*chatRequest, map[string]bool, error) {
+	out := &chatRequest{Model: model, MaxTokens: req.MaxOutputTokens, Temperature: req.Temperature, TopP: req.TopP, Stream: req.Stream, User: req.User}
+	if req.Stream {
+		out.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	}
+	if req.Instructions != "" {
+		out.Messages = append(out.Messages, chatMessage{Role: "system", Content: req.Instructions})
+	}
+	var items []responsesItem
+	var text string
+	if json.Unmarshal(req.Input, &text) == nil {
+		items = []responsesItem{{Type: "message", Role: "user", Content: req.Input}}
+	} else if err := json.Unmarshal(req.Input, &items); err != nil {
+		return nil, nil, fmt.Errorf("input: %w", err)
+	}
+
+	// Consecutive assistant output (text, reasoning, calls) becomes one assistant message
+	var assistant *chatMessage
+	var reasoning []string
+	flush := func() {
+		if assistant != nil {
+			if len(assistant.ToolCalls) > 0 {
+				assistant.ReasoningContent = strings.Join(reasoning, "\n")
+			}
+			out.Messages = append(out.Messages, *assistant)
+		}
+		assistant, reasoning = nil, nil
+	}
+	call := func(id, name, args string) {
+		if assistant == nil {
+			assistant = &chatMessage{Role: "assistant"}
+		}
+		assistant.ToolCalls = append(assistant.ToolCalls, chatToolCall{Id: id, Type: "function", Function: chatFunctionCall{Name: name, Arguments: args}})
+	}
+	for i, item := range items {
+		if item.Type == "" && item.Role != "" {
+			item.Type = "message"
+		}
+		switch item.Type {
+		case "message":
+			parts, err := parseResponsesParts(item.Content)
+			if err != nil {
+				return nil, nil, fmt.Errorf("input[%d]: %w", i, err)
+			}
+			if item.Role == "assistant" {
+				if assistant != nil && len(assistant.ToolCalls) > 0 {
+					flush()
+				}
+				if assistant == nil {
+					assistant = &chatMessage{Role: "assistant"}
+				}
+				if text := responsesText(parts); text != "" {
+					if prev, _ := assistant.Content.(string); prev != "" {
+						text = prev + "\n" + text
+					}
+					assistant.Content = text
+				}
+				continue
+			}
+			flush()
+			role := item.Role
+			if role == "developer" {
+				role = "system" // Not every provider knows the developer role
+			}
+			out.Messages = append(out.Messages, chatMessage{Role: role, Content: userContent(responsesChatParts(parts))})
+		case "reasoning":
+			for _, s := range item.Summary {
+				reasoning = append(reasoning, s.Text)
+			}
+			if len(item.Summary) == 0 {
+				// Open models report their reasoning as content rather than a summary
+				parts, _ := parseResponsesParts(item.Content)
+				if text := responsesText(parts); text != "" {
+					reasoning = append(reasoning, text)
+				}
+			}
+		case "function_call":
+			call(item.CallId, item.Name, item.Arguments)
+		case "custom_tool_call":
+			args, _ := json.Marshal(map[string]string{"input": item.Input})
+			call(item.CallId, item.Name, string(args))
+		case "function_call_output", "custom_tool_call_output":
+			flush()
+			parts, err := parseResponsesParts(item.Output)
+			if err != nil {
+				return nil, nil, fmt.Errorf("input[%d]: %w", i, err)
+			}
+			out.Messages = append(out.Messages, chatMessage{Role: "tool", ToolCallId: item.CallId, Content: responsesText(parts)})
+			// Tool messages only take text, images follow in a user message
+			var images []chatPart
+			for _, p := range responsesChatParts(parts) {
+				if p.Type == "image_url" {
+					images = append(images, p)
+				}
+			}
+			if len(images) > 0 {
+				out.Messages = append(out.Messages, chatMessage{Role: "user", Content: images})
+			}
+		}
+	}
+	flush()
+
+	custom := make(map[string]bool)
+	for _, t := range req.Tools {
+		switch t.Type {
+		case "function":
+			out.Tools = append(out.Tools, chatTool{Type: "function", Function: chatFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters}})
+		case "custom":
+			custom[t.Name] = true
+			params := json.RawMessage(`{"type":"object","properties":{"input":{"type":"string","description":"The raw input of the tool, in the format its description asks for"}},"required":["input"]}`)
+			out.Tools = append(out.Tools, chatTool{Type: "function", Function: chatFunction{Name: t.Name, Description: t.Description, Parameters: params}})
+		}
+		// Hosted tools such as web_search only exist at OpenAI
+	}
+	if len(out.Tools) > 0 && len(req.ToolChoice) > 0 {
+		var choice string
+		var named struct{ Name string }
+		if json.Unmarshal(req.ToolChoice, &choice) == nil {
+			if choice == "required" || choice == "none" {
+				out.ToolChoice = choice
+			}
+		} else if json.Unmarshal(req.ToolChoice, &named) == nil && named.Name != "" {
+			out.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]string{"name": named.Name}}
+		}
+	}
+	return out, custom, nil
+}
+
+// responsesChatParts returns the parts of user content. Images only appear as parts,
+// so the result without images is a single text part.
+func responsesChatParts(parts []responsesPart) []chatPart {
+	var out []chatPart
+	for _, p := range parts {
+		switch {
+		case p.Type == "input_image" && p.ImageUrl != "":
+			out = append(out, chatPart{Type: "image_url", ImageUrl: &chatImageUrl{Url: p.ImageUrl}})
+		case p.Text != "":
+			out = append(out, chatPart{Type: "text", Text: p.Text})
+		}
+	}
+	if len(out) == 0 {
+		out = append(out, chatPart{Type: "text", Text: ""})
+	}
+	return out
+}
+
+// responsesCallItem returns the output item for a tool call of the model.
+func responsesCallItem(call chatToolCall, custom map[string]bool) responsesItem {
+	callId := call.Id
+	if callId == "" {
+		callId = "call_" + strings.TrimPrefix(toolCallId(""), "toolu_")
+	}
+	if custom[call.Function.Name] {
+		var args struct {
+			Input string `json:"input"`
+		}
+		json.Unmarshal(toolArguments(call.Function.Arguments), &args)
+		return responsesItem{Type: "custom_tool_call", Id: "ctc_" + callId, Status: "completed", CallId: callId, Name: call.Function.Name, Input: args.Input}
+	}
+	return responsesItem{Type: "function_call", Id: "fc_" + callId, Status: "completed", CallId: callId, Name: call.Function.Name, Arguments: string(toolArguments(call.Function.Arguments))}
+}
+
+func newResponseObject(id, model, status string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "object": "response", "created_at": time.Now().Unix(), "model": model, "status": status, "output": []interface{}{}}
+}
+
+func outputTextContent(text string) json.RawMessage {
+	data, _ := json.Marshal([]map[string]interface{}{{"type": "output_text", "text": text, "annotations": []interface{}{}}})
+	return data
+}
+
+// chatToResponsesObject translates a whole Chat Completions response.
+func chatToResponsesObject(resp *chatResponse, model string, custom map[string]bool) map[string]interface{} {
+	suffix := strings.TrimPrefix(newMessageId(), "msg_")
+	out := newResponseObject("resp_"+suffix, model, "completed")
+	var output []responsesItem
+	if len(resp.Choices) > 0 {
+		msg := resp.Choices[0].Message
+		if r := msg.reasoning(); r != "" {
+			output = append(output, responsesItem{Type: "reasoning", Id: "rs_" + suffix, Summary: []responsesSummary{{Type: "summary_text", Text: r}}})
+		}
+		if msg.Content != "" {
+			output = append(output, responsesItem{Type: "message", Id: "msg_" + suffix, Status: "completed", Role: "assistant", Content: outputTextContent(msg.Content)})
+		}
+		for _, c := range msg.ToolCalls {
+			output = append(output, responsesCallItem(c, custom))
+		}
+		if resp.Choices[0].FinishReason == "length" {
+			out["status"] = "incomplete"
+			out["incomplete_details"] = map[string]string{"reason": "max_output_tokens"}
+		}
+	}
+	out["output"] = output
+	out["usage"] = newResponsesUsage(resp.Usage)
+	return out
+}
+
+// responsesToChat serves /responses from a Chat Completions upstream.
+func (gw *gateway) responsesToChat(r *http.Request, u *gatewayUpstream, body []byte) (*gatewayCall, error) {
+	var req responsesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	model := u.model(req.Model)
+	chatReq, custom, err := responsesToChatRequest(&req, model)
+	if err != nil {
+		return nil, err
+	}
+	if limit := cachedMaxOutputTokens(u.Protocol, u.BaseUrl, u.ApiKey, model); limit > 0 && chatReq.MaxTokens > limit {
+		chatReq.MaxTokens = limit
+	}
+	httpReq, err := u.newPost(r.Context(), u.chatCompletionsPath(), chatReq)
+	if err != nil {
+		return nil, err
+	}
+	return &gatewayCall{req: httpReq, respond: func(w http.ResponseWriter, resp *http.Response) {
+		if resp.StatusCode != http.StatusOK {
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			msg := providerErrorMessage(data)
+			gw.app.log(fmt.Sprintf("Gateway %s/%s: HTTP %d: %s", u.Tool, u.Provider, resp.StatusCode, msg))
+			writeOpenAIError(w, resp.StatusCode, msg)
+			return
+		}
+		if !req.Stream {
+			var chatResp chatResponse
+			if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+				writeOpenAIError(w, http.StatusBadGateway, "the provider sent an invalid response: "+err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, chatToResponsesObject(&chatResp, model, custom))
+			gw.recordUsage(u, model, chatTokenCounts(chatResp.Usage))
+			return
+		}
+		usage := streamChatAsResponses(newSSEWriter(w), resp.Body, model, custom)
+		gw.recordUsage(u, model, chatTokenCounts(usage))
+	}}, nil
+}
+
+// streamChatAsResponses translates a Chat Completions stream into Responses events.
+// Reasoning and text are passed on as they arrive, tool calls are sent whole at the
+// end. Codex only takes the output once response.completed arrives.
+func streamChatAsResponses(sse *sseWriter, body io.Reader, model string, custom map[string]bool) *chatUsage {
+	id := "resp_" + strings.TrimPrefix(newMessageId(), "msg_")
+	seq := 0
+	send := func(eventType string, fields map[string]interface{}) {
+		fields["type"], fields["sequence_number"] = eventType, seq
+		seq++
+		sse.event(eventType, fields)
+	}
+	send("response.created", map[string]interface{}{"response": newResponseObject(id, model, "in_progress")})
+	send("response.in_progress", map[string]interface{}{"response": newResponseObject(id, model, "in_progress")})
+
+	var output []responsesItem
+	var open string // "reasoning" or "message"
+	var text strings.Builder
+	itemId := func(kind string) string {
+		prefix := map[string]string{"reasoning": "rs_", "message": "msg_"}[kind]
+		return fmt.Sprintf("%s%s_%d", prefix, strings.TrimPrefix(id, "resp_"), len(output))
+	}
+	closeItem := func() {
+		index, iid := len(output), itemId(open)
+		switch open {
+		case "reasoning":
+			send("response.reasoning_summary_text.done", map[string]interface{}{"item_id": iid, "output_index": index, "summary_index": 0, "text": text.String()})
+			send("response.reasoning_summary_part.done", map[string]interface{}{"item_id": iid, "output_index": index, "summary_index": 0, "part": responsesSummary{Type: "summary_text", Text: text.String()}})
+			item := responsesItem{Type: "reasoning", Id: iid, Summary: []responsesSummary{{Type: "summary_text", Text: text.String()}}}
+			send("response.output_item.done", map[string]interface{}{"output_index": index, "item": item})
+			output = append(output, item)
+		case "message":
+			part := map[string]interface{}{"type": "output_text", "text": text.String(), "annotations": []interface{}{}}
+			send("response.output_text.done", map[string]interface{}{"item_id": iid, "output_index": index, "content_index": 0, "text": text.String()})
+			send("response.content_part.done", map[string]interface{}{"item_id": iid, "output_index": index, "content_index": 0, "part": part})
+			item := responsesItem{Type: "message", Id: iid, Status: "completed", Role: "assistant", Content: outputTextContent(text.String())}
+			send("response.output_item.done", map[string]interface{}{"output_index": index, "item": item})
+			output = append(output, item)
+		}
+		open = ""
+		text.Reset()
+	}
+	delta := func(kind, s string) {
+		if open != kind {
+			closeItem()
+			index, iid := len(output), itemId(kind)
+			if kind == "reasoning" {
+				// Codex needs the summary field, which responsesItem omits when empty
+				send("response.output_item.added", map[string]interface{}{"output_index": index, "item": map[string]interface{}{"type": "reasoning", "id": iid, "summary": []interface{}{}}})
+				send("response.reasoning_summary_part.added", map[string]interface{}{"item_id": iid, "output_index": index, "summary_index": 0, "part": responsesSummary{Type: "summary_text"}})
+			} else {
+				send("response.output_item.added", map[string]interface{}{"output_index": index, "item": responsesItem{Type: "message", Id: iid, Status: "in_progress", Role: "assistant", Content: json.RawMessage("[]")}})
+				send("response.content_part.added", map[string]interface{}{"item_id": iid, "output_index": index, "content_index": 0, "part": map[string]interface{}{"type": "output_text", "text": "", "annotations": []interface{}{}}})
+			}
+			open = kind
+		}
+		index, iid := len(output), itemId(kind)
+		text.WriteString(s)
+		if kind == "reasoning" {
+			send("response.reasoning_summary_text.delta", map[string]interface{}{"item_id": iid, "output_index": index, "summary_index": 0, "delta": s})
+		} else {
+			send("response.output_text.delta", map[string]interface{}{"item_id": iid, "output_index": index, "content_index": 0, "delta": s})
+		}
+	}
+
+	var tools chatToolCallBuffer
+	var usage *chatUsage
+	var finish, streamErr string
+	err := readSSE(body, func(_, data string) bool {
+		if data == "[DONE]" {
+			return false
+		}
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return true
+		}
+		if chunk.Error != nil {
+			raw, _ := json.Marshal(map[string]interface{}{"error": chunk.Error})
+			streamErr = providerErrorMessage(raw)
+			return false
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if r := choice.Delta.reasoning(); r != "" {
+				delta("reasoning", r)
+			}
+			if choice.Delta.Content != "" {
+				delta("message", choice.Delta.Content)
+			}
+			for _, call := range choice.Delta.ToolCalls {
+				tools.add(call)
+			}
+			if choice.FinishReason != "" {
+				finish = choice.FinishReason
+			}
+		}
+		return true
+	})
+	if err != nil {
+		streamErr = "the stream from the provider broke off: " + err.Error()
+	}
+	closeItem()
+	if streamErr != "" {
+		failed := newResponseObject(id, model, "failed")
+		failed["error"] = map[string]string{"code": "server_error", "message": streamErr}
+		send("response.failed", map[string]interface{}{"response": failed})
+		return usage
+	}
+	for _, call := range tools.calls {
+		item := responsesCallItem(call, custom)
+		index := len(output)
+		added := item
+		added.Status = "in_progress"
+		send("response.output_item.added", map[string]interface{}{"output_index": index, "item": added})
+		if item.Type == "function_call" {
+			send("response.function_call_arguments.delta", map[string]interface{}{"item_id": item.Id, "output_index": index, "delta": item.Arguments})
+			send("response.function_call_arguments.done", map[string]interface{}{"item_id": item.Id, "output_index": index, "arguments": item.Arguments})
+		} else {
+			send("response.custom_tool_call_input.delta", map[string]interface{}{"item_id": item.Id, "output_index": index, "delta": item.Input})
+			send("response.custom_tool_call_input.done", map[string]interface{}{"item_id": item.Id, "output_index": index, "input": item.Input})
+		}
+		send("response.output_item.done", map[string]interface{}{"output_index": index, "item": item})
+		output = append(output, item)
+	}
+	// A cut-off answer still ends with response.completed, which every Codex version
+	// reads; the status tells why it ended
+	done := newResponseObject(id, model, "completed")
+	if finish == "length" {
+		done["status"] = "incomplete"
+		done["incomplete_details"] = map[string]string{"reason": "max_output_tokens"}
+	}
+	done["output"], done["usage"] = output, newResponsesUsage(usage)
+	send("response.completed", map[string]interface{}{"response": done})
+	return usage
+}
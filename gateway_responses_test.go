@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func codexRelayConfig(baseUrl string) AppConfig {
+	return AppConfig{Codex: ToolConfig{CurrentModel: "Relay", Models: []ModelConfig{
+		{ModelName: "Relay", ModelUrl: baseUrl, ModelId: "relay-large, relay-small", ApiKey: "sk-relay", WireApi: "chat"},
+	}}}
+}
+
+// TestResponsesToChat sends each testdata/gateway_responses/<case>.request.json through
+// the gateway, see checkTranslationGoldens.
+func TestResponsesToChat(t *testing.T) {
+	checkTranslationGoldens(t, filepath.Join("testdata", "gateway_responses"), "/codex/Relay/-/v1/responses", codexRelayConfig)
+}
+
+// TestResponsesStreamEvents checks that the sequence numbers rise by one, that every
+// item added is done at the same output index and that response.completed lists them.
+func TestResponsesStreamEvents(t *testing.T) {
+	var received []upstreamRequest
+	srv := chatUpstream(t, filepath.Join("testdata", "gateway_responses", "stream-tool-calls.upstream.sse"), &received)
+	gw := newTestGateway(t, codexRelayConfig(srv.URL))
+	rec := serveGatewayRequest(gw, "/codex/Relay/-/v1/responses", `{"model":"x","input":"hi","tools":[{"type":"custom","name":"apply_patch"}],"stream":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HTTP %d %s", rec.Code, rec.Body.String())
+	}
+
+	var events, added, done []string
+	var completed struct {
+		Output []responsesItem `json:"output"`
+	}
+	readSSE(rec.Body, func(event, data string) bool {
+		var e struct {
+			Type           string          `json:"type"`
+			SequenceNumber int             `json:"sequence_number"`
+			OutputIndex    int             `json:"output_index"`
+			Item           responsesItem   `json:"item"`
+			Response       json.RawMessage `json:"response"`
+		}
+		if err := json.Unmarshal([]byte(data), &e); err != nil || e.Type != event || e.SequenceNumber != len(events) {
+			t.Errorf("event %d %s with data %s", len(events), event, data)
+		}
+		events = append(events, event)
+		switch event {
+		case "response.output_item.added":
+			if e.OutputIndex != len(added) {
+				t.Errorf("item %s added at %d, want %d", e.Item.Type, e.OutputIndex, len(added))
+			}
+			added = append(added, e.Item.Type)
+		case "response.output_item.done":
+			if e.OutputIndex != len(done) || e.OutputIndex >= len(added) || added[e.OutputIndex] != e.Item.Type {
+				t.Errorf("item %s done at %d after %v were added", e.Item.Type, e.OutputIndex, added)
+			}
+			done = append(done, e.Item.Type)
+		case "response.completed":
+			json.Unmarshal(e.Response, &completed)
+		}
+		return true
+	})
+	if want := []string{"message", "function_call", "custom_tool_call"}; strings.Join(done, ",") != strings.Join(want, ",") {
+		t.Errorf("items = %v, want %v", done, want)
+	}
+	if len(completed.Output) != len(done) || events[len(events)-1] != "response.completed" {
+		t.Errorf("response.completed lists %d items, last event %s", len(completed.Output), events[len(events)-1])
+	}
+	if c := completed.Output[2]; c.CallId != "call_patch" || !strings.HasPrefix(c.Input, "*** Begin Patch") {
+		t.Errorf("custom tool call = %+v", c)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testGatewayToken = "test-token"
+
+// newTestGateway writes config into a temporary home directory and returns a gateway
+// serving it, as `aicoder gateway serve` would.
+func newTestGateway(t *testing.T, config AppConfig) *gateway {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	config.SchemaVersion = currentConfigSchema
+	config.SecretBackend = SecretBackendPlaintext
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".aicoder_config.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &gateway{app: &App{testHomeDir: home}, token: testGatewayToken, keys: map[string]gatewayKey{}, clients: map[string]*http.Client{},
+		breakers: map[string]*gatewayBreaker{}, failovers: map[string]GatewayFailover{}}
+}
+
+// serveGatewayRequest sends a tool request for path to gw.
+func serveGatewayRequest(gw *gateway, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", testGatewayToken)
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	return rec
+}
+
+// upstreamRequest is a request an upstream test server received.
+type upstreamRequest struct {
+	Path, Auth string
+	Body       []byte
+}
+
+func TestGatewayRouteURL(t *testing.T) {
+	cases := []struct {
+		provider, project, model, want string
+	}{
+		{"GLM", "", "", "http://127.0.0.1:18421/claude/GLM/-"},
+		{"My Relay", "p 1", "", "http://127.0.0.1:18421/claude/My%20Relay/p%201"},
+		{"OpenRouter", "p1", "deepseek/deepseek-chat", "http://127.0.0.1:18421/claude/OpenRouter/p1/@deepseek%2Fdeepseek-chat"},
+	}
+	for _, c := range cases {
+		if got := gatewayRouteURL(defaultGatewayPort, "Claude", c.provider, c.project, c.model); got != c.want {
+			t.Errorf("gatewayRouteURL(%q, %q, %q) = %q, want %q", c.provider, c.project, c.model, got, c.want)
+		}
+	}
+}
+
+func TestGatewayUnauthorized(t *testing.T) {
+	gw := newTestGateway(t, AppConfig{})
+	req := httptest.NewRequest(http.MethodPost, "/claude/GLM/-/v1/messages", strings.NewReader("{}"))
+	req.Header.Set("x-api-key", "wrong")
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "authentication_error") {
+		t.Errorf("got HTTP %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// chatUpstream starts a Chat Completions server that answers every request with the
+// content of file, as an event stream for .sse files, and records what it was sent.
+func chatUpstream(t *testing.T, file string, requests *[]upstreamRequest) *httptest.Server {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*requests = append(*requests, upstreamRequest{Path: r.URL.Path, Auth: r.Header.Get("Authorization"), Body: body})
+		if strings.HasSuffix(file, ".sse") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var (
+	generatedIdPattern = regexp.MustCompile(`(resp|rs|msg|call|toolu)_[0-9a-f]{24}`)
+	createdAtPattern   = regexp.MustCompile(`"created_at":\s*\d+`)
+)
+
+// indentJSON sorts the keys of a JSON document and indents it, for stable golden files.
+func indentJSON(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+	return marshalGolden(t, v)
+}
+
+// checkTranslationGoldens sends each <dir>/<case>.request.json through the gateway on
+// route, to an upstream configured by config that answers with <case>.upstream.json or
+// .sse, and compares the request the upstream got and the response the tool got with
+// <case>.upstream-request.golden.json and <case>.response.golden.
+func checkTranslationGoldens(t *testing.T, dir, route string, config func(baseUrl string) AppConfig) {
+	requests, err := filepath.Glob(filepath.Join(dir, "*.request.json"))
+	if err != nil || len(requests) == 0 {
+		t.Fatalf("no fixtures in %s: %v", dir, err)
+	}
+	for _, requestFile := range requests {
+		name := strings.TrimSuffix(requestFile, ".request.json")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			upstreamFile := name + ".upstream.json"
+			if _, err := os.Stat(upstreamFile); err != nil {
+				upstreamFile = name + ".upstream.sse"
+			}
+			var received []upstreamRequest
+			srv := chatUpstream(t, upstreamFile, &received)
+			gw := newTestGateway(t, config(srv.URL+"/v1"))
+			body, err := os.ReadFile(requestFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := serveGatewayRequest(gw, route, string(body))
+
+			if len(received) != 1 {
+				t.Fatalf("upstream got %d requests, want 1", len(received))
+			}
+			if received[0].Path != "/v1/chat/completions" || received[0].Auth != "Bearer sk-relay" {
+				t.Errorf("upstream request to %s with %q", received[0].Path, received[0].Auth)
+			}
+			checkGolden(t, name+".upstream-request.golden.json", indentJSON(t, received[0].Body))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("HTTP %d", rec.Code)
+			}
+			response := generatedIdPattern.ReplaceAll(rec.Body.Bytes(), []byte("${1}_generated"))
+			response = createdAtPattern.ReplaceAll(response, []byte(`"created_at":0`))
+			if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+				response = indentJSON(t, response)
+			} else if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+				t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+			}
+			checkGolden(t, name+".response.golden", response)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provider entries of different tools can share one credential through a key group.
+// Changing the key of one member changes it for every member when the config is saved.
+//
+//	key_group ""      the group named after the provider, so e.g. every "Kimi" entry
+//	                  shares its key (the behaviour before key groups existed)
+//	key_group "own"   the entry keeps its own key, e.g. a different account for Codex
+//	key_group "<name>" an explicit group shared with every entry naming it
+//
+// Original never shares a key, and Custom entries only when they name a group. Labelled
+// keys (see provider_keys.go) are shared as a whole, the strategy stays per entry.
+
+const KeyGroupOwn = "own"
+
+// KeySyncChange is a key that a save copies from another member of its group.
+type KeySyncChange struct {
+	Tool     string `json:"tool"`
+	Provider string `json:"provider"`
+	Group    string `json:"group"`
+	Source   string `json:"source"` // "<tool>/<provider>" the key comes from
+	Cleared  bool   `json:"cleared"`
+}
+
+// keyGroupOf returns the key group of a provider entry, or "" if it has none.
+func keyGroupOf(m *ModelConfig) string {
+	group := strings.TrimSpace(m.KeyGroup)
+	switch {
+	case strings.EqualFold(m.ModelName, "Original"), strings.EqualFold(group, KeyGroupOwn):
+		return ""
+	case group != "":
+		return strings.ToLower(group)
+	case m.IsCustom || strings.EqualFold(m.ModelName, "Custom"):
+		return ""
+	}
+	return strings.ToLower(m.ModelName)
+}
+
+// syncKeyGroups propagates changed keys in newConfig to the other members of their
+// groups and returns what it changed. When members of one group were changed to
+// different keys, the active tool wins, then the first tool in supportedTools order.
+// An entry that joins a group (or is new) without changing its key takes the group's key.
+func syncKeyGroups(oldConfig, newConfig *AppConfig) []KeySyncChange {
+	type member struct {
+		tool    string
+		model   *ModelConfig
+		changed bool // The key was edited
+		joined  bool // New entry, or its group changed
+	}
+	groups := make(map[string][]member)
+	var order []string
+	tools := append([]string{strings.ToLower(newConfig.ActiveTool)}, supportedTools...)
+	seen := make(map[string]bool)
+	for _, tool := range tools {
+		toolCfg := newConfig.toolConfig(tool)
+		if toolCfg == nil || seen[tool] {
+			continue
+		}
+		seen[tool] = true
+		for i := range toolCfg.Models {
+			m := &toolCfg.Models[i]
+			group := keyGroupOf(m)
+			if group == "" {
+				continue
+			}
+			old := getProviderModel(oldConfig.toolConfig(tool), m.ModelName)
+			mem := member{tool: tool, model: m}
+			if old == nil {
+				mem.joined, mem.changed = true, m.ApiKey != ""
+			} else {
+				mem.joined, mem.changed = keyGroupOf(old) != group, !sameKeys(old, m)
+			}
+			if _, ok := groups[group]; !ok {
+				order = append(order, group)
+			}
+			groups[group] = append(groups[group], mem)
+		}
+	}
+	var changes []KeySyncChange
+	for _, group := range order {
+		members := groups[group]
+		// The first edited key wins; otherwise the first key of a member that was already in the group
+		var source *member
+		for i := range members {
+			if members[i].changed {
+				source = &members[i]
+				break
+			}
+		}
+		if source == nil {
+			for i := range members {
+				if !members[i].joined && members[i].model.ApiKey != "" {
+					source = &members[i]
+					break
+				}
+			}
+		}
+		if source == nil {
+			continue
+		}
+		for i := range members {
+			mem := &members[i]
+			if mem == source || sameKeys(mem.model, source.model) {
+				continue
+			}
+			// Without an edit, only entries joining the group are brought in line
+			if !source.changed && !mem.joined {
+				continue
+			}
+			mem.model.ApiKey, mem.model.Keys = source.model.ApiKey, append([]ProviderKey(nil), source.model.Keys...)
+			changes = append(changes, KeySyncChange{Tool: mem.tool, Provider: mem.model.ModelName, Group: group, Source: source.tool + "/" + source.model.ModelName, Cleared: mem.model.ApiKey == ""})
+		}
+	}
+	return changes
+}
+
+// sameKeys reports whether two entries hold the same key, or the same labelled keys.
+func sameKeys(a, b *ModelConfig) bool {
+	if a.ApiKey != b.ApiKey || len(a.Keys) != len(b.Keys) {
+		return false
+	}
+	for i := range a.Keys {
+		if a.Keys[i] != b.Keys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// PreviewKeySync lists the keys that saving config would change in other tools, for
+// showing before the save.
+func (a *App) PreviewKeySync(config AppConfig) ([]KeySyncChange, error) {
+	current, err := a.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	config = cloneConfig(config)
+	syncPrimaryKeys(&config)
+	return syncKeyGroups(&current, &config), nil
+}
+
+// GetKeyGroupMembers lists the "<tool>/<provider>" entries sharing a key with the given
+// provider of tool, itself included.
+func (a *App) GetKeyGroupMembers(tool, provider string) ([]string, error) {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	toolCfg := config.toolConfig(tool)
+	if toolCfg == nil {
+		return nil, fmt.Errorf("unknown tool: %s", tool)
+	}
+	m := getProviderModel(toolCfg, provider)
+	if m == nil {
+		return nil, fmt.Errorf("provider %q is not configured for %s", provider, tool)
+	}
+	group := keyGroupOf(m)
+	if group == "" {
+		return []string{strings.ToLower(tool) + "/" + m.ModelName}, nil
+	}
+	var members []string
+	for _, t := range supportedTools {
+		for i := range config.toolConfig(t).Models {
+			if other := &config.toolConfig(t).Models[i]; keyGroupOf(other) == group {
+				members = append(members, t+"/"+other.ModelName)
+			}
+		}
+	}
+	return members, nil
+}
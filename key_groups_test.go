@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func keyGroupTestConfig() AppConfig {
+	return AppConfig{ActiveTool: "codex",
+		Claude: ToolConfig{Models: []ModelConfig{
+			{ModelName: "Kimi", ApiKey: "sk-kimi"},
+			{ModelName: "DeepSeek", ApiKey: "sk-team", KeyGroup: "team"},
+			{ModelName: "Original"},
+		}},
+		Codex: ToolConfig{Models: []ModelConfig{
+			{ModelName: "Kimi", ApiKey: "sk-kimi"},
+			{ModelName: "Relay", ApiKey: "sk-team", KeyGroup: "Team", IsCustom: true},
+		}},
+		Kilo: ToolConfig{Models: []ModelConfig{
+			{ModelName: "Kimi", ApiKey: "sk-other-account", KeyGroup: KeyGroupOwn},
+			{ModelName: "DS", ApiKey: "sk-team", KeyGroup: "team"},
+		}},
+	}
+}
+
+func keyOf(c *AppConfig, tool, provider string) string {
+	return getProviderModel(c.toolConfig(tool), provider).ApiKey
+}
+
+func TestSyncKeyGroups(t *testing.T) {
+	oldConfig := keyGroupTestConfig()
+	newConfig := cloneConfig(oldConfig)
+	getProviderModel(&newConfig.Claude, "Kimi").ApiKey = "sk-kimi-2"
+	getProviderModel(&newConfig.Kilo, "DS").ApiKey = "sk-team-2"
+	changes := syncKeyGroups(&oldConfig, &newConfig)
+
+	want := map[string]string{
+		"claude/Kimi": "sk-kimi-2", "codex/Kimi": "sk-kimi-2", "kilo/Kimi": "sk-other-account",
+		"claude/DeepSeek": "sk-team-2", "codex/Relay": "sk-team-2", "kilo/DS": "sk-team-2",
+	}
+	for entry, key := range want {
+		tool, provider, _ := strings.Cut(entry, "/")
+		if got := keyOf(&newConfig, tool, provider); got != key {
+			t.Errorf("%s key = %q, want %q", entry, got, key)
+		}
+	}
+	wantChanges := []KeySyncChange{
+		{Tool: "codex", Provider: "Kimi", Group: "kimi", Source: "claude/Kimi"},
+		{Tool: "codex", Provider: "Relay", Group: "team", Source: "kilo/DS"},
+		{Tool: "claude", Provider: "DeepSeek", Group: "team", Source: "kilo/DS"},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("changes = %+v, want %+v", changes, wantChanges)
+	}
+}
+
+func TestSyncKeyGroupsConflictsAndJoins(t *testing.T) {
+	oldConfig := keyGroupTestConfig()
+	newConfig := cloneConfig(oldConfig)
+	// Both members edited: the active tool (codex) wins
+	getProviderModel(&newConfig.Claude, "Kimi").ApiKey = "sk-from-claude"
+	getProviderModel(&newConfig.Codex, "Kimi").ApiKey = "sk-from-codex"
+	// A new entry and one leaving "own" take the group's key instead of spreading theirs
+	newConfig.Gemini.Models = append(newConfig.Gemini.Models, ModelConfig{ModelName: "DeepSeek", KeyGroup: "team"})
+	getProviderModel(&newConfig.Kilo, "Kimi").KeyGroup = ""
+	syncKeyGroups(&oldConfig, &newConfig)
+
+	for _, tool := range []string{"claude", "codex", "kilo"} {
+		if got := keyOf(&newConfig, tool, "Kimi"); got != "sk-from-codex" {
+			t.Errorf("%s/Kimi key = %q, want the active tool's sk-from-codex", tool, got)
+		}
+	}
+	if got := keyOf(&newConfig, "gemini", "DeepSeek"); got != "sk-team" {
+		t.Errorf("new gemini/DeepSeek key = %q, want the team key", got)
+	}
+	// Clearing a shared key clears it everywhere
+	oldConfig = keyGroupTestConfig()
+	newConfig = cloneConfig(oldConfig)
+	getProviderModel(&newConfig.Codex, "Relay").ApiKey = ""
+	changes := syncKeyGroups(&oldConfig, &newConfig)
+	if keyOf(&newConfig, "claude", "DeepSeek") != "" || keyOf(&newConfig, "kilo", "DS") != "" || len(changes) != 2 || !changes[0].Cleared {
+		t.Errorf("after clearing the team key: changes %+v", changes)
+	}
+}
+
+func TestPreviewKeySync(t *testing.T) {
+	a := newTestGateway(t, keyGroupTestConfig()).app
+	config, err := a.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	getProviderModel(&config.Claude, "DeepSeek").ApiKey = "sk-team-2"
+	changes, err := a.PreviewKeySync(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Tool+"/"+c.Provider)
+	}
+	if want := []string{"codex/Relay", "kilo/DS"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("preview = %v, want %v", got, want)
+	}
+	// The preview saves nothing and leaves the passed config alone
+	if keyOf(&config, "kilo", "DS") != "sk-team" {
+		t.Error("PreviewKeySync changed its argument")
+	}
+	saved, _ := a.LoadConfig()
+	if keyOf(&saved, "claude", "DeepSeek") != "sk-team" {
+		t.Error("PreviewKeySync saved the config")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An API key can say where to get the key instead of holding it:
+//
+//	env:NAME      the environment variable NAME
+//	file:PATH     the first line of a file, e.g. file:~/.secrets/glm
+//	cmd:COMMAND   the first line a command prints, e.g. cmd:pass show kimi
+//
+// References are saved as written and only resolved in memory when a tool is launched,
+// so the key itself never reaches ~/.aicoder_config.json. Resolved keys are masked in
+// the log.
+
+const keyRefTimeout = 10 * time.Second
+
+var keyRefPrefixes = []string{"env:", "file:", "cmd:"}
+
+var (
+	logSecretsMutex sync.Mutex
+	logSecrets      = make(map[string]bool)
+)
+
+// isKeyRef reports whether an API key is a reference to resolve at launch.
+func isKeyRef(value string) bool {
+	for _, prefix := range keyRefPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeyRef reports whether the key or one of the labelled keys of a provider is a reference.
+func hasKeyRef(m *ModelConfig) bool {
+	if isKeyRef(m.ApiKey) {
+		return true
+	}
+	for _, k := range m.Keys {
+		if isKeyRef(k.ApiKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKeyRef reports a malformed reference without resolving it.
+func checkKeyRef(ref string) error {
+	kind, target, _ := strings.Cut(ref, ":")
+	target = strings.TrimSpace(target)
+	switch {
+	case target == "":
+		return fmt.Errorf("%s: reference has nothing after %q", ref, kind+":")
+	case kind == "env" && !envNamePattern.MatchString(target):
+		return fmt.Errorf("%s: %q is not a valid environment variable name", ref, target)
+	case kind == "cmd":
+		if _, err := splitCommandLine(target); err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+// resolveKeyRef returns the key a reference points to. Other values are returned as is.
+func resolveKeyRef(value string) (string, error) {
+	if !isKeyRef(value) {
+		return value, nil
+	}
+	if err := checkKeyRef(value); err != nil {
+		return "", err
+	}
+	kind, target, _ := strings.Cut(value, ":")
+	target = strings.TrimSpace(target)
+	var key string
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(target)
+		if !ok {
+			return "", fmt.Errorf("%s: environment variable %s is not set", value, target)
+		}
+		key = v
+	case "file":
+		data, err := os.ReadFile(expandHome(target))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", value, err)
+		}
+		key = firstLine(string(data))
+	case "cmd":
+		out, err := runKeyCommand(target)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", value, err)
+		}
+		key = firstLine(out)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("%s: resolved to an empty key", value)
+	}
+	addLogSecret(key)
+	return key, nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimLeft(s, "\r\n"), "\n")
+	return strings.TrimSpace(line)
+}
+
+// runKeyCommand runs a cmd: reference (without a shell) and returns its output, giving
+// up after keyRefTimeout.
+func runKeyCommand(command string) (string, error) {
+	args, _ := splitCommandLine(command)
+	if len(args) == 0 {
+		return "", errors.New("empty command")
+	}
+	cmd := createHiddenCmd(args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			if msg := firstLine(stderr.String()); msg != "" {
+				return "", fmt.Errorf("%w: %s", err, msg)
+			}
+			return "", err
+		}
+		return stdout.String(), nil
+	case <-time.After(keyRefTimeout):
+		cmd.Process.Kill()
+		<-done
+		return "", fmt.Errorf("timed out after %s", keyRefTimeout)
+	}
+}
+
+// resolveLaunchKey resolves a reference in the key of the tool's current provider. Like
+// selectLaunchKey it only changes the in-memory config of the launch. A secret the locked
+// store could not resolve fails the launch.
+func (a *App) resolveLaunchKey(config *AppConfig, tool string) error {
+	toolCfg := config.toolConfig(tool)
+	if toolCfg == nil {
+		return nil
+	}
+	m := getProviderModel(toolCfg, toolCfg.CurrentModel)
+	if m == nil {
+		return nil
+	}
+	if err := checkSecretResolved(m.ModelName, m.ApiKey); err != nil {
+		a.log(err.Error())
+		return err
+	}
+	if !isKeyRef(m.ApiKey) {
+		return nil
+	}
+	key, err := resolveKeyRef(m.ApiKey)
+	if err != nil {
+		a.log(fmt.Sprintf("Cannot resolve the API key of %s: %v", m.ModelName, err))
+		return fmt.Errorf("resolving the API key of %s: %w", m.ModelName, err)
+	}
+	a.log(fmt.Sprintf("Resolved the API key of %s from %s", m.ModelName, m.ApiKey))
+	m.ApiKey = key
+	return nil
+}
+
+// addLogSecret makes a.log mask a resolved key.
+func addLogSecret(key string) {
+	if len(key) < 8 {
+		return // Too short to mask without mangling ordinary messages
+	}
+	logSecretsMutex.Lock()
+	logSecrets[key] = true
+	logSecretsMutex.Unlock()
+}
+
+// maskLogSecrets replaces resolved keys in a log message.
+func maskLogSecrets(message string) string {
+	logSecretsMutex.Lock()
+	defer logSecretsMutex.Unlock()
+	for key := range logSecrets {
+		if strings.Contains(message, key) {
+			message = strings.ReplaceAll(message, key, maskSecret(key))
+		}
+	}
+	return message
+}
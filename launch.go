@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envNamePattern matches environment variable names that are safe on every platform.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// launchSpec is everything needed to start a tool, independent of how it is started
+// (new terminal window or in place, see runInPlace).
+type launchSpec struct {
+	ToolName   string
+	BinaryName string
+	ProjectDir string
+	PythonEnv  string
+	ModelId    string
+	YoloMode   bool
+	AdminMode  bool
+	Env        map[string]string
+}
+
+// toolLaunchArgs returns the command line arguments a tool is started with.
+func toolLaunchArgs(binaryName string, yoloMode bool, modelId string) []string {
+	args := []string{}
+	if binaryName == "codebuddy" && modelId != "" {
+		args = append(args, "--model", modelId)
+	}
+	if yoloMode {
+		switch binaryName {
+		case "claude":
+			args = append(args, "--dangerously-skip-permissions")
+		case "gemini":
+			args = append(args, "--yolo")
+		case "codex":
+			args = append(args, "--full-auto")
+		case "codebuddy":
+			args = append(args, "-y")
+		case "iflow":
+			args = append(args, "-y")
+		case "qodercli", "qoder":
+			args = append(args, "--yolo")
+		}
+	}
+	return args
+}
+
+// launchEnviron merges the tool env into the current process environment and puts the
+// private tools directory first on PATH.
+func launchEnviron(env map[string]string) []string {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	home, _ := os.UserHomeDir()
+	localBin := filepath.Join(home, ".cceasy", "tools", "bin")
+	if path := vars["PATH"]; path != "" {
+		vars["PATH"] = localBin + string(os.PathListSeparator) + path
+	} else {
+		vars["PATH"] = localBin
+	}
+	result := make([]string, 0, len(vars))
+	for k, v := range vars {
+		result = append(result, k+"="+v)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// runInPlace starts the tool in the caller's terminal instead of opening a new window.
+// On Unix the current process is replaced by the tool, so this only returns on error.
+// Administrator mode and Python environments need a launcher window, so they are refused
+// rather than silently ignored.
+func (a *App) runInPlace(spec *launchSpec, extraArgs []string) error {
+	if spec.AdminMode {
+		return fmt.Errorf("%s cannot run as administrator in this terminal, use: aicoder launch %s (or --admin=false)", spec.BinaryName, spec.ToolName)
+	}
+	if spec.PythonEnv != "" {
+		return fmt.Errorf("activate the Python environment %s first and pass --python-env \"\", or use: aicoder launch %s", spec.PythonEnv, spec.ToolName)
+	}
+	status := NewToolManager(a).GetToolStatus(spec.BinaryName)
+	if !status.Installed {
+		return fmt.Errorf("%s is not installed, run: aicoder tools install %s", spec.BinaryName, spec.BinaryName)
+	}
+	if err := os.Chdir(spec.ProjectDir); err != nil {
+		return fmt.Errorf("cannot change to project directory: %w", err)
+	}
+	args := append(toolLaunchArgs(spec.BinaryName, spec.YoloMode, spec.ModelId), extraArgs...)
+	a.log(fmt.Sprintf("Running %s %s in %s", status.Path, strings.Join(args, " "), spec.ProjectDir))
+	return execInPlace(status.Path, args, launchEnviron(spec.Env))
+}
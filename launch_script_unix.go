@@ -0,0 +1,151 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Launch scripts for opening a tool in a new terminal on Linux and macOS. The script
+// itself only holds the working directory and command line; the environment (API keys,
+// proxy credentials) is handed over through a FIFO that the script sources, so secrets
+// never reach the disk. The private temp directory holding both is removed as soon as
+// the script has read its environment.
+
+// launchScriptTimeout is how long to wait for the terminal to start the script.
+const launchScriptTimeout = 2 * time.Minute
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// launchScript describes a script that runs a tool in a terminal.
+type launchScript struct {
+	Dir         string
+	Program     string
+	Args        []string
+	Env         map[string]string
+	PrependPath string
+	// PauseOnExit keeps the window open after the tool exits.
+	PauseOnExit bool
+}
+
+// body renders the script. envFile is sourced before anything else runs.
+func (s *launchScript) body(envFile string) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/bash\n")
+	fmt.Fprintf(&b, ". %s || exit 1\n", shellQuote(envFile))
+	fmt.Fprintf(&b, "cd -- %s || exit 1\n", shellQuote(s.Dir))
+	if s.PrependPath != "" {
+		fmt.Fprintf(&b, "export PATH=%s:\"$PATH\"\n", shellQuote(s.PrependPath))
+	}
+	words := []string{shellQuote(s.Program)}
+	for _, arg := range s.Args {
+		words = append(words, shellQuote(arg))
+	}
+	b.WriteString(strings.Join(words, " ") + "\n")
+	if s.PauseOnExit {
+		b.WriteString("echo\nread -r -p 'Press Enter to close...' _\n")
+	}
+	return b.String()
+}
+
+// envExports renders the environment as export statements. Invalid names are skipped.
+func (s *launchScript) envExports() (string, []string) {
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	var skipped []string
+	for _, k := range keys {
+		if !envNamePattern.MatchString(k) {
+			skipped = append(skipped, k)
+			continue
+		}
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(s.Env[k]))
+	}
+	return b.String(), skipped
+}
+
+// prepare writes the script (mode 0700) and its environment FIFO into a new private
+// temp directory and returns the script path. deliver must be called once the terminal
+// has been started; it feeds the environment to the script and removes the directory.
+func (s *launchScript) prepare() (scriptPath string, deliver func() error, err error) {
+	dir, err := os.MkdirTemp("", "aicoder-launch-")
+	if err != nil {
+		return "", nil, err
+	}
+	envFile := filepath.Join(dir, "env")
+	if err := syscall.Mkfifo(envFile, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("cannot create environment pipe: %w", err)
+	}
+	scriptPath = filepath.Join(dir, "launch.sh")
+	if err := os.WriteFile(scriptPath, []byte(s.body(envFile)), 0700); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	exports, _ := s.envExports()
+	deliver = func() error {
+		defer os.RemoveAll(dir)
+		return writeFIFO(envFile, exports, launchScriptTimeout)
+	}
+	return scriptPath, deliver, nil
+}
+
+// writeFIFO waits for a reader to open the FIFO at path and writes data to it.
+func writeFIFO(path, data string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		// A non-blocking open for writing fails with ENXIO until a reader has opened it
+		f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			defer f.Close()
+			_, err = f.WriteString(data)
+			return err
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the terminal to start")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// startInTerminal writes the launch script, starts the terminal command returned by
+// open and hands the environment over in the background. Callers that exit right away
+// (the CLI) must wait on a.pendingLaunches first.
+func (a *App) startInTerminal(s *launchScript, open func(scriptPath string) error) error {
+	if _, skipped := s.envExports(); len(skipped) > 0 {
+		a.log(fmt.Sprintf("Skipping invalid environment variable names: %s", strings.Join(skipped, ", ")))
+	}
+	scriptPath, deliver, err := s.prepare()
+	if err != nil {
+		return err
+	}
+	if err := open(scriptPath); err != nil {
+		os.RemoveAll(filepath.Dir(scriptPath))
+		return err
+	}
+	a.pendingLaunches.Add(1)
+	go func() {
+		defer a.pendingLaunches.Done()
+		if err := deliver(); err != nil {
+			a.log("Launch script: " + err.Error())
+		}
+	}()
+	return nil
+}
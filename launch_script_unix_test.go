@@ -0,0 +1,238 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// hostileStrings returns words that break naive quoting. Any command they manage to
+// inject creates marker.
+func hostileStrings(marker string) []string {
+	return []string{
+		"plain",
+		"with space",
+		"it's",
+		`say "hi"`,
+		"back\\slash",
+		"tab\there",
+		"line\nbreak",
+		"trailing newline\n",
+		"$HOME",
+		"${HOME}",
+		"*",
+		"~",
+		"-n",
+		"!!",
+		"",
+		fmt.Sprintf("$(touch %s)", marker),
+		fmt.Sprintf("`touch %s`", marker),
+		fmt.Sprintf("'; touch %s; '", marker),
+		fmt.Sprintf(`"; touch %s; "`, marker),
+		fmt.Sprintf("\n touch %s\n", marker),
+	}
+}
+
+func assertNotInjected(t *testing.T, marker string) {
+	t.Helper()
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("a hostile string ran a command")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "pwned")
+	for _, s := range hostileStrings(marker) {
+		out, err := exec.Command("sh", "-c", "printf '%s' "+shellQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("sh -c with %q: %v", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("round trip of %q gave %q", s, out)
+		}
+	}
+	assertNotInjected(t, marker)
+}
+
+func TestLaunchScriptEnvExports(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "pwned")
+	s := &launchScript{Env: map[string]string{
+		"GOOD":                                      "value",
+		"with space":                                "x",
+		"NEW\nLINE":                                 "x",
+		"QUOTE'":                                    "x",
+		"1DIGIT":                                    "x",
+		"":                                          "x",
+		fmt.Sprintf("A$(touch %s)", marker):         "x",
+		fmt.Sprintf("B;touch %s;C", marker):         "x",
+		fmt.Sprintf("D=1 touch %s #", marker):       "x",
+		fmt.Sprintf("E`touch %s`", marker):          "x",
+		fmt.Sprintf("F\n touch %s\n", marker):       "x",
+		fmt.Sprintf(`G"$(touch %s)"`, marker):       "x",
+		fmt.Sprintf("H'; touch %s; '", marker):      "x",
+		fmt.Sprintf("I\\\ntouch %s", marker):        "x",
+		fmt.Sprintf("J${IFS}touch${IFS}%s", marker): "x",
+	}}
+	exports, skipped := s.envExports()
+	if exports != "export GOOD='value'\n" {
+		t.Errorf("exports = %q", exports)
+	}
+	if len(skipped) != len(s.Env)-1 {
+		t.Errorf("skipped %d names, want %d: %q", len(skipped), len(s.Env)-1, skipped)
+	}
+	if err := exec.Command("sh", "-c", exports).Run(); err != nil {
+		t.Fatal(err)
+	}
+	assertNotInjected(t, marker)
+}
+
+// TestLaunchScriptRoundTrip runs a prepared script with sh, with hostile strings in the
+// directory, program path, arguments and environment, and checks that the program sees
+// them unchanged.
+func TestLaunchScriptRoundTrip(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "pwned")
+	hostile := hostileStrings(marker)
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Directory names cannot hold "/" or be empty
+	var name strings.Builder
+	for _, s := range hostile {
+		if s != "" && !strings.Contains(s, "/") {
+			name.WriteString(s)
+		}
+	}
+	dir := filepath.Join(base, name.String()+" it's $(x) `y` \"z\"")
+	binDir := filepath.Join(dir, "bin $(x)\n'")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	program := filepath.Join(binDir, "s h'\"$(x)`y`")
+	if err := os.Symlink(sh, program); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	for i, s := range hostile {
+		env[fmt.Sprintf("AICODER_TEST_%d", i)] = s
+	}
+	var printEnv strings.Builder
+	for i := range hostile {
+		fmt.Fprintf(&printEnv, ` "$AICODER_TEST_%d"`, i)
+	}
+	s := &launchScript{
+		Dir:         dir,
+		Program:     program,
+		Args:        append([]string{"-c", `printf '%s\0' "$PWD" "$PATH" "$@"` + printEnv.String(), "sh"}, hostile...),
+		Env:         env,
+		PrependPath: binDir,
+	}
+	scriptPath, deliver, err := s.prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(scriptPath); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("script mode: %v, %v", info, err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", scriptPath)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliver(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("script failed: %v\n%s", err, stderr.String())
+	}
+	if _, err := os.Stat(filepath.Dir(scriptPath)); !os.IsNotExist(err) {
+		t.Errorf("launch directory not removed: %v", err)
+	}
+	got := strings.Split(strings.TrimSuffix(stdout.String(), "\x00"), "\x00")
+	want := append(append([]string{dir}, hostile...), hostile...)
+	if len(got) != len(want)+1 {
+		t.Fatalf("got %d words, want %d: %q", len(got), len(want)+1, got)
+	}
+	if !strings.HasPrefix(got[1], binDir+":") {
+		t.Errorf("PATH = %q, want it to start with %q", got[1], binDir)
+	}
+	got = append(got[:1], got[2:]...)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("word %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	assertNotInjected(t, marker)
+}
+
+func TestWriteFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("waits for a reader", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() { done <- writeFIFO(path, "export A='1'\n", 5*time.Second) }()
+		// The writer retries on ENXIO until the reader shows up
+		time.Sleep(300 * time.Millisecond)
+		select {
+		case err := <-done:
+			t.Fatalf("writeFIFO returned before a reader opened the pipe: %v", err)
+		default:
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "export A='1'\n" {
+			t.Errorf("read %q", data)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("times out without a reader", func(t *testing.T) {
+		start := time.Now()
+		err := writeFIFO(path, "x", 250*time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("writeFIFO = %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+			t.Errorf("timed out after %s", elapsed)
+		}
+	})
+
+	t.Run("fails fast on other errors", func(t *testing.T) {
+		start := time.Now()
+		err := writeFIFO(filepath.Join(t.TempDir(), "missing"), "x", 5*time.Second)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("writeFIFO = %v, want not exist", err)
+		}
+		if time.Since(start) > time.Second {
+			t.Error("writeFIFO retried an error other than ENXIO")
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunInPlaceRefusesLauncherOnlyOptions(t *testing.T) {
+	app := &App{}
+	for _, spec := range []launchSpec{
+		{ToolName: "claude", BinaryName: "claude", AdminMode: true},
+		{ToolName: "claude", BinaryName: "claude", PythonEnv: "ml"},
+	} {
+		err := app.runInPlace(&spec, nil)
+		if err == nil || !strings.Contains(err.Error(), "aicoder launch claude") {
+			t.Errorf("runInPlace(%+v) = %v, want a hint to use launch", spec, err)
+		}
+	}
+}
+
+func projectOverrideConfig(dir string) AppConfig {
+	return AppConfig{ActiveTool: "claude",
+		Claude: ToolConfig{CurrentModel: "GLM", Models: []ModelConfig{
+			{ModelName: "GLM", ModelUrl: "https://open.bigmodel.cn/api/anthropic", ModelId: "glm-4.7", ApiKey: "sk-glm"},
+			{ModelName: "DeepSeek", ModelUrl: "https://api.deepseek.com/anthropic", ModelId: "deepseek-chat", ApiKey: "sk-deepseek"},
+		}},
+		Codex: ToolConfig{CurrentModel: "Kimi", Models: []ModelConfig{
+			{ModelName: "Kimi", ModelUrl: "https://api.moonshot.cn/v1", ModelId: "kimi-k2", ApiKey: "sk-kimi"},
+		}},
+		Projects: []ProjectConfig{
+			{Id: "billing", Name: "billing", Path: filepath.Join(dir, "billing"), Provider: "deepseek", ModelId: "deepseek-reasoner", Env: map[string]string{"TEAM": "billing"}},
+			{Id: "personal", Name: "personal", Path: filepath.Join(dir, "personal"), Tool: "codex", ModelId: "kimi-latest"},
+			{Id: "broken", Name: "broken", Path: filepath.Join(dir, "broken"), Provider: "Gone"},
+		},
+	}
+}
+
+func TestApplyProjectOverrides(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		project, tool     string
+		provider, modelId string
+	}{
+		// Project override, then the tool's current provider
+		{"billing", "claude", "DeepSeek", "deepseek-reasoner"},
+		{"", "claude", "GLM", "glm-4.7"},
+		// A project pinned to another tool leaves this one alone
+		{"personal", "claude", "GLM", "glm-4.7"},
+		{"personal", "codex", "Kimi", "kimi-latest"},
+	}
+	for _, c := range cases {
+		config := projectOverrideConfig(dir)
+		project := config.projectForDir(filepath.Join(dir, c.project) + string(filepath.Separator))
+		if c.project == "" {
+			project = config.projectForDir(dir)
+		}
+		if err := config.applyProjectOverrides(project, c.tool); err != nil {
+			t.Fatal(err)
+		}
+		toolCfg := config.toolConfig(c.tool)
+		m := getProviderModel(toolCfg, toolCfg.CurrentModel)
+		if m.ModelName != c.provider || m.ModelId != c.modelId {
+			t.Errorf("%s in %q: %s/%s, want %s/%s", c.tool, c.project, m.ModelName, m.ModelId, c.provider, c.modelId)
+		}
+	}
+	config := projectOverrideConfig(dir)
+	if err := config.applyProjectOverrides(config.projectForDir(filepath.Join(dir, "broken")), "claude"); err == nil || !strings.Contains(err.Error(), `"Gone"`) {
+		t.Errorf("pinning an unknown provider = %v, want an error naming it", err)
+	}
+	if got := config.defaultToolFor(filepath.Join(dir, "personal")); got != "codex" {
+		t.Errorf("default tool of the pinned project = %q, want codex", got)
+	}
+	if got := config.defaultToolFor(filepath.Join(dir, "billing")); got != "claude" {
+		t.Errorf("default tool of an unpinned project = %q, want the active tool", got)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepareLaunchProjectOverrides(t *testing.T) {
+	for _, k := range []string{"ANTHROPIC_AUTH_TOKEN", "ANTHROPIC_BASE_URL", "ANTHROPIC_MODEL", "TEAM"} {
+		t.Setenv(k, "")
+	}
+	dir := t.TempDir()
+	config := projectOverrideConfig(dir)
+	a := newTestGateway(t, config).app
+	billing := config.Projects[0].Path
+	if err := os.MkdirAll(billing, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := a.prepareLaunch("claude", false, false, false, "", billing, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Env["ANTHROPIC_AUTH_TOKEN"] != "sk-deepseek" || spec.Env["ANTHROPIC_MODEL"] != "deepseek-reasoner" || spec.Env["TEAM"] != "billing" {
+		t.Errorf("billing launch env = %v, want the project's provider, model and env", spec.Env)
+	}
+	spec, err = a.prepareLaunch("claude", false, false, false, "", filepath.Join(dir, "elsewhere"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Env["ANTHROPIC_AUTH_TOKEN"] != "sk-glm" || spec.Env["ANTHROPIC_MODEL"] != "glm-4.7" || spec.Env["TEAM"] != "" {
+		t.Errorf("launch env outside the project = %v, want the tool's current provider", spec.Env)
+	}
+	// The overrides only apply to the launch, they are never saved
+	saved, _ := a.LoadConfig()
+	if saved.Claude.CurrentModel != "GLM" || getProviderModel(&saved.Claude, "DeepSeek").ModelId != "deepseek-chat" {
+		t.Errorf("saved claude config changed: current %s", saved.Claude.CurrentModel)
+	}
+}
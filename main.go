@@ -24,6 +24,11 @@ func main() {
 
 	// Check for command line arguments
 	args := os.Args
+
+	// Headless subcommands (use, launch, providers, config, tools, gateway, usage) never start the webview
+	if len(args) > 1 && isCLICommand(args[1]) {
+		os.Exit(runCLI(app, args[1:]))
+	}
 	if len(args) > 1 {
 		for _, arg := range args[1:] {
 			if arg == "init" {
@@ -31,7 +36,7 @@ func main() {
 			}
 			if arg == "--tui" || arg == "-tui" {
 				// Launch TUI mode
-				if err := tui.RunTUI(); err != nil {
+				if err := tui.RunTUI(app.configIssueLines()); err != nil {
 					fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
 					os.Exit(1)
 				}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// AICoder edits tool settings files in place. The keys it writes are recorded in
+// ~/.cceasy/managed_keys.json so that switching a tool back to "Original" removes
+// exactly those keys and leaves the rest of the user's settings alone.
+
+type managedFile struct {
+	Created bool       `json:"created,omitempty"` // The file did not exist before AICoder wrote it
+	Keys    [][]string `json:"keys"`              // Key paths written by AICoder
+}
+
+// managedUpdate rewrites file content and returns the new content with the key paths AICoder now owns.
+type managedUpdate func(existing []byte, owned [][]string) ([]byte, [][]string, error)
+
+var managedKeysMutex sync.Mutex
+
+func getManagedKeysPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "managed_keys.json")
+}
+
+func loadManagedKeys() map[string]managedFile {
+	files := make(map[string]managedFile)
+	if data, err := os.ReadFile(getManagedKeysPath()); err == nil {
+		json.Unmarshal(data, &files)
+	}
+	return files
+}
+
+func saveManagedKeys(files map[string]managedFile) error {
+	path := getManagedKeysPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// updateManagedFile applies update to the file at path and records the keys it owns.
+func updateManagedFile(path string, update managedUpdate) error {
+	managedKeysMutex.Lock()
+	defer managedKeysMutex.Unlock()
+	files := loadManagedKeys()
+	entry, tracked := files[path]
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if !tracked {
+			entry.Created = true
+		}
+	}
+	data, keys, err := update(existing, entry.Keys)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, data) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+	}
+	entry.Keys = keys
+	files[path] = entry
+	return saveManagedKeys(files)
+}
+
+// removeManagedFile strips the keys AICoder owns from the file at path. legacyKeys are
+// used for files written by versions that did not record ownership yet. The file itself
+// is deleted only when AICoder created it and nothing else is left in it.
+func removeManagedFile(path string, legacyKeys [][]string, remove func(existing []byte, owned [][]string) ([]byte, bool, error)) error {
+	managedKeysMutex.Lock()
+	defer managedKeysMutex.Unlock()
+	files := loadManagedKeys()
+	entry, tracked := files[path]
+	if !tracked {
+		entry.Keys = legacyKeys
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else if len(entry.Keys) > 0 {
+		data, empty, rmErr := remove(existing, entry.Keys)
+		switch {
+		case rmErr != nil:
+			return rmErr
+		case empty && entry.Created:
+			err = os.Remove(path)
+		case !bytes.Equal(existing, data):
+			err = os.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if tracked {
+		delete(files, path)
+		return saveManagedKeys(files)
+	}
+	return nil
+}
+
+// writeManagedJSON merges values into a JSON settings file. Nested objects are merged key
+// by key, and keys AICoder wrote on a previous sync but no longer sets are removed.
+func writeManagedJSON(path string, values map[string]interface{}) error {
+	// Round-trip through JSON so values compare equal to what is decoded from the file
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return err
+	}
+	return updateManagedFile(path, func(existing []byte, owned [][]string) ([]byte, [][]string, error) {
+		doc, err := decodeJSONObject(path, existing)
+		if err != nil {
+			return nil, nil, err
+		}
+		leaves := jsonLeafPaths(normalized, nil)
+		var keys [][]string
+		for _, p := range leaves {
+			value, _ := getJSONPath(normalized, p)
+			// A value the user already had is left as theirs
+			if current, ok := getJSONPath(doc, p); ok && !containsKeyPath(owned, p) && reflect.DeepEqual(current, value) {
+				continue
+			}
+			setJSONPath(doc, p, value)
+			keys = append(keys, p)
+		}
+		for _, p := range owned {
+			if !containsKeyPath(leaves, p) {
+				deleteJSONPath(doc, p)
+			}
+		}
+		data, err := encodeJSONObject(existing, doc)
+		return data, keys, err
+	})
+}
+
+// removeManagedJSON deletes the keys AICoder wrote to a JSON settings file.
+func removeManagedJSON(path string, legacyKeys [][]string) error {
+	return removeManagedFile(path, legacyKeys, func(existing []byte, owned [][]string) ([]byte, bool, error) {
+		doc, err := decodeJSONObject(path, existing)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, p := range owned {
+			deleteJSONPath(doc, p)
+		}
+		data, err := encodeJSONObject(existing, doc)
+		return data, len(doc) == 0, err
+	})
+}
+
+// writeManagedJSONEntry puts entry into the array at key of a JSON settings file, in
+// place of the element with the same "id". The other elements are the user's and are kept.
+func writeManagedJSONEntry(path, key string, entry map[string]interface{}) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(raw, &normalized); err != nil {
+		return err
+	}
+	id, _ := normalized["id"].(string)
+	return updateManagedFile(path, func(existing []byte, owned [][]string) ([]byte, [][]string, error) {
+		doc, err := decodeJSONObject(path, existing)
+		if err != nil {
+			return nil, nil, err
+		}
+		list, _ := doc[key].([]interface{})
+		var updated []interface{}
+		replaced := false
+		for _, item := range list {
+			itemId := jsonEntryId(item)
+			switch {
+			case itemId == id && !replaced:
+				updated = append(updated, normalized)
+				replaced = true
+			case itemId == id || containsKeyPath(owned, []string{key, itemId}):
+				// A duplicate, or an entry AICoder wrote under another id
+			default:
+				updated = append(updated, item)
+			}
+		}
+		if !replaced {
+			updated = append(updated, normalized)
+		}
+		doc[key] = updated
+		data, err := encodeJSONObject(existing, doc)
+		return data, [][]string{{key, id}}, err
+	})
+}
+
+// removeManagedJSONEntries deletes the array elements AICoder wrote to a JSON settings
+// file, see writeManagedJSONEntry. An array left empty is removed with them.
+func removeManagedJSONEntries(path string, legacyKeys [][]string) error {
+	return removeManagedFile(path, legacyKeys, func(existing []byte, owned [][]string) ([]byte, bool, error) {
+		doc, err := decodeJSONObject(path, existing)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, p := range owned {
+			list, ok := doc[p[0]].([]interface{})
+			if !ok || len(p) != 2 {
+				continue
+			}
+			var kept []interface{}
+			for _, item := range list {
+				if jsonEntryId(item) != p[1] {
+					kept = append(kept, item)
+				}
+			}
+			if len(kept) == 0 {
+				delete(doc, p[0])
+			} else {
+				doc[p[0]] = kept
+			}
+		}
+		data, err := encodeJSONObject(existing, doc)
+		return data, len(doc) == 0, err
+	})
+}
+
+func jsonEntryId(item interface{}) string {
+	m, _ := item.(map[string]interface{})
+	id, _ := m["id"].(string)
+	return id
+}
+
+func decodeJSONObject(path string, data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			// Never overwrite a file we cannot read back, it may hold hand-written settings
+			return nil, fmt.Errorf("cannot update %s, it is not a valid JSON object: %w", path, err)
+		}
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	return doc, nil
+}
+
+// encodeJSONObject marshals doc, returning existing unchanged when the content is the same.
+func encodeJSONObject(existing []byte, doc map[string]interface{}) ([]byte, error) {
+	if len(existing) > 0 {
+		var current interface{}
+		if json.Unmarshal(existing, &current) == nil && reflect.DeepEqual(current, interface{}(doc)) {
+			return existing, nil
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// jsonLeafPaths lists the paths of all non-object values (and empty objects) in m.
+func jsonLeafPaths(m map[string]interface{}, prefix []string) [][]string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var paths [][]string
+	for _, k := range keys {
+		p := append(append([]string{}, prefix...), k)
+		if child, ok := m[k].(map[string]interface{}); ok && len(child) > 0 {
+			paths = append(paths, jsonLeafPaths(child, p)...)
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func getJSONPath(m map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = m
+	for _, k := range path {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[k]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func setJSONPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, k := range path[:len(path)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[k] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+// deleteJSONPath removes a key and any parent objects left empty by the removal.
+func deleteJSONPath(m map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	child, ok := m[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteJSONPath(child, path[1:])
+	if len(child) == 0 {
+		delete(m, path[0])
+	}
+}
+
+func containsKeyPath(paths [][]string, path []string) bool {
+	for _, p := range paths {
+		if equalKeyPath(p, path) {
+			return true
+		}
+	}
+	return false
+}
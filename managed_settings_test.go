@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func kiloTestConfig(current string) AppConfig {
+	return AppConfig{Kilo: ToolConfig{CurrentModel: current, Models: []ModelConfig{
+		{ModelName: "Original"},
+		{ModelName: "Relay", ModelUrl: "https://relay.example/v1", ModelId: "relay-large", ApiKey: "sk-relay"},
+	}}}
+}
+
+func readKiloProviders(t *testing.T, path string) (map[string]interface{}, []string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	providers, _ := doc["providers"].([]interface{})
+	for _, p := range providers {
+		ids = append(ids, jsonEntryId(p))
+	}
+	return doc, ids
+}
+
+func TestKiloSettingsKeepUserProviders(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	a := &App{testHomeDir: home}
+	_, path := a.getKiloConfigPaths()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, []byte(`{"theme":"dark","providers":[{"id":"mine","provider":"anthropic"},{"id":"default","provider":"openai","openAiApiKey":"old"}]}`), 0644)
+
+	for i := 0; i < 2; i++ {
+		if err := a.syncToKiloSettings(kiloTestConfig("Relay")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, ids := readKiloProviders(t, path)
+	if want := []string{"mine", "default"}; !reflect.DeepEqual(ids, want) || doc["theme"] != "dark" {
+		t.Fatalf("after sync: providers %v, theme %v, want %v and the user's theme", ids, doc["theme"], want)
+	}
+	if key := doc["providers"].([]interface{})[1].(map[string]interface{})["openAiApiKey"]; key != "sk-relay" {
+		t.Errorf("default provider key = %v, want sk-relay", key)
+	}
+
+	if err := a.syncToKiloSettings(kiloTestConfig("Original")); err != nil {
+		t.Fatal(err)
+	}
+	doc, ids = readKiloProviders(t, path)
+	if want := []string{"mine"}; !reflect.DeepEqual(ids, want) || doc["theme"] != "dark" {
+		t.Errorf("after clear: providers %v, theme %v, want %v and the user's theme", ids, doc["theme"], want)
+	}
+}
+
+func TestKiloSettingsRemovedWhenCreatedByAICoder(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	a := &App{testHomeDir: home}
+	_, path := a.getKiloConfigPaths()
+	if err := a.syncToKiloSettings(kiloTestConfig("Relay")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ids := readKiloProviders(t, path); !reflect.DeepEqual(ids, []string{"default"}) {
+		t.Fatalf("providers = %v, want AICoder's alone", ids)
+	}
+	a.clearKiloConfig()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("config still exists after clear: %v", err)
+	}
+}
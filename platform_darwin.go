@@ -253,45 +253,23 @@ func (a *App) platformLaunch(binaryName string, yoloMode bool, adminMode bool, p
 		return
 	}
 	
-	cmdArgs := []string{}
-	if binaryName == "codebuddy" && modelId != "" {
-		cmdArgs = append(cmdArgs, "--model", modelId)
-	}
-
-	if yoloMode {
-		switch binaryName {
-		case "claude":
-			cmdArgs = append(cmdArgs, "--dangerously-skip-permissions")
-		case "gemini":
-			cmdArgs = append(cmdArgs, "--yolo")
-		case "codex":
-			cmdArgs = append(cmdArgs, "--full-auto")
-		case "codebuddy":
-			cmdArgs = append(cmdArgs, "-y")
-		case "iflow":
-			cmdArgs = append(cmdArgs, "-y")
-		case "qodercli", "qoder":
-			cmdArgs = append(cmdArgs, "--yolo")
-		}
-	}
-	
-	scriptPath := filepath.Join(os.TempDir(), fmt.Sprintf("aicoder_launch_%d.sh", time.Now().UnixNano()))
-	scriptContent := "#!/bin/bash\n"
-	scriptContent += fmt.Sprintf("cd \"%s\"\n", projectDir)
-	for k, v := range env {
-		scriptContent += fmt.Sprintf("export %s=\"%s\"\n", k, v)
-	}
+	cmdArgs := toolLaunchArgs(binaryName, yoloMode, modelId)
 	
 	home, _ := os.UserHomeDir()
-	localBin := filepath.Join(home, ".cceasy", "tools", "bin")
-	scriptContent += fmt.Sprintf("export PATH=\"%s:$PATH\"\n", localBin)
-	
-	scriptContent += fmt.Sprintf("\"%s\" %s\n", status.Path, strings.Join(cmdArgs, " "))
-	
-os.WriteFile(scriptPath, []byte(scriptContent), 0755)
-	
-	cmd := exec.Command("open", "-a", "Terminal", scriptPath)
-	cmd.Start()
+	script := &launchScript{
+		Dir:         projectDir,
+		Program:     status.Path,
+		Args:        cmdArgs,
+		Env:         env,
+		PrependPath: filepath.Join(home, ".cceasy", "tools", "bin"),
+	}
+	err := a.startInTerminal(script, func(scriptPath string) error {
+		return exec.Command("open", "-a", "Terminal", scriptPath).Start()
+	})
+	if err != nil {
+		a.log("Launch failed: " + err.Error())
+		a.ShowMessage("Error", err.Error())
+	}
 }
 
 func (a *App) syncToSystemEnv(config AppConfig) {
@@ -273,68 +273,28 @@ func (a *App) platformLaunch(binaryName string, yoloMode bool, adminMode bool, p
 		return
 	}
 	
-	cmdArgs := []string{}
-	if binaryName == "codebuddy" && modelId != "" {
-		cmdArgs = append(cmdArgs, "--model", modelId)
-	}
-
-	if yoloMode {
-		switch binaryName {
-		case "claude":
-			cmdArgs = append(cmdArgs, "--dangerously-skip-permissions")
-		case "gemini":
-			cmdArgs = append(cmdArgs, "--yolo")
-		case "codex":
-			cmdArgs = append(cmdArgs, "--full-auto")
-		case "codebuddy":
-			cmdArgs = append(cmdArgs, "-y")
-		case "iflow":
-			cmdArgs = append(cmdArgs, "-y")
-		case "qodercli", "qoder":
-			cmdArgs = append(cmdArgs, "--yolo")
-		}
-	}
-	
-	// Simple terminal launch (x-terminal-emulator or similar)
-	// For now just background process or basic attempt
-	// A robust solution needs to handle various terminal emulators
+	cmdArgs := toolLaunchArgs(binaryName, yoloMode, modelId)
 	
-	// Create shell script wrapper
-	scriptPath := filepath.Join(os.TempDir(), fmt.Sprintf("aicoder_launch_%d.sh", time.Now().UnixNano()))
-	scriptContent := "#!/bin/bash\n"
-	scriptContent += fmt.Sprintf("cd \"%s\"\n", projectDir)
-	for k, v := range env {
-		scriptContent += fmt.Sprintf("export %s=\"%s\"\n", k, v)
-	}
-	
-	// Add local node to PATH
 	home, _ := os.UserHomeDir()
-	localBin := filepath.Join(home, ".cceasy", "tools", "bin")
-	scriptContent += fmt.Sprintf("export PATH=\"%s:$PATH\"\n", localBin)
-	
-	scriptContent += fmt.Sprintf("\"%s\" %s\n", status.Path, strings.Join(cmdArgs, " "))
-	scriptContent += "echo 'Press Enter to close...\nread\n"
-	
-	os.WriteFile(scriptPath, []byte(scriptContent), 0755)
-	
-	// Try to open terminal
-	terminals := []string{"x-terminal-emulator", "gnome-terminal", "konsole", "xterm"}
-	var cmd *exec.Cmd
-	for _, t := range terminals {
-		if _, err := exec.LookPath(t); err == nil {
-			if t == "gnome-terminal" {
-				cmd = exec.Command(t, "--", scriptPath)
-			} else {
-				cmd = exec.Command(t, "-e", scriptPath)
-			}
-			break
-		}
+	script := &launchScript{
+		Dir:         projectDir,
+		Program:     status.Path,
+		Args:        cmdArgs,
+		Env:         env,
+		PrependPath: filepath.Join(home, ".cceasy", "tools", "bin"),
+		PauseOnExit: true,
 	}
-	
-	if cmd != nil {
-		cmd.Start()
-	} else {
-		a.log("No supported terminal emulator found.")
+	config, _ := a.LoadConfig()
+	err := a.startInTerminal(script, func(scriptPath string) error {
+		cmd, err := terminalCommand(config.Terminal, scriptPath, projectDir, terminalWindowTitle+" - "+binaryName)
+		if err != nil {
+			return err
+		}
+		return cmd.Start()
+	})
+	if err != nil {
+		a.log("Launch failed: " + err.Error())
+		a.ShowMessage("Error", err.Error())
 	}
 }
 
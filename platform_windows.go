@@ -305,11 +305,11 @@ func (a *App) RunEnvironmentCheckCLI() {
 	}
 
 	// Update config
-	if cfg, err := a.LoadConfig(); err == nil {
+	a.UpdateConfig(func(cfg *AppConfig) error {
 		cfg.EnvCheckDone = true
 		cfg.PauseEnvCheck = true
-		a.SaveConfig(cfg)
-	}
+		return nil
+	})
 }
 
 func (a *App) installNodeJSCLI() error {
@@ -885,16 +885,12 @@ func (a *App) CheckEnvironment(force bool) {
 		a.log(a.tr("Environment check complete."))
 
 		// Update config to skip check next time if this was the first run
-		if cfg, err := a.LoadConfig(); err == nil {
-			needsSave := false
-			if !cfg.EnvCheckDone {
+		if cfg, err := a.LoadConfig(); err == nil && !cfg.EnvCheckDone {
+			a.UpdateConfig(func(cfg *AppConfig) error {
 				cfg.EnvCheckDone = true
 				cfg.PauseEnvCheck = true
-				needsSave = true
-			}
-			if needsSave {
-				a.SaveConfig(cfg)
-			}
+				return nil
+			})
 		}
 
 		a.emitEvent("env-check-done")
@@ -1875,11 +1871,22 @@ func (a *App) syncToSystemEnv(config AppConfig) {
 		os.Setenv("WIRE_API", "responses")
 	}
 
+	// A key from env:, file: or cmd: is resolved for each launch, it must not be persisted
+	persistKey := true
+	if configured, err := a.LoadConfig(); err == nil {
+		if m := getProviderModel(configured.toolConfig(toolName), selectedModel.ModelName); m != nil && hasKeyRef(m) {
+			persistKey = false
+			a.log(fmt.Sprintf("Not persisting %s, the key of %s is a reference", envKey, m.ModelName))
+		}
+	}
+
 	// Set persistent environment variables on Windows in a goroutine
 	go func() {
-		cmd1 := exec.Command("setx", envKey, selectedModel.ApiKey)
-		cmd1.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
-		cmd1.Run()
+		if persistKey {
+			cmd1 := exec.Command("setx", envKey, selectedModel.ApiKey)
+			cmd1.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+			cmd1.Run()
+		}
 
 		if baseUrl != "" {
 			cmd2 := exec.Command("setx", envBaseUrl, baseUrl)
@@ -2031,3 +2038,11 @@ func createHiddenCmd(name string, args ...string) *exec.Cmd {
 	return cmd
 }
 
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
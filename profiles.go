@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Profiles let one config hold several sets of provider choices, e.g. a company-paid and
+// a personal one. A profile covers the current provider and API keys of every tool, the
+// default proxy and which tool tabs are shown. Providers themselves (URLs, model IDs,
+// custom providers) and projects are shared.
+//
+// The active profile lives in the regular top-level fields, so everything else keeps
+// reading AppConfig as before. AppConfig.Profiles holds a snapshot of every inactive
+// profile and only the name of the active one; activating a profile swaps the two.
+
+const defaultProfileName = "Default"
+
+// ConfigProfile is a saved profile. For the active profile only Name is set.
+type ConfigProfile struct {
+	Name                 string             `json:"name"`
+	Tools                []ProfileToolState `json:"tools,omitempty"`
+	DefaultProxyHost     string             `json:"default_proxy_host,omitempty"`
+	DefaultProxyPort     string             `json:"default_proxy_port,omitempty"`
+	DefaultProxyUsername string             `json:"default_proxy_username,omitempty"`
+	DefaultProxyPassword string             `json:"default_proxy_password,omitempty"`
+	HiddenTools          []string           `json:"hidden_tools,omitempty"` // Tools whose tab is hidden
+}
+
+// ProfileToolState is the part of a ToolConfig that belongs to a profile.
+type ProfileToolState struct {
+	Tool         string       `json:"tool"`
+	CurrentModel string       `json:"current_model"`
+	Keys         []ProfileKey `json:"keys,omitempty"`
+}
+
+// ProfileKey is the API key of one provider in a profile.
+type ProfileKey struct {
+	Provider string        `json:"provider"`
+	ApiKey   string        `json:"api_key"`
+	Keys     []ProviderKey `json:"keys,omitempty"` // Labelled keys, if the provider has several
+}
+
+// ProfileSummary is what GetProfiles returns for each profile.
+type ProfileSummary struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// toolVisibility returns the show_* flag of a tool, or nil for tools that are always shown.
+func (c *AppConfig) toolVisibility(tool string) *bool {
+	switch tool {
+	case "gemini":
+		return &c.ShowGemini
+	case "codex":
+		return &c.ShowCodex
+	case "opencode":
+		return &c.ShowOpenCode
+	case "codebuddy":
+		return &c.ShowCodeBuddy
+	case "qoder":
+		return &c.ShowQoder
+	case "iflow":
+		return &c.ShowIFlow
+	case "kilo":
+		return &c.ShowKilo
+	}
+	return nil
+}
+
+// ensureProfiles turns a config without profiles into one with a single default profile.
+func (c *AppConfig) ensureProfiles() {
+	if c.ActiveProfile == "" {
+		c.ActiveProfile = defaultProfileName
+	}
+	if c.findProfile(c.ActiveProfile) < 0 {
+		c.Profiles = append([]ConfigProfile{{Name: c.ActiveProfile}}, c.Profiles...)
+	}
+}
+
+func (c *AppConfig) findProfile(name string) int {
+	for i, p := range c.Profiles {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// captureProfile snapshots the top-level fields into a profile.
+func (c *AppConfig) captureProfile(name string) ConfigProfile {
+	p := ConfigProfile{
+		Name:                 name,
+		DefaultProxyHost:     c.DefaultProxyHost,
+		DefaultProxyPort:     c.DefaultProxyPort,
+		DefaultProxyUsername: c.DefaultProxyUsername,
+		DefaultProxyPassword: c.DefaultProxyPassword,
+	}
+	for _, tool := range supportedTools {
+		toolCfg := c.toolConfig(tool)
+		state := ProfileToolState{Tool: tool, CurrentModel: toolCfg.CurrentModel}
+		for _, m := range toolCfg.Models {
+			if m.ApiKey != "" {
+				state.Keys = append(state.Keys, ProfileKey{Provider: m.ModelName, ApiKey: m.ApiKey, Keys: append([]ProviderKey(nil), m.Keys...)})
+			}
+		}
+		p.Tools = append(p.Tools, state)
+		if show := c.toolVisibility(tool); show != nil && !*show {
+			p.HiddenTools = append(p.HiddenTools, tool)
+		}
+	}
+	return p
+}
+
+// applyProfile loads a profile snapshot into the top-level fields. Providers the profile
+// has no key for get an empty key, unless they share one through a key group.
+func (c *AppConfig) applyProfile(p ConfigProfile) {
+	c.DefaultProxyHost = p.DefaultProxyHost
+	c.DefaultProxyPort = p.DefaultProxyPort
+	c.DefaultProxyUsername = p.DefaultProxyUsername
+	c.DefaultProxyPassword = p.DefaultProxyPassword
+	for _, tool := range supportedTools {
+		toolCfg := c.toolConfig(tool)
+		var state ProfileToolState
+		for _, s := range p.Tools {
+			if s.Tool == tool {
+				state = s
+			}
+		}
+		for i := range toolCfg.Models {
+			m := &toolCfg.Models[i]
+			m.ApiKey, m.Keys = "", nil
+			for _, k := range state.Keys {
+				if k.Provider == m.ModelName {
+					m.ApiKey, m.Keys = k.ApiKey, append([]ProviderKey(nil), k.Keys...)
+				}
+			}
+		}
+		toolCfg.CurrentModel = "Original"
+		if m := getProviderModel(toolCfg, state.CurrentModel); m != nil {
+			toolCfg.CurrentModel = m.ModelName
+		}
+		if show := c.toolVisibility(tool); show != nil {
+			*show = !contains(p.HiddenTools, tool)
+		}
+	}
+	// Members of a key group the profile has no key for take the group's key, otherwise
+	// saving would see their keys cleared and clear the whole group
+	syncKeyGroups(&AppConfig{}, c)
+}
+
+// profileSnapshot returns the full snapshot of a profile, taking the active one from the
+// top-level fields.
+func (c *AppConfig) profileSnapshot(name string) (ConfigProfile, error) {
+	if name == c.ActiveProfile {
+		return c.captureProfile(name), nil
+	}
+	idx := c.findProfile(name)
+	if idx < 0 {
+		return ConfigProfile{}, fmt.Errorf("profile %q not found", name)
+	}
+	return c.Profiles[idx], nil
+}
+
+func checkProfileName(c *AppConfig, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("profile name must not be empty")
+	}
+	if c.findProfile(name) >= 0 {
+		return "", fmt.Errorf("profile %q already exists", name)
+	}
+	return name, nil
+}
+
+// GetProfiles lists the profiles, the active one included.
+func (a *App) GetProfiles() ([]ProfileSummary, error) {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.ensureProfiles()
+	summaries := make([]ProfileSummary, len(config.Profiles))
+	for i, p := range config.Profiles {
+		summaries[i] = ProfileSummary{Name: p.Name, Active: p.Name == config.ActiveProfile}
+	}
+	return summaries, nil
+}
+
+// CreateProfile adds an empty profile: every tool on Original, no keys and no proxy.
+func (a *App) CreateProfile(name string) error {
+	return a.UpdateConfig(func(config *AppConfig) error {
+		config.ensureProfiles()
+		name, err := checkProfileName(config, name)
+		if err != nil {
+			return err
+		}
+		config.Profiles = append(config.Profiles, ConfigProfile{Name: name})
+		return nil
+	})
+}
+
+// CloneProfile adds a copy of source, keys included, under a new name.
+func (a *App) CloneProfile(source, name string) error {
+	return a.UpdateConfig(func(config *AppConfig) error {
+		config.ensureProfiles()
+		name, err := checkProfileName(config, name)
+		if err != nil {
+			return err
+		}
+		p, err := config.profileSnapshot(source)
+		if err != nil {
+			return err
+		}
+		p.Name = name
+		p.Tools = append([]ProfileToolState(nil), p.Tools...)
+		for i := range p.Tools {
+			p.Tools[i].Keys = append([]ProfileKey(nil), p.Tools[i].Keys...)
+			for j := range p.Tools[i].Keys {
+				p.Tools[i].Keys[j].Keys = append([]ProviderKey(nil), p.Tools[i].Keys[j].Keys...)
+			}
+		}
+		p.HiddenTools = append([]string(nil), p.HiddenTools...)
+		config.Profiles = append(config.Profiles, p)
+		return nil
+	})
+}
+
+// RenameProfile renames a profile.
+func (a *App) RenameProfile(oldName, newName string) error {
+	return a.UpdateConfig(func(config *AppConfig) error {
+		config.ensureProfiles()
+		idx := config.findProfile(oldName)
+		if idx < 0 {
+			return fmt.Errorf("profile %q not found", oldName)
+		}
+		newName, err := checkProfileName(config, newName)
+		if err != nil {
+			return err
+		}
+		config.Profiles[idx].Name = newName
+		if config.ActiveProfile == oldName {
+			config.ActiveProfile = newName
+		}
+		return nil
+	})
+}
+
+// DeleteProfile removes an inactive profile and its keys.
+func (a *App) DeleteProfile(name string) error {
+	return a.UpdateConfig(func(config *AppConfig) error {
+		config.ensureProfiles()
+		if name == config.ActiveProfile {
+			return errors.New("the active profile cannot be deleted, activate another profile first")
+		}
+		idx := config.findProfile(name)
+		if idx < 0 {
+			return fmt.Errorf("profile %q not found", name)
+		}
+		config.Profiles = append(config.Profiles[:idx], config.Profiles[idx+1:]...)
+		return nil
+	})
+}
+
+// ActivateProfile makes name the active profile. The current settings are saved into the
+// previously active profile first, so no keys are lost.
+func (a *App) ActivateProfile(name string) (AppConfig, error) {
+	var updated AppConfig
+	err := a.UpdateConfig(func(config *AppConfig) error {
+		config.ensureProfiles()
+		if name == config.ActiveProfile {
+			updated = *config
+			return nil
+		}
+		idx := config.findProfile(name)
+		if idx < 0 {
+			return fmt.Errorf("profile %q not found", name)
+		}
+		target := config.Profiles[idx]
+		config.Profiles[config.findProfile(config.ActiveProfile)] = config.captureProfile(config.ActiveProfile)
+		config.applyProfile(target)
+		config.Profiles[idx] = ConfigProfile{Name: name}
+		config.ActiveProfile = name
+		updated = *config
+		return nil
+	})
+	if err == nil {
+		a.log("Switched to profile " + name)
+	}
+	return updated, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestActivateProfileKeepsKeys(t *testing.T) {
+	a := cliTestApp(t)
+	err := a.UpdateConfig(func(config *AppConfig) error {
+		config.DefaultProxyHost = "proxy.corp"
+		config.ShowCodex = false
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreateProfile("Personal"); err != nil {
+		t.Fatal(err)
+	}
+	config, err := a.ActivateProfile("Personal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty profile: no keys, no proxy, every tool on Original and shown
+	if config.Claude.CurrentModel != "Original" || providerKeyOf(config, "claude", "GLM") != "" || config.DefaultProxyHost != "" || !config.ShowCodex {
+		t.Fatalf("new profile: current %s, GLM key %q, proxy %q, codex shown %v", config.Claude.CurrentModel, providerKeyOf(config, "claude", "GLM"), config.DefaultProxyHost, config.ShowCodex)
+	}
+	err = a.UpdateConfig(func(config *AppConfig) error {
+		setProviderKey(config, "claude", "Kimi", "sk-personal")
+		config.Claude.CurrentModel = "Kimi"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err = a.ActivateProfile("Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Claude.CurrentModel != "GLM" || providerKeyOf(config, "claude", "GLM") != "sk-glm-0123456789" || providerKeyOf(config, "claude", "Kimi") != "" {
+		t.Errorf("back to Default: current %s, keys GLM %q Kimi %q", config.Claude.CurrentModel, providerKeyOf(config, "claude", "GLM"), providerKeyOf(config, "claude", "Kimi"))
+	}
+	if config.DefaultProxyHost != "proxy.corp" || config.ShowCodex {
+		t.Errorf("back to Default: proxy %q, codex shown %v", config.DefaultProxyHost, config.ShowCodex)
+	}
+	config, _ = a.ActivateProfile("Personal")
+	if config.Claude.CurrentModel != "Kimi" || providerKeyOf(config, "claude", "Kimi") != "sk-personal" {
+		t.Errorf("back to Personal: current %s, Kimi key %q", config.Claude.CurrentModel, providerKeyOf(config, "claude", "Kimi"))
+	}
+	// The saved config holds the same, not only the returned copy
+	saved, _ := a.LoadConfig()
+	if saved.ActiveProfile != "Personal" || providerKeyOf(saved, "claude", "Kimi") != "sk-personal" {
+		t.Errorf("saved: active %s, Kimi key %q", saved.ActiveProfile, providerKeyOf(saved, "claude", "Kimi"))
+	}
+}
+
+func TestProfileManagement(t *testing.T) {
+	a := cliTestApp(t)
+	if err := a.CloneProfile("Default", "Work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RenameProfile("Work", "Client"); err != nil {
+		t.Fatal(err)
+	}
+	for name, err := range map[string]error{
+		"clone to an existing name": a.CloneProfile("Default", "Client"),
+		"create with an empty name": a.CreateProfile("  "),
+		"rename a missing profile":  a.RenameProfile("Work", "Other"),
+		"delete the active profile": a.DeleteProfile("Default"),
+		"clone a missing profile":   a.CloneProfile("Nope", "Other"),
+	} {
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	config, err := a.ActivateProfile("Client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Claude.CurrentModel != "GLM" || providerKeyOf(config, "claude", "GLM") != "sk-glm-0123456789" {
+		t.Errorf("the clone has current %s and GLM key %q, want the copied ones", config.Claude.CurrentModel, providerKeyOf(config, "claude", "GLM"))
+	}
+	// The clone was taken before the first save shared the GLM key with the other tools,
+	// activating it must not clear the key group
+	if got := providerKeyOf(config, "codex", "GLM"); got != "sk-glm-0123456789" {
+		t.Errorf("codex GLM key = %q, want the key shared through the GLM key group", got)
+	}
+	if err := a.DeleteProfile("Default"); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := a.GetProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ProfileSummary{{Name: "Client", Active: true}}; !reflect.DeepEqual(profiles, want) {
+		t.Errorf("profiles = %+v, want %+v", profiles, want)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TestProvider sends the smallest possible request to a provider, in the protocol the
+// tool will speak to it, so a wrong key, URL or model shows up before a terminal opens.
+
+const providerTestTimeout = 20 * time.Second
+
+const (
+	ProviderTestOK          = "ok"
+	ProviderTestAuthError   = "auth_error"   // The key was rejected
+	ProviderTestRateLimited = "rate_limited" // The key works but has no quota left right now
+	ProviderTestNotFound    = "not_found"    // Wrong base path or model
+	ProviderTestError       = "error"        // Any other HTTP error
+	ProviderTestUnreachable = "unreachable"  // No HTTP response at all
+)
+
+// ProviderTestResult is the outcome of TestProvider.
+type ProviderTestResult struct {
+	Tool      string `json:"tool"`
+	Provider  string `json:"provider"`
+	Status    string `json:"status"`
+	Protocol  string `json:"protocol"` // "anthropic", "chat", "responses" or "gemini"
+	Url       string `json:"url"`
+	Model     string `json:"model"`
+	HttpCode  int    `json:"http_code"`
+	LatencyMs int64  `json:"latency_ms"`
+	Message   string `json:"message"` // The provider's error message
+	Hint      string `json:"hint"`
+	Proxy     string `json:"proxy,omitempty"` // host:port of the proxy used
+}
+
+// TestProvider tests a provider of a tool, using the proxy of the current project if it
+// has one enabled.
+func (a *App) TestProvider(tool, providerName string) (ProviderTestResult, error) {
+	return a.testProvider(tool, providerName, a.GetCurrentProjectPath(), false)
+}
+
+// testProvider tests a provider, see providerClient for the proxy used.
+func (a *App) testProvider(tool, providerName, projectDir string, useProxy bool) (ProviderTestResult, error) {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return ProviderTestResult{}, err
+	}
+	m, key, err := providerForRequest(&config, tool, providerName)
+	if err != nil {
+		return ProviderTestResult{}, err
+	}
+	client, proxyAddr, err := config.providerClient(projectDir, useProxy, providerTestTimeout)
+	if err != nil {
+		return ProviderTestResult{}, err
+	}
+	result := testProviderEndpoint(client, strings.ToLower(tool), getProviderRegistry().Resolve(tool, m), key)
+	result.Provider, result.Proxy = m.ModelName, proxyAddr
+	if result.HttpCode == http.StatusUnauthorized || result.HttpCode == http.StatusForbidden {
+		if err := markKeyRejected(tool, m.ModelName, m.ApiKey, time.Now()); err != nil {
+			a.log(err.Error())
+		}
+	}
+	a.log(fmt.Sprintf("Provider test %s/%s: %s (HTTP %d, %d ms) %s", result.Tool, result.Provider, result.Status, result.HttpCode, result.LatencyMs, result.Message))
+	return result, nil
+}
+
+// providerForRequest returns a provider entry and its resolved API key, for talking to
+// the provider directly.
+func providerForRequest(config *AppConfig, tool, providerName string) (*ModelConfig, string, error) {
+	m, err := findProviderEntry(config, tool, providerName)
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.EqualFold(m.ModelName, "Original") {
+		return nil, "", errors.New("Original uses the tool's own login and cannot be queried")
+	}
+	if err := checkSecretResolved(m.ModelName, m.ApiKey); err != nil {
+		return nil, "", err
+	}
+	key, err := resolveKeyRef(m.ApiKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("resolving the API key of %s: %w", m.ModelName, err)
+	}
+	if key == "" {
+		return nil, "", fmt.Errorf("provider %s has no API key", m.ModelName)
+	}
+	return m, key, nil
+}
+
+// providerClient returns an HTTP client going through the proxy a launch in projectDir
+// would use, when useProxy is set or the project enables its proxy, and the proxy's
+// host:port.
+func (c *AppConfig) providerClient(projectDir string, useProxy bool, timeout time.Duration) (*http.Client, string, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var proxyAddr string
+	if project := c.projectForDir(projectDir); useProxy || (project != nil && project.UseProxy) {
+		var proxyURL string
+		if proxyURL, proxyAddr = c.proxyFor(project); proxyURL != "" {
+			u, err := url.Parse(proxyURL)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid proxy %s: %w", proxyAddr, err)
+			}
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, proxyAddr, nil
+}
+
+// providerTestProtocol returns the protocol a tool speaks to a provider, directly or
+// through the gateway.
+func providerTestProtocol(tool, wireApi string) string {
+	switch tool {
+	case "claude":
+		if !strings.EqualFold(wireApi, "chat") {
+			return "anthropic"
+		}
+	case "gemini":
+		return "gemini"
+	case "codex", "iflow":
+		if strings.EqualFold(wireApi, "responses") {
+			return "responses"
+		}
+	}
+	return "chat"
+}
+
+// testProviderEndpoint sends one minimal request to ep and classifies the response.
+func testProviderEndpoint(client *http.Client, tool string, ep ProviderEndpoint, apiKey string) ProviderTestResult {
+	protocol := providerTestProtocol(tool, ep.WireApi)
+	model := strings.TrimSpace(strings.Split(ep.ModelId, ",")[0])
+	base := strings.TrimRight(strings.TrimSpace(ep.BaseUrl), "/")
+	result := ProviderTestResult{Tool: tool, Protocol: protocol, Model: model}
+
+	var body interface{}
+	header := http.Header{"Content-Type": {"application/json"}}
+	switch protocol {
+	case "anthropic":
+		result.Url = base + "/v1/messages"
+		body = map[string]interface{}{"model": model, "max_tokens": 1, "messages": []map[string]string{{"role": "user", "content": "ping"}}}
+		header.Set("x-api-key", apiKey)
+		header.Set("Authorization", "Bearer "+apiKey)
+		header.Set("anthropic-version", "2023-06-01")
+	case "gemini":
+		if base == "" {
+			base = "https://generativelanguage.googleapis.com"
+		}
+		result.Url = base + "/v1beta/models/" + url.PathEscape(model) + ":generateContent"
+		body = map[string]interface{}{"contents": []map[string]interface{}{{"parts": []map[string]string{{"text": "ping"}}}}, "generationConfig": map[string]int{"maxOutputTokens": 1}}
+		header.Set("x-goog-api-key", apiKey)
+	case "responses":
+		result.Url = base + "/responses"
+		body = map[string]interface{}{"model": model, "input": "ping", "max_output_tokens": 16}
+		header.Set("Authorization", "Bearer "+apiKey)
+	default:
+		result.Url = base
+		if !strings.HasSuffix(base, "/chat/completions") {
+			result.Url += "/chat/completions"
+		}
+		body = map[string]interface{}{"model": model, "max_tokens": 1, "messages": []map[string]string{{"role": "user", "content": "ping"}}}
+		header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if base == "" {
+		result.Status, result.Hint = ProviderTestError, "the provider has no base URL"
+		return result
+	}
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, result.Url, bytes.NewReader(data))
+	if err != nil {
+		result.Status, result.Message, result.Hint = ProviderTestError, err.Error(), "the base URL is not valid"
+		return result
+	}
+	req.Header = header
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Status, result.Message, result.Hint = ProviderTestUnreachable, err.Error(), unreachableHint(err)
+		return result
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	result.HttpCode = resp.StatusCode
+	isHTML := strings.Contains(resp.Header.Get("Content-Type"), "text/html")
+	if resp.StatusCode < 300 && !isHTML {
+		result.Status = ProviderTestOK
+		return result
+	}
+	result.Message = providerErrorMessage(respBody)
+	switch code := resp.StatusCode; {
+	case code < 300:
+		// A web page instead of JSON means the request did not reach the API
+		result.Status, result.Message = ProviderTestNotFound, ""
+		result.Hint = "the base URL returned a web page, not an API response; check the base path"
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		result.Status, result.Hint = ProviderTestAuthError, "the provider rejected the API key; check that it is complete and belongs to this provider"
+	case code == http.StatusTooManyRequests:
+		result.Status, result.Hint = ProviderTestRateLimited, "the key was accepted but is rate limited or out of quota"
+	case code == http.StatusNotFound || code == http.StatusMethodNotAllowed:
+		result.Status, result.Hint = ProviderTestNotFound, notFoundHint(protocol, base, result.Message, model)
+	case code >= 500:
+		result.Status, result.Hint = ProviderTestError, "the provider had an internal error, try again later"
+	default:
+		result.Status = ProviderTestError
+		if strings.Contains(strings.ToLower(result.Message), "model") {
+			result.Hint = fmt.Sprintf("check the model ID %q", model)
+		}
+	}
+	return result
+}
+
+// notFoundHint guesses what is wrong with a base URL that answered 404.
+func notFoundHint(protocol, base, message, model string) string {
+	lower := strings.ToLower(base)
+	switch {
+	case strings.Contains(strings.ToLower(message), "model"):
+		return fmt.Sprintf("the provider does not know the model ID %q", model)
+	case protocol == "anthropic" && !strings.Contains(lower, "anthropic"):
+		return "wrong base path, try /anthropic (Claude needs the provider's Anthropic-compatible endpoint)"
+	case protocol == "anthropic" && strings.HasSuffix(lower, "/v1"):
+		return "wrong base path, remove /v1 (Claude adds /v1/messages itself)"
+	case protocol != "anthropic" && protocol != "gemini" && strings.Contains(lower, "anthropic"):
+		return "this is an Anthropic endpoint; use the provider's OpenAI-compatible base URL"
+	case protocol == "responses":
+		return "the provider may not support the Responses API, try wire_api \"chat\""
+	case protocol == "chat" && !strings.Contains(lower, "/v1") && !strings.Contains(lower, "/v4"):
+		return "wrong base path, try adding /v1"
+	}
+	return "wrong base path, check the base URL"
+}
+
+func unreachableHint(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return "the host name of the base URL cannot be resolved"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the request timed out; check the network or the proxy"
+	case strings.Contains(err.Error(), "certificate"):
+		return "the TLS certificate was not accepted; a proxy may be intercepting the connection"
+	case strings.Contains(err.Error(), "proxyconnect"):
+		return "the proxy cannot be reached"
+	}
+	return "the provider cannot be reached; check the base URL and the proxy"
+}
+
+// providerErrorMessage extracts the error message from a provider's response body.
+func providerErrorMessage(body []byte) string {
+	var doc map[string]interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		if e, ok := doc["error"].(map[string]interface{}); ok {
+			if msg, ok := e["message"].(string); ok {
+				return msg
+			}
+		}
+		for _, key := range []string{"error", "message", "msg", "detail"} {
+			if msg, ok := doc[key].(string); ok && msg != "" {
+				return msg
+			}
+		}
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	return msg
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestProviderEndpoint(t *testing.T) {
+	protocols := []struct {
+		tool, wireApi, basePath string
+		protocol, path          string
+		authHeader, authValue   string
+		notFoundHint            string
+	}{
+		{"claude", "", "", "anthropic", "/v1/messages", "x-api-key", "sk-test", "try /anthropic"},
+		{"claude", "chat", "", "chat", "/chat/completions", "Authorization", "Bearer sk-test", "try adding /v1"},
+		{"codex", "responses", "/v1", "responses", "/v1/responses", "Authorization", "Bearer sk-test", "Responses API"},
+		{"gemini", "", "", "gemini", "/v1beta/models/test-model:generateContent", "x-goog-api-key", "sk-test", "check the base URL"},
+	}
+	statuses := []struct {
+		name        string
+		code        int
+		contentType string
+		body        string
+		status      string
+		message     string
+		hint        string // Substring, "404" for the protocol's not-found hint
+	}{
+		{"ok", 200, "application/json", `{"id":"x"}`, ProviderTestOK, "", ""},
+		{"html", 200, "text/html; charset=utf-8", "<html>Welcome</html>", ProviderTestNotFound, "", "web page"},
+		{"unauthorized", 401, "application/json", `{"error":{"message":"invalid api key"}}`, ProviderTestAuthError, "invalid api key", "rejected the API key"},
+		{"not found", 404, "application/json", `{"error":"no route"}`, ProviderTestNotFound, "no route", "404"},
+		{"unknown model", 404, "application/json", `{"message":"model test-model not found"}`, ProviderTestNotFound, "model test-model not found", `does not know the model ID "test-model"`},
+		{"rate limited", 429, "application/json", `{"msg":"quota exceeded"}`, ProviderTestRateLimited, "quota exceeded", "rate limited"},
+		{"server error", 503, "text/plain", "upstream down", ProviderTestError, "upstream down", "internal error"},
+	}
+	for _, p := range protocols {
+		for _, s := range statuses {
+			t.Run(p.protocol+"/"+s.name, func(t *testing.T) {
+				var gotPath, gotAuth string
+				var gotBody map[string]interface{}
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotPath, gotAuth = r.URL.Path, r.Header.Get(p.authHeader)
+					json.NewDecoder(r.Body).Decode(&gotBody)
+					w.Header().Set("Content-Type", s.contentType)
+					w.WriteHeader(s.code)
+					w.Write([]byte(s.body))
+				}))
+				defer srv.Close()
+				ep := ProviderEndpoint{BaseUrl: srv.URL + p.basePath + "/", ModelId: "test-model, other-model", WireApi: p.wireApi}
+				result := testProviderEndpoint(srv.Client(), p.tool, ep, "sk-test")
+
+				if gotPath != p.path || gotAuth != p.authValue {
+					t.Errorf("request to %s with %s %q, want %s with %q", gotPath, p.authHeader, gotAuth, p.path, p.authValue)
+				}
+				if p.protocol != "gemini" && gotBody["model"] != "test-model" {
+					t.Errorf("request model = %v, want the first model ID", gotBody["model"])
+				}
+				if result.Protocol != p.protocol || result.Url != srv.URL+p.path || result.Model != "test-model" {
+					t.Errorf("result protocol %q, url %q, model %q", result.Protocol, result.Url, result.Model)
+				}
+				if result.Status != s.status || result.HttpCode != s.code || result.Message != s.message {
+					t.Errorf("result = %s, HTTP %d, %q; want %s, HTTP %d, %q", result.Status, result.HttpCode, result.Message, s.status, s.code, s.message)
+				}
+				hint := s.hint
+				if hint == "404" {
+					hint = p.notFoundHint
+				}
+				if hint == "" && result.Hint != "" || !strings.Contains(result.Hint, hint) {
+					t.Errorf("hint = %q, want it to contain %q", result.Hint, hint)
+				}
+			})
+		}
+
+		t.Run(p.protocol+"/unreachable", func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			base := "http://" + ln.Addr().String() + p.basePath
+			ln.Close()
+			client := &http.Client{Timeout: 5 * time.Second}
+			result := testProviderEndpoint(client, p.tool, ProviderEndpoint{BaseUrl: base, ModelId: "test-model", WireApi: p.wireApi}, "sk-test")
+			if result.Status != ProviderTestUnreachable || result.HttpCode != 0 || result.Message == "" {
+				t.Errorf("result = %+v, want unreachable with the connection error", result)
+			}
+			if !strings.Contains(result.Hint, "cannot be reached") {
+				t.Errorf("hint = %q", result.Hint)
+			}
+		})
+	}
+
+	t.Run("no base url", func(t *testing.T) {
+		result := testProviderEndpoint(http.DefaultClient, "codex", ProviderEndpoint{ModelId: "m"}, "sk-test")
+		if result.Status != ProviderTestError || !strings.Contains(result.Hint, "no base URL") {
+			t.Errorf("result = %+v", result)
+		}
+	})
+}
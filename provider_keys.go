@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A provider entry can hold several labelled keys (ModelConfig.Keys), e.g. team keys
+// that spread rate limits. One is picked per launch according to KeyStrategy. ApiKey
+// always mirrors the first key, so code that only asks "is there a key" keeps working.
+//
+// When each key was last used and last rejected is kept in ~/.cceasy/key_usage.json,
+// indexed by tool, provider and a hash of the key, so the config does not change on every
+// launch and each provider entry rotates its keys on its own, even when it shares a key
+// with another tool.
+
+const (
+	KeyStrategyPinned     = "pinned"      // Always the first key
+	KeyStrategyRoundRobin = "round-robin" // The key after the one used last
+	KeyStrategyLRU        = "lru"         // The key unused for longest
+
+	// keyRejectCooldown is how long a rejected key is skipped while others are available.
+	keyRejectCooldown = 10 * time.Minute
+)
+
+// ProviderKey is one labelled key of a provider entry.
+type ProviderKey struct {
+	Label  string `json:"label"`
+	ApiKey string `json:"api_key"`
+}
+
+// ProviderKeyStatus is the usage of one key, as shown in the settings.
+type ProviderKeyStatus struct {
+	Label        string `json:"label"`
+	LastUsed     string `json:"last_used"`     // RFC3339, empty if never used
+	LastRejected string `json:"last_rejected"` // RFC3339, empty if never rejected
+}
+
+type keyUsage struct {
+	LastUsed     time.Time `json:"last_used,omitempty"`
+	LastRejected time.Time `json:"last_rejected,omitempty"`
+}
+
+var keyUsageMutex sync.Mutex
+
+func getKeyUsagePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "key_usage.json")
+}
+
+// keyFingerprint identifies a key without storing it.
+func keyFingerprint(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:12])
+}
+
+// keyUsageID identifies the key of a provider entry in the usage file.
+func keyUsageID(tool, provider, apiKey string) string {
+	return strings.ToLower(tool) + "/" + strings.ToLower(provider) + "/" + keyFingerprint(apiKey)
+}
+
+func loadKeyUsage() map[string]keyUsage {
+	usage := make(map[string]keyUsage)
+	if data, err := os.ReadFile(getKeyUsagePath()); err == nil {
+		json.Unmarshal(data, &usage)
+	}
+	return usage
+}
+
+func saveKeyUsage(usage map[string]keyUsage) error {
+	path := getKeyUsagePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// providerKeys returns the keys of an entry in order: Keys, or ApiKey as a single
+// unlabelled key.
+func providerKeys(m *ModelConfig) []ProviderKey {
+	if len(m.Keys) > 0 {
+		return m.Keys
+	}
+	if m.ApiKey == "" {
+		return nil
+	}
+	return []ProviderKey{{ApiKey: m.ApiKey}}
+}
+
+// syncPrimaryKeys makes ApiKey mirror the first of Keys in every provider entry.
+func syncPrimaryKeys(c *AppConfig) {
+	for _, tool := range supportedTools {
+		toolCfg := c.toolConfig(tool)
+		for i := range toolCfg.Models {
+			if m := &toolCfg.Models[i]; len(m.Keys) > 0 {
+				m.ApiKey = m.Keys[0].ApiKey
+			}
+		}
+	}
+}
+
+// chooseProviderKey picks the key for a launch, or a gateway request, and records it as used.
+func chooseProviderKey(tool string, m *ModelConfig, now time.Time) (ProviderKey, error) {
+	keys := providerKeys(m)
+	if len(keys) == 0 {
+		return ProviderKey{}, nil
+	}
+	keyUsageMutex.Lock()
+	defer keyUsageMutex.Unlock()
+	usage := loadKeyUsage()
+	// Keys rejected recently are skipped unless that leaves nothing
+	var candidates []int
+	for i, k := range keys {
+		if now.Sub(usage[keyUsageID(tool, m.ModelName, k.ApiKey)].LastRejected) > keyRejectCooldown {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range keys {
+			candidates = append(candidates, i)
+		}
+	}
+	chosen := candidates[0]
+	switch m.KeyStrategy {
+	case "", KeyStrategyPinned:
+	case KeyStrategyRoundRobin:
+		// Continue after the key of this entry that was used last
+		last, lastUsed := -1, time.Time{}
+		for i, k := range keys {
+			if u := usage[keyUsageID(tool, m.ModelName, k.ApiKey)].LastUsed; u.After(lastUsed) {
+				last, lastUsed = i, u
+			}
+		}
+		for _, i := range candidates {
+			if i > last {
+				chosen = i
+				break
+			}
+		}
+	case KeyStrategyLRU:
+		for _, i := range candidates {
+			if usage[keyUsageID(tool, m.ModelName, keys[i].ApiKey)].LastUsed.Before(usage[keyUsageID(tool, m.ModelName, keys[chosen].ApiKey)].LastUsed) {
+				chosen = i
+			}
+		}
+	default:
+		return ProviderKey{}, fmt.Errorf("unknown key strategy %q", m.KeyStrategy)
+	}
+	id := keyUsageID(tool, m.ModelName, keys[chosen].ApiKey)
+	u := usage[id]
+	u.LastUsed = now
+	usage[id] = u
+	if err := saveKeyUsage(usage); err != nil {
+		return keys[chosen], fmt.Errorf("recording key usage: %w", err)
+	}
+	return keys[chosen], nil
+}
+
+// selectLaunchKey sets ApiKey of the tool's current provider to the key chosen for this
+// launch. Only the in-memory config of the launch is changed.
+func (a *App) selectLaunchKey(config *AppConfig, tool string) error {
+	toolCfg := config.toolConfig(tool)
+	if toolCfg == nil {
+		return nil
+	}
+	m := getProviderModel(toolCfg, toolCfg.CurrentModel)
+	if m == nil || len(m.Keys) == 0 {
+		return nil
+	}
+	key, err := chooseProviderKey(tool, m, time.Now())
+	if err != nil && key.ApiKey == "" {
+		return err
+	} else if err != nil {
+		a.log(err.Error())
+	}
+	m.ApiKey = key.ApiKey
+	a.log(fmt.Sprintf("Using key %q of %s (%s)", key.Label, m.ModelName, keyStrategyName(m.KeyStrategy)))
+	return nil
+}
+
+func keyStrategyName(strategy string) string {
+	if strategy == "" {
+		return KeyStrategyPinned
+	}
+	return strategy
+}
+
+func findProviderEntry(config *AppConfig, tool, provider string) (*ModelConfig, error) {
+	toolCfg := config.toolConfig(tool)
+	if toolCfg == nil {
+		return nil, fmt.Errorf("unknown tool: %s", tool)
+	}
+	m := getProviderModel(toolCfg, provider)
+	if m == nil {
+		return nil, fmt.Errorf("provider %q is not configured for %s", provider, tool)
+	}
+	return m, nil
+}
+
+// GetProviderKeyStatus lists the keys of a provider with when they were last used and rejected.
+func (a *App) GetProviderKeyStatus(tool, provider string) ([]ProviderKeyStatus, error) {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	m, err := findProviderEntry(&config, tool, provider)
+	if err != nil {
+		return nil, err
+	}
+	keyUsageMutex.Lock()
+	usage := loadKeyUsage()
+	keyUsageMutex.Unlock()
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	statuses := []ProviderKeyStatus{}
+	for _, k := range providerKeys(m) {
+		u := usage[keyUsageID(tool, m.ModelName, k.ApiKey)]
+		statuses = append(statuses, ProviderKeyStatus{Label: k.Label, LastUsed: formatTime(u.LastUsed), LastRejected: formatTime(u.LastRejected)})
+	}
+	return statuses, nil
+}
+
+// ReportKeyRejected records that the provider refused a key (e.g. 401 or 429), so the
+// next launches prefer the other keys for a while. label selects the key; empty means
+// the single ApiKey.
+func (a *App) ReportKeyRejected(tool, provider, label string) error {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return err
+	}
+	m, err := findProviderEntry(&config, tool, provider)
+	if err != nil {
+		return err
+	}
+	for _, k := range providerKeys(m) {
+		if k.Label == label {
+			return markKeyRejected(tool, m.ModelName, k.ApiKey, time.Now())
+		}
+	}
+	return errors.New("no key labelled " + label)
+}
+
+// markKeyRejected records that a provider refused one of its keys.
+func markKeyRejected(tool, provider, apiKey string, now time.Time) error {
+	keyUsageMutex.Lock()
+	defer keyUsageMutex.Unlock()
+	usage := loadKeyUsage()
+	id := keyUsageID(tool, provider, apiKey)
+	u := usage[id]
+	u.LastRejected = now
+	usage[id] = u
+	return saveKeyUsage(usage)
+}
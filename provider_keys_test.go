@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChooseProviderKeyPerEntry(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	keys := []ProviderKey{{Label: "a", ApiKey: "sk-a"}, {Label: "b", ApiKey: "sk-b"}, {Label: "c", ApiKey: "sk-c"}}
+	claude := ModelConfig{ModelName: "GLM", Keys: keys, KeyStrategy: KeyStrategyRoundRobin}
+	codex := ModelConfig{ModelName: "GLM", Keys: keys, KeyStrategy: KeyStrategyLRU}
+
+	now := time.Now()
+	var got []string
+	for i := 0; i < 4; i++ {
+		key, err := chooseProviderKey("claude", &claude, now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, key.Label)
+	}
+	if want := []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("claude round-robin = %v, want %v", got, want)
+	}
+	// The same keys under another tool have their own history
+	key, _ := chooseProviderKey("codex", &codex, now.Add(10*time.Second))
+	if key.Label != "a" {
+		t.Errorf("codex LRU picked %q, want the first key, unused by codex", key.Label)
+	}
+	if err := markKeyRejected("codex", "GLM", "sk-b", now.Add(11*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	key, _ = chooseProviderKey("codex", &codex, now.Add(12*time.Second))
+	if key.Label != "c" {
+		t.Errorf("codex LRU picked %q, want c while b is rejected", key.Label)
+	}
+	key, _ = chooseProviderKey("claude", &claude, now.Add(13*time.Second))
+	if key.Label != "b" {
+		t.Errorf("claude round-robin picked %q, want b, rejected only for codex", key.Label)
+	}
+}
+
+func TestGatewayRotatesKeysAndRecordsRejections(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		auths = append(auths, strings.TrimPrefix(auth, "Bearer "))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if auth == "Bearer sk-b" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"invalid api key"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer srv.Close()
+	t.Setenv("TEST_KEY_C", "sk-c")
+	gw := newTestGateway(t, AppConfig{Claude: ToolConfig{CurrentModel: "Relay", Models: []ModelConfig{{
+		ModelName: "Relay", ModelUrl: srv.URL, ModelId: "relay-large", WireApi: "chat", KeyStrategy: KeyStrategyRoundRobin,
+		Keys: []ProviderKey{{Label: "a", ApiKey: "sk-a"}, {Label: "b", ApiKey: "sk-b"}, {Label: "c", ApiKey: "env:TEST_KEY_C"}},
+	}}}})
+
+	for i := 0; i < 5; i++ {
+		if i == 3 {
+			// The resolved reference is cached, a changed variable is only seen later
+			t.Setenv("TEST_KEY_C", "sk-c-new")
+		}
+		serveGatewayRequest(gw, "/claude/Relay/-/v1/messages", failoverRequest)
+	}
+	if want := []string{"sk-a", "sk-b", "sk-c", "sk-a", "sk-c"}; !reflect.DeepEqual(auths, want) {
+		t.Errorf("upstream keys = %v, want %v with b skipped after its 401", auths, want)
+	}
+	statuses, err := gw.app.GetProviderKeyStatus("claude", "Relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if (s.LastRejected != "") != (s.Label == "b") || s.LastUsed == "" {
+			t.Errorf("key %s: last used %q, last rejected %q", s.Label, s.LastUsed, s.LastRejected)
+		}
+	}
+}
+
+func TestTestProviderRecordsRejectedKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"message":"key disabled"}}`))
+	}))
+	defer srv.Close()
+	gw := newTestGateway(t, AppConfig{Claude: ToolConfig{CurrentModel: "Relay", Models: []ModelConfig{{
+		ModelName: "Relay", ModelUrl: srv.URL, ModelId: "relay-large", ApiKey: "sk-a", Keys: []ProviderKey{{Label: "a", ApiKey: "sk-a"}, {Label: "b", ApiKey: "sk-b"}},
+	}}}})
+	result, err := gw.app.testProvider("claude", "Relay", "", false)
+	if err != nil || result.HttpCode != http.StatusForbidden {
+		t.Fatalf("testProvider = %+v, %v", result, err)
+	}
+	statuses, err := gw.app.GetProviderKeyStatus("claude", "Relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].LastRejected == "" || statuses[1].LastRejected != "" {
+		t.Errorf("statuses = %+v, want the tested first key rejected", statuses)
+	}
+}
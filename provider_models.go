@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListProviderModels asks a provider which models it offers, so ModelConfig.ModelId can
+// be picked from a list instead of typed. Lists are cached in ~/.cceasy/model_cache.json
+// for modelCacheTTL, indexed by endpoint and a hash of the key (keys of different plans
+// can see different models).
+
+const (
+	modelCacheTTL        = 6 * time.Hour
+	modelListTimeout     = 20 * time.Second
+	modelListMaxPages    = 10
+	modelListMaxResponse = 8 << 20
+)
+
+// ProviderModel is one model offered by a provider. Limits are 0 when the provider does
+// not report them.
+type ProviderModel struct {
+	Id              string `json:"id"`
+	DisplayName     string `json:"display_name,omitempty"`
+	ContextWindow   int    `json:"context_window,omitempty"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"`
+}
+
+// ProviderModelList is what ListProviderModels returns.
+type ProviderModelList struct {
+	Tool      string          `json:"tool"`
+	Provider  string          `json:"provider"`
+	Models    []ProviderModel `json:"models"`
+	FetchedAt string          `json:"fetched_at"` // RFC3339
+	Cached    bool            `json:"cached"`
+}
+
+type modelCacheEntry struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Models    []ProviderModel `json:"models"`
+}
+
+var modelCacheMutex sync.Mutex
+
+func getModelCachePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "model_cache.json")
+}
+
+func loadModelCache() map[string]modelCacheEntry {
+	cache := make(map[string]modelCacheEntry)
+	if data, err := os.ReadFile(getModelCachePath()); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+func saveModelCache(cache map[string]modelCacheEntry) error {
+	path := getModelCachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// ListProviderModels returns the models a provider offers, from the cache when it is
+// fresh enough.
+func (a *App) ListProviderModels(tool, provider string) (ProviderModelList, error) {
+	return a.listProviderModels(tool, provider, a.GetCurrentProjectPath(), false, false)
+}
+
+// RefreshProviderModels is ListProviderModels bypassing the cache.
+func (a *App) RefreshProviderModels(tool, provider string) (ProviderModelList, error) {
+	return a.listProviderModels(tool, provider, a.GetCurrentProjectPath(), false, true)
+}
+
+func (a *App) listProviderModels(tool, provider, projectDir string, useProxy, refresh bool) (ProviderModelList, error) {
+	config, err := a.LoadConfig()
+	if err != nil {
+		return ProviderModelList{}, err
+	}
+	m, key, err := providerForRequest(&config, tool, provider)
+	if err != nil {
+		return ProviderModelList{}, err
+	}
+	tool = strings.ToLower(tool)
+	ep := getProviderRegistry().Resolve(tool, m)
+	protocol := providerTestProtocol(tool, ep.WireApi)
+	cacheKey := modelCacheKey(protocol, ep.BaseUrl, key)
+	list := ProviderModelList{Tool: tool, Provider: m.ModelName}
+
+	modelCacheMutex.Lock()
+	cache := loadModelCache()
+	modelCacheMutex.Unlock()
+	if entry, ok := cache[cacheKey]; ok && !refresh && time.Since(entry.FetchedAt) < modelCacheTTL {
+		list.Models, list.FetchedAt, list.Cached = entry.Models, entry.FetchedAt.Format(time.RFC3339), true
+		return list, nil
+	}
+
+	client, _, err := config.providerClient(projectDir, useProxy, modelListTimeout)
+	if err != nil {
+		return ProviderModelList{}, err
+	}
+	models, err := fetchProviderModels(client, protocol, ep.BaseUrl, key)
+	if err != nil {
+		a.log(fmt.Sprintf("Listing the models of %s/%s failed: %v", tool, m.ModelName, err))
+		return ProviderModelList{}, err
+	}
+	now := time.Now()
+	modelCacheMutex.Lock()
+	cache = loadModelCache()
+	for k, entry := range cache {
+		if now.Sub(entry.FetchedAt) > modelCacheTTL {
+			delete(cache, k)
+		}
+	}
+	cache[cacheKey] = modelCacheEntry{FetchedAt: now, Models: models}
+	if err := saveModelCache(cache); err != nil {
+		a.log("Saving the model cache failed: " + err.Error())
+	}
+	modelCacheMutex.Unlock()
+	list.Models, list.FetchedAt = models, now.Format(time.RFC3339)
+	return list, nil
+}
+
+func modelCacheKey(protocol, base, apiKey string) string {
+	return protocol + " " + strings.TrimRight(base, "/") + " " + keyFingerprint(apiKey)
+}
+
+// cachedMaxOutputTokens returns the output limit of a model from the cache, 0 when the
+// models were never listed or the provider does not report it.
+func cachedMaxOutputTokens(protocol, base, apiKey, model string) int {
+	modelCacheMutex.Lock()
+	entry, ok := loadModelCache()[modelCacheKey(protocol, base, apiKey)]
+	modelCacheMutex.Unlock()
+	if !ok {
+		return 0
+	}
+	for _, m := range entry.Models {
+		if m.Id == model {
+			return m.MaxOutputTokens
+		}
+	}
+	return 0
+}
+
+// modelListURL returns the listing URL for a protocol (see providerTestProtocol).
+func modelListURL(protocol, base string) string {
+	base = strings.TrimRight(strings.TrimSpace(base), "/")
+	switch protocol {
+	case "anthropic":
+		return base + "/v1/models?limit=1000"
+	case "gemini":
+		if base == "" {
+			base = "https://generativelanguage.googleapis.com"
+		}
+		return base + "/v1beta/models?pageSize=1000"
+	}
+	// OpenAI-compatible base URLs already end in the version, e.g. /v1 or /api/paas/v4
+	return strings.TrimSuffix(base, "/chat/completions") + "/models"
+}
+
+// fetchProviderModels reads every page of a provider's model list, sorted by ID.
+func fetchProviderModels(client *http.Client, protocol, base, apiKey string) ([]ProviderModel, error) {
+	if strings.TrimSpace(base) == "" && protocol != "gemini" {
+		return nil, fmt.Errorf("the provider has no base URL")
+	}
+	listURL := modelListURL(protocol, base)
+	var models []ProviderModel
+	seen := make(map[string]bool)
+	pageURL := listURL
+	for page := 0; page < modelListMaxPages && pageURL != ""; page++ {
+		req, err := http.NewRequest(http.MethodGet, pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		switch protocol {
+		case "anthropic":
+			req.Header.Set("x-api-key", apiKey)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+			req.Header.Set("anthropic-version", "2023-06-01")
+		case "gemini":
+			req.Header.Set("x-goog-api-key", apiKey)
+		default:
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, modelListMaxResponse))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			if msg := providerErrorMessage(body); msg != "" {
+				return nil, fmt.Errorf("GET %s: HTTP %d: %s", req.URL.Path, resp.StatusCode, msg)
+			}
+			return nil, fmt.Errorf("GET %s: HTTP %d", req.URL.Path, resp.StatusCode)
+		}
+		var doc struct {
+			Data          []map[string]interface{} `json:"data"`   // OpenAI, Anthropic
+			Models        []map[string]interface{} `json:"models"` // Gemini
+			HasMore       bool                     `json:"has_more"`
+			LastId        string                   `json:"last_id"`
+			NextPageToken string                   `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("GET %s: the response is not a model list", req.URL.Path)
+		}
+		for _, raw := range append(doc.Data, doc.Models...) {
+			model, ok := parseProviderModel(protocol, raw)
+			if ok && !seen[model.Id] {
+				seen[model.Id] = true
+				models = append(models, model)
+			}
+		}
+		pageURL = ""
+		switch {
+		case protocol == "anthropic" && doc.HasMore && doc.LastId != "":
+			pageURL = listURL + "&after_id=" + url.QueryEscape(doc.LastId)
+		case protocol == "gemini" && doc.NextPageToken != "":
+			pageURL = listURL + "&pageToken=" + url.QueryEscape(doc.NextPageToken)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Id < models[j].Id })
+	return models, nil
+}
+
+// parseProviderModel reads one entry of a model list. Providers report limits under
+// many names; the first one present is used.
+func parseProviderModel(protocol string, raw map[string]interface{}) (ProviderModel, bool) {
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := raw[k].(string); ok && v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	num := func(keys ...string) int {
+		for _, k := range keys {
+			path := strings.Split(k, ".")
+			var v interface{} = raw
+			for _, p := range path {
+				obj, ok := v.(map[string]interface{})
+				if !ok {
+					v = nil
+					break
+				}
+				v = obj[p]
+			}
+			if n, ok := v.(float64); ok && n > 0 {
+				return int(n)
+			}
+		}
+		return 0
+	}
+	if protocol == "gemini" {
+		// Only models that can chat; embedding models and the like are left out
+		methods, _ := raw["supportedGenerationMethods"].([]interface{})
+		canGenerate := len(methods) == 0
+		for _, m := range methods {
+			canGenerate = canGenerate || m == "generateContent"
+		}
+		id := strings.TrimPrefix(str("name"), "models/")
+		return ProviderModel{
+			Id:              id,
+			DisplayName:     str("displayName"),
+			ContextWindow:   num("inputTokenLimit"),
+			MaxOutputTokens: num("outputTokenLimit"),
+		}, id != "" && canGenerate
+	}
+	id := str("id")
+	return ProviderModel{
+		Id:              id,
+		DisplayName:     str("display_name", "name"),
+		ContextWindow:   num("context_window", "context_length", "max_context_length", "max_input_tokens", "input_token_limit", "top_provider.context_length"),
+		MaxOutputTokens: num("max_output_tokens", "max_completion_tokens", "output_token_limit", "max_tokens", "top_provider.max_completion_tokens"),
+	}, id != ""
+}
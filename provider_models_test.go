@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestListProviderModelsCache(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Get("x-api-key")+" "+r.URL.RequestURI())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("anthropic-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("after_id") == "" {
+			w.Write([]byte(`{"data":[{"id":"relay-small","display_name":"Small"},{"id":"relay-large","max_input_tokens":200000,"max_tokens":64000}],"has_more":true,"last_id":"relay-large"}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"relay-large"},{"id":"relay-medium","context_window":128000}],"has_more":false}`))
+	}))
+	defer srv.Close()
+	gw := newTestGateway(t, AppConfig{Claude: ToolConfig{CurrentModel: "Relay", Models: []ModelConfig{
+		{ModelName: "Relay", ModelUrl: srv.URL, ModelId: "relay-large", ApiKey: "sk-a"},
+	}}})
+	a, dir := gw.app, t.TempDir()
+	take := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := requests
+		requests = nil
+		return r
+	}
+
+	list, err := a.listProviderModels("claude", "Relay", dir, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProviderModel{
+		{Id: "relay-large", ContextWindow: 200000, MaxOutputTokens: 64000},
+		{Id: "relay-medium", ContextWindow: 128000},
+		{Id: "relay-small", DisplayName: "Small"},
+	}
+	if !reflect.DeepEqual(list.Models, want) || list.Cached {
+		t.Errorf("models = %+v (cached %v), want %+v", list.Models, list.Cached, want)
+	}
+	if got, want := take(), []string{"sk-a /v1/models?limit=1000", "sk-a /v1/models?limit=1000&after_id=relay-large"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+	if got := cachedMaxOutputTokens("anthropic", srv.URL+"/", "sk-a", "relay-large"); got != 64000 {
+		t.Errorf("cached output limit = %d, want 64000", got)
+	}
+
+	// From the cache until a refresh, and per key
+	list, err = a.listProviderModels("claude", "Relay", dir, false, false)
+	if err != nil || !list.Cached || len(take()) != 0 {
+		t.Errorf("second listing: cached %v, err %v, want no request", list.Cached, err)
+	}
+	if list, err = a.listProviderModels("claude", "Relay", dir, false, true); err != nil || list.Cached || len(take()) != 2 {
+		t.Errorf("refresh: cached %v, err %v, want a new listing", list.Cached, err)
+	}
+	err = a.UpdateConfig(func(c *AppConfig) error {
+		c.Claude.Models[getProviderIndex(&c.Claude, "Relay")].Keys = []ProviderKey{{Label: "b", ApiKey: "sk-b"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, err = a.listProviderModels("claude", "Relay", dir, false, false); err != nil || list.Cached || len(take()) != 2 {
+		t.Errorf("another key: cached %v, err %v, want a new listing", list.Cached, err)
+	}
+}
+
+func getProviderIndex(toolCfg *ToolConfig, name string) int {
+	for i, m := range toolCfg.Models {
+		if m.ModelName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestFetchProviderModelsProtocols(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v1/models" && r.Header.Get("Authorization") == "Bearer sk-o":
+			w.Write([]byte(`{"object":"list","data":[{"id":"b-model","top_provider":{"context_length":32768,"max_completion_tokens":4096}},{"id":"a-model","name":"A"}]}`))
+		case r.URL.Path == "/v1beta/models" && r.Header.Get("x-goog-api-key") == "sk-g" && r.URL.Query().Get("pageToken") == "":
+			w.Write([]byte(`{"models":[{"name":"models/gemini-pro","displayName":"Pro","inputTokenLimit":1048576,"outputTokenLimit":65536,"supportedGenerationMethods":["generateContent"]},{"name":"models/embedding","supportedGenerationMethods":["embedContent"]}],"nextPageToken":"p2"}`))
+		case r.URL.Path == "/v1beta/models" && r.URL.Query().Get("pageToken") == "p2":
+			w.Write([]byte(`{"models":[{"name":"models/gemini-flash"}]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"bad key"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	models, err := fetchProviderModels(srv.Client(), "chat", srv.URL+"/v1/chat/completions", "sk-o")
+	if want := []ProviderModel{{Id: "a-model", DisplayName: "A"}, {Id: "b-model", ContextWindow: 32768, MaxOutputTokens: 4096}}; err != nil || !reflect.DeepEqual(models, want) {
+		t.Errorf("OpenAI models = %+v, %v, want %+v", models, err, want)
+	}
+	models, err = fetchProviderModels(srv.Client(), "gemini", srv.URL, "sk-g")
+	if want := []ProviderModel{{Id: "gemini-flash"}, {Id: "gemini-pro", DisplayName: "Pro", ContextWindow: 1048576, MaxOutputTokens: 65536}}; err != nil || !reflect.DeepEqual(models, want) {
+		t.Errorf("Gemini models = %+v, %v, want %+v", models, err, want)
+	}
+	if _, err := fetchProviderModels(srv.Client(), "chat", srv.URL+"/v1", "sk-wrong"); err == nil || err.Error() != "GET /v1/models: HTTP 401: bad key" {
+		t.Errorf("wrong key: %v", err)
+	}
+}
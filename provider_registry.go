@@ -0,0 +1,297 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// providers.json is the single source of truth for built-in provider defaults.
+// Users can extend or override it with ~/.cceasy/providers.json.
+//
+//go:embed providers.json
+var embeddedProviderRegistry []byte
+
+// ProviderEndpoint describes how a provider is wired into one tool.
+// Empty fields inherit from the provider defaults, then from the tool defaults.
+type ProviderEndpoint struct {
+	BaseUrl         string                 `json:"base_url,omitempty"`
+	ModelId         string                 `json:"model_id,omitempty"`
+	WireApi         string                 `json:"wire_api,omitempty"`
+	Env             map[string]string      `json:"env,omitempty"`              // Extra env vars, "{model_id}" and "{provider}" are expanded
+	Settings        map[string]interface{} `json:"settings,omitempty"`         // Extra top-level keys for the tool's settings file
+	ProviderOptions map[string]interface{} `json:"provider_options,omitempty"` // Extra keys for the provider block (e.g. Codex [model_providers.x])
+}
+
+type ProviderDefinition struct {
+	Name     string                      `json:"name"`
+	Aliases  []string                    `json:"aliases,omitempty"`
+	Disabled bool                        `json:"disabled,omitempty"`
+	Defaults ProviderEndpoint            `json:"defaults"`
+	Tools    map[string]ProviderEndpoint `json:"tools"`
+}
+
+type ToolDefaults struct {
+	DefaultProvider string           `json:"default_provider,omitempty"`
+	NoCustom        bool             `json:"no_custom,omitempty"`
+	Common          ProviderEndpoint `json:"common"`   // Applied to every provider of the tool
+	Fallback        ProviderEndpoint `json:"fallback"` // Used for custom providers and names not in the registry
+}
+
+type ProviderRegistry struct {
+	Version   int                     `json:"version"`
+	Tools     map[string]ToolDefaults `json:"tools"`
+	Providers []ProviderDefinition    `json:"providers"`
+}
+
+var (
+	registryMutex       sync.Mutex
+	registryCache       *ProviderRegistry
+	registryOverrideMod time.Time
+)
+
+func getProviderRegistryOverridePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "providers.json")
+}
+
+// getProviderRegistry returns the embedded registry merged with the user override file.
+// The result is cached and reloaded when the override file changes.
+func getProviderRegistry() *ProviderRegistry {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	overridePath := getProviderRegistryOverridePath()
+	var modTime time.Time
+	if info, err := os.Stat(overridePath); err == nil {
+		modTime = info.ModTime()
+	}
+	if registryCache != nil && modTime.Equal(registryOverrideMod) {
+		return registryCache
+	}
+	registry, err := parseProviderRegistry(embeddedProviderRegistry)
+	if err != nil {
+		// TestEmbeddedProviderRegistry checks the embedded file, this should never happen
+		panic(fmt.Sprintf("invalid embedded provider registry: %v", err))
+	}
+	if !modTime.IsZero() {
+		if data, err := os.ReadFile(overridePath); err == nil {
+			if override, err := parseProviderRegistry(data); err == nil {
+				registry.merge(override)
+			} else {
+				fmt.Fprintf(os.Stderr, "Ignoring invalid provider registry %s: %v\n", overridePath, err)
+			}
+		}
+	}
+	registryCache = registry
+	registryOverrideMod = modTime
+	return registry
+}
+
+func parseProviderRegistry(data []byte) (*ProviderRegistry, error) {
+	var registry ProviderRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, err
+	}
+	if registry.Tools == nil {
+		registry.Tools = make(map[string]ToolDefaults)
+	}
+	return &registry, nil
+}
+
+// merge overlays another registry on top of this one. Providers are matched by name (case-insensitive).
+func (r *ProviderRegistry) merge(other *ProviderRegistry) {
+	if other.Version > r.Version {
+		r.Version = other.Version
+	}
+	for tool, td := range other.Tools {
+		base := r.Tools[tool]
+		if td.DefaultProvider != "" {
+			base.DefaultProvider = td.DefaultProvider
+		}
+		base.NoCustom = base.NoCustom || td.NoCustom
+		base.Common = base.Common.overlay(td.Common)
+		base.Fallback = base.Fallback.overlay(td.Fallback)
+		r.Tools[tool] = base
+	}
+	for _, p := range other.Providers {
+		idx := -1
+		for i := range r.Providers {
+			if strings.EqualFold(r.Providers[i].Name, p.Name) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			r.Providers = append(r.Providers, p)
+			continue
+		}
+		existing := &r.Providers[idx]
+		existing.Disabled = p.Disabled
+		if len(p.Aliases) > 0 {
+			existing.Aliases = p.Aliases
+		}
+		existing.Defaults = existing.Defaults.overlay(p.Defaults)
+		if existing.Tools == nil {
+			existing.Tools = make(map[string]ProviderEndpoint)
+		}
+		for tool, ep := range p.Tools {
+			existing.Tools[tool] = existing.Tools[tool].overlay(ep)
+		}
+	}
+}
+
+// overlay returns a copy of e with the non-empty fields of o applied on top.
+func (e ProviderEndpoint) overlay(o ProviderEndpoint) ProviderEndpoint {
+	result := ProviderEndpoint{
+		BaseUrl: e.BaseUrl,
+		ModelId: e.ModelId,
+		WireApi: e.WireApi,
+	}
+	if o.BaseUrl != "" {
+		result.BaseUrl = o.BaseUrl
+	}
+	if o.ModelId != "" {
+		result.ModelId = o.ModelId
+	}
+	if o.WireApi != "" {
+		result.WireApi = o.WireApi
+	}
+	if len(e.Env) > 0 || len(o.Env) > 0 {
+		result.Env = make(map[string]string)
+		for k, v := range e.Env {
+			result.Env[k] = v
+		}
+		for k, v := range o.Env {
+			result.Env[k] = v
+		}
+	}
+	if len(e.Settings) > 0 || len(o.Settings) > 0 {
+		result.Settings = make(map[string]interface{})
+		for k, v := range e.Settings {
+			result.Settings[k] = v
+		}
+		for k, v := range o.Settings {
+			result.Settings[k] = v
+		}
+	}
+	if len(e.ProviderOptions) > 0 || len(o.ProviderOptions) > 0 {
+		result.ProviderOptions = make(map[string]interface{})
+		for k, v := range e.ProviderOptions {
+			result.ProviderOptions[k] = v
+		}
+		for k, v := range o.ProviderOptions {
+			result.ProviderOptions[k] = v
+		}
+	}
+	return result
+}
+
+// findProvider returns the registry entry matching a provider name or one of its aliases.
+func (r *ProviderRegistry) findProvider(name string) *ProviderDefinition {
+	for i := range r.Providers {
+		p := &r.Providers[i]
+		if p.Disabled {
+			continue
+		}
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+		for _, alias := range p.Aliases {
+			if strings.EqualFold(alias, name) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// Lookup returns the registry endpoint of a provider for a tool, without tool-level defaults applied.
+func (r *ProviderRegistry) Lookup(tool, providerName string) (ProviderEndpoint, bool) {
+	p := r.findProvider(providerName)
+	if p == nil {
+		return ProviderEndpoint{}, false
+	}
+	ep, ok := p.Tools[strings.ToLower(tool)]
+	if !ok {
+		return ProviderEndpoint{}, false
+	}
+	return p.Defaults.overlay(ep), true
+}
+
+// Resolve computes the effective endpoint for a configured provider: tool common settings,
+// then the registry entry (or the tool fallback for custom/unknown providers), then the
+// user's own URL, model ID and wire API from the ModelConfig.
+func (r *ProviderRegistry) Resolve(tool string, m *ModelConfig) ProviderEndpoint {
+	tool = strings.ToLower(tool)
+	td := r.Tools[tool]
+	ep := td.Common
+	if entry, ok := r.Lookup(tool, m.ModelName); ok && !m.IsCustom {
+		ep = ep.overlay(entry)
+	} else {
+		ep = ep.overlay(td.Fallback)
+	}
+	ep = ep.overlay(ProviderEndpoint{BaseUrl: m.ModelUrl, ModelId: m.ModelId, WireApi: m.WireApi})
+	vendor := strings.ToLower(m.ModelName)
+	ep.ModelId = strings.ReplaceAll(ep.ModelId, "{provider}", vendor)
+	for k, v := range ep.Env {
+		v = strings.ReplaceAll(v, "{model_id}", ep.ModelId)
+		ep.Env[k] = strings.ReplaceAll(v, "{provider}", vendor)
+	}
+	return ep
+}
+
+// DefaultModels builds the provider list offered for a tool on a fresh config.
+func (r *ProviderRegistry) DefaultModels(tool string) []ModelConfig {
+	tool = strings.ToLower(tool)
+	models := []ModelConfig{{ModelName: "Original"}}
+	for _, p := range r.Providers {
+		if p.Disabled {
+			continue
+		}
+		ep, ok := r.Lookup(tool, p.Name)
+		if !ok {
+			continue
+		}
+		models = append(models, ModelConfig{ModelName: p.Name, ModelId: ep.ModelId, ModelUrl: ep.BaseUrl, WireApi: ep.WireApi})
+	}
+	if !r.Tools[tool].NoCustom {
+		models = append(models, ModelConfig{ModelName: "Custom", IsCustom: true})
+	}
+	return models
+}
+
+// ProvidersForTool returns the names of the registry providers available for a tool.
+func (r *ProviderRegistry) ProvidersForTool(tool string) []string {
+	var names []string
+	for _, p := range r.Providers {
+		if _, ok := r.Lookup(tool, p.Name); ok {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+func (r *ProviderRegistry) DefaultProvider(tool string) string {
+	if name := r.Tools[strings.ToLower(tool)].DefaultProvider; name != "" {
+		return name
+	}
+	return "Original"
+}
+
+var providerKeyPattern = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// providerKey turns a provider display name into an identifier usable as a config table/object key.
+func providerKey(name string) string {
+	key := providerKeyPattern.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-")
+	key = strings.Trim(key, "-")
+	if key == "" {
+		return "custom"
+	}
+	return key
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedProviderRegistry(t *testing.T) {
+	registry, err := parseProviderRegistry(embeddedProviderRegistry)
+	if err != nil {
+		t.Fatalf("providers.json does not parse: %v", err)
+	}
+	known := make(map[string]bool)
+	for _, tool := range supportedTools {
+		known[tool] = true
+	}
+	for tool := range registry.Tools {
+		if !known[tool] {
+			t.Errorf("tools.%s is not a supported tool", tool)
+		}
+	}
+	names := make(map[string]string)
+	for _, p := range registry.Providers {
+		for _, name := range append([]string{p.Name}, p.Aliases...) {
+			if other, ok := names[strings.ToLower(name)]; ok {
+				t.Errorf("%s: name %q is also used by %s", p.Name, name, other)
+			}
+			names[strings.ToLower(name)] = p.Name
+		}
+		if p.Disabled {
+			continue
+		}
+		if len(p.Tools) == 0 {
+			t.Errorf("%s: lists no tools", p.Name)
+		}
+		for tool := range p.Tools {
+			if !known[tool] {
+				t.Errorf("%s: tools.%s is not a supported tool", p.Name, tool)
+				continue
+			}
+			if _, ok := registry.Lookup(tool, p.Name); !ok {
+				t.Errorf("%s: Lookup fails for %s", p.Name, tool)
+			}
+			ep := registry.Resolve(tool, &ModelConfig{ModelName: p.Name})
+			if u, err := url.Parse(ep.BaseUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				t.Errorf("%s: base URL %q for %s is not an http(s) URL", p.Name, ep.BaseUrl, tool)
+			}
+			if strings.TrimSpace(ep.ModelId) == "" || strings.Contains(ep.ModelId, "{") {
+				t.Errorf("%s: model ID %q for %s", p.Name, ep.ModelId, tool)
+			}
+			for k, v := range ep.Env {
+				if strings.Contains(v, "{model_id}") || strings.Contains(v, "{provider}") {
+					t.Errorf("%s: env %s=%q for %s is not expanded", p.Name, k, v, tool)
+				}
+			}
+		}
+	}
+	for _, tool := range supportedTools {
+		def := registry.DefaultProvider(tool)
+		if def == "Original" {
+			continue
+		}
+		if _, ok := registry.Lookup(tool, def); !ok {
+			t.Errorf("default provider %q of %s is not offered for %s", def, tool, tool)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// API keys and proxy passwords are kept out of ~/.aicoder_config.json when a secret
+// backend is available. The config then holds references such as
+// "aicoder-secret:claude/GLM/api_key" and the values live in the desktop keyring
+// (Secret Service on Linux) or in a passphrase-encrypted file. LoadConfig resolves the
+// references and SaveConfig turns plaintext values back into references.
+
+const secretRefPrefix = "aicoder-secret:"
+
+// Values of AppConfig.SecretBackend
+const (
+	SecretBackendAuto      = "auto"      // Keyring if reachable, otherwise the encrypted file once unlocked
+	SecretBackendKeyring   = "keyring"   // Linux Secret Service (GNOME Keyring, KWallet, KeePassXC...)
+	SecretBackendFile      = "file"      // ~/.cceasy/secrets.enc, AES-GCM with a PBKDF2 derived key
+	SecretBackendPlaintext = "plaintext" // Legacy behaviour, values stay in the config file
+)
+
+// secretPassphraseEnv unlocks the encrypted file store without a UI prompt (CLI, SSH sessions).
+const secretPassphraseEnv = "AICODER_SECRET_PASSPHRASE"
+
+type SecretStore interface {
+	Name() string
+	Get(id string) (string, bool, error)
+	Set(id, value string) error
+	Delete(id string) error
+}
+
+type SecretStoreStatus struct {
+	Backend   string `json:"backend"`   // Configured backend
+	Active    string `json:"active"`    // Backend actually in use, "plaintext" if none
+	Locked    bool   `json:"locked"`    // The encrypted file needs a passphrase
+	Available bool   `json:"available"` // The keyring is reachable
+	Error     string `json:"error"`
+}
+
+var (
+	secretMutex      sync.Mutex
+	secretPassphrase string
+	keyringStore     SecretStore
+	keyringErr       error
+	keyringProbed    bool
+	fileStore        *fileSecretStore
+)
+
+type secretField struct {
+	id    string
+	value *string
+}
+
+// secretFields lists every secret in the config together with its stable store ID.
+func secretFields(c *AppConfig) []secretField {
+	var fields []secretField
+	for _, tool := range supportedTools {
+		toolCfg := c.toolConfig(tool)
+		for i := range toolCfg.Models {
+			m := &toolCfg.Models[i]
+			fields = append(fields, secretField{tool + "/" + m.ModelName + "/api_key", &m.ApiKey})
+			for j := range m.Keys {
+				fields = append(fields, secretField{tool + "/" + m.ModelName + "/keys/" + m.Keys[j].Label + "/api_key", &m.Keys[j].ApiKey})
+			}
+		}
+	}
+	for i := range c.Projects {
+		p := &c.Projects[i]
+		fields = append(fields, secretField{"project/" + p.Id + "/proxy_password", &p.ProxyPassword})
+	}
+	fields = append(fields, secretField{"default_proxy_password", &c.DefaultProxyPassword})
+	for i := range c.Profiles {
+		p := &c.Profiles[i]
+		for j := range p.Tools {
+			for k := range p.Tools[j].Keys {
+				key := &p.Tools[j].Keys[k]
+				fields = append(fields, secretField{"profile/" + p.Name + "/" + p.Tools[j].Tool + "/" + key.Provider + "/api_key", &key.ApiKey})
+				for l := range key.Keys {
+					fields = append(fields, secretField{"profile/" + p.Name + "/" + p.Tools[j].Tool + "/" + key.Provider + "/keys/" + key.Keys[l].Label + "/api_key", &key.Keys[l].ApiKey})
+				}
+			}
+		}
+		fields = append(fields, secretField{"profile/" + p.Name + "/default_proxy_password", &p.DefaultProxyPassword})
+	}
+	return fields
+}
+
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefPrefix)
+}
+
+// cloneConfig returns a deep copy of a config.
+func cloneConfig(c AppConfig) AppConfig {
+	var clone AppConfig
+	data, _ := json.Marshal(c)
+	json.Unmarshal(data, &clone)
+	return clone
+}
+
+// openSecretStore returns the store for a backend setting, or nil when secrets stay in plaintext.
+func openSecretStore(backend string) (SecretStore, error) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	switch backend {
+	case SecretBackendPlaintext:
+		return nil, nil
+	case SecretBackendKeyring:
+		return openKeyringLocked()
+	case SecretBackendFile:
+		return openFileStoreLocked()
+	case "", SecretBackendAuto:
+		if store, err := openKeyringLocked(); err == nil {
+			return store, nil
+		}
+		if store, err := openFileStoreLocked(); err == nil {
+			return store, nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown secret backend: %s", backend)
+}
+
+func openKeyringLocked() (SecretStore, error) {
+	if !keyringProbed {
+		keyringStore, keyringErr = newKeyringSecretStore()
+		keyringProbed = true
+	}
+	return keyringStore, keyringErr
+}
+
+func openFileStoreLocked() (SecretStore, error) {
+	if fileStore != nil {
+		return fileStore, nil
+	}
+	passphrase := secretPassphrase
+	if passphrase == "" {
+		passphrase = os.Getenv(secretPassphraseEnv)
+	}
+	if passphrase == "" {
+		return nil, errSecretStoreLocked
+	}
+	store := &fileSecretStore{path: getSecretFilePath(), passphrase: passphrase}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	fileStore = store
+	return store, nil
+}
+
+var errSecretStoreLocked = errors.New("the encrypted secret file is locked, a passphrase is required")
+
+// checkSecretResolved fails for a key that is still a secret reference because the store
+// could not be opened, so the reference never reaches a tool or a provider.
+func checkSecretResolved(provider, key string) error {
+	if isSecretRef(key) {
+		return fmt.Errorf("cannot use the API key of %s: %w", provider, errSecretStoreLocked)
+	}
+	return nil
+}
+
+// resolveSecrets replaces secret references with their values. References that cannot be
+// resolved are left in place so that a later save does not wipe the stored secret.
+func (a *App) resolveSecrets(config *AppConfig) {
+	var store SecretStore
+	var storeErr error
+	opened := false
+	for _, f := range secretFields(config) {
+		if !isSecretRef(*f.value) {
+			continue
+		}
+		if !opened {
+			store, storeErr = openSecretStore(config.SecretBackend)
+			opened = true
+			if storeErr == nil && store == nil {
+				storeErr = errSecretStoreLocked
+			}
+			if storeErr != nil {
+				a.log("Cannot resolve stored secrets: " + storeErr.Error())
+			}
+		}
+		if storeErr != nil {
+			return
+		}
+		id := strings.TrimPrefix(*f.value, secretRefPrefix)
+		value, ok, err := store.Get(id)
+		if err != nil || !ok {
+			a.log(fmt.Sprintf("Secret %s could not be read from %s", id, store.Name()))
+			continue
+		}
+		*f.value = value
+	}
+}
+
+// protectSecrets returns a copy of config with plaintext secrets moved into the secret
+// store. Secrets referenced in previousRefs but no longer used are deleted.
+func (a *App) protectSecrets(config AppConfig, previousRefs []string) (AppConfig, error) {
+	store, err := openSecretStore(config.SecretBackend)
+	if err != nil || store == nil {
+		if err != nil && config.SecretBackend != "" && config.SecretBackend != SecretBackendAuto {
+			return config, fmt.Errorf("secret backend %s is not available: %w", config.SecretBackend, err)
+		}
+		return config, nil
+	}
+	stored := cloneConfig(config)
+	used := make(map[string]bool)
+	for _, f := range secretFields(&stored) {
+		value := *f.value
+		if isSecretRef(value) {
+			used[strings.TrimPrefix(value, secretRefPrefix)] = true
+			continue
+		}
+		if value == "" || isKeyRef(value) {
+			continue // References are not secrets, see key_refs.go
+		}
+		if current, ok, err := store.Get(f.id); err != nil || !ok || current != value {
+			if err := store.Set(f.id, value); err != nil {
+				if config.SecretBackend == "" || config.SecretBackend == SecretBackendAuto {
+					a.log(fmt.Sprintf("Failed to store secret %s in %s, keeping it in the config file: %v", f.id, store.Name(), err))
+					continue
+				}
+				return config, err
+			}
+		}
+		used[f.id] = true
+		*f.value = secretRefPrefix + f.id
+	}
+	for _, ref := range previousRefs {
+		if id := strings.TrimPrefix(ref, secretRefPrefix); !used[id] {
+			store.Delete(id)
+		}
+	}
+	return stored, nil
+}
+
+// secretRefs collects the secret references currently stored in a config.
+func secretRefs(config *AppConfig) []string {
+	var refs []string
+	for _, f := range secretFields(config) {
+		if isSecretRef(*f.value) {
+			refs = append(refs, *f.value)
+		}
+	}
+	return refs
+}
+
+// hasPlaintextSecrets reports whether a config read from disk still holds secret values.
+// env:, file: and cmd: references are not secrets and stay in the file.
+func hasPlaintextSecrets(config *AppConfig) bool {
+	for _, f := range secretFields(config) {
+		if *f.value != "" && !isSecretRef(*f.value) && !isKeyRef(*f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnlockSecretStore sets the passphrase of the encrypted secret file for this session.
+// A new file is created on first use.
+func (a *App) UnlockSecretStore(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	store := &fileSecretStore{path: getSecretFilePath(), passphrase: passphrase}
+	if err := store.load(); err != nil {
+		return err
+	}
+	secretMutex.Lock()
+	secretPassphrase = passphrase
+	fileStore = store
+	secretMutex.Unlock()
+	a.emitEvent("config-changed")
+	return nil
+}
+
+// GetSecretStoreStatus reports which secret backend is in use.
+func (a *App) GetSecretStoreStatus() SecretStoreStatus {
+	config, _ := a.LoadConfig()
+	status := SecretStoreStatus{Backend: config.SecretBackend, Active: SecretBackendPlaintext}
+	if status.Backend == "" {
+		status.Backend = SecretBackendAuto
+	}
+	secretMutex.Lock()
+	_, kErr := openKeyringLocked()
+	secretMutex.Unlock()
+	status.Available = kErr == nil
+	store, err := openSecretStore(config.SecretBackend)
+	if store != nil {
+		status.Active = store.Name()
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	status.Locked = (status.Backend == SecretBackendFile || (status.Backend == SecretBackendAuto && !status.Available)) && store == nil
+	return status
+}
+
+func getSecretFilePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "secrets.enc")
+}
+
+const (
+	secretFileIterations = 600000
+	secretFileAAD        = "aicoder-secrets-v1"
+)
+
+type encryptedSecretFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// fileSecretStore keeps secrets in a single AES-256-GCM encrypted JSON file.
+type fileSecretStore struct {
+	mu         sync.Mutex
+	path       string
+	passphrase string
+	salt       []byte
+	iterations int
+	aead       cipher.AEAD
+	secrets    map[string]string
+}
+
+func (s *fileSecretStore) Name() string { return SecretBackendFile }
+
+func (s *fileSecretStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		s.salt = make([]byte, 16)
+		if _, err := rand.Read(s.salt); err != nil {
+			return err
+		}
+		s.iterations = secretFileIterations
+		s.secrets = make(map[string]string)
+		return s.initCipher()
+	} else if err != nil {
+		return err
+	}
+	var file encryptedSecretFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid secret file %s: %w", s.path, err)
+	}
+	if file.Version != 1 || file.KDF != "pbkdf2-sha256" {
+		return fmt.Errorf("unsupported secret file format in %s", s.path)
+	}
+	s.salt = file.Salt
+	s.iterations = file.Iterations
+	if err := s.initCipher(); err != nil {
+		return err
+	}
+	plain, err := s.aead.Open(nil, file.Nonce, file.Data, []byte(secretFileAAD))
+	if err != nil {
+		return errors.New("wrong passphrase for the secret file")
+	}
+	return json.Unmarshal(plain, &s.secrets)
+}
+
+func (s *fileSecretStore) initCipher() error {
+	key, err := pbkdf2.Key(sha256.New, s.passphrase, s.salt, s.iterations, 32)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	s.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+func (s *fileSecretStore) save() error {
+	plain, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(encryptedSecretFile{
+		Version:    1,
+		KDF:        "pbkdf2-sha256",
+		Iterations: s.iterations,
+		Salt:       s.salt,
+		Nonce:      nonce,
+		Data:       s.aead.Seal(nil, nonce, plain, []byte(secretFileAAD)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileSecretStore) Get(id string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.secrets[id]
+	return value, ok, nil
+}
+
+func (s *fileSecretStore) Set(id, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[id] = value
+	return s.save()
+}
+
+func (s *fileSecretStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[id]; !ok {
+		return nil
+	}
+	delete(s.secrets, id)
+	return s.save()
+}
@@ -0,0 +1,187 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Minimal client for the freedesktop Secret Service API, implemented by GNOME Keyring,
+// KWallet and KeePassXC. Items are tagged with {application: aicoder, id: <secret id>}.
+
+const (
+	secretServiceDest       = "org.freedesktop.secrets"
+	secretServicePath       = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretServiceCollection = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	secretServicePromptWait = 2 * time.Minute
+)
+
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type keyringSecretStore struct {
+	mu      sync.Mutex
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+	cache   map[string]string
+}
+
+func newKeyringSecretStore() (SecretStore, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("no D-Bus session bus: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(secretServiceDest, secretServicePath).CallWithContext(ctx, "org.freedesktop.Secret.Service.OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("secret service not available: %w", err)
+	}
+	return &keyringSecretStore{conn: conn, session: session, cache: make(map[string]string)}, nil
+}
+
+func (s *keyringSecretStore) Name() string { return SecretBackendKeyring }
+
+func (s *keyringSecretStore) service() dbus.BusObject {
+	return s.conn.Object(secretServiceDest, secretServicePath)
+}
+
+func secretAttributes(id string) map[string]string {
+	return map[string]string{"application": "aicoder", "id": id}
+}
+
+func (s *keyringSecretStore) search(id string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := s.service().Call("org.freedesktop.Secret.Service.SearchItems", 0, secretAttributes(id)).Store(&unlocked, &locked); err != nil {
+		return nil, err
+	}
+	if len(locked) > 0 {
+		if err := s.unlock(locked); err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, locked...)
+	}
+	return unlocked, nil
+}
+
+func (s *keyringSecretStore) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := s.service().Call("org.freedesktop.Secret.Service.Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return err
+	}
+	return s.prompt(prompt)
+}
+
+// prompt shows a keyring prompt (e.g. the unlock dialog) and waits for the user.
+func (s *keyringSecretStore) prompt(path dbus.ObjectPath) error {
+	if path == "" || path == "/" {
+		return nil
+	}
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.Secret.Prompt"),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 4)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+	if err := s.conn.Object(secretServiceDest, path).Call("org.freedesktop.Secret.Prompt.Prompt", 0, "").Err; err != nil {
+		return err
+	}
+	timeout := time.After(secretServicePromptWait)
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Path != path || sig.Name != "org.freedesktop.Secret.Prompt.Completed" {
+				continue
+			}
+			if len(sig.Body) > 0 {
+				if dismissed, ok := sig.Body[0].(bool); ok && dismissed {
+					return errors.New("keyring prompt was dismissed")
+				}
+			}
+			return nil
+		case <-timeout:
+			return errors.New("timed out waiting for the keyring prompt")
+		}
+	}
+}
+
+func (s *keyringSecretStore) Get(id string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.cache[id]; ok {
+		return value, true, nil
+	}
+	items, err := s.search(id)
+	if err != nil || len(items) == 0 {
+		return "", false, err
+	}
+	var secret secretServiceSecret
+	if err := s.conn.Object(secretServiceDest, items[0]).Call("org.freedesktop.Secret.Item.GetSecret", 0, s.session).Store(&secret); err != nil {
+		return "", false, err
+	}
+	s.cache[id] = string(secret.Value)
+	return string(secret.Value), true, nil
+}
+
+func (s *keyringSecretStore) Set(id, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.unlock([]dbus.ObjectPath{secretServiceCollection}); err != nil {
+		return err
+	}
+	props := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Item.Label":      dbus.MakeVariant("AICoder: " + id),
+		"org.freedesktop.Secret.Item.Attributes": dbus.MakeVariant(secretAttributes(id)),
+	}
+	secret := secretServiceSecret{Session: s.session, Value: []byte(value), ContentType: "text/plain; charset=utf8"}
+	var item, prompt dbus.ObjectPath
+	err := s.conn.Object(secretServiceDest, secretServiceCollection).Call("org.freedesktop.Secret.Collection.CreateItem", 0, props, secret, true).Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+	if err := s.prompt(prompt); err != nil {
+		return err
+	}
+	s.cache[id] = value
+	return nil
+}
+
+func (s *keyringSecretStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, id)
+	items, err := s.search(id)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := s.conn.Object(secretServiceDest, item).Call("org.freedesktop.Secret.Item.Delete", 0).Store(&prompt); err != nil {
+			return err
+		}
+		if err := s.prompt(prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func newKeyringSecretStore() (SecretStore, error) {
+	return nil, errors.New("the system keyring backend is only available on Linux")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnresolvedSecretRefIsNotUsed(t *testing.T) {
+	ref := secretRefPrefix + "claude-glm"
+	config := AppConfig{Claude: ToolConfig{CurrentModel: "GLM", Models: []ModelConfig{{ModelName: "GLM", ApiKey: ref}}}}
+	app := &App{}
+	if err := app.resolveLaunchKey(&config, "claude"); !errors.Is(err, errSecretStoreLocked) {
+		t.Fatalf("resolveLaunchKey = %v, want errSecretStoreLocked", err)
+	}
+	if config.Claude.Models[0].ApiKey != ref {
+		t.Fatalf("the reference was replaced with %q", config.Claude.Models[0].ApiKey)
+	}
+
+	gw := &gateway{app: app, keys: map[string]gatewayKey{}, clients: map[string]*http.Client{}}
+	if _, _, err := gw.providerKey("claude", &config.Claude.Models[0]); !errors.Is(err, errSecretStoreLocked) {
+		t.Fatalf("providerKey = %v, want errSecretStoreLocked", err)
+	}
+	if _, _, err := providerForRequest(&config, "claude", "GLM"); !errors.Is(err, errSecretStoreLocked) {
+		t.Fatalf("providerForRequest = %v, want errSecretStoreLocked", err)
+	}
+	m := ModelConfig{ModelName: "Kimi", Keys: []ProviderKey{{Label: "work", ApiKey: ref}}}
+	if _, _, err := gw.providerKey("claude", &m); !errors.Is(err, errSecretStoreLocked) {
+		t.Fatalf("providerKey with a key list = %v, want errSecretStoreLocked", err)
+	}
+}
+
+func TestLoadConfigMovesLiteralKeysButKeepsRefs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv(secretPassphraseEnv, "correct horse")
+	secretMutex.Lock()
+	fileStore, secretPassphrase = nil, ""
+	secretMutex.Unlock()
+	t.Cleanup(func() {
+		secretMutex.Lock()
+		fileStore, secretPassphrase = nil, ""
+		secretMutex.Unlock()
+	})
+	config := AppConfig{SchemaVersion: currentConfigSchema, SecretBackend: SecretBackendFile, Claude: ToolConfig{CurrentModel: "GLM", Models: []ModelConfig{
+		{ModelName: "GLM", ModelUrl: "https://glm.example/anthropic", ApiKey: "sk-literal"},
+		{ModelName: "Kimi", ModelUrl: "https://kimi.example/anthropic", ApiKey: "env:KIMI_API_KEY"},
+	}}}
+	path := filepath.Join(home, ".aicoder_config.json")
+	data, _ := json.Marshal(config)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	app := &App{testHomeDir: home}
+	loaded, err := app.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := getProviderModel(&loaded.Claude, "GLM"); m.ApiKey != "sk-literal" {
+		t.Errorf("loaded GLM key = %q, want the literal resolved", m.ApiKey)
+	}
+
+	var onDisk AppConfig
+	data, _ = os.ReadFile(path)
+	json.Unmarshal(data, &onDisk)
+	if m := getProviderModel(&onDisk.Claude, "GLM"); !isSecretRef(m.ApiKey) {
+		t.Errorf("GLM key on disk = %q, want a secret reference", m.ApiKey)
+	}
+	if m := getProviderModel(&onDisk.Claude, "Kimi"); m.ApiKey != "env:KIMI_API_KEY" {
+		t.Errorf("Kimi key on disk = %q, want the env: reference", m.ApiKey)
+	}
+	if bytes.Contains(data, []byte("sk-literal")) {
+		t.Error("the literal key is still in the config file")
+	}
+	store, err := openSecretStore(SecretBackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := store.Get("claude/GLM/api_key"); !ok || value != "sk-literal" {
+		t.Errorf("stored GLM key = %q, %v", value, ok)
+	}
+	if hasPlaintextSecrets(&onDisk) {
+		t.Error("hasPlaintextSecrets is true for a config holding only references")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Terminal profiles decide how a launch script is opened on Linux. A profile is an argv
+// template; {script}, {cwd} and {title} are replaced per argument, so no shell is
+// involved and paths with spaces or quotes stay intact.
+
+const (
+	TerminalProfileAuto   = "auto"
+	TerminalProfileCustom = "custom"
+
+	TerminalTmuxOff     = ""
+	TerminalTmuxWindow  = "window"
+	TerminalTmuxSplitH  = "split-horizontal"
+	TerminalTmuxSplitV  = "split-vertical"
+	terminalWindowTitle = "AICoder"
+)
+
+// TerminalConfig is the terminal section of AppConfig.
+type TerminalConfig struct {
+	Profile       string `json:"profile"`        // "auto", a built-in profile name or "custom"
+	CustomCommand string `json:"custom_command"` // e.g. `mlterm --working-dir={cwd} -e {script}`
+	Tmux          string `json:"tmux"`           // "", "window", "split-horizontal" or "split-vertical"
+}
+
+// TerminalProfile is a built-in terminal emulator template.
+type TerminalProfile struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+}
+
+// builtinTerminalProfiles are tried in this order when the profile is "auto".
+// x-terminal-emulator comes first because it is the distribution's configured default.
+var builtinTerminalProfiles = []TerminalProfile{
+	{Name: "x-terminal-emulator", Command: []string{"x-terminal-emulator", "-e", "{script}"}},
+	{Name: "kitty", Command: []string{"kitty", "--directory", "{cwd}", "--title", "{title}", "{script}"}},
+	{Name: "alacritty", Command: []string{"alacritty", "--working-directory", "{cwd}", "--title", "{title}", "-e", "{script}"}},
+	{Name: "wezterm", Command: []string{"wezterm", "start", "--cwd", "{cwd}", "--", "{script}"}},
+	{Name: "foot", Command: []string{"foot", "--working-directory={cwd}", "--title={title}", "{script}"}},
+	{Name: "tilix", Command: []string{"tilix", "--working-directory={cwd}", "--title={title}", "-e", "{script}"}},
+	{Name: "gnome-terminal", Command: []string{"gnome-terminal", "--working-directory={cwd}", "--title={title}", "--", "{script}"}},
+	{Name: "konsole", Command: []string{"konsole", "--workdir", "{cwd}", "-p", "tabtitle={title}", "-e", "{script}"}},
+	{Name: "xfce4-terminal", Command: []string{"xfce4-terminal", "--working-directory={cwd}", "--title={title}", "-x", "{script}"}},
+	{Name: "xterm", Command: []string{"xterm", "-T", "{title}", "-e", "{script}"}},
+}
+
+// GetTerminalProfiles lists the built-in terminal profiles for the settings UI.
+func (a *App) GetTerminalProfiles() []TerminalProfile {
+	return builtinTerminalProfiles
+}
+
+func findTerminalProfile(name string) (TerminalProfile, bool) {
+	for _, p := range builtinTerminalProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return TerminalProfile{}, false
+}
+
+// terminalCommand builds the command that opens script in a new terminal (or tmux
+// window/split) according to cfg.
+func terminalCommand(cfg TerminalConfig, script, cwd, title string) (*exec.Cmd, error) {
+	vars := strings.NewReplacer("{script}", script, "{cwd}", cwd, "{title}", title)
+	if cfg.Tmux != TerminalTmuxOff {
+		if argv, err := tmuxCommand(cfg.Tmux); err == nil {
+			return newTerminalCmd(expandTerminalArgs(argv, vars), cwd)
+		} else if os.Getenv("TMUX") != "" {
+			return nil, err
+		}
+		// No tmux server running: fall back to a terminal window
+	}
+	switch cfg.Profile {
+	case "", TerminalProfileAuto:
+		p, ok := detectTerminalProfile()
+		if !ok {
+			return nil, errors.New("no supported terminal emulator found, choose one in the terminal settings")
+		}
+		return newTerminalCmd(expandTerminalArgs(p.Command, vars), cwd)
+	case TerminalProfileCustom:
+		argv, err := splitCommandLine(cfg.CustomCommand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid custom terminal command: %w", err)
+		}
+		if len(argv) == 0 {
+			return nil, errors.New("custom terminal command is empty")
+		}
+		if !strings.Contains(cfg.CustomCommand, "{script}") {
+			return nil, errors.New("custom terminal command must contain {script}")
+		}
+		return newTerminalCmd(expandTerminalArgs(argv, vars), cwd)
+	default:
+		p, ok := findTerminalProfile(cfg.Profile)
+		if !ok {
+			return nil, fmt.Errorf("unknown terminal profile %q", cfg.Profile)
+		}
+		return newTerminalCmd(expandTerminalArgs(p.Command, vars), cwd)
+	}
+}
+
+// detectTerminalProfile returns the first installed built-in profile. $TERMINAL, when it
+// names a built-in profile, wins over the default order.
+func detectTerminalProfile() (TerminalProfile, bool) {
+	if p, ok := findTerminalProfile(filepath.Base(os.Getenv("TERMINAL"))); ok {
+		if _, err := exec.LookPath(p.Command[0]); err == nil {
+			return p, true
+		}
+	}
+	for _, p := range builtinTerminalProfiles {
+		if _, err := exec.LookPath(p.Command[0]); err == nil {
+			return p, true
+		}
+	}
+	return TerminalProfile{}, false
+}
+
+// tmuxCommand returns the argv that opens a new tmux window or split, provided a tmux
+// server is running.
+func tmuxCommand(mode string) ([]string, error) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return nil, errors.New("tmux is not installed")
+	}
+	if err := exec.Command("tmux", "has-session").Run(); err != nil {
+		return nil, errors.New("no tmux server is running")
+	}
+	switch mode {
+	case TerminalTmuxWindow:
+		return []string{"tmux", "new-window", "-c", "{cwd}", "-n", "{title}", "{script}"}, nil
+	case TerminalTmuxSplitH:
+		return []string{"tmux", "split-window", "-h", "-c", "{cwd}", "{script}"}, nil
+	case TerminalTmuxSplitV:
+		return []string{"tmux", "split-window", "-v", "-c", "{cwd}", "{script}"}, nil
+	}
+	return nil, fmt.Errorf("unknown tmux mode %q", mode)
+}
+
+func expandTerminalArgs(argv []string, vars *strings.Replacer) []string {
+	result := make([]string, len(argv))
+	for i, arg := range argv {
+		result[i] = vars.Replace(arg)
+	}
+	return result
+}
+
+func newTerminalCmd(argv []string, cwd string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, fmt.Errorf("terminal %s not found: %w", argv[0], err)
+	}
+	cmd := exec.Command(path, argv[1:]...)
+	cmd.Dir = cwd
+	return cmd, nil
+}
+
+// splitCommandLine splits a command template into arguments, honouring single quotes,
+// double quotes and backslash escapes much like a POSIX shell.
+func splitCommandLine(s string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeTerminals puts executables with the given names on an otherwise empty PATH.
+func fakeTerminals(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("TERMINAL", "")
+	t.Setenv("TMUX", "")
+	return dir
+}
+
+func TestTerminalCommandProfiles(t *testing.T) {
+	bin := fakeTerminals(t, "kitty", "xterm", "mlterm")
+	script, cwd := "/tmp/my launch's script.sh", "/home/me/my project"
+	cases := []struct {
+		name     string
+		cfg      TerminalConfig
+		terminal string
+		want     []string
+	}{
+		{"auto picks the first installed", TerminalConfig{Profile: TerminalProfileAuto}, "",
+			[]string{"kitty", "--directory", cwd, "--title", "AICoder - claude", script}},
+		{"auto prefers $TERMINAL", TerminalConfig{}, "/usr/bin/xterm",
+			[]string{"xterm", "-T", "AICoder - claude", "-e", script}},
+		{"named profile", TerminalConfig{Profile: "xterm"}, "kitty",
+			[]string{"xterm", "-T", "AICoder - claude", "-e", script}},
+		{"custom template", TerminalConfig{Profile: TerminalProfileCustom, CustomCommand: `mlterm --working-dir={cwd} -T "{title} (x)" -e {script}`}, "",
+			[]string{"mlterm", "--working-dir=" + cwd, "-T", "AICoder - claude (x)", "-e", script}},
+		{"tmux without a server falls back", TerminalConfig{Profile: "kitty", Tmux: TerminalTmuxWindow}, "",
+			[]string{"kitty", "--directory", cwd, "--title", "AICoder - claude", script}},
+	}
+	for _, c := range cases {
+		t.Setenv("TERMINAL", c.terminal)
+		cmd, err := terminalCommand(c.cfg, script, cwd, "AICoder - claude")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if cmd.Path != filepath.Join(bin, c.want[0]) || !reflect.DeepEqual(cmd.Args[1:], c.want[1:]) || cmd.Dir != cwd {
+			t.Errorf("%s: %s %q in %s, want %q", c.name, cmd.Path, cmd.Args[1:], cmd.Dir, c.want)
+		}
+	}
+}
+
+func TestTerminalCommandErrors(t *testing.T) {
+	fakeTerminals(t, "xterm")
+	for name, cfg := range map[string]TerminalConfig{
+		"not installed":       {Profile: "kitty"},
+		"unknown profile":     {Profile: "teletype"},
+		"custom no {script}":  {Profile: TerminalProfileCustom, CustomCommand: "xterm -e bash"},
+		"custom unterminated": {Profile: TerminalProfileCustom, CustomCommand: `xterm -e "{script}`},
+		"custom empty":        {Profile: TerminalProfileCustom},
+	} {
+		if _, err := terminalCommand(cfg, "/tmp/s.sh", "/tmp", "t"); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	// Inside tmux a failing tmux launch is reported rather than opening a window
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1,0")
+	if _, err := terminalCommand(TerminalConfig{Profile: "xterm", Tmux: TerminalTmuxSplitH}, "/tmp/s.sh", "/tmp", "t"); err == nil {
+		t.Error("tmux split without tmux installed: no error")
+	}
+	fakeTerminals(t)
+	if _, err := terminalCommand(TerminalConfig{}, "/tmp/s.sh", "/tmp", "t"); err == nil {
+		t.Error("auto without any terminal: no error")
+	}
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	cases := map[string][]string{
+		`foot -e {script}`:            {"foot", "-e", "{script}"},
+		`  a   'b c'  "d \"e\"" `:     {"a", "b c", `d "e"`},
+		`x --title='it'\''s' y\ z ""`: {"x", "--title=it's", "y z", ""},
+		``:                            nil,
+	}
+	for in, want := range cases {
+		got, err := splitCommandLine(in)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("splitCommandLine(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{`a "b`, `a 'b`, `a b\`} {
+		if _, err := splitCommandLine(in); err == nil {
+			t.Errorf("splitCommandLine(%q): no error", in)
+		}
+	}
+}
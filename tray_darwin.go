@@ -50,10 +50,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Claude.CurrentModel = modelName
-						currentConfig.ActiveTool = "claude"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("claude", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.Claude.Models {
@@ -75,10 +72,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Gemini.CurrentModel = modelName
-						currentConfig.ActiveTool = "gemini"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("gemini", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.Gemini.Models {
@@ -100,10 +94,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Codex.CurrentModel = modelName
-						currentConfig.ActiveTool = "codex"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("codex", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.Codex.Models {
@@ -125,10 +116,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Opencode.CurrentModel = modelName
-						currentConfig.ActiveTool = "opencode"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("opencode", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.Opencode.Models {
@@ -150,10 +138,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.CodeBuddy.CurrentModel = modelName
-						currentConfig.ActiveTool = "codebuddy"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("codebuddy", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.CodeBuddy.Models {
@@ -175,10 +160,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Qoder.CurrentModel = modelName
-						currentConfig.ActiveTool = "qoder"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("qoder", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.Qoder.Models {
@@ -200,10 +182,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.IFlow.CurrentModel = modelName
-						currentConfig.ActiveTool = "iflow"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("iflow", modelName)
 
 						// Check if API key is missing
 						for _, m := range currentConfig.IFlow.Models {
@@ -225,10 +204,7 @@ func setupTray(app *App, appOptions *options.App) {
 			modelName := model.ModelName
 			m.Click(func() {
 				go func() {
-					currentConfig, _ := app.LoadConfig()
-					currentConfig.Kilo.CurrentModel = modelName
-					currentConfig.ActiveTool = "kilo"
-					app.SaveConfig(currentConfig)
+					currentConfig, _ := app.switchProvider("kilo", modelName)
 
 					// Check if API key is missing
 					for _, m := range currentConfig.Kilo.Models {
@@ -241,6 +217,9 @@ func setupTray(app *App, appOptions *options.App) {
 			})
 		}
 
+			systray.AddSeparator()
+			mProfiles, updateProfiles := addProfileMenu(app, config)
+			addFailoverItem(app)
 			systray.AddSeparator()
 			mQuit := systray.AddMenuItem("Quit", "Quit Application")
 
@@ -253,11 +232,13 @@ func setupTray(app *App, appOptions *options.App) {
 				systray.SetTooltip(t["title"])
 				mShow.SetTitle(t["show"])
 				mLaunch.SetTitle(t["launch"])
+				mProfiles.SetTitle(t["profiles"])
 				mQuit.SetTitle(t["quit"])
 			}
 
 			// Register config change listener
 			OnConfigChanged = func(cfg AppConfig) {
+				updateProfiles(cfg)
 				if modelItems == nil {
 					return
 				}
@@ -288,7 +269,7 @@ func setupTray(app *App, appOptions *options.App) {
 				go func() {
 					currentConfig, _ := app.LoadConfig()
 					path := app.GetCurrentProjectPath()
-					app.LaunchTool(currentConfig.ActiveTool, false, false, false, "", path, false)
+					app.LaunchTool(currentConfig.defaultToolFor(path), false, false, false, "", path, false)
 				}()
 			})
 
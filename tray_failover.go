@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/energye/systray"
+)
+
+const trayFailoverPoll = 10 * time.Second
+
+// addFailoverItem adds a tray entry that shows when the gateway, a separate process,
+// serves a tool with a provider of its failover chain. The gateway is polled, and each
+// change is logged and emitted as a "gateway-failover" event.
+func addFailoverItem(app *App) {
+	item := systray.AddMenuItem("", "Provider failover")
+	item.Disable()
+	item.Hide()
+	go func() {
+		shown := ""
+		for range time.Tick(trayFailoverPoll) {
+			if _, err := os.Stat(getGatewayTokenPath()); err != nil {
+				continue // No gateway was ever started
+			}
+			status, err := app.GetGatewayStatus()
+			if err != nil {
+				continue
+			}
+			var lines []string
+			for _, f := range status.Failovers {
+				lines = append(lines, fmt.Sprintf("%s: %s → %s", f.Tool, f.Provider, f.Active))
+			}
+			text := strings.Join(lines, ", ")
+			if text == shown {
+				continue
+			}
+			if text == "" {
+				item.Hide()
+				app.log("Gateway failover ended")
+			} else {
+				item.SetTitle("⚠ " + text)
+				item.Show()
+				app.log("Gateway failover: " + text)
+			}
+			shown = text
+			app.emitEvent("gateway-failover", status.Failovers)
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/energye/systray"
+)
+
+// addProfileMenu adds the profile switcher to the tray. The returned function updates the
+// check marks and is called from OnConfigChanged. Like the provider menus, the entries
+// are built once; profiles created later appear after a restart.
+func addProfileMenu(app *App, config AppConfig) (*systray.MenuItem, func(AppConfig)) {
+	config.ensureProfiles()
+	mProfiles := systray.AddMenuItem("Profiles", "Switch Profile")
+	items := make(map[string]*systray.MenuItem)
+	for _, p := range config.Profiles {
+		name := p.Name
+		item := mProfiles.AddSubMenuItemCheckbox(name, "Switch to "+name, name == config.ActiveProfile)
+		items[name] = item
+		item.Click(func() {
+			go func() {
+				if _, err := app.ActivateProfile(name); err != nil {
+					app.log("Failed to switch profile: " + err.Error())
+				}
+			}()
+		})
+	}
+	update := func(cfg AppConfig) {
+		active := cfg.ActiveProfile
+		if active == "" {
+			active = defaultProfileName
+		}
+		for name, item := range items {
+			if name == active {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+	}
+	return mProfiles, update
+}
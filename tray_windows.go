@@ -51,10 +51,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Claude.CurrentModel = modelName
-						currentConfig.ActiveTool = "claude"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("claude", modelName)
 
 						for _, m := range currentConfig.Claude.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -75,10 +72,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Gemini.CurrentModel = modelName
-						currentConfig.ActiveTool = "gemini"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("gemini", modelName)
 
 						for _, m := range currentConfig.Gemini.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -99,10 +93,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Codex.CurrentModel = modelName
-						currentConfig.ActiveTool = "codex"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("codex", modelName)
 
 						for _, m := range currentConfig.Codex.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -123,10 +114,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Opencode.CurrentModel = modelName
-						currentConfig.ActiveTool = "opencode"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("opencode", modelName)
 
 						for _, m := range currentConfig.Opencode.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -147,10 +135,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.CodeBuddy.CurrentModel = modelName
-						currentConfig.ActiveTool = "codebuddy"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("codebuddy", modelName)
 
 						for _, m := range currentConfig.CodeBuddy.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -171,10 +156,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.Qoder.CurrentModel = modelName
-						currentConfig.ActiveTool = "qoder"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("qoder", modelName)
 
 						for _, m := range currentConfig.Qoder.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -195,10 +177,7 @@ func setupTray(app *App, appOptions *options.App) {
 				modelName := model.ModelName
 				m.Click(func() {
 					go func() {
-						currentConfig, _ := app.LoadConfig()
-						currentConfig.IFlow.CurrentModel = modelName
-						currentConfig.ActiveTool = "iflow"
-						app.SaveConfig(currentConfig)
+						currentConfig, _ := app.switchProvider("iflow", modelName)
 
 						for _, m := range currentConfig.IFlow.Models {
 							if m.ModelName == modelName && m.ApiKey == "" {
@@ -219,10 +198,7 @@ func setupTray(app *App, appOptions *options.App) {
 			modelName := model.ModelName
 			m.Click(func() {
 				go func() {
-					currentConfig, _ := app.LoadConfig()
-					currentConfig.Kilo.CurrentModel = modelName
-					currentConfig.ActiveTool = "kilo"
-					app.SaveConfig(currentConfig)
+					currentConfig, _ := app.switchProvider("kilo", modelName)
 
 					for _, m := range currentConfig.Kilo.Models {
 						if m.ModelName == modelName && m.ApiKey == "" {
@@ -236,6 +212,12 @@ func setupTray(app *App, appOptions *options.App) {
 
 							systray.AddSeparator()
 
+							mProfiles, updateProfiles := addProfileMenu(app, config)
+
+							addFailoverItem(app)
+
+							systray.AddSeparator()
+
 							mQuit := systray.AddMenuItem("Quit", "Quit Application")
 
 				
@@ -272,6 +254,8 @@ func setupTray(app *App, appOptions *options.App) {
 
 								mLaunch.SetTitle(t["launch"])
 
+								mProfiles.SetTitle(t["profiles"])
+
 												mQuit.SetTitle(t["quit"])
 
 											}
@@ -289,6 +273,7 @@ func setupTray(app *App, appOptions *options.App) {
 
 											// Register config change listener
 											OnConfigChanged = func(cfg AppConfig) {
+												updateProfiles(cfg)
 												if toolItems == nil {
 													return
 												}
@@ -347,7 +332,7 @@ func setupTray(app *App, appOptions *options.App) {
 				go func() {
 					currentConfig, _ := app.LoadConfig()
 					path := app.GetCurrentProjectPath()
-					app.LaunchTool(currentConfig.ActiveTool, false, false, false, "", path, false)
+					app.LaunchTool(currentConfig.defaultToolFor(path), false, false, false, "", path, false)
 				}()
 			})
 
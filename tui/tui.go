@@ -18,6 +18,7 @@ var (
 	helpStyle         = list.DefaultStyles().HelpStyle.PaddingLeft(4).PaddingBottom(1)
 	quitTextStyle     = lipgloss.NewStyle().Margin(1, 0, 2, 4)
 	headerStyle       = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212")).MarginLeft(2).MarginBottom(1)
+	warningStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).PaddingLeft(4)
 )
 
 type item string
@@ -58,6 +59,7 @@ type model struct {
 	checker      *ToolChecker
 	showingForm  bool
 	form         *formModel
+	warnings     []string // Config validation issues, shown below the main menu
 }
 
 func (m model) Init() tea.Cmd {
@@ -263,11 +265,19 @@ func (m model) View() string {
 	if m.quitting {
 		return quitTextStyle.Render("Thanks for using AICoder TUI! Goodbye! 👋")
 	}
-	return "\n" + m.list.View()
+	view := "\n" + m.list.View()
+	if m.view == "menu" && len(m.warnings) > 0 {
+		view += "\n" + headerStyle.Render(fmt.Sprintf("⚠ Config issues (%d)", len(m.warnings)))
+		for _, w := range m.warnings {
+			view += "\n" + warningStyle.Render(w)
+		}
+		view += "\n"
+	}
+	return view
 }
 
-// RunTUI starts the TUI application
-func RunTUI() error {
+// RunTUI starts the TUI application. warnings are config problems to point out.
+func RunTUI(warnings []string) error {
 	items := []list.Item{
 		item("View Tool Status"),
 		item("Configure API Keys"),
@@ -292,6 +302,7 @@ func RunTUI() error {
 		view:         "menu",
 		checker:      checker,
 		toolStatuses: make(map[string]string),
+		warnings:     warnings,
 	}
 
 	p := tea.NewProgram(m, tea.WithAltScreen())
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The gateway records the tokens of every response it relays in ~/.cceasy/usage, one
+// JSON line per request in a file per month (2026-10.jsonl). Costs are computed when a
+// report is made, from the price table in the config (model_prices), so correcting a
+// price also corrects the reports of past months.
+//
+// Only requests that go through the gateway are counted: translated providers always,
+// the others when usage_tracking is on (see routeThroughGateway).
+
+const defaultPriceCurrency = "USD"
+
+// UsageRecord is one request relayed by the gateway. InputTokens excludes the tokens
+// read from or written to the provider's prompt cache.
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Tool             string    `json:"tool"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	Project          string    `json:"project,omitempty"` // ProjectConfig.Id
+	InputTokens      int       `json:"input_tokens"`
+	OutputTokens     int       `json:"output_tokens"`
+	CacheReadTokens  int       `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int       `json:"cache_write_tokens,omitempty"`
+}
+
+// ModelPrice is the price of a model per million tokens. Cache prices of 0 are charged
+// like input tokens.
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cache_read,omitempty"`
+	CacheWrite float64 `json:"cache_write,omitempty"`
+	Currency   string  `json:"currency,omitempty"` // Default USD
+}
+
+// UsageReportRow sums the records of one group. Costs are per currency, since prices
+// can be in different ones.
+type UsageReportRow struct {
+	Keys             []string           `json:"keys"` // One value per group-by field
+	Requests         int                `json:"requests"`
+	InputTokens      int                `json:"input_tokens"`
+	OutputTokens     int                `json:"output_tokens"`
+	CacheReadTokens  int                `json:"cache_read_tokens"`
+	CacheWriteTokens int                `json:"cache_write_tokens"`
+	Cost             map[string]float64 `json:"cost"`
+	UnpricedRequests int                `json:"unpriced_requests"` // Requests of models without a price
+}
+
+// UsageReport is what GetUsageReport returns.
+type UsageReport struct {
+	From       string           `json:"from"` // RFC3339, inclusive
+	To         string           `json:"to"`   // RFC3339, exclusive
+	GroupBy    []string         `json:"group_by"`
+	Rows       []UsageReportRow `json:"rows"`
+	Total      UsageReportRow   `json:"total"`
+	Currencies []string         `json:"currencies"`
+	Unpriced   []string         `json:"unpriced"` // provider/model pairs without a price
+}
+
+var usageGroupFields = []string{"project", "tool", "provider", "model", "day", "month"}
+
+var usageMutex sync.Mutex
+
+func getUsageDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cceasy", "usage")
+}
+
+// appendUsageRecord adds a record to the file of its month.
+func appendUsageRecord(r UsageRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	dir := getUsageDir()
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, r.Time.Format("2006-01")+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readUsageRecords returns the records in [from, to).
+func readUsageRecords(from, to time.Time) ([]UsageRecord, error) {
+	files, err := filepath.Glob(filepath.Join(getUsageDir(), "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var records []UsageRecord
+	for _, file := range files {
+		month, err := time.ParseInLocation("2006-01", strings.TrimSuffix(filepath.Base(file), ".jsonl"), time.Local)
+		if err != nil || !month.AddDate(0, 1, 0).After(from) || !month.Before(to) {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r UsageRecord
+			if json.Unmarshal(scanner.Bytes(), &r) != nil {
+				continue // A line cut short by a crash
+			}
+			if !r.Time.Before(from) && r.Time.Before(to) {
+				records = append(records, r)
+			}
+		}
+		f.Close()
+	}
+	return records, nil
+}
+
+var usageDaysPattern = regexp.MustCompile(`^(\d+)d$`)
+
+// parseUsageRange turns a report range into [from, to): today, 7d (any number of days),
+// month (the default), last-month, all, a month like 2026-09 or days like
+// 2026-09-01..2026-09-15.
+func parseUsageRange(spec string, now time.Time) (time.Time, time.Time, error) {
+	day := func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()) }
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	end := day(now).AddDate(0, 0, 1)
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	switch spec {
+	case "", "month":
+		return monthStart, end, nil
+	case "today":
+		return day(now), end, nil
+	case "last-month":
+		return monthStart.AddDate(0, -1, 0), monthStart, nil
+	case "all":
+		return time.Time{}, end, nil
+	}
+	if m := usageDaysPattern.FindStringSubmatch(spec); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if n < 1 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid range %q", spec)
+		}
+		return end.AddDate(0, 0, -n), end, nil
+	}
+	if month, err := time.ParseInLocation("2006-01", spec, now.Location()); err == nil {
+		return month, month.AddDate(0, 1, 0), nil
+	}
+	if first, last, ok := strings.Cut(spec, ".."); ok {
+		from, err1 := time.ParseInLocation("2006-01-02", first, now.Location())
+		to, err2 := time.ParseInLocation("2006-01-02", last, now.Location())
+		if err1 == nil && err2 == nil && !to.Before(from) {
+			return from, to.AddDate(0, 0, 1), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid range %q (expected today, 7d, month, last-month, all, YYYY-MM or YYYY-MM-DD..YYYY-MM-DD)", spec)
+}
+
+// parseUsageGroupBy reads a comma-separated list of usageGroupFields.
+func parseUsageGroupBy(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []string{"project"}, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		known := false
+		for _, g := range usageGroupFields {
+			known = known || f == g
+		}
+		if !known {
+			return nil, fmt.Errorf("cannot group by %q (expected %s)", f, strings.Join(usageGroupFields, ", "))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// priceFor looks up the price of a model, preferring a "provider/model" entry to a
+// plain "model" one. Names are not case sensitive.
+func (c *AppConfig) priceFor(provider, model string) (ModelPrice, bool) {
+	var fallback *ModelPrice
+	for name, p := range c.ModelPrices {
+		p := p
+		if strings.EqualFold(name, provider+"/"+model) {
+			return p, true
+		}
+		if strings.EqualFold(name, model) {
+			fallback = &p
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return ModelPrice{}, false
+}
+
+// cost returns the cost of a record and its currency.
+func (p ModelPrice) cost(r UsageRecord) (float64, string) {
+	cacheRead, cacheWrite := p.CacheRead, p.CacheWrite
+	if cacheRead == 0 {
+		cacheRead = p.Input
+	}
+	if cacheWrite == 0 {
+		cacheWrite = p.Input
+	}
+	currency := strings.ToUpper(p.Currency)
+	if currency == "" {
+		currency = defaultPriceCurrency
+	}
+	total := float64(r.InputTokens)*p.Input + float64(r.OutputTokens)*p.Output + float64(r.CacheReadTokens)*cacheRead + float64(r.CacheWriteTokens)*cacheWrite
+	return total / 1e6, currency
+}
+
+func (row *UsageReportRow) add(r UsageRecord, cost float64, currency string, priced bool) {
+	row.Requests++
+	row.InputTokens += r.InputTokens
+	row.OutputTokens += r.OutputTokens
+	row.CacheReadTokens += r.CacheReadTokens
+	row.CacheWriteTokens += r.CacheWriteTokens
+	if !priced {
+		row.UnpricedRequests++
+		return
+	}
+	row.Cost[currency] += cost
+}
+
+// GetUsageReport sums the recorded usage over a range (see parseUsageRange), grouped by
+// a comma-separated list of project, tool, provider, model, day and month.
+func (a *App) GetUsageReport(rangeSpec, groupBy string) (UsageReport, error) {
+	from, to, err := parseUsageRange(rangeSpec, time.Now())
+	if err != nil {
+		return UsageReport{}, err
+	}
+	fields, err := parseUsageGroupBy(groupBy)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	config, err := a.LoadConfig()
+	if err != nil {
+		return UsageReport{}, err
+	}
+	records, err := readUsageRecords(from, to)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	projectNames := make(map[string]string)
+	for _, p := range config.Projects {
+		projectNames[p.Id] = p.Name
+	}
+	report := UsageReport{To: to.Format(time.RFC3339), GroupBy: fields, Rows: []UsageReportRow{}, Total: UsageReportRow{Cost: make(map[string]float64)}, Currencies: []string{}, Unpriced: []string{}}
+	if !from.IsZero() {
+		report.From = from.Format(time.RFC3339)
+	}
+	rows := make(map[string]*UsageReportRow)
+	currencies := make(map[string]bool)
+	unpriced := make(map[string]bool)
+	for _, r := range records {
+		keys := make([]string, len(fields))
+		for i, f := range fields {
+			switch f {
+			case "project":
+				keys[i] = r.Project
+				if name := projectNames[r.Project]; name != "" {
+					keys[i] = name
+				}
+			case "tool":
+				keys[i] = r.Tool
+			case "provider":
+				keys[i] = r.Provider
+			case "model":
+				keys[i] = r.Model
+			case "day":
+				keys[i] = r.Time.Local().Format("2006-01-02")
+			case "month":
+				keys[i] = r.Time.Local().Format("2006-01")
+			}
+			if keys[i] == "" {
+				keys[i] = "-"
+			}
+		}
+		id := strings.Join(keys, "\x00")
+		row := rows[id]
+		if row == nil {
+			row = &UsageReportRow{Keys: keys, Cost: make(map[string]float64)}
+			rows[id] = row
+		}
+		price, priced := config.priceFor(r.Provider, r.Model)
+		cost, currency := price.cost(r)
+		if priced {
+			currencies[currency] = true
+		} else {
+			unpriced[r.Provider+"/"+r.Model] = true
+		}
+		row.add(r, cost, currency, priced)
+		report.Total.add(r, cost, currency, priced)
+	}
+	for _, row := range rows {
+		report.Rows = append(report.Rows, *row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return strings.Join(report.Rows[i].Keys, "\x00") < strings.Join(report.Rows[j].Keys, "\x00")
+	})
+	for c := range currencies {
+		report.Currencies = append(report.Currencies, c)
+	}
+	sort.Strings(report.Currencies)
+	for m := range unpriced {
+		report.Unpriced = append(report.Unpriced, m)
+	}
+	sort.Strings(report.Unpriced)
+	return report, nil
+}
+
+// writeCSV writes the report with one cost column per currency and a total line.
+func (report *UsageReport) writeCSV(w *csv.Writer) error {
+	header := append([]string{}, report.GroupBy...)
+	header = append(header, "requests", "input_tokens", "output_tokens", "cache_read_tokens", "cache_write_tokens")
+	for _, c := range report.Currencies {
+		header = append(header, "cost_"+strings.ToLower(c))
+	}
+	header = append(header, "unpriced_requests")
+	w.Write(header)
+	line := func(keys []string, row UsageReportRow) {
+		record := append([]string{}, keys...)
+		for _, n := range []int{row.Requests, row.InputTokens, row.OutputTokens, row.CacheReadTokens, row.CacheWriteTokens} {
+			record = append(record, strconv.Itoa(n))
+		}
+		for _, c := range report.Currencies {
+			record = append(record, strconv.FormatFloat(row.Cost[c], 'f', 4, 64))
+		}
+		record = append(record, strconv.Itoa(row.UnpricedRequests))
+		w.Write(record)
+	}
+	for _, row := range report.Rows {
+		line(row.Keys, row)
+	}
+	total := make([]string, len(report.GroupBy))
+	total[0] = "TOTAL"
+	line(total, report.Total)
+	w.Flush()
+	return w.Error()
+}
+
+// ExportUsageCSV writes the report of GetUsageReport to a CSV file and returns its path.
+func (a *App) ExportUsageCSV(rangeSpec, groupBy, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("no file path given")
+	}
+	report, err := a.GetUsageReport(rangeSpec, groupBy)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(expandHome(path))
+	if err != nil {
+		return "", err
+	}
+	err = report.writeCSV(csv.NewWriter(f))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	a.log("Exported the usage report to " + path)
+	return path, nil
+}
+
+// SetModelPrice adds or changes a price, for "model" or "provider/model".
+func (a *App) SetModelPrice(name string, price ModelPrice) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("no model given")
+	}
+	return a.UpdateConfig(func(config *AppConfig) error {
+		if config.ModelPrices == nil {
+			config.ModelPrices = make(map[string]ModelPrice)
+		}
+		for existing := range config.ModelPrices {
+			if strings.EqualFold(existing, name) {
+				delete(config.ModelPrices, existing)
+			}
+		}
+		config.ModelPrices[name] = price
+		return nil
+	})
+}
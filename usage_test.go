@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const anthropicUsageStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"id":"msg_1","model":"relay-large","usage":{"input_tokens":100,"cache_read_input_tokens":20,"cache_creation_input_tokens":5,"output_tokens":1}}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"the word usage in text"}}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":42}}` + "\n\n" +
+	"event: message_stop\n" +
+	`data: {"type":"message_stop"}` + "\n\n"
+
+func TestPassthroughRecordsStreamUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "sk-relay" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Chunks that split events and lines
+		for i := 0; i < len(anthropicUsageStream); i += 37 {
+			w.Write([]byte(anthropicUsageStream[i:min(i+37, len(anthropicUsageStream))]))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+	gw := newTestGateway(t, AppConfig{UsageTracking: true,
+		Claude: ToolConfig{CurrentModel: "Relay", Models: []ModelConfig{
+			{ModelName: "Relay", ModelUrl: srv.URL, ModelId: "relay-large", ApiKey: "sk-relay"},
+		}},
+		Projects:    []ProjectConfig{{Id: "p1", Name: "billing", Path: t.TempDir()}},
+		ModelPrices: map[string]ModelPrice{"Relay/relay-large": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75}},
+	})
+
+	rec := serveGatewayRequest(gw, "/claude/Relay/p1/v1/messages", `{"model":"relay-large","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK || rec.Body.String() != anthropicUsageStream {
+		t.Fatalf("HTTP %d, body relayed unchanged: %v", rec.Code, rec.Body.String() == anthropicUsageStream)
+	}
+	records, err := readUsageRecords(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("records = %+v, %v", records, err)
+	}
+	r := records[0]
+	r.Time = time.Time{}
+	want := UsageRecord{Tool: "claude", Provider: "Relay", Model: "relay-large", Project: "p1", InputTokens: 100, OutputTokens: 42, CacheReadTokens: 20, CacheWriteTokens: 5}
+	if r != want {
+		t.Errorf("record = %+v, want %+v", r, want)
+	}
+
+	report, err := gw.app.GetUsageReport("today", "project,model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cost := (100*3 + 42*15 + 20*0.3 + 5*3.75) / 1e6
+	if len(report.Rows) != 1 || !reflect.DeepEqual(report.Rows[0].Keys, []string{"billing", "relay-large"}) || fmt.Sprintf("%.8f", report.Rows[0].Cost["USD"]) != fmt.Sprintf("%.8f", cost) {
+		t.Errorf("report rows = %+v, want billing/relay-large costing %f USD", report.Rows, cost)
+	}
+
+	// Failed requests and requests that use no tokens are not recorded
+	serveGatewayRequest(gw, "/claude/Relay/p1/v1/messages/count_tokens", `{"model":"relay-large","messages":[]}`)
+	if records, _ := readUsageRecords(time.Now().Add(-time.Hour), time.Now().Add(time.Hour)); len(records) != 1 {
+		t.Errorf("%d records after count_tokens, want 1", len(records))
+	}
+}
+
+func TestUsageTapProtocols(t *testing.T) {
+	cases := []struct {
+		protocol string
+		stream   bool
+		body     string
+		want     tokenCounts
+	}{
+		{"responses", false, `{"id":"resp_1","usage":{"input_tokens":1000,"input_tokens_details":{"cached_tokens":400},"output_tokens":50}}`, tokenCounts{600, 50, 400, 0}},
+		{"responses", true, "event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"usage\":{\"input_tokens\":10,\"output_tokens\":5}}}\n\n", tokenCounts{10, 5, 0, 0}},
+		{"chat", true, "data: {\"choices\":[{\"delta\":{\"content\":\"x\"}}],\"usage\":null}\n\ndata: {\"choices\":[],\"usage\":{\"prompt_tokens\":30,\"completion_tokens\":7,\"prompt_tokens_details\":{\"cached_tokens\":10}}}\n\ndata: [DONE]\n\n", tokenCounts{20, 7, 10, 0}},
+		{"chat", false, `{"choices":[]}`, tokenCounts{}},
+	}
+	for _, c := range cases {
+		tap := &usageTap{stream: c.stream}
+		for _, chunk := range strings.SplitAfter(c.body, ",") {
+			tap.Write([]byte(chunk))
+		}
+		if got := tap.counts(c.protocol); got != c.want {
+			t.Errorf("%s (stream %v): %+v, want %+v", c.protocol, c.stream, got, c.want)
+		}
+	}
+}
+
+func TestSetModelPriceConcurrent(t *testing.T) {
+	a := newTestGateway(t, AppConfig{ModelPrices: map[string]ModelPrice{"GLM/glm-4.7": {Input: 1}}}).app
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- a.SetModelPrice(fmt.Sprintf("model-%d", i), ModelPrice{Input: float64(i), Output: 1})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Replacing a price matches its name without case
+	if err := a.SetModelPrice("glm/GLM-4.7", ModelPrice{Input: 2}); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := a.LoadConfig()
+	if len(config.ModelPrices) != 21 {
+		t.Errorf("%d prices saved, want 21: concurrent saves were lost", len(config.ModelPrices))
+	}
+	for i := 0; i < 20; i++ {
+		if p := config.ModelPrices[fmt.Sprintf("model-%d", i)]; p.Input != float64(i) {
+			t.Errorf("model-%d price = %+v", i, p)
+		}
+	}
+	if _, old := config.ModelPrices["GLM/glm-4.7"]; old || config.ModelPrices["glm/GLM-4.7"].Input != 2 {
+		t.Errorf("prices = %v, want GLM/glm-4.7 replaced", config.ModelPrices)
+	}
+}